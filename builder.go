@@ -3,6 +3,8 @@ package vespa
 import (
 	"fmt"
 	"strings"
+
+	"github.com/vipulsodha/vespa-go/schema"
 )
 
 // QueryBuilderImpl is the concrete implementation of QueryBuilder
@@ -11,22 +13,36 @@ type QueryBuilderImpl struct {
 	sources         []string
 	whereConditions []WhereCondition
 	rankExpression  RankExpression
-	ranking         string
+	orderBy         []OrderBySpec
+	limit           int
+	yqlOffset       int
+	timeout         int
+	grouping        string
+	ranking         Ranking
 	hits            int
 	offset          int
 	defaultIndex    string
 	inputParams     map[string]interface{}
+	parameters      map[string]interface{}
 	query           string
+	queryProfile    string
+	optimize        bool
+	schemas         []*schema.Schema
 }
 
 // NewQueryBuilder creates a new query builder instance.
 // This is the main entry point for constructing Vespa YQL queries.
 func NewQueryBuilder() QueryBuilder {
+	return newQueryBuilder()
+}
+
+func newQueryBuilder() *QueryBuilderImpl {
 	return &QueryBuilderImpl{
 		selectFields:    make([]string, 0),
 		sources:         make([]string, 0),
 		whereConditions: make([]WhereCondition, 0),
 		inputParams:     make(map[string]interface{}),
+		parameters:      make(map[string]interface{}),
 	}
 }
 
@@ -54,9 +70,41 @@ func (qb *QueryBuilderImpl) Rank(rankExpression RankExpression) QueryBuilder {
 	return qb
 }
 
-// WithRanking sets the ranking profile
-func (qb *QueryBuilderImpl) WithRanking(profile string) QueryBuilder {
-	qb.ranking = profile
+// OrderBy adds a field to the order by clause. Calls accumulate, so the
+// first field added is the primary sort key.
+func (qb *QueryBuilderImpl) OrderBy(field string, order SortOrder) QueryBuilder {
+	qb.orderBy = append(qb.orderBy, OrderBySpec{Field: field, Order: order})
+	return qb
+}
+
+// Limit sets the YQL limit and offset clauses. A zero value omits the clause.
+// Unlike WithHits/WithOffset, these are part of the YQL string itself.
+func (qb *QueryBuilderImpl) Limit(limit, offset int) QueryBuilder {
+	qb.limit = limit
+	qb.yqlOffset = offset
+	return qb
+}
+
+// Timeout sets the YQL timeout clause in milliseconds
+func (qb *QueryBuilderImpl) Timeout(milliseconds int) QueryBuilder {
+	qb.timeout = milliseconds
+	return qb
+}
+
+// Grouping sets the grouping expression appended to the YQL after '|',
+// e.g. "all(group(brand) each(output(count())))"
+func (qb *QueryBuilderImpl) Grouping(expression string) QueryBuilder {
+	qb.grouping = expression
+	return qb
+}
+
+// WithRanking sets the ranking profile along with optional ranking settings
+// such as match-phase, diversity and rerank counts
+func (qb *QueryBuilderImpl) WithRanking(profile string, opts ...RankingOption) QueryBuilder {
+	qb.ranking.Profile = profile
+	for _, opt := range opts {
+		opt(&qb.ranking)
+	}
 	return qb
 }
 
@@ -84,18 +132,132 @@ func (qb *QueryBuilderImpl) WithInput(key string, value interface{}) QueryBuilde
 	return qb
 }
 
+// WithQueryInput adds a typed query input. The value is checked against the
+// declared input type and serialized when the query is built.
+func (qb *QueryBuilderImpl) WithQueryInput(input QueryInput, value interface{}) QueryBuilder {
+	qb.inputParams[input.Key()] = typedInputValue{input: input, value: value}
+	return qb
+}
+
+// WithParameter adds an arbitrary request parameter, serialized as a top-level
+// key of the request (e.g. for parameters referenced from embed() or query profiles).
+// Standard keys such as yql, hits or ranking are rejected when the query is built.
+func (qb *QueryBuilderImpl) WithParameter(name string, value interface{}) QueryBuilder {
+	qb.parameters[name] = value
+	return qb
+}
+
+// WithQueryProfile selects a server-side query profile. Variant dimension
+// values are ordinary request parameters and can be set with WithParameter.
+func (qb *QueryBuilderImpl) WithQueryProfile(profile string) QueryBuilder {
+	qb.queryProfile = profile
+	return qb
+}
+
 // WithQuery sets the text query
 func (qb *QueryBuilderImpl) WithQuery(query string) QueryBuilder {
 	qb.query = query
 	return qb
 }
 
+// WithSchema validates the query against the application's schemas when it
+// is built (see ValidateSchema). The sources select the schemas to check,
+// and inputs are checked against the selected rank profile. Schemas and
+// documents inherited by a schema must be passed as well.
+func (qb *QueryBuilderImpl) WithSchema(schemas ...*schema.Schema) QueryBuilder {
+	for _, s := range schemas {
+		if s != nil {
+			qb.schemas = append(qb.schemas, s)
+		}
+	}
+	return qb
+}
+
+// Rewrite applies a rewrite function to every where condition and to the
+// conditions of the rank expression (see Rewrite). Conditions rewritten to
+// nil are removed. This lets middleware swap fields or strip conditions
+// before the query is rendered.
+func (qb *QueryBuilderImpl) Rewrite(fn RewriteFunc) QueryBuilder {
+	qb.whereConditions = rewriteAll(qb.whereConditions, fn)
+	if rank, ok := qb.rankExpression.(*RankExpressionImpl); ok {
+		qb.rankExpression = &RankExpressionImpl{conditions: rewriteAll(rank.conditions, fn)}
+	}
+	return qb
+}
+
+func rewriteAll(conditions []WhereCondition, fn RewriteFunc) []WhereCondition {
+	rewritten := make([]WhereCondition, 0, len(conditions))
+	for _, condition := range conditions {
+		if result := Rewrite(condition, fn); result != nil {
+			rewritten = append(rewritten, result)
+		}
+	}
+	return rewritten
+}
+
+// Optimize enables simplification of the where conditions and rank expression
+// when the YQL is rendered (see Simplify). Conditions that simplify to true
+// are dropped from the where clause and duplicate conditions are removed.
+func (qb *QueryBuilderImpl) Optimize() QueryBuilder {
+	qb.optimize = true
+	return qb
+}
+
+// Clone returns a copy of the builder. The copy can be extended
+// independently of the original, e.g. to derive per-request queries from a
+// shared base query. Conditions and input values are shared, not copied, so
+// they must not be mutated once they have been passed to the builder.
+func (qb *QueryBuilderImpl) Clone() QueryBuilder {
+	return qb.clone()
+}
+
+func (qb *QueryBuilderImpl) clone() *QueryBuilderImpl {
+	c := *qb
+	c.selectFields = append(make([]string, 0, len(qb.selectFields)), qb.selectFields...)
+	c.sources = append(make([]string, 0, len(qb.sources)), qb.sources...)
+	c.whereConditions = append(make([]WhereCondition, 0, len(qb.whereConditions)), qb.whereConditions...)
+	c.orderBy = append(make([]OrderBySpec, 0, len(qb.orderBy)), qb.orderBy...)
+	c.schemas = append([]*schema.Schema(nil), qb.schemas...)
+	c.ranking = qb.ranking.clone()
+
+	c.inputParams = make(map[string]interface{}, len(qb.inputParams))
+	for k, v := range qb.inputParams {
+		c.inputParams[k] = v
+	}
+	c.parameters = make(map[string]interface{}, len(qb.parameters))
+	for k, v := range qb.parameters {
+		c.parameters[k] = v
+	}
+
+	if rank, ok := qb.rankExpression.(*RankExpressionImpl); ok {
+		c.rankExpression = rank.clone()
+	}
+	return &c
+}
+
 // BuildYQL builds just the YQL string
 func (qb *QueryBuilderImpl) BuildYQL() (string, error) {
+	return qb.buildYQLWith(qb.renderYQL)
+}
+
+// buildYQLWith validates the builder and renders its YQL with render
+func (qb *QueryBuilderImpl) buildYQLWith(render func() string) (string, error) {
 	if err := qb.validate(); err != nil {
 		return "", err
 	}
 
+	yql := render()
+	if strings.Contains(yql, placeholderMarker) {
+		return "", &ValidationError{
+			Field:   "yql",
+			Message: "query contains template parameters, use NewTemplate to instantiate it",
+		}
+	}
+	return yql, nil
+}
+
+// renderYQL renders the YQL string of a validated builder
+func (qb *QueryBuilderImpl) renderYQL() string {
 	var yqlParts []string
 
 	// SELECT clause
@@ -115,7 +277,15 @@ func (qb *QueryBuilderImpl) BuildYQL() (string, error) {
 		yqlParts = append(yqlParts, "where", "true")
 	}
 
-	return strings.Join(yqlParts, " "), nil
+	// ORDER BY, LIMIT, OFFSET and TIMEOUT clauses
+	yqlParts = append(yqlParts, qb.buildTrailingClauses()...)
+
+	// Grouping
+	if qb.grouping != "" {
+		yqlParts = append(yqlParts, "|", qb.grouping)
+	}
+
+	return strings.Join(yqlParts, " ")
 }
 
 // Build creates the complete VespaQuery
@@ -124,14 +294,19 @@ func (qb *QueryBuilderImpl) Build() (*VespaQuery, error) {
 	if err != nil {
 		return nil, err
 	}
+	return qb.buildQuery(yql), nil
+}
 
+// buildQuery creates the VespaQuery of a validated builder with the given YQL
+func (qb *QueryBuilderImpl) buildQuery(yql string) *VespaQuery {
 	query := &VespaQuery{
 		YQL: yql,
 	}
 
 	// Set optional fields
-	if qb.ranking != "" {
-		query.Ranking = qb.ranking
+	if !qb.ranking.isZero() {
+		ranking := qb.ranking.clone()
+		query.Ranking = &ranking
 	}
 
 	if qb.hits > 0 {
@@ -146,18 +321,24 @@ func (qb *QueryBuilderImpl) Build() (*VespaQuery, error) {
 		query.DefaultIndex = qb.defaultIndex
 	}
 
-	if len(qb.inputParams) > 0 {
-		query.Input = make(map[string]interface{})
-		for k, v := range qb.inputParams {
-			query.Input[k] = v
-		}
+	// Inputs were checked by validate, so resolving them cannot fail here
+	inputs, parameters, _ := qb.resolveInputs()
+	if len(inputs) > 0 {
+		query.Input = inputs
+	}
+	if len(parameters) > 0 {
+		query.Parameters = parameters
 	}
 
 	if qb.query != "" {
 		query.Query = qb.query
 	}
 
-	return query, nil
+	if qb.queryProfile != "" {
+		query.QueryProfile = qb.queryProfile
+	}
+
+	return query
 }
 
 // Helper methods for building query parts
@@ -176,19 +357,42 @@ func (qb *QueryBuilderImpl) buildFromClause() string {
 	return fmt.Sprintf("from sources %s", strings.Join(qb.sources, ", "))
 }
 
+func (qb *QueryBuilderImpl) buildTrailingClauses() []string {
+	var clauses []string
+
+	if len(qb.orderBy) > 0 {
+		var fields []string
+		for _, spec := range qb.orderBy {
+			fields = append(fields, fmt.Sprintf("%s %s", spec.Field, spec.Order))
+		}
+		clauses = append(clauses, fmt.Sprintf("order by %s", strings.Join(fields, ", ")))
+	}
+	if qb.limit > 0 {
+		clauses = append(clauses, fmt.Sprintf("limit %d", qb.limit))
+	}
+	if qb.yqlOffset > 0 {
+		clauses = append(clauses, fmt.Sprintf("offset %d", qb.yqlOffset))
+	}
+	if qb.timeout > 0 {
+		clauses = append(clauses, fmt.Sprintf("timeout %d", qb.timeout))
+	}
+
+	return clauses
+}
+
 func (qb *QueryBuilderImpl) buildWhereClause() string {
 	var conditions []string
 
 	// Add regular where conditions
-	for _, condition := range qb.whereConditions {
+	for _, condition := range qb.renderedWhereConditions() {
 		if yql := condition.ToYQL(); yql != "" {
 			conditions = append(conditions, yql)
 		}
 	}
 
 	// Add rank expression if present
-	if qb.rankExpression != nil {
-		if rankYQL := qb.rankExpression.ToYQL(); rankYQL != "" {
+	if rankExpression := qb.renderedRankExpression(); rankExpression != nil {
+		if rankYQL := rankExpression.ToYQL(); rankYQL != "" {
 			conditions = append(conditions, rankYQL)
 		}
 	}
@@ -201,25 +405,203 @@ func (qb *QueryBuilderImpl) buildWhereClause() string {
 	return strings.Join(conditions, " and ")
 }
 
+// renderedWhereConditions returns the where conditions to render, simplified
+// when optimization is enabled
+func (qb *QueryBuilderImpl) renderedWhereConditions() []WhereCondition {
+	if !qb.optimize {
+		return qb.whereConditions
+	}
+
+	simplified := Simplify(&JunctionCondition{Conditions: qb.whereConditions, Operator: "AND"})
+	switch c := simplified.(type) {
+	case *ConstantCondition:
+		if c.Value {
+			return nil
+		}
+		return []WhereCondition{c}
+	case *JunctionCondition:
+		if c.Operator == "AND" {
+			// Keep top-level conditions as separate clauses joined with 'and'
+			return c.Conditions
+		}
+	}
+	return []WhereCondition{simplified}
+}
+
+// renderedRankExpression returns the rank expression to render, with its
+// conditions simplified when optimization is enabled
+func (qb *QueryBuilderImpl) renderedRankExpression() RankExpression {
+	rank, ok := qb.rankExpression.(*RankExpressionImpl)
+	if !qb.optimize || !ok {
+		return qb.rankExpression
+	}
+
+	conditions := make([]WhereCondition, 0, len(rank.conditions))
+	for _, condition := range rank.conditions {
+		conditions = append(conditions, Simplify(condition))
+	}
+	return &RankExpressionImpl{conditions: conditions}
+}
+
 // validate checks if the query builder state is valid
 func (qb *QueryBuilderImpl) validate() error {
+	var errs ValidationErrors
+
 	// At minimum, we need a FROM clause or sources
 	if len(qb.sources) == 0 {
-		return &ValidationError{
+		errs.add(&ValidationError{
 			Field:   "sources",
 			Message: "at least one source must be specified",
+		})
+	}
+
+	for _, spec := range qb.orderBy {
+		if spec.Field == "" || (spec.Order != Ascending && spec.Order != Descending) {
+			errs.add(&ValidationError{
+				Field:   "orderBy",
+				Message: fmt.Sprintf("invalid order by '%s %s', expected a field and 'asc' or 'desc'", spec.Field, spec.Order),
+			})
+		}
+	}
+
+	if qb.limit < 0 || qb.yqlOffset < 0 || qb.timeout < 0 {
+		errs.add(&ValidationError{
+			Field:   "limit",
+			Message: "limit, offset and timeout must not be negative",
+		})
+	}
+
+	errs.add(qb.ranking.validate())
+
+	errs = append(errs, validateConditions("where", qb.whereConditions)...)
+	if rank, ok := qb.rankExpression.(*RankExpressionImpl); ok {
+		errs = append(errs, validateConditions("rank", rank.conditions)...)
+	}
+
+	_, _, inputErrs := qb.resolveInputs()
+	errs = append(errs, inputErrs...)
+
+	errs = append(errs, validateParameters(qb.parameters)...)
+
+	if len(qb.schemas) > 0 {
+		errs = append(errs, qb.validateSchema()...)
+	}
+
+	return errs.err()
+}
+
+// validateParameters rejects parameters that would clash with a standard
+// request key such as yql, hits or ranking
+func validateParameters(parameters map[string]interface{}) ValidationErrors {
+	var errs ValidationErrors
+	for _, key := range standardQueryKeys() {
+		if _, ok := parameters[key]; ok {
+			errs.add(&ValidationError{
+				Field:   "parameters",
+				Message: fmt.Sprintf("parameter '%s' clashes with a standard request key, set it with its builder method", key),
+			})
+		}
+	}
+	return errs
+}
+
+// resolveInputs serializes typed inputs and embeddings into the request input
+// map, and collects the request parameters that embed() texts are bound to.
+// Every invalid input and embedding is reported, each with its path.
+func (qb *QueryBuilderImpl) resolveInputs() (map[string]interface{}, map[string]interface{}, ValidationErrors) {
+	var errs ValidationErrors
+	inputs := make(map[string]interface{}, len(qb.inputParams))
+	parameters := make(map[string]interface{}, len(qb.parameters))
+	for k, v := range qb.parameters {
+		parameters[k] = v
+	}
+
+	fail := func(err error, path string) {
+		var added ValidationErrors
+		added.add(err)
+		for _, e := range added {
+			if e.Path == "" {
+				e.Path = path
+			}
+		}
+		errs = append(errs, added...)
+	}
+	bind := func(field, path string, embedding *Embedding) {
+		if err := embedding.bind(field, parameters); err != nil {
+			fail(err, path)
 		}
 	}
 
 	// Validate that if we have input parameters, they follow the expected format
-	for key := range qb.inputParams {
-		if !strings.HasPrefix(key, "input.query(") {
-			return &ValidationError{
+	for _, key := range sortedInputKeys(qb.inputParams) {
+		path := fmt.Sprintf("input[%s]", key)
+		if !inputKeyPattern.MatchString(key) {
+			fail(&ValidationError{
 				Field:   "input",
-				Message: fmt.Sprintf("input parameter key '%s' should start with 'input.query('", key),
+				Message: fmt.Sprintf("input parameter key '%s' must have the form 'input.query(name)'", key),
+			}, path)
+			continue
+		}
+
+		value := qb.inputParams[key]
+		if typed, ok := value.(typedInputValue); ok {
+			serialized, err := typed.input.Serialize(typed.value)
+			if err != nil {
+				fail(err, path)
+				continue
+			}
+			value = serialized
+		}
+		if embedding, ok := value.(*Embedding); ok {
+			bind(key, path, embedding)
+		}
+		inputs[key] = value
+	}
+
+	// Nearest neighbor operations with a query embedding supply their own input
+	for _, located := range qb.nearestNeighbors() {
+		nn := located.nn
+		if nn.Embedding == nil {
+			continue
+		}
+		key := fmt.Sprintf("input.query(%s)", nn.QueryVector)
+		if existing, ok := inputs[key]; ok {
+			if embedding, isEmbedding := existing.(*Embedding); isEmbedding && *embedding == *nn.Embedding {
+				continue
 			}
+			fail(&ValidationError{
+				Field:   key,
+				Message: fmt.Sprintf("nearestNeighbor on '%s' embeds its query vector, but the input is already set to a different value", nn.Field),
+			}, located.path)
+			continue
 		}
+		bind(key, located.path, nn.Embedding)
+		inputs[key] = nn.Embedding
 	}
 
-	return nil
+	return inputs, parameters, errs
+}
+
+// locatedNearestNeighbor is a nearest neighbor operation and its path in the query
+type locatedNearestNeighbor struct {
+	nn   *NearestNeighbor
+	path string
+}
+
+// nearestNeighbors returns the nearest neighbor operations in the where
+// conditions and the rank expression
+func (qb *QueryBuilderImpl) nearestNeighbors() []locatedNearestNeighbor {
+	var result []locatedNearestNeighbor
+	visit := func(nn *NearestNeighbor, path string) {
+		result = append(result, locatedNearestNeighbor{nn: nn, path: path})
+	}
+	for i, condition := range qb.whereConditions {
+		collectNearestNeighbors(condition, fmt.Sprintf("where[%d]", i), visit)
+	}
+	if rank, ok := qb.rankExpression.(*RankExpressionImpl); ok {
+		for i, condition := range rank.conditions {
+			collectNearestNeighbors(condition, fmt.Sprintf("rank[%d]", i), visit)
+		}
+	}
+	return result
 }
@@ -1,8 +1,14 @@
 package vespa
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"strings"
+	"sync"
 	"testing"
+
+	"github.com/vipulsodha/vespa-go/tensor"
 )
 
 func TestFieldConditions(t *testing.T) {
@@ -311,12 +317,12 @@ func TestQueryBuilder_ComplexQuery(t *testing.T) {
 				Field("category").In("electronics", "gadgets"),
 			),
 		).
-				Rank(
+		Rank(
 			NewRank().
-			AddCondition(Field("embedding_field").NearestNeighbor("query_vector", 1000, WithLabel("query_vector"))).
-			AddCondition(Field("embedding_field").NearestNeighbor("sort_vector", 1000, WithLabel("sort_vector"))).
-			AddCondition(Field("brand").Contains([]string{"nike", "air"}, WithPhraseMatching())).
-			AddCondition(Field("color").Contains("red")),
+				AddCondition(Field("embedding_field").NearestNeighbor("query_vector", 1000, WithLabel("query_vector"))).
+				AddCondition(Field("embedding_field").NearestNeighbor("sort_vector", 1000, WithLabel("sort_vector"))).
+				AddCondition(Field("brand").Contains([]string{"nike", "air"}, WithPhraseMatching())).
+				AddCondition(Field("color").Contains("red")),
 		).
 		WithRanking("hybrid_profile").
 		WithHits(50).
@@ -344,8 +350,8 @@ func TestQueryBuilder_ComplexQuery(t *testing.T) {
 	}
 
 	// Verify other parameters
-	if query.Ranking != "hybrid_profile" {
-		t.Errorf("Expected ranking %q, got %q", "hybrid_profile", query.Ranking)
+	if query.Ranking == nil || query.Ranking.Profile != "hybrid_profile" {
+		t.Errorf("Expected ranking %q, got %+v", "hybrid_profile", query.Ranking)
 	}
 	if query.Hits != 50 {
 		t.Errorf("Expected hits %d, got %d", 50, query.Hits)
@@ -397,8 +403,8 @@ func TestQueryBuilder_WithNearestNeighborInWhere(t *testing.T) {
 	}
 
 	// Verify other parameters
-	if query.Ranking != "hybrid_profile" {
-		t.Errorf("Expected ranking %q, got %q", "hybrid_profile", query.Ranking)
+	if query.Ranking == nil || query.Ranking.Profile != "hybrid_profile" {
+		t.Errorf("Expected ranking %q, got %+v", "hybrid_profile", query.Ranking)
 	}
 	if query.Hits != 50 {
 		t.Errorf("Expected hits %d, got %d", 50, query.Hits)
@@ -485,10 +491,6 @@ func TestQueryBuilder_Validation(t *testing.T) {
 	}
 }
 
-
-
-
-
 func TestValueFormatting(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -534,7 +536,8 @@ func TestStringEscaping(t *testing.T) {
 		})
 	}
 }
-	// TestSameElementCondition tests basic sameElement functionality
+
+// TestSameElementCondition tests basic sameElement functionality
 func TestSameElementCondition(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -665,8 +668,6 @@ func TestSameElementConditionChaining(t *testing.T) {
 	}
 }
 
-
-
 // TestSameElementConditionEdgeCases tests edge cases and error conditions
 func TestSameElementConditionEdgeCases(t *testing.T) {
 	tests := []struct {
@@ -865,8 +866,8 @@ func TestQueryBuilder_PaginationScenario(t *testing.T) {
 		t.Errorf("Expected offset %d, got %d", 50, query.Offset)
 	}
 
-	if query.Ranking != "popularity" {
-		t.Errorf("Expected ranking %q, got %q", "popularity", query.Ranking)
+	if query.Ranking == nil || query.Ranking.Profile != "popularity" {
+		t.Errorf("Expected ranking %q, got %+v", "popularity", query.Ranking)
 	}
 }
 
@@ -908,8 +909,8 @@ func TestQueryBuilder_ComplexQueryWithOffset(t *testing.T) {
 	}
 
 	// Verify other parameters are preserved
-	if query.Ranking != "hybrid_search" {
-		t.Errorf("Expected ranking %q, got %q", "hybrid_search", query.Ranking)
+	if query.Ranking == nil || query.Ranking.Profile != "hybrid_search" {
+		t.Errorf("Expected ranking %q, got %+v", "hybrid_search", query.Ranking)
 	}
 
 	if len(query.Input) != 1 {
@@ -1301,4 +1302,512 @@ func TestNotConditionXORUseCase(t *testing.T) {
 	if query.YQL != expectedYQL {
 		t.Errorf("Expected YQL %q, got %q", expectedYQL, query.YQL)
 	}
-}
\ No newline at end of file
+}
+func TestQueryBuilder_RankingConfiguration(t *testing.T) {
+	query, err := NewQueryBuilder().
+		From("products").
+		WithRanking("hybrid_profile",
+			WithListFeatures(true),
+			WithRerankCount(200),
+			WithGlobalPhaseRerankCount(50),
+			WithMaxFilterCoverage(0.2),
+			WithMatchPhase("popularity", 10000, false),
+			WithDiversity("brand", 5),
+			WithSorting("-price"),
+		).
+		Build()
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	data, err := json.Marshal(query)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expectedJSON := `{"yql":"select * from sources products where true","ranking":{"profile":"hybrid_profile","listFeatures":true,"sorting":"-price","rerankCount":200,"matchPhase":{"attribute":"popularity","maxHits":10000,"ascending":false,"maxFilterCoverage":0.2},"diversity":{"attribute":"brand","minGroups":5},"globalPhase":{"rerankCount":50}}}`
+	if string(data) != expectedJSON {
+		t.Errorf("Expected JSON %s, got %s", expectedJSON, data)
+	}
+}
+
+func TestQueryBuilder_RankingProfileOnlySerialization(t *testing.T) {
+	query, err := NewQueryBuilder().
+		From("products").
+		WithRanking("bm25").
+		Build()
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	data, err := json.Marshal(query)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expectedJSON := `{"yql":"select * from sources products where true","ranking":"bm25"}`
+	if string(data) != expectedJSON {
+		t.Errorf("Expected JSON %s, got %s", expectedJSON, data)
+	}
+
+	var decoded VespaQuery
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if decoded.Ranking == nil || decoded.Ranking.Profile != "bm25" {
+		t.Errorf("Expected ranking profile %q, got %+v", "bm25", decoded.Ranking)
+	}
+}
+
+func TestQueryBuilder_RankingValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  []RankingOption
+		field string
+	}{
+		{"Negative rerank count", []RankingOption{WithRerankCount(-1)}, "ranking.rerankCount"},
+		{"Negative global phase rerank count", []RankingOption{WithGlobalPhaseRerankCount(-1)}, "ranking.globalPhase.rerankCount"},
+		{"Match phase without attribute", []RankingOption{WithMatchPhase("", 100, true)}, "ranking.matchPhase.attribute"},
+		{"Diversity without attribute", []RankingOption{WithDiversity("", 3)}, "ranking.diversity.attribute"},
+		{"Max filter coverage out of range", []RankingOption{WithMatchPhase("popularity", 100, true), WithMaxFilterCoverage(1.5)}, "ranking.matchPhase.maxFilterCoverage"},
+		{"Max filter coverage without match phase", []RankingOption{WithMaxFilterCoverage(0.2)}, "ranking.matchPhase.attribute"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewQueryBuilder().From("products").WithRanking("default", tt.opts...).Build()
+
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("Expected ValidationError, got %v", err)
+			}
+			if validationErr.Field != tt.field {
+				t.Errorf("Expected error in field %q, got %q", tt.field, validationErr.Field)
+			}
+		})
+	}
+}
+
+func TestQueryBuilder_TypedQueryInputs(t *testing.T) {
+	embeddingType := tensor.MustType(tensor.Float, tensor.Indexed("x", 3))
+	embedding := TensorInput("q_embedding", embeddingType)
+
+	query, err := NewQueryBuilder().
+		From("products").
+		Where(Field("embedding").NearestNeighbor("q_embedding", 100)).
+		WithQueryInput(embedding, []float32{0.1, 0.2, 0.3}).
+		WithQueryInput(ScalarInput("boost"), 1.5).
+		Build()
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if embedding.Key() != "input.query(q_embedding)" {
+		t.Errorf("Expected key %q, got %q", "input.query(q_embedding)", embedding.Key())
+	}
+
+	data, err := json.Marshal(query.Input)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expectedJSON := `{"input.query(boost)":1.5,"input.query(q_embedding)":{"values":[0.1,0.2,0.3]}}`
+	if string(data) != expectedJSON {
+		t.Errorf("Expected input JSON %s, got %s", expectedJSON, data)
+	}
+}
+
+func TestQueryBuilder_TypedQueryInputValidation(t *testing.T) {
+	embeddingType := tensor.MustType(tensor.Float, tensor.Indexed("x", 3))
+	otherType := tensor.MustType(tensor.Float, tensor.Indexed("x", 2))
+	other, _ := tensor.NewDense(otherType, 1, 2)
+
+	tests := []struct {
+		name  string
+		input QueryInput
+		value interface{}
+	}{
+		{"Wrong vector length", TensorInput("q", embeddingType), []float32{0.1, 0.2}},
+		{"Wrong tensor type", TensorInput("q", embeddingType), other},
+		{"Scalar for tensor input", TensorInput("q", embeddingType), 1.0},
+		{"Tensor for scalar input", ScalarInput("boost"), []float32{0.1, 0.2, 0.3}},
+		{"String for scalar input", ScalarInput("boost"), "high"},
+		{"Invalid input name", ScalarInput("boost)"), 1.0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewQueryBuilder().From("products").WithQueryInput(tt.input, tt.value).Build()
+
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Errorf("Expected ValidationError, got %v", err)
+			}
+		})
+	}
+}
+
+func TestQueryBuilder_MalformedInputKeys(t *testing.T) {
+	keys := []string{
+		"input.query(q_embedding",
+		"input.query()",
+		"input.query(q) ",
+		"query(q_embedding)",
+	}
+
+	for _, key := range keys {
+		t.Run(key, func(t *testing.T) {
+			_, err := NewQueryBuilder().From("products").WithInput(key, 1.0).Build()
+			if err == nil {
+				t.Errorf("Expected error for input key %q", key)
+			}
+		})
+	}
+}
+
+func TestEmbedding(t *testing.T) {
+	tests := []struct {
+		name      string
+		embedding *Embedding
+		expected  string
+	}{
+		{"Inline text", Embed("e5", "wireless headphones"), `embed(e5, "wireless headphones")`},
+		{"Inline text with quotes", Embed("e5", `say "hi"`), `embed(e5, "say \"hi\"")`},
+		{"Bound text", Embed("e5", "wireless headphones").BindTo("q_text"), "embed(e5, @q_text)"},
+		{"Default embedder", Embed("", "shoes").BindTo("text"), "embed(@text)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := tt.embedding.String(); result != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestQueryBuilder_EmbedInput(t *testing.T) {
+	query, err := NewQueryBuilder().
+		From("products").
+		Where(Field("embedding").NearestNeighbor("q", 100)).
+		WithInput("input.query(q)", Embed("e5", "wireless headphones").BindTo("q_text")).
+		Build()
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	data, err := json.Marshal(query)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expectedJSON := `{"input":{"input.query(q)":"embed(e5, @q_text)"},"q_text":"wireless headphones","yql":"select * from sources products where ({targetHits:100}nearestNeighbor(embedding, q))"}`
+	if string(data) != expectedJSON {
+		t.Errorf("Expected JSON %s, got %s", expectedJSON, data)
+	}
+}
+
+func TestQueryBuilder_NearestNeighborWithQueryEmbedding(t *testing.T) {
+	embeddingType := tensor.MustType(tensor.Float, tensor.Indexed("x", 384))
+
+	query, err := NewQueryBuilder().
+		From("products").
+		Where(Field("category").Eq("shoes")).
+		Rank(
+			NewRank().
+				AddCondition(Field("embedding").NearestNeighbor("q", 100,
+					WithQueryEmbedding(Embed("e5", "running shoes").BindTo("q_text")))),
+		).
+		WithQueryInput(TensorInput("q", embeddingType), Embed("e5", "running shoes").BindTo("q_text")).
+		Build()
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expectedYQL := "select * from sources products where (category contains 'shoes') and rank(({targetHits:100}nearestNeighbor(embedding, q)))"
+	if query.YQL != expectedYQL {
+		t.Errorf("Expected YQL %q, got %q", expectedYQL, query.YQL)
+	}
+	if embedding, ok := query.Input["input.query(q)"].(*Embedding); !ok || embedding.String() != "embed(e5, @q_text)" {
+		t.Errorf("Expected embed() input, got %v", query.Input["input.query(q)"])
+	}
+	if query.Parameters["q_text"] != "running shoes" {
+		t.Errorf("Expected bound text parameter, got %v", query.Parameters["q_text"])
+	}
+}
+
+func TestQueryBuilder_EmbedValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		builder func() QueryBuilder
+	}{
+		{
+			"Empty text",
+			func() QueryBuilder {
+				return NewQueryBuilder().From("products").WithInput("input.query(q)", Embed("e5", ""))
+			},
+		},
+		{
+			"Conflicting input value",
+			func() QueryBuilder {
+				return NewQueryBuilder().
+					From("products").
+					Where(Field("embedding").NearestNeighbor("q", 10, WithQueryEmbedding(Embed("e5", "shoes")))).
+					WithInput("input.query(q)", []float32{0.1, 0.2})
+			},
+		},
+		{
+			"Conflicting text parameter",
+			func() QueryBuilder {
+				return NewQueryBuilder().
+					From("products").
+					WithInput("input.query(a)", Embed("e5", "shoes").BindTo("text")).
+					WithInput("input.query(b)", Embed("e5", "bags").BindTo("text"))
+			},
+		},
+		{
+			"Embedding for scalar input",
+			func() QueryBuilder {
+				return NewQueryBuilder().From("products").WithQueryInput(ScalarInput("boost"), Embed("e5", "shoes"))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.builder().Build(); err == nil {
+				t.Error("Expected error but got none")
+			}
+		})
+	}
+}
+
+func TestQueryBuilder_CollectsInputErrors(t *testing.T) {
+	_, err := NewQueryBuilder().
+		From("products").
+		Where(Field("price").Gt(10)).
+		Where(Or(
+			Field("a").NearestNeighbor("qa", 10, WithQueryEmbedding(Embed("e5", ""))),
+			Field("b").NearestNeighbor("qb", 10, WithQueryEmbedding(Embed("e5", "shoes"))),
+		)).
+		WithInput("query(bad)", 1.0).
+		WithInput("input.query(qb)", []float32{0.1}).
+		WithQueryInput(ScalarInput("boost"), "high").
+		Build()
+
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Expected ValidationErrors, got %v", err)
+	}
+
+	expected := []struct {
+		field string
+		path  string
+	}{
+		{"input.query(boost)", "input[input.query(boost)]"},
+		{"input", "input[query(bad)]"},
+		{"input.query(qa)", "where[1].left"},
+		{"input.query(qb)", "where[1].right"},
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, got %d: %v", len(expected), len(errs), errs)
+	}
+	for i, e := range expected {
+		if errs[i].Field != e.field || errs[i].Path != e.path {
+			t.Errorf("Expected error %d in field %q at %q, got %q at %q", i, e.field, e.path, errs[i].Field, errs[i].Path)
+		}
+	}
+}
+
+func TestVespaQueryParametersRoundTrip(t *testing.T) {
+	data := []byte(`{"yql":"select * from sources * where true","hits":10,"q_text":"shoes","timeout":"1s"}`)
+
+	var query VespaQuery
+	if err := json.Unmarshal(data, &query); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if query.Hits != 10 {
+		t.Errorf("Expected hits %d, got %d", 10, query.Hits)
+	}
+	if len(query.Parameters) != 2 || query.Parameters["q_text"] != "shoes" || query.Parameters["timeout"] != "1s" {
+		t.Errorf("Expected additional parameters to be collected, got %v", query.Parameters)
+	}
+
+	encoded, err := json.Marshal(query)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(encoded) != `{"hits":10,"q_text":"shoes","timeout":"1s","yql":"select * from sources * where true"}` {
+		t.Errorf("Unexpected JSON %s", encoded)
+	}
+}
+
+func TestQueryBuilder_Clone(t *testing.T) {
+	base := NewQueryBuilder().
+		From("products").
+		Where(Field("in_stock").Eq(true)).
+		Rank(NewRank().AddCondition(Field("brand").Contains("nike"))).
+		WithRanking("default", WithRerankCount(100)).
+		WithInput("input.query(boost)", 1.0)
+
+	derived := base.Clone().
+		From("archive").
+		Where(Field("category").Eq("shoes")).
+		WithRanking("semantic", WithRerankCount(10)).
+		WithInput("input.query(extra)", 2.0)
+
+	baseQuery, err := base.Build()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	derivedQuery, err := derived.Build()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expectedBaseYQL := "select * from sources products where (in_stock = true) and rank((brand contains 'nike'))"
+	if baseQuery.YQL != expectedBaseYQL {
+		t.Errorf("Expected base YQL %q, got %q", expectedBaseYQL, baseQuery.YQL)
+	}
+	if baseQuery.Ranking.Profile != "default" || baseQuery.Ranking.RerankCount != 100 {
+		t.Errorf("Expected base ranking to be unchanged, got %+v", baseQuery.Ranking)
+	}
+	if len(baseQuery.Input) != 1 {
+		t.Errorf("Expected 1 base input, got %v", baseQuery.Input)
+	}
+
+	expectedDerivedYQL := "select * from sources products, archive where (in_stock = true) and (category contains 'shoes') and rank((brand contains 'nike'))"
+	if derivedQuery.YQL != expectedDerivedYQL {
+		t.Errorf("Expected derived YQL %q, got %q", expectedDerivedYQL, derivedQuery.YQL)
+	}
+	if len(derivedQuery.Input) != 2 {
+		t.Errorf("Expected 2 derived inputs, got %v", derivedQuery.Input)
+	}
+}
+
+func TestImmutableQueryBuilder(t *testing.T) {
+	rank := NewRank().AddCondition(Field("brand").Contains("nike"))
+	base := NewImmutableQueryBuilder().
+		From("products").
+		Where(Field("in_stock").Eq(true)).
+		Rank(rank)
+
+	// Neither deriving queries nor mutating the caller's rank expression affects the base
+	base.Where(Field("category").Eq("shoes")).WithHits(5)
+	rank.AddCondition(Field("color").Contains("red"))
+
+	query, err := base.Build()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expectedYQL := "select * from sources products where (in_stock = true) and rank((brand contains 'nike'))"
+	if query.YQL != expectedYQL {
+		t.Errorf("Expected YQL %q, got %q", expectedYQL, query.YQL)
+	}
+	if query.Hits != 0 {
+		t.Errorf("Expected base hits to be unset, got %d", query.Hits)
+	}
+
+	snapshot := Immutable(NewQueryBuilder().From("products"))
+	if snapshot.From("archive") == snapshot {
+		t.Error("Expected a new builder from a copy-on-write method")
+	}
+	if Immutable(snapshot) != snapshot {
+		t.Error("Expected an immutable builder to be returned as is")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected Immutable to panic for a foreign QueryBuilder implementation")
+		}
+	}()
+	Immutable(foreignBuilder{snapshot})
+}
+
+// foreignBuilder is a QueryBuilder implemented outside of the package
+type foreignBuilder struct {
+	QueryBuilder
+}
+
+func TestImmutableQueryBuilder_ConcurrentDerivation(t *testing.T) {
+	base := NewImmutableQueryBuilder().
+		Select("id", "title").
+		From("products").
+		Where(Field("in_stock").Eq(true)).
+		Rank(NewRank().AddCondition(Field("brand").Contains("nike"))).
+		WithRanking("default").
+		WithInput("input.query(boost)", 1.0)
+
+	const workers = 16
+	results := make([]string, workers)
+	errs := make([]error, workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			category := fmt.Sprintf("category_%d", i)
+			query, err := base.
+				Where(Field("category").Eq(category)).
+				WithRanking(category, WithRerankCount(i)).
+				WithInput(fmt.Sprintf("input.query(q_%d)", i), float64(i)).
+				WithHits(i + 1).
+				Build()
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			if len(query.Input) != 2 || query.Hits != i+1 || query.Ranking.Profile != category {
+				errs[i] = fmt.Errorf("worker %d got corrupted query %+v", i, query)
+				return
+			}
+			results[i] = query.YQL
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < workers; i++ {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		expectedYQL := fmt.Sprintf("select id, title from sources products where (in_stock = true) and (category contains 'category_%d') and rank((brand contains 'nike'))", i)
+		if results[i] != expectedYQL {
+			t.Errorf("Expected YQL %q, got %q", expectedYQL, results[i])
+		}
+	}
+}
+
+func TestQueryBuilder_ParameterClash(t *testing.T) {
+	_, err := NewQueryBuilder().
+		From("products").
+		WithParameter("hits", 100).
+		WithParameter("ranking", "bm25").
+		WithParameter("trace.level", 1).
+		Build()
+
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Expected ValidationErrors, got %v", err)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("Expected 2 errors, got %d: %v", len(errs), errs)
+	}
+	for _, e := range errs {
+		if e.Field != "parameters" || !strings.Contains(e.Message, "clashes with a standard request key") {
+			t.Errorf("Unexpected error %v", e)
+		}
+	}
+
+	query := &VespaQuery{YQL: "select * from sources * where true", Parameters: map[string]interface{}{"yql": "other"}}
+	if _, err := json.Marshal(query); err == nil || !strings.Contains(err.Error(), "parameter 'yql' clashes") {
+		t.Errorf("Expected clash error from MarshalJSON, got %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package vespa
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// =============================================================================
+// Result Cache
+// =============================================================================
+
+// Store caches raw search responses by key. Implementations must be safe for
+// concurrent use; a failing remote store should report a miss from Get and
+// ignore Set.
+type Store interface {
+	Get(ctx context.Context, key string) ([]byte, bool)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration)
+}
+
+// MemoryStore is an in-memory Store that evicts the least recently used
+// entry when full and drops entries after their TTL
+type MemoryStore struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List // front is most recently used
+	now      func() time.Time
+}
+
+type memoryEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// NewMemoryStore creates an in-memory store holding at most capacity entries
+func NewMemoryStore(capacity int) *MemoryStore {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &MemoryStore{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		now:      time.Now,
+	}
+}
+
+// Get returns the value stored for key, unless it has expired
+func (s *MemoryStore) Get(ctx context.Context, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*memoryEntry)
+	if !s.now().Before(entry.expires) {
+		s.remove(element)
+		return nil, false
+	}
+	s.order.MoveToFront(element)
+	return entry.value, true
+}
+
+// Set stores value for key for the duration of ttl
+func (s *MemoryStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expires := s.now().Add(ttl)
+	if element, ok := s.entries[key]; ok {
+		entry := element.Value.(*memoryEntry)
+		entry.value = value
+		entry.expires = expires
+		s.order.MoveToFront(element)
+		return
+	}
+
+	s.entries[key] = s.order.PushFront(&memoryEntry{key: key, value: value, expires: expires})
+	for s.order.Len() > s.capacity {
+		s.remove(s.order.Back())
+	}
+}
+
+// Len returns the number of entries, including expired entries not yet evicted
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
+
+func (s *MemoryStore) remove(element *list.Element) {
+	s.order.Remove(element)
+	delete(s.entries, element.Value.(*memoryEntry).key)
+}
+
+// =============================================================================
+// Request De-duplication
+// =============================================================================
+
+// flightGroup runs one request per key at a time; concurrent callers with
+// the same key wait for and share its result
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+type flight struct {
+	done  chan struct{}
+	value []byte
+	err   error
+}
+
+// do runs fn for key unless a call for key is already in flight, in which
+// case it waits for that call. shared reports whether the result came from
+// another caller. fn runs in its own goroutine and is not stopped when
+// callers give up: every caller, including the one that started it, returns
+// early when its own ctx is done.
+func (g *flightGroup) do(ctx context.Context, key string, fn func() ([]byte, error)) (value []byte, shared bool, err error) {
+	g.mu.Lock()
+	if g.flights == nil {
+		g.flights = make(map[string]*flight)
+	}
+	f, shared := g.flights[key]
+	if !shared {
+		f = &flight{done: make(chan struct{})}
+		g.flights[key] = f
+		go func() {
+			f.value, f.err = fn()
+			g.mu.Lock()
+			delete(g.flights, key)
+			g.mu.Unlock()
+			close(f.done)
+		}()
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.value, shared, f.err
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+}
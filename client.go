@@ -0,0 +1,314 @@
+package vespa
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// Client
+// =============================================================================
+
+// Client executes queries against the /search/ endpoint of a Vespa container.
+// Results can optionally be cached by query fingerprint (see WithCache). A
+// Client is safe for concurrent use.
+type Client struct {
+	endpoint       string
+	httpClient     *http.Client
+	store          Store
+	ttl            time.Duration
+	personalInputs map[string]bool
+	bypass         func(*VespaQuery) bool
+	flights        flightGroup
+}
+
+// ClientOption configures a Client
+type ClientOption func(*Client)
+
+// NewClient creates a client for the Vespa container at endpoint, e.g.
+// "http://localhost:8080". Queries are sent to endpoint + "/search/".
+func NewClient(endpoint string, opts ...ClientOption) *Client {
+	client := &Client{
+		endpoint:   strings.TrimSuffix(endpoint, "/"),
+		httpClient: http.DefaultClient,
+	}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(client)
+		}
+	}
+	return client
+}
+
+// WithHTTPClient sets the HTTP client used for requests (default http.DefaultClient)
+func WithHTTPClient(httpClient *http.Client) ClientOption {
+	return func(c *Client) {
+		if httpClient != nil {
+			c.httpClient = httpClient
+		}
+	}
+}
+
+// WithCache caches successful results in store for ttl, keyed by
+// VespaQuery.Fingerprint. Results with errors or partial coverage are not
+// cached. Concurrent identical queries are sent to Vespa only once and share
+// the response; the shared request is not canceled when callers stop
+// waiting, so bound it with a timeout on the HTTP client. Use one store per
+// endpoint, since the key does not include the endpoint.
+func WithCache(store Store, ttl time.Duration) ClientOption {
+	return func(c *Client) {
+		if store != nil && ttl > 0 {
+			c.store = store
+			c.ttl = ttl
+		}
+	}
+}
+
+// WithPersonalInputs marks query inputs that carry per-user data, e.g. a
+// user embedding. Queries setting any of them bypass the cache. Names are
+// either input names ("user_profile") or full keys ("input.query(user_profile)").
+func WithPersonalInputs(names ...string) ClientOption {
+	return func(c *Client) {
+		if c.personalInputs == nil {
+			c.personalInputs = make(map[string]bool, len(names))
+		}
+		for _, name := range names {
+			if !inputKeyPattern.MatchString(name) {
+				name = fmt.Sprintf("input.query(%s)", name)
+			}
+			c.personalInputs[name] = true
+		}
+	}
+}
+
+// WithCacheBypass sets a function deciding whether a query bypasses the
+// cache, in addition to WithPersonalInputs
+func WithCacheBypass(bypass func(query *VespaQuery) bool) ClientOption {
+	return func(c *Client) {
+		if bypass != nil {
+			c.bypass = bypass
+		}
+	}
+}
+
+// Search executes a query. A response with a non-2xx status is returned as a
+// *ResponseError; errors Vespa reports in a successful response are
+// available in SearchResult.Errors.
+func (c *Client) Search(ctx context.Context, query *VespaQuery) (*SearchResult, error) {
+	if query == nil {
+		return nil, &ValidationError{Field: "query", Message: "query must not be nil"}
+	}
+
+	body, err := json.Marshal(query)
+	if err != nil {
+		return nil, fmt.Errorf("vespa: encoding query: %w", err)
+	}
+
+	if !c.cacheable(query) {
+		data, err := c.post(ctx, body)
+		if err != nil {
+			return nil, err
+		}
+		return decodeSearchResult(data)
+	}
+
+	key := query.Fingerprint()
+	if data, ok := c.store.Get(ctx, key); ok {
+		if result, err := decodeSearchResult(data); err == nil {
+			result.Cached = true
+			return result, nil
+		}
+	}
+
+	// The shared request must not fail for every caller when the one that
+	// started it gives up, so it runs without ctx's cancellation; each
+	// caller's ctx only bounds how long it waits
+	flightCtx := context.WithoutCancel(ctx)
+	data, shared, err := c.flights.do(ctx, key, func() ([]byte, error) {
+		data, err := c.post(flightCtx, body)
+		if err != nil {
+			return nil, err
+		}
+		result, err := decodeSearchResult(data)
+		if err != nil {
+			return nil, err
+		}
+		if result.complete() {
+			c.store.Set(flightCtx, key, data, c.ttl)
+		}
+		return data, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := decodeSearchResult(data)
+	if err != nil {
+		return nil, err
+	}
+	result.Cached = shared
+	return result, nil
+}
+
+// complete reports whether a result may be cached: Vespa reported no errors
+// and, if it reported coverage, the whole corpus was searched
+func (r *SearchResult) complete() bool {
+	if len(r.Root.Errors) > 0 {
+		return false
+	}
+	return r.Root.Coverage == nil || r.Root.Coverage.Full
+}
+
+// cacheable reports whether a query may be served from and stored in the cache
+func (c *Client) cacheable(query *VespaQuery) bool {
+	if c.store == nil {
+		return false
+	}
+	for key := range query.Input {
+		if c.personalInputs[key] {
+			return false
+		}
+	}
+	return c.bypass == nil || !c.bypass(query)
+}
+
+func (c *Client) post(ctx context.Context, body []byte) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint+"/search/", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		responseErr := &ResponseError{StatusCode: response.StatusCode}
+		if result, err := decodeSearchResult(data); err == nil {
+			responseErr.Errors = result.Errors()
+		}
+		return nil, responseErr
+	}
+	return data, nil
+}
+
+func decodeSearchResult(data []byte) (*SearchResult, error) {
+	var result SearchResult
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&result); err != nil {
+		return nil, fmt.Errorf("vespa: decoding search result: %w", err)
+	}
+	return &result, nil
+}
+
+// =============================================================================
+// Search Results
+// =============================================================================
+
+// SearchResult is the response of the /search/ endpoint
+type SearchResult struct {
+	Root   Hit           `json:"root"`
+	Timing *SearchTiming `json:"timing,omitempty"`
+
+	// Cached is set when the result was served from the cache or shared with
+	// a concurrent identical query
+	Cached bool `json:"-"`
+}
+
+// Hits returns the hits of the result
+func (r *SearchResult) Hits() []Hit {
+	return r.Root.Children
+}
+
+// TotalCount returns the number of documents matching the query
+func (r *SearchResult) TotalCount() int64 {
+	if number, ok := r.Root.Fields["totalCount"].(json.Number); ok {
+		count, _ := number.Int64()
+		return count
+	}
+	return 0
+}
+
+// Errors returns the errors Vespa reported for the query, e.g. timeouts or
+// unavailable content nodes
+func (r *SearchResult) Errors() []SearchError {
+	return r.Root.Errors
+}
+
+// Hit is a node of the result tree: the root, a document hit, or a group or
+// hit list of a grouping result
+type Hit struct {
+	ID        string                 `json:"id,omitempty"`
+	Relevance float64                `json:"relevance"`
+	Source    string                 `json:"source,omitempty"`
+	Label     string                 `json:"label,omitempty"`
+	Value     interface{}            `json:"value,omitempty"`
+	Fields    map[string]interface{} `json:"fields,omitempty"`
+	Coverage  *Coverage              `json:"coverage,omitempty"`
+	Errors    []SearchError          `json:"errors,omitempty"`
+	Children  []Hit                  `json:"children,omitempty"`
+}
+
+// Coverage describes how much of the corpus was searched
+type Coverage struct {
+	Coverage    int   `json:"coverage"`
+	Documents   int64 `json:"documents"`
+	Full        bool  `json:"full"`
+	Nodes       int   `json:"nodes"`
+	Results     int   `json:"results"`
+	ResultsFull int   `json:"resultsFull"`
+}
+
+// SearchTiming reports the time spent on the query in seconds
+type SearchTiming struct {
+	QueryTime        float64 `json:"querytime"`
+	SummaryFetchTime float64 `json:"summaryfetchtime"`
+	SearchTime       float64 `json:"searchtime"`
+}
+
+// SearchError is an error reported by Vespa for a query
+type SearchError struct {
+	Code    int    `json:"code"`
+	Summary string `json:"summary"`
+	Message string `json:"message,omitempty"`
+	Source  string `json:"source,omitempty"`
+}
+
+func (e SearchError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("vespa error %d (%s): %s", e.Code, e.Summary, e.Message)
+	}
+	return fmt.Sprintf("vespa error %d (%s)", e.Code, e.Summary)
+}
+
+// ResponseError is returned by Search for responses with a non-2xx status
+type ResponseError struct {
+	StatusCode int
+	Errors     []SearchError
+}
+
+func (e *ResponseError) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("vespa: search failed with status %d", e.StatusCode)
+	}
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("vespa: search failed with status %d: %s", e.StatusCode, strings.Join(messages, "; "))
+}
@@ -0,0 +1,320 @@
+package vespa
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testSearchResponse = `{
+	"root": {
+		"id": "toplevel",
+		"relevance": 1.0,
+		"fields": {"totalCount": 2},
+		"coverage": {"coverage": 100, "documents": 10, "full": true, "nodes": 1, "results": 1, "resultsFull": 1},
+		"children": [
+			{"id": "id:shop:product::1", "relevance": 0.9, "source": "products", "fields": {"title": "Running shoes", "price": 99}},
+			{"id": "id:shop:product::2", "relevance": 0.5, "source": "products", "fields": {"title": "Trail shoes"}}
+		]
+	},
+	"timing": {"querytime": 0.01, "summaryfetchtime": 0.002, "searchtime": 0.013}
+}`
+
+// newTestServer returns a server answering every search with response after
+// waiting for release, counting the requests it receives
+func newTestServer(t *testing.T, status int, response string, release <-chan struct{}) (*httptest.Server, *int32) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.Method != http.MethodPost || r.URL.Path != "/search/" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		var query VespaQuery
+		if err := json.NewDecoder(r.Body).Decode(&query); err != nil || query.YQL == "" {
+			t.Errorf("Expected query in request body, got %v", err)
+		}
+		if release != nil {
+			<-release
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func testQuery(t *testing.T, brand string) *VespaQuery {
+	query, err := NewQueryBuilder().From("products").Where(Field("brand").Eq(brand)).Build()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return query
+}
+
+func TestClientSearch(t *testing.T) {
+	server, _ := newTestServer(t, http.StatusOK, testSearchResponse, nil)
+
+	result, err := NewClient(server.URL+"/").Search(context.Background(), testQuery(t, "nike"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if result.TotalCount() != 2 {
+		t.Errorf("Expected total count 2, got %d", result.TotalCount())
+	}
+	hits := result.Hits()
+	if len(hits) != 2 || hits[0].ID != "id:shop:product::1" || hits[0].Fields["title"] != "Running shoes" {
+		t.Errorf("Unexpected hits %+v", hits)
+	}
+	if result.Root.Coverage == nil || !result.Root.Coverage.Full {
+		t.Errorf("Expected full coverage, got %+v", result.Root.Coverage)
+	}
+	if result.Timing == nil || result.Timing.SearchTime != 0.013 {
+		t.Errorf("Unexpected timing %+v", result.Timing)
+	}
+	if result.Cached {
+		t.Error("Expected uncached result")
+	}
+}
+
+func TestClientSearchErrors(t *testing.T) {
+	server, _ := newTestServer(t, http.StatusBadRequest,
+		`{"root": {"id": "toplevel", "relevance": 1.0, "errors": [{"code": 3, "summary": "Illegal query", "message": "Could not parse"}]}}`, nil)
+
+	_, err := NewClient(server.URL).Search(context.Background(), testQuery(t, "nike"))
+	var responseErr *ResponseError
+	if !errors.As(err, &responseErr) {
+		t.Fatalf("Expected ResponseError, got %v", err)
+	}
+	if responseErr.StatusCode != http.StatusBadRequest || len(responseErr.Errors) != 1 || responseErr.Errors[0].Code != 3 {
+		t.Errorf("Unexpected error %+v", responseErr)
+	}
+
+	if _, err := NewClient(server.URL).Search(context.Background(), nil); err == nil {
+		t.Error("Expected error for nil query")
+	}
+}
+
+func TestClientCache(t *testing.T) {
+	server, requests := newTestServer(t, http.StatusOK, testSearchResponse, nil)
+	store := NewMemoryStore(10)
+	client := NewClient(server.URL, WithCache(store, time.Minute))
+
+	first, err := client.Search(context.Background(), testQuery(t, "nike"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	second, err := client.Search(context.Background(), testQuery(t, "nike"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if atomic.LoadInt32(requests) != 1 {
+		t.Errorf("Expected 1 request, got %d", *requests)
+	}
+	if first.Cached || !second.Cached {
+		t.Errorf("Expected only the second result to be cached, got %v and %v", first.Cached, second.Cached)
+	}
+	if len(second.Hits()) != 2 {
+		t.Errorf("Expected cached hits, got %+v", second.Hits())
+	}
+
+	// Results are decoded per call and can be modified independently
+	second.Root.Children[0].Fields["title"] = "changed"
+	third, _ := client.Search(context.Background(), testQuery(t, "nike"))
+	if third.Hits()[0].Fields["title"] != "Running shoes" {
+		t.Error("Expected cached results not to share state")
+	}
+
+	if _, err := client.Search(context.Background(), testQuery(t, "adidas")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if atomic.LoadInt32(requests) != 2 {
+		t.Errorf("Expected a different query to miss the cache, got %d requests", *requests)
+	}
+}
+
+func TestClientCacheBypass(t *testing.T) {
+	server, requests := newTestServer(t, http.StatusOK, testSearchResponse, nil)
+	client := NewClient(server.URL,
+		WithCache(NewMemoryStore(10), time.Minute),
+		WithPersonalInputs("user_profile"),
+		WithCacheBypass(func(q *VespaQuery) bool { return q.Parameters["trace.level"] != nil }),
+	)
+
+	personal, err := NewQueryBuilder().From("products").WithInput("input.query(user_profile)", []float64{1, 2}).Build()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	traced, err := NewQueryBuilder().From("products").WithParameter("trace.level", 3).Build()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, query := range []*VespaQuery{personal, personal, traced, traced} {
+		result, err := client.Search(context.Background(), query)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if result.Cached {
+			t.Error("Expected bypassed query not to be cached")
+		}
+	}
+	if atomic.LoadInt32(requests) != 4 {
+		t.Errorf("Expected 4 requests, got %d", *requests)
+	}
+}
+
+func TestClientCacheSkipsErrors(t *testing.T) {
+	server, requests := newTestServer(t, http.StatusOK,
+		`{"root": {"id": "toplevel", "relevance": 1.0, "errors": [{"code": 12, "summary": "Timed out"}]}}`, nil)
+	client := NewClient(server.URL, WithCache(NewMemoryStore(10), time.Minute))
+
+	for i := 0; i < 2; i++ {
+		result, err := client.Search(context.Background(), testQuery(t, "nike"))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(result.Errors()) != 1 {
+			t.Errorf("Expected soft error in result, got %+v", result.Errors())
+		}
+	}
+	if atomic.LoadInt32(requests) != 2 {
+		t.Errorf("Expected results with errors not to be cached, got %d requests", *requests)
+	}
+}
+
+func TestClientCacheSkipsPartialCoverage(t *testing.T) {
+	server, requests := newTestServer(t, http.StatusOK,
+		`{"root": {"id": "toplevel", "relevance": 1.0, "coverage": {"coverage": 50, "documents": 5, "full": false, "nodes": 1}}}`, nil)
+	client := NewClient(server.URL, WithCache(NewMemoryStore(10), time.Minute))
+
+	for i := 0; i < 2; i++ {
+		result, err := client.Search(context.Background(), testQuery(t, "nike"))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if result.Cached {
+			t.Error("Expected result with partial coverage not to be served from the cache")
+		}
+	}
+	if atomic.LoadInt32(requests) != 2 {
+		t.Errorf("Expected results with partial coverage not to be cached, got %d requests", *requests)
+	}
+}
+
+func TestClientSingleflight(t *testing.T) {
+	release := make(chan struct{})
+	server, requests := newTestServer(t, http.StatusOK, testSearchResponse, release)
+	client := NewClient(server.URL, WithCache(NewMemoryStore(10), time.Minute))
+
+	const callers = 20
+	var wg sync.WaitGroup
+	var shared int32
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := client.Search(context.Background(), testQuery(t, "nike"))
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+				return
+			}
+			if result.Cached {
+				atomic.AddInt32(&shared, 1)
+			}
+		}()
+	}
+
+	// Wait for the first request to reach the server before releasing it
+	for atomic.LoadInt32(requests) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if atomic.LoadInt32(requests) != 1 {
+		t.Errorf("Expected concurrent identical queries to be sent once, got %d requests", *requests)
+	}
+	if shared != callers-1 {
+		t.Errorf("Expected %d shared results, got %d", callers-1, shared)
+	}
+}
+
+func TestClientSingleflightLeaderCanceled(t *testing.T) {
+	release := make(chan struct{})
+	server, requests := newTestServer(t, http.StatusOK, testSearchResponse, release)
+	client := NewClient(server.URL, WithCache(NewMemoryStore(10), time.Minute))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := make(chan error, 1)
+	go func() {
+		_, err := client.Search(ctx, testQuery(t, "nike"))
+		leader <- err
+	}()
+	for atomic.LoadInt32(requests) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	follower := make(chan *SearchResult, 1)
+	go func() {
+		result, err := client.Search(context.Background(), testQuery(t, "nike"))
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		follower <- result
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// The leader stops waiting without failing the shared request
+	cancel()
+	if err := <-leader; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled for the leader, got %v", err)
+	}
+	close(release)
+
+	result := <-follower
+	if result == nil || len(result.Hits()) != 2 || !result.Cached {
+		t.Errorf("Expected the follower to share the response, got %+v", result)
+	}
+	if atomic.LoadInt32(requests) != 1 {
+		t.Errorf("Expected one request, got %d", *requests)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	now := time.Unix(0, 0)
+	store := NewMemoryStore(2)
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	store.Set(ctx, "a", []byte("1"), time.Minute)
+	store.Set(ctx, "b", []byte("2"), time.Minute)
+	store.Get(ctx, "a") // a is now more recently used than b
+	store.Set(ctx, "c", []byte("3"), time.Minute)
+
+	if _, ok := store.Get(ctx, "b"); ok {
+		t.Error("Expected least recently used entry to be evicted")
+	}
+	if value, ok := store.Get(ctx, "a"); !ok || string(value) != "1" {
+		t.Errorf("Expected a to be cached, got %q", value)
+	}
+	if store.Len() != 2 {
+		t.Errorf("Expected 2 entries, got %d", store.Len())
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := store.Get(ctx, "c"); ok {
+		t.Error("Expected expired entry to be dropped")
+	}
+	if store.Len() != 1 {
+		t.Errorf("Expected expired entry to be removed, got %d entries", store.Len())
+	}
+}
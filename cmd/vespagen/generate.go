@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strings"
+	"unicode"
+
+	"github.com/vipulsodha/vespa-go/schema"
+)
+
+// initialisms are written in upper case in Go identifiers
+var initialisms = map[string]bool{
+	"api": true, "html": true, "http": true, "id": true,
+	"ip": true, "json": true, "uri": true, "url": true,
+}
+
+// reservedNames are the package-level identifiers every generated file declares
+var reservedNames = []string{"DocumentType", "Document", "DecodeHit"}
+
+// generator renders the Go package of a single schema
+type generator struct {
+	schema  *schema.Schema
+	buf     bytes.Buffer
+	names   map[string]bool
+	structs map[string]string // schema struct name to Go type name
+}
+
+// generate returns the formatted Go source of the package for a schema.
+// source names the schema file in the generated header.
+func generate(s *schema.Schema, source string) ([]byte, error) {
+	g := &generator{
+		schema:  s,
+		names:   make(map[string]bool),
+		structs: make(map[string]string),
+	}
+	for _, name := range reservedNames {
+		g.names[name] = true
+	}
+	for _, st := range s.Document.Structs {
+		g.structs[st.Name] = g.identifier(st.Name, "Struct")
+	}
+
+	g.accessors()
+	g.documentTypes()
+
+	var file bytes.Buffer
+	fmt.Fprintf(&file, "// Code generated by vespagen from %s. DO NOT EDIT.\n\n", source)
+	fmt.Fprintf(&file, "// Package %s provides typed field accessors and document types for the\n", packageName(s.Name))
+	fmt.Fprintf(&file, "// %s schema.\n", s.Name)
+	fmt.Fprintf(&file, "package %s\n\n", packageName(s.Name))
+	file.WriteString("import (\n\t\"encoding/json\"\n\n\tvespa \"github.com/vipulsodha/vespa-go\"\n)\n\n")
+	fmt.Fprintf(&file, "// DocumentType is the document type, for QueryBuilder.From\nconst DocumentType = %q\n", s.Document.Name)
+	file.Write(g.buf.Bytes())
+
+	formatted, err := format.Source(file.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated code for schema '%s': %w", s.Name, err)
+	}
+	return formatted, nil
+}
+
+// identifier returns a unique exported Go identifier for a schema name,
+// appending suffix on collisions
+func (g *generator) identifier(name, suffix string) string {
+	id := goName(name)
+	for g.names[id] {
+		id += suffix
+	}
+	g.names[id] = true
+	return id
+}
+
+// accessors writes a variable per field and fieldset
+func (g *generator) accessors() {
+	g.buf.WriteString("\n// Field accessors\nvar (\n")
+	for _, f := range g.schema.AllFields() {
+		_, expr, ok := g.accessor(f.Type, f.Name, f.IsIndex())
+		if !ok {
+			continue
+		}
+		id := g.identifier(f.Name, "Field")
+		fmt.Fprintf(&g.buf, "\t// %s is the %s field %s%s\n", id, f.Type, f.Name, fieldSettings(f))
+		fmt.Fprintf(&g.buf, "\t%s = %s\n", id, expr)
+	}
+	for _, fs := range g.schema.Fieldsets {
+		id := g.identifier(fs.Name, "Fieldset")
+		fmt.Fprintf(&g.buf, "\t// %s is the fieldset %s (%s)\n", id, fs.Name, strings.Join(fs.Fields, ", "))
+		fmt.Fprintf(&g.buf, "\t%s = vespa.TextField(%q)\n", id, fs.Name)
+	}
+	g.buf.WriteString(")\n")
+}
+
+// accessor returns the Go type and expression of the typed field builder for
+// a field of the given type. Indexed strings get text matching, other strings
+// are attributes. Only integer types offer in, which Vespa does not support
+// for floating point attributes. Struct members are named relative to the
+// enclosing sameElement field. Types that cannot be queried have no accessor.
+func (g *generator) accessor(t schema.Type, name string, indexed bool) (string, string, bool) {
+	switch t.Kind {
+	case schema.String, schema.URI:
+		if indexed {
+			return "vespa.TextFieldBuilder", fmt.Sprintf("vespa.TextField(%q)", name), true
+		}
+		return "vespa.StringFieldBuilder", fmt.Sprintf("vespa.StringField(%q)", name), true
+	case schema.Int, schema.Long, schema.Byte:
+		number := goType(t, nil)
+		return fmt.Sprintf("vespa.IntegerFieldBuilder[%s]", number), fmt.Sprintf("vespa.IntegerField[%s](%q)", number, name), true
+	case schema.Float, schema.Double:
+		number := goType(t, nil)
+		return fmt.Sprintf("vespa.NumericFieldBuilder[%s]", number), fmt.Sprintf("vespa.NumericField[%s](%q)", number, name), true
+	case schema.Bool:
+		return "vespa.BoolFieldBuilder", fmt.Sprintf("vespa.BoolField(%q)", name), true
+	case schema.Tensor:
+		return "vespa.TensorFieldBuilder", fmt.Sprintf("vespa.TensorField(%q)", name), true
+	case schema.Array, schema.WeightedSet:
+		if t.Element.Kind != schema.Struct {
+			// Conditions on arrays and weighted sets match any element
+			return g.accessor(*t.Element, name, indexed)
+		}
+		return g.members("vespa.ArrayFieldBuilder", fmt.Sprintf("vespa.ArrayField(%q)", name), g.structMembers(t.Element.Name, ""))
+	case schema.Map:
+		members := []member{{name: "key", typ: *t.Key}}
+		if t.Value.Kind == schema.Struct {
+			members = append(members, member{name: "value", typ: *t.Value, prefix: "value."})
+		} else {
+			members = append(members, member{name: "value", typ: *t.Value})
+		}
+		return g.members("vespa.MapFieldBuilder", fmt.Sprintf("vespa.MapField(%q)", name), members)
+	case schema.Struct:
+		return g.members("", "", g.structMembers(t.Name, name+"."))
+	default:
+		return "", "", false
+	}
+}
+
+// member is a field of a struct or map accessed inside sameElement
+type member struct {
+	name   string // Go field name source
+	typ    schema.Type
+	prefix string // path prefix of nested struct members
+}
+
+func (g *generator) structMembers(structName, prefix string) []member {
+	st, ok := g.schema.Struct(structName)
+	if !ok {
+		return nil
+	}
+	var members []member
+	for _, f := range st.Fields {
+		members = append(members, member{name: prefix + f.Name, typ: f.Type})
+	}
+	return members
+}
+
+// members returns an anonymous struct type embedding the builder of the
+// field, with a builder per member
+func (g *generator) members(embedded, embeddedExpr string, members []member) (string, string, bool) {
+	var fields, values []string
+	if embedded != "" {
+		fields = append(fields, embedded)
+		values = append(values, embeddedExpr)
+	}
+
+	for _, m := range members {
+		var typ, expr string
+		var ok bool
+		if m.prefix != "" {
+			typ, expr, ok = g.members("", "", g.structMembers(m.typ.Name, m.prefix))
+		} else {
+			typ, expr, ok = g.accessor(m.typ, m.name, false)
+		}
+		if !ok {
+			continue
+		}
+		id := goName(m.name[strings.LastIndex(m.name, ".")+1:])
+		fields = append(fields, fmt.Sprintf("%s %s", id, typ))
+		values = append(values, expr)
+	}
+
+	typ := fmt.Sprintf("struct {\n%s\n}", strings.Join(fields, "\n"))
+	return typ, fmt.Sprintf("%s{%s}", typ, strings.Join(values, ", ")), true
+}
+
+// fieldSettings describes how a field is indexed, e.g. " (attribute, summary)"
+func fieldSettings(f *schema.Field) string {
+	var settings []string
+	if f.IsIndex() {
+		settings = append(settings, "index")
+	}
+	if f.IsAttribute() {
+		settings = append(settings, "attribute")
+	}
+	if f.IsSummary() {
+		settings = append(settings, "summary")
+	}
+	if len(settings) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%s)", strings.Join(settings, ", "))
+}
+
+// documentTypes writes the document struct, the struct types it uses and
+// DecodeHit
+func (g *generator) documentTypes() {
+	fmt.Fprintf(&g.buf, "\n// Document holds the fields of a %s document, for feeding and for\n", g.schema.Document.Name)
+	g.buf.WriteString("// decoding search hits\n")
+	g.writeStruct("Document", g.schema.Document.Fields)
+
+	for _, st := range g.schema.Document.Structs {
+		fmt.Fprintf(&g.buf, "\n// %s is the struct %s\n", g.structs[st.Name], st.Name)
+		g.writeStruct(g.structs[st.Name], st.Fields)
+	}
+
+	g.buf.WriteString(`
+// DecodeHit decodes the fields of a search hit into a Document
+func DecodeHit(hit vespa.Hit) (*Document, error) {
+	data, err := json.Marshal(hit.Fields)
+	if err != nil {
+		return nil, err
+	}
+	var document Document
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	return &document, nil
+}
+`)
+}
+
+func (g *generator) writeStruct(name string, fields []*schema.Field) {
+	fmt.Fprintf(&g.buf, "type %s struct {\n", name)
+	used := make(map[string]bool)
+	for _, f := range fields {
+		id := goName(f.Name)
+		for used[id] {
+			id += "Field"
+		}
+		used[id] = true
+
+		typ := goType(f.Type, g.structs)
+		tag := f.Name
+		if strings.HasPrefix(typ, "[]") || strings.HasPrefix(typ, "map[") || typ == "interface{}" {
+			tag += ",omitempty"
+		}
+		fmt.Fprintf(&g.buf, "\t%s %s `json:%q`\n", id, typ, tag)
+	}
+	g.buf.WriteString("}\n")
+}
+
+// goType returns the Go type of a field type for feeding and decoding.
+// Tensors and positions use their JSON form.
+func goType(t schema.Type, structs map[string]string) string {
+	switch t.Kind {
+	case schema.String, schema.URI, schema.Predicate, schema.Reference:
+		return "string"
+	case schema.Int:
+		return "int32"
+	case schema.Long:
+		return "int64"
+	case schema.Float:
+		return "float32"
+	case schema.Double:
+		return "float64"
+	case schema.Byte:
+		return "int8"
+	case schema.Bool:
+		return "bool"
+	case schema.Raw:
+		return "[]byte"
+	case schema.Array:
+		return "[]" + goType(*t.Element, structs)
+	case schema.WeightedSet:
+		return fmt.Sprintf("map[%s]int32", goType(*t.Element, structs))
+	case schema.Map:
+		return fmt.Sprintf("map[%s]%s", goType(*t.Key, structs), goType(*t.Value, structs))
+	case schema.Struct:
+		if name, ok := structs[t.Name]; ok {
+			return name
+		}
+		return "map[string]interface{}"
+	default:
+		return "interface{}"
+	}
+}
+
+// goName converts a schema name such as "title_embedding" to an exported Go
+// identifier such as "TitleEmbedding"
+func goName(name string) string {
+	var b strings.Builder
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if initialisms[strings.ToLower(part)] {
+			b.WriteString(strings.ToUpper(part))
+			continue
+		}
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	id := b.String()
+	if id == "" || unicode.IsDigit(rune(id[0])) {
+		id = "F" + id
+	}
+	return id
+}
+
+// packageName converts a schema name to a Go package name
+func packageName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+	pkg := b.String()
+	if pkg == "" || unicode.IsDigit(rune(pkg[0])) {
+		pkg = "schema" + pkg
+	}
+	return pkg
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vipulsodha/vespa-go/schema"
+)
+
+const exampleDir = "../../examples/schema_codegen"
+
+// TestGenerateExample checks that the committed example package is up to
+// date with the generator
+func TestGenerateExample(t *testing.T) {
+	s, err := schema.ParseFile(filepath.Join(exampleDir, "schemas", "product.sd"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	code, err := generate(s, "product.sd")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected, err := os.ReadFile(filepath.Join(exampleDir, "product", "product.go"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(code) != string(expected) {
+		t.Errorf("Generated code differs from %s/product/product.go, run go generate ./examples/schema_codegen", exampleDir)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	s, err := schema.Parse([]byte(`
+schema catalog_item {
+    document catalog_item {
+        struct address {
+            field city type string {}
+            field zip type int {}
+        }
+        struct person {
+            field name type string {}
+            field home type address {}
+        }
+        field id type long {
+            indexing: summary | attribute
+        }
+        field url type uri {
+            indexing: summary | index
+        }
+        field scores type array<double> {
+            indexing: attribute
+        }
+        field owners type map<string, person> {
+            indexing: summary
+        }
+        field location type position {
+            indexing: attribute
+        }
+        field data type raw {
+            indexing: summary
+        }
+        field document type string {
+            indexing: index
+        }
+    }
+    field id_text type string {
+        indexing: input id | to_string | index
+    }
+    fieldset url {
+        fields: url
+    }
+}
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	code, err := generate(s, "catalog_item.sd")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// Compare with whitespace collapsed, since gofmt aligns struct fields
+	source := strings.Join(strings.Fields(string(code)), " ")
+
+	expected := []string{
+		"// Code generated by vespagen from catalog_item.sd. DO NOT EDIT.",
+		"package catalogitem",
+		`const DocumentType = "catalog_item"`,
+		`ID = vespa.IntegerField[int64]("id")`,
+		`URL = vespa.TextField("url")`,
+		`Scores = vespa.NumericField[float64]("scores")`,
+		`vespa.MapField("owners"), vespa.StringField("key")`,
+		`vespa.StringField("value.name")`,
+		`vespa.StringField("value.home.city"), vespa.IntegerField[int32]("value.home.zip")`,
+		`DocumentField = vespa.TextField("document")`,
+		`IDText = vespa.TextField("id_text")`,
+		`URLFieldset = vespa.TextField("url")`,
+		"ID int64 `json:\"id\"`",
+		"Owners map[string]Person `json:\"owners,omitempty\"`",
+		"Location interface{} `json:\"location,omitempty\"`",
+		"Data []byte `json:\"data,omitempty\"`",
+		"Home Address `json:\"home\"`",
+	}
+	for _, e := range expected {
+		if !strings.Contains(source, e) {
+			t.Errorf("Expected generated code to contain %q, got:\n%s", e, source)
+		}
+	}
+
+	unexpected := []string{
+		// Positions and raw fields have no accessor
+		`("location")`,
+		`("data")`,
+		// Synthetic fields are not part of the document
+		"`json:\"id_text\"`",
+	}
+	for _, u := range unexpected {
+		if strings.Contains(source, u) {
+			t.Errorf("Expected generated code not to contain %q", u)
+		}
+	}
+}
+
+func TestGoName(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"title", "Title"},
+		{"title_embedding", "TitleEmbedding"},
+		{"product-id", "ProductID"},
+		{"image_url", "ImageURL"},
+		{"inStock", "InStock"},
+		{"2d_vector", "F2dVector"},
+	}
+
+	for _, tt := range tests {
+		if result := goName(tt.input); result != tt.expected {
+			t.Errorf("Expected %q, got %q", tt.expected, result)
+		}
+	}
+}
+
+func TestPackageName(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"product", "product"},
+		{"Music_Track", "musictrack"},
+		{"2024news", "schema2024news"},
+	}
+
+	for _, tt := range tests {
+		if result := packageName(tt.input); result != tt.expected {
+			t.Errorf("Expected %q, got %q", tt.expected, result)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	out := t.TempDir()
+	if err := run(out, []string{filepath.Join(exampleDir, "schemas")}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "product", "product.go")); err != nil {
+		t.Errorf("Expected generated package: %v", err)
+	}
+
+	if err := run(out, []string{"missing.sd"}); err == nil {
+		t.Error("Expected error for missing schema file")
+	}
+}
@@ -0,0 +1,102 @@
+// Command vespagen generates typed field accessors and document types from
+// Vespa schema files.
+//
+// For every schema it writes a package named after the schema, e.g.
+// <out>/product/product.go for schema product:
+//
+//	q := vespa.NewQueryBuilder().
+//		From(product.DocumentType).
+//		Where(vespa.And(product.Title.Contains("shoes"), product.Price.Gte(100)))
+//
+// Accessors only offer the operators Vespa supports for the field type:
+// numeric fields offer comparisons and ranges, string fields text matching
+// and equality, tensor fields nearestNeighbor, and arrays of struct and maps
+// sameElement over their members. The Document struct holds the document
+// fields for feeding and DecodeHit decodes search hits into it.
+//
+// Usage:
+//
+//	vespagen [-out dir] [schema files or directories...]
+//
+// Directories are loaded like an application package schemas directory,
+// including rank profiles in separate .profile files. Without arguments,
+// vespagen reads ./schemas. Use it from go:generate:
+//
+//	//go:generate go run github.com/vipulsodha/vespa-go/cmd/vespagen -out . schemas
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/vipulsodha/vespa-go/schema"
+)
+
+func main() {
+	out := flag.String("out", ".", "directory to write the generated packages to")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: vespagen [-out dir] [schema files or directories...]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	paths := flag.Args()
+	if len(paths) == 0 {
+		paths = []string{"schemas"}
+	}
+	if err := run(*out, paths); err != nil {
+		fmt.Fprintf(os.Stderr, "vespagen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run generates a package for every schema found at paths
+func run(out string, paths []string) error {
+	for _, path := range paths {
+		schemas, err := load(path)
+		if err != nil {
+			return err
+		}
+		for source, s := range schemas {
+			code, err := generate(s, source)
+			if err != nil {
+				return err
+			}
+			dir := filepath.Join(out, packageName(s.Name))
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				return err
+			}
+			if err := os.WriteFile(filepath.Join(dir, packageName(s.Name)+".go"), code, 0o644); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// load reads a schema file or directory, keyed by schema file name
+func load(path string) (map[string]*schema.Schema, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		s, err := schema.ParseFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]*schema.Schema{filepath.Base(path): s}, nil
+	}
+
+	loaded, err := schema.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	schemas := make(map[string]*schema.Schema, len(loaded))
+	for _, s := range loaded {
+		schemas[s.Name+".sd"] = s
+	}
+	return schemas, nil
+}
@@ -61,7 +61,7 @@ func (f FieldBuilder) NotIn(values ...interface{}) WhereCondition {
 }
 
 // Contains creates a CONTAINS condition for text matching with optional matching type.
-// Supports exact, phrase, and fuzzy matching through functional options.
+// Supports exact, phrase, fuzzy, prefix and near matching through functional options.
 func (f FieldBuilder) Contains(value interface{}, opts ...ContainsOption) WhereCondition {
 	// Apply options to config (default to ExactMatch)
 	config := &ContainsConfig{Type: ExactMatch}
@@ -109,14 +109,16 @@ func (f FieldBuilder) NearestNeighbor(queryVector string, targetHits int, opts .
 		Label:             config.Label,
 		DistanceThreshold: config.DistanceThreshold,
 		Approximate:       config.Approximate,
+		Embedding:         config.Embedding,
 	}
 }
 
 // And combines multiple conditions with the AND boolean operator.
-// Returns a single condition if only one is provided, nil if none are provided.
+// Returns a single condition if only one is provided. With no conditions it
+// returns an empty AND, which is reported as an error when the query is built.
 func And(conditions ...WhereCondition) WhereCondition {
 	if len(conditions) == 0 {
-		return nil
+		return &JunctionCondition{Operator: "AND"}
 	}
 	if len(conditions) == 1 {
 		return conditions[0]
@@ -135,10 +137,11 @@ func And(conditions ...WhereCondition) WhereCondition {
 }
 
 // Or combines multiple conditions with the OR boolean operator.
-// Returns a single condition if only one is provided, nil if none are provided.
+// Returns a single condition if only one is provided. With no conditions it
+// returns an empty OR, which is reported as an error when the query is built.
 func Or(conditions ...WhereCondition) WhereCondition {
 	if len(conditions) == 0 {
-		return nil
+		return &JunctionCondition{Operator: "OR"}
 	}
 	if len(conditions) == 1 {
 		return conditions[0]
@@ -215,13 +218,13 @@ func (fc *FieldCondition) ToYQL() string {
 	switch fc.Operator {
 	case EQ:
 		// For string values, use 'contains' for exact matching in Vespa
-		if _, isString := fc.Value.(string); isString {
+		if isStringValue(fc.Value) {
 			return fmt.Sprintf("(%s contains %s)", fc.Field, formatValue(fc.Value))
 		}
 		return fmt.Sprintf("(%s = %s)", fc.Field, formatValue(fc.Value))
 	case NEQ:
 		// For string values, use 'not contains' for exact matching in Vespa
-		if _, isString := fc.Value.(string); isString {
+		if isStringValue(fc.Value) {
 			return fmt.Sprintf("!(%s contains %s)", fc.Field, formatValue(fc.Value))
 		}
 		return fmt.Sprintf("(%s != %s)", fc.Field, formatValue(fc.Value))
@@ -259,6 +262,17 @@ func (fc *FieldCondition) ToYQL() string {
 		case FuzzyMatch:
 			// For fuzzy matching, we can use a custom implementation
 			return fmt.Sprintf("(%s contains fuzzy(%s))", fc.Field, formatValue(fc.Value))
+		case PrefixMatch:
+			return fmt.Sprintf("(%s contains ({prefix:true}%s))", fc.Field, formatValue(fc.Value))
+		case NearMatch:
+			if keywords, ok := fc.Value.([]string); ok {
+				var quotedKeywords []string
+				for _, kw := range keywords {
+					quotedKeywords = append(quotedKeywords, fmt.Sprintf("'%s'", escapeString(kw)))
+				}
+				return fmt.Sprintf("(%s contains near(%s))", fc.Field, strings.Join(quotedKeywords, ", "))
+			}
+			return fmt.Sprintf("(%s contains near(%s))", fc.Field, formatValue(fc.Value))
 		default:
 			return fmt.Sprintf("(%s contains %s)", fc.Field, formatValue(fc.Value))
 		}
@@ -302,6 +316,65 @@ func (bc *BooleanCondition) Or(condition WhereCondition) WhereCondition {
 	return Or(bc, condition)
 }
 
+// =============================================================================
+// JunctionCondition
+// =============================================================================
+
+// JunctionCondition represents an n-ary AND/OR of conditions, rendered flat as
+// (A AND B AND C). It is produced by Simplify when flattening chains of
+// BooleanConditions.
+type JunctionCondition struct {
+	Conditions []WhereCondition
+	Operator   string // "AND" or "OR"
+}
+
+func (jc *JunctionCondition) ToYQL() string {
+	parts := make([]string, 0, len(jc.Conditions))
+	for _, condition := range jc.Conditions {
+		parts = append(parts, condition.ToYQL())
+	}
+	return fmt.Sprintf("(%s)", strings.Join(parts, fmt.Sprintf(" %s ", jc.Operator)))
+}
+
+func (jc *JunctionCondition) And(condition WhereCondition) WhereCondition {
+	return And(jc, condition)
+}
+
+func (jc *JunctionCondition) Or(condition WhereCondition) WhereCondition {
+	return Or(jc, condition)
+}
+
+// =============================================================================
+// ConstantCondition
+// =============================================================================
+
+// True creates a condition that matches all documents
+func True() WhereCondition {
+	return &ConstantCondition{Value: true}
+}
+
+// False creates a condition that matches no documents
+func False() WhereCondition {
+	return &ConstantCondition{Value: false}
+}
+
+// ConstantCondition represents the YQL constants true and false
+type ConstantCondition struct {
+	Value bool
+}
+
+func (cc *ConstantCondition) ToYQL() string {
+	return fmt.Sprintf("%t", cc.Value)
+}
+
+func (cc *ConstantCondition) And(condition WhereCondition) WhereCondition {
+	return And(cc, condition)
+}
+
+func (cc *ConstantCondition) Or(condition WhereCondition) WhereCondition {
+	return Or(cc, condition)
+}
+
 // =============================================================================
 // RangeCondition
 // =============================================================================
@@ -339,6 +412,7 @@ type NearestNeighbor struct {
 	Label             string
 	DistanceThreshold *float64
 	Approximate       *bool
+	Embedding         *Embedding // server-side embedding of the query vector, if any
 }
 
 func (nn *NearestNeighbor) ToYQL() string {
@@ -349,7 +423,7 @@ func (nn *NearestNeighbor) ToYQL() string {
 
 	// Add label if specified
 	if nn.Label != "" {
-		params = append(params, fmt.Sprintf("label:'%s'", nn.Label))
+		params = append(params, fmt.Sprintf("label:'%s'", escapeString(nn.Label)))
 	}
 
 	// Add distance threshold if specified
@@ -387,6 +461,10 @@ func formatValue(value interface{}) string {
 	switch v := value.(type) {
 	case string:
 		return fmt.Sprintf("'%s'", escapeString(v))
+	case *Placeholder:
+		return v.marker()
+	case maskedValue:
+		return "?"
 	case int, int8, int16, int32, int64:
 		return fmt.Sprintf("%v", v)
 	case uint, uint8, uint16, uint32, uint64:
@@ -417,7 +495,8 @@ func formatInValues(value interface{}) string {
 }
 
 func escapeString(s string) string {
-	// Escape single quotes in strings for YQL
+	// Escape backslashes before single quotes, so a trailing backslash cannot escape the closing quote
+	s = strings.ReplaceAll(s, "\\", "\\\\")
 	return strings.ReplaceAll(s, "'", "\\'")
 }
 
@@ -0,0 +1,138 @@
+package vespa
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/vipulsodha/vespa-go/tensor"
+)
+
+// =============================================================================
+// Embedding
+// =============================================================================
+
+// Embedding represents a server-side embed() invocation used as a query input
+// value, so text is embedded by a Vespa embedder instead of the client:
+//
+//	input.query(q)=embed(e5, @q_text)
+type Embedding struct {
+	EmbedderID string // may be empty when the application has a single embedder
+	Text       string
+	Param      string // request parameter the text is bound to, if any
+}
+
+// Embed creates an embed() value that embeds text with the given embedder.
+// Use BindTo to pass the text as a separate request parameter instead of inline.
+//
+// Example:
+//
+//	builder.WithInput("input.query(q)", Embed("e5", "wireless headphones").BindTo("q_text"))
+func Embed(embedderID, text string) *Embedding {
+	return &Embedding{EmbedderID: embedderID, Text: text}
+}
+
+// BindTo returns a copy of the embedding whose text is passed as the named
+// request parameter and referenced as @param inside embed()
+func (e *Embedding) BindTo(param string) *Embedding {
+	bound := *e
+	bound.Param = param
+	return &bound
+}
+
+// String renders the embed() expression, e.g. embed(e5, @q_text) or embed(e5, "text")
+func (e *Embedding) String() string {
+	var args []string
+	if e.EmbedderID != "" {
+		args = append(args, e.EmbedderID)
+	}
+	if e.Param != "" {
+		args = append(args, "@"+e.Param)
+	} else {
+		args = append(args, fmt.Sprintf("\"%s\"", escapeEmbedText(e.Text)))
+	}
+	return fmt.Sprintf("embed(%s)", strings.Join(args, ", "))
+}
+
+// MarshalJSON serializes the embedding as its embed() expression
+func (e *Embedding) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.String())
+}
+
+// validate checks the embedding for values Vespa would reject
+func (e *Embedding) validate(field string) error {
+	if e.Text == "" {
+		return &ValidationError{
+			Field:   field,
+			Message: "embed() requires a non-empty text",
+		}
+	}
+	if e.Param != "" && !tensor.IsIdentifier(e.Param) {
+		return &ValidationError{
+			Field:   field,
+			Message: fmt.Sprintf("invalid embed() text parameter name '%s'", e.Param),
+		}
+	}
+	if strings.ContainsAny(e.EmbedderID, " ,()@\"") {
+		return &ValidationError{
+			Field:   field,
+			Message: fmt.Sprintf("invalid embedder id '%s'", e.EmbedderID),
+		}
+	}
+	return nil
+}
+
+// bind validates the embedding and binds its text to its request parameter,
+// rejecting a parameter already set to a different value
+func (e *Embedding) bind(field string, parameters map[string]interface{}) error {
+	if err := e.validate(field); err != nil {
+		return err
+	}
+	if e.Param == "" {
+		return nil
+	}
+	if existing, ok := parameters[e.Param]; ok && existing != e.Text {
+		return &ValidationError{
+			Field:   field,
+			Message: fmt.Sprintf("embed() text parameter '%s' is already bound to a different value", e.Param),
+		}
+	}
+	parameters[e.Param] = e.Text
+	return nil
+}
+
+// WithQueryEmbedding sets the query vector of a nearest neighbor operation to
+// be embedded server-side. The builder adds the matching input.query() value.
+func WithQueryEmbedding(embedding *Embedding) NearestNeighborOption {
+	return func(config *NearestNeighborConfig) {
+		if config != nil {
+			config.Embedding = embedding
+		}
+	}
+}
+
+// =============================================================================
+// Helper Functions
+// =============================================================================
+
+func escapeEmbedText(s string) string {
+	s = strings.ReplaceAll(s, "\\", "\\\\")
+	return strings.ReplaceAll(s, "\"", "\\\"")
+}
+
+// collectNearestNeighbors calls visit with every nearest neighbor operation in a
+// condition tree and its path, using the same path segments as Validate
+func collectNearestNeighbors(condition WhereCondition, path string, visit func(*NearestNeighbor, string)) {
+	switch c := condition.(type) {
+	case *NearestNeighbor:
+		visit(c, path)
+	case *SameElementCondition:
+		for i, inner := range c.Conditions {
+			collectNearestNeighbors(inner, joinPath(path, fmt.Sprintf("conditions[%d]", i)), visit)
+		}
+	case Node:
+		for i, child := range c.Children() {
+			collectNearestNeighbors(child, joinPath(path, childSegment(c, i)), visit)
+		}
+	}
+}
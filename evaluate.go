@@ -0,0 +1,566 @@
+package vespa
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// =============================================================================
+// Offline Evaluation
+// =============================================================================
+
+// fuzzyMaxEditDistance is Vespa's default maxEditDistance for fuzzy matching
+const fuzzyMaxEditDistance = 2
+
+// nearDistance is Vespa's default distance for near matching
+const nearDistance = 2
+
+// Evaluate reports whether a document matches a condition, following Vespa's
+// matching semantics, so filter logic can be unit-tested without a running
+// Vespa instance. The document is a map[string]interface{} or a struct;
+// struct fields are matched by `vespa` tag, `json` tag or name.
+//
+// Conditions on multi-value fields (slices, arrays and maps) match when any
+// element matches. Maps are weighted sets for contains and in, and maps with
+// key/value entries for sameElement and the .key and .value paths. Dotted
+// paths address nested structs and maps, e.g. person.address.city.
+//
+// String matching is case-insensitive: contains matches a whole value or a
+// token of it, phrase() matches consecutive tokens, near() matches keywords
+// in any order with at most 2 positions between adjacent keywords, fuzzy()
+// allows an edit distance of 2, prefix matches the start of a value and
+// matches is an unanchored regular expression.
+//
+// nearestNeighbor, userQuery and custom features depend on the index and
+// cannot be evaluated; they are reported as an error.
+func Evaluate(condition WhereCondition, document interface{}) (bool, error) {
+	return evaluate(condition, reflect.ValueOf(document))
+}
+
+func evaluate(condition WhereCondition, document reflect.Value) (bool, error) {
+	if isNilCondition(condition) {
+		return false, &ValidationError{Field: "condition", Message: "condition is nil"}
+	}
+
+	switch c := condition.(type) {
+	case *FieldCondition:
+		return evaluateField(c, fieldValues(document, c.Field))
+	case *RangeCondition:
+		for _, value := range fieldValues(document, c.Field) {
+			lower, lowerOK := compareValues(value, c.Min)
+			upper, upperOK := compareValues(value, c.Max)
+			if lowerOK && upperOK && lower >= 0 && upper <= 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	case *BooleanCondition:
+		return evaluateJunction(c.Operator, []WhereCondition{c.Left, c.Right}, document)
+	case *JunctionCondition:
+		return evaluateJunction(c.Operator, c.Conditions, document)
+	case *NotCondition:
+		matched, err := evaluate(c.Condition, document)
+		return !matched && err == nil, err
+	case *ConstantCondition:
+		return c.Value, nil
+	case *SameElementCondition:
+		for _, element := range sameElements(document, c.Field) {
+			matched, err := evaluateJunction("AND", c.Conditions, element)
+			if err != nil || matched {
+				return matched, err
+			}
+		}
+		return false, nil
+	default:
+		return false, &ValidationError{
+			Field:   "condition",
+			Message: fmt.Sprintf("%s cannot be evaluated without a Vespa index", condition.ToYQL()),
+		}
+	}
+}
+
+func evaluateJunction(operator string, conditions []WhereCondition, document reflect.Value) (bool, error) {
+	and := strings.EqualFold(operator, "AND")
+	for _, condition := range conditions {
+		matched, err := evaluate(condition, document)
+		if err != nil {
+			return false, err
+		}
+		if matched != and {
+			return matched, nil
+		}
+	}
+	return and, nil
+}
+
+func evaluateField(fc *FieldCondition, values []interface{}) (bool, error) {
+	if p, ok := fc.Value.(*Placeholder); ok {
+		return false, &ValidationError{Field: fc.Field, Message: fmt.Sprintf("template parameter '%s' cannot be evaluated", p.Name)}
+	}
+
+	matchAny := func(match func(value interface{}) bool) bool {
+		for _, value := range values {
+			if match(value) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch fc.Operator {
+	case EQ:
+		return matchAny(func(v interface{}) bool { return containsValue(v, fc.Value) }), nil
+	case NEQ:
+		return !matchAny(func(v interface{}) bool { return containsValue(v, fc.Value) }), nil
+	case GT, GTE, LT, LTE:
+		return matchAny(func(v interface{}) bool {
+			c, ok := compareValues(v, fc.Value)
+			if !ok {
+				return false
+			}
+			switch fc.Operator {
+			case GT:
+				return c > 0
+			case GTE:
+				return c >= 0
+			case LT:
+				return c < 0
+			default:
+				return c <= 0
+			}
+		}), nil
+	case IN, NOT_IN:
+		matched := matchAny(func(v interface{}) bool {
+			for _, candidate := range sliceValues(fc.Value) {
+				if equalValues(v, candidate) {
+					return true
+				}
+			}
+			return false
+		})
+		return matched == (fc.Operator == IN), nil
+	case CONTAINS, NOT_CONTAINS:
+		match, err := containsMatcher(fc)
+		if err != nil {
+			return false, err
+		}
+		return matchAny(match) == (fc.Operator == CONTAINS), nil
+	case MATCHES:
+		pattern, ok := fc.Value.(string)
+		if !ok {
+			return false, &ValidationError{Field: fc.Field, Message: "matches requires a string pattern"}
+		}
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return false, &ValidationError{Field: fc.Field, Message: fmt.Sprintf("invalid regular expression: %v", err)}
+		}
+		return matchAny(func(v interface{}) bool {
+			s, ok := v.(string)
+			return ok && re.MatchString(s)
+		}), nil
+	default:
+		return false, &ValidationError{Field: fc.Field, Message: fmt.Sprintf("unknown operator '%s'", fc.Operator)}
+	}
+}
+
+// containsMatcher returns the function matching a single field value for a
+// contains condition
+func containsMatcher(fc *FieldCondition) (func(interface{}) bool, error) {
+	switch fc.ContainsType {
+	case PhraseMatch:
+		var phrase []string
+		switch v := fc.Value.(type) {
+		case []string:
+			for _, keyword := range v {
+				phrase = append(phrase, textTokens(keyword)...)
+			}
+		case string:
+			phrase = textTokens(v)
+		default:
+			return nil, &ValidationError{Field: fc.Field, Message: "phrase requires a string or []string value"}
+		}
+		return func(v interface{}) bool {
+			s, ok := v.(string)
+			return ok && containsPhrase(textTokens(s), phrase)
+		}, nil
+	case FuzzyMatch:
+		term, ok := fc.Value.(string)
+		if !ok {
+			return nil, &ValidationError{Field: fc.Field, Message: "fuzzy requires a string value"}
+		}
+		term = strings.ToLower(term)
+		return func(v interface{}) bool {
+			s, ok := v.(string)
+			return ok && editDistance(strings.ToLower(s), term) <= fuzzyMaxEditDistance
+		}, nil
+	case PrefixMatch:
+		prefix, ok := fc.Value.(string)
+		if !ok {
+			return nil, &ValidationError{Field: fc.Field, Message: "prefix requires a string value"}
+		}
+		prefix = strings.ToLower(prefix)
+		return func(v interface{}) bool {
+			s, ok := v.(string)
+			return ok && strings.HasPrefix(strings.ToLower(s), prefix)
+		}, nil
+	case NearMatch:
+		keywords, ok := fc.Value.([]string)
+		if !ok {
+			return nil, &ValidationError{Field: fc.Field, Message: "near requires a []string value"}
+		}
+		var terms []string
+		for _, keyword := range keywords {
+			terms = append(terms, textTokens(keyword)...)
+		}
+		return func(v interface{}) bool {
+			s, ok := v.(string)
+			return ok && containsNear(textTokens(s), terms)
+		}, nil
+	default:
+		return func(v interface{}) bool { return containsValue(v, fc.Value) }, nil
+	}
+}
+
+// =============================================================================
+// Values
+// =============================================================================
+
+// containsValue matches a field value against a contains or equality term:
+// strings match a whole value or, like an indexed field, a token sequence of it
+func containsValue(fieldValue, value interface{}) bool {
+	if s, ok := value.(string); ok {
+		fs, ok := fieldValue.(string)
+		if !ok {
+			return false
+		}
+		term := textTokens(s)
+		return strings.EqualFold(fs, s) || (len(term) > 0 && containsPhrase(textTokens(fs), term))
+	}
+	return equalValues(fieldValue, value)
+}
+
+// equalValues compares a field value with a condition value; strings must
+// match the whole value
+func equalValues(fieldValue, value interface{}) bool {
+	if s, ok := value.(string); ok {
+		fs, ok := fieldValue.(string)
+		return ok && strings.EqualFold(fs, s)
+	}
+	if value == nil {
+		return fieldValue == nil
+	}
+	if b, ok := value.(bool); ok {
+		fb, ok := fieldValue.(bool)
+		return ok && fb == b
+	}
+	c, ok := compareValues(fieldValue, value)
+	return ok && c == 0
+}
+
+// compareValues compares two numbers, returning -1, 0 or 1; ok is false when
+// either value is not a number
+func compareValues(a, b interface{}) (c int, ok bool) {
+	x, ok := numberValue(a)
+	if !ok {
+		return 0, false
+	}
+	y, ok := numberValue(b)
+	if !ok {
+		return 0, false
+	}
+	switch {
+	case x < y:
+		return -1, true
+	case x > y:
+		return 1, true
+	default:
+		return 0, true
+	}
+}
+
+func numberValue(value interface{}) (float64, bool) {
+	if n, ok := value.(json.Number); ok {
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	default:
+		return 0, false
+	}
+}
+
+func sliceValues(value interface{}) []interface{} {
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return []interface{}{value}
+	}
+	values := make([]interface{}, rv.Len())
+	for i := range values {
+		values[i] = rv.Index(i).Interface()
+	}
+	return values
+}
+
+// textTokens lowercases a string and splits it into letter and digit sequences
+func textTokens(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// containsNear reports whether tokens contain an occurrence of every term
+// such that, in position order, each occurrence is at most nearDistance
+// positions after the previous one. Like Vespa's near distance, this bounds
+// the gap between adjacent terms, so the span may grow with the number of
+// terms.
+func containsNear(tokens, terms []string) bool {
+	if len(terms) == 0 {
+		return false
+	}
+	index := make(map[string]int)
+	for _, term := range terms {
+		if _, ok := index[term]; !ok {
+			index[term] = len(index)
+		}
+	}
+
+	// For each recent occurrence, the sets of terms (as bitsets) covered by
+	// chains of occurrences ending at it
+	full := ""
+	for term := 0; term < len(index); term++ {
+		full = addTerm(full, term, len(index))
+	}
+	type occurrence struct {
+		position int
+		covered  map[string]bool
+	}
+	var recent []occurrence
+	for position, token := range tokens {
+		term, ok := index[token]
+		if !ok {
+			continue
+		}
+		for len(recent) > 0 && position-recent[0].position > nearDistance {
+			recent = recent[1:]
+		}
+
+		current := occurrence{position: position, covered: map[string]bool{addTerm("", term, len(index)): true}}
+		for _, previous := range recent {
+			for set := range previous.covered {
+				if set[term/8]&(1<<(term%8)) == 0 {
+					current.covered[addTerm(set, term, len(index))] = true
+				}
+			}
+		}
+		if current.covered[full] {
+			return true
+		}
+		recent = append(recent, current)
+	}
+	return false
+}
+
+// addTerm returns the bitset of n terms set with term added
+func addTerm(set string, term, n int) string {
+	result := make([]byte, (n+7)/8)
+	copy(result, set)
+	result[term/8] |= 1 << (term % 8)
+	return string(result)
+}
+
+func containsPhrase(tokens, phrase []string) bool {
+	if len(phrase) == 0 {
+		return false
+	}
+	for i := 0; i+len(phrase) <= len(tokens); i++ {
+		matched := true
+		for j, token := range phrase {
+			if tokens[i+j] != token {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// editDistance returns the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
+
+// =============================================================================
+// Document Access
+// =============================================================================
+
+// fieldValues returns the values of a dotted field path in a document,
+// flattening slices and the entries of weighted sets
+func fieldValues(document reflect.Value, path string) []interface{} {
+	var values []interface{}
+	for _, value := range resolvePath(document, strings.Split(path, ".")) {
+		values = appendLeafValues(values, value)
+	}
+	return values
+}
+
+func appendLeafValues(values []interface{}, value reflect.Value) []interface{} {
+	value = indirect(value)
+	if !value.IsValid() {
+		return values
+	}
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			// []byte is a raw value, not an array
+			return append(values, value.Interface())
+		}
+		for i := 0; i < value.Len(); i++ {
+			values = appendLeafValues(values, value.Index(i))
+		}
+		return values
+	case reflect.Map:
+		// A weighted set: contains and in match its keys
+		for _, key := range value.MapKeys() {
+			values = append(values, key.Interface())
+		}
+		return values
+	default:
+		return append(values, value.Interface())
+	}
+}
+
+// resolvePath follows a field path through structs, maps and slices
+func resolvePath(value reflect.Value, path []string) []reflect.Value {
+	value = indirect(value)
+	if !value.IsValid() {
+		return nil
+	}
+	if len(path) == 0 {
+		return []reflect.Value{value}
+	}
+
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		var values []reflect.Value
+		for i := 0; i < value.Len(); i++ {
+			values = append(values, resolvePath(value.Index(i), path)...)
+		}
+		return values
+	case reflect.Map:
+		if value.Type().Key().Kind() == reflect.String {
+			if field := value.MapIndex(reflect.ValueOf(path[0]).Convert(value.Type().Key())); field.IsValid() {
+				return resolvePath(field, path[1:])
+			}
+		}
+		// A map field: .key and .value address all keys and values
+		var values []reflect.Value
+		switch path[0] {
+		case "key":
+			for _, key := range value.MapKeys() {
+				values = append(values, resolvePath(key, path[1:])...)
+			}
+		case "value":
+			iter := value.MapRange()
+			for iter.Next() {
+				values = append(values, resolvePath(iter.Value(), path[1:])...)
+			}
+		}
+		return values
+	case reflect.Struct:
+		if field, ok := structField(value, path[0]); ok {
+			return resolvePath(field, path[1:])
+		}
+	}
+	return nil
+}
+
+// sameElements returns the elements of an array of structs or the entries of
+// a map field as documents for the conditions inside sameElement
+func sameElements(document reflect.Value, path string) []reflect.Value {
+	var elements []reflect.Value
+	for _, value := range resolvePath(document, strings.Split(path, ".")) {
+		value = indirect(value)
+		switch value.Kind() {
+		case reflect.Slice, reflect.Array:
+			for i := 0; i < value.Len(); i++ {
+				elements = append(elements, value.Index(i))
+			}
+		case reflect.Map:
+			iter := value.MapRange()
+			for iter.Next() {
+				elements = append(elements, reflect.ValueOf(map[string]interface{}{
+					"key":   iter.Key().Interface(),
+					"value": iter.Value().Interface(),
+				}))
+			}
+		}
+	}
+	return elements
+}
+
+// structField finds a struct field by vespa tag, json tag, name or
+// case-insensitive name
+func structField(value reflect.Value, name string) (reflect.Value, bool) {
+	fields := reflect.VisibleFields(value.Type())
+	for _, tag := range []string{"vespa", "json"} {
+		for _, field := range fields {
+			if field.IsExported() && strings.Split(field.Tag.Get(tag), ",")[0] == name {
+				return fieldByIndex(value, field.Index)
+			}
+		}
+	}
+	for _, field := range fields {
+		if field.IsExported() && !field.Anonymous && strings.EqualFold(field.Name, name) {
+			return fieldByIndex(value, field.Index)
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// fieldByIndex returns a possibly promoted struct field; fields of nil
+// embedded pointers are missing
+func fieldByIndex(value reflect.Value, index []int) (reflect.Value, bool) {
+	field, err := value.FieldByIndexErr(index)
+	return field, err == nil
+}
+
+func indirect(value reflect.Value) reflect.Value {
+	for value.IsValid() && (value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface) {
+		if value.IsNil() {
+			return reflect.Value{}
+		}
+		value = value.Elem()
+	}
+	return value
+}
@@ -0,0 +1,153 @@
+package vespa
+
+import "testing"
+
+type testSize struct {
+	Family string `json:"family"`
+	Value  string `json:"size_value"`
+	Stock  int    `vespa:"stock"`
+}
+
+type testProduct struct {
+	Title      string            `json:"title"`
+	Brand      string            `json:"brand"`
+	Price      float64           `json:"price"`
+	InStock    bool              `json:"in_stock"`
+	Tags       []string          `json:"tags"`
+	Categories map[string]int    `json:"categories"` // weighted set
+	Attributes map[string]string `json:"attributes"`
+	Sizes      []testSize        `json:"sizes"`
+	Seller     *testSeller       `json:"seller"`
+}
+
+type testSeller struct {
+	Name    string
+	Country string `json:"country"`
+}
+
+func TestEvaluate(t *testing.T) {
+	product := testProduct{
+		Title:      "Nike Air Zoom Pegasus running shoes",
+		Brand:      "Nike",
+		Price:      129.99,
+		InStock:    true,
+		Tags:       []string{"running", "road"},
+		Categories: map[string]int{"shoes": 100, "sports": 50},
+		Attributes: map[string]string{"color": "red", "material": "mesh"},
+		Sizes: []testSize{
+			{Family: "US", Value: "10", Stock: 0},
+			{Family: "EU", Value: "44", Stock: 3},
+		},
+		Seller: &testSeller{Name: "Sports Shop", Country: "NO"},
+	}
+
+	tests := []struct {
+		name      string
+		condition WhereCondition
+		expected  bool
+	}{
+		{"String equality is case-insensitive", Field("brand").Eq("nike"), true},
+		{"Contains token", Field("title").Contains("pegasus"), true},
+		{"Contains token sequence", Field("title").Contains("running shoes"), true},
+		{"Contains missing token", Field("title").Contains("trail"), false},
+		{"Not equal", Field("brand").NotEq("adidas"), true},
+		{"Numeric comparison", Field("price").Lt(150), true},
+		{"Numeric comparison false", Field("price").Gte(130), false},
+		{"Range", Field("price").Between(100, 130), true},
+		{"Bool", Field("in_stock").Eq(true), true},
+		{"Array contains", Field("tags").Contains("road"), true},
+		{"Array in", Field("tags").In("trail", "running"), true},
+		{"Not in", Field("brand").NotIn("adidas", "puma"), true},
+		{"In matches whole values", Field("title").In("nike"), false},
+		{"Weighted set", Field("categories").Contains("shoes"), true},
+		{"Phrase", Field("title").Contains([]string{"air", "zoom"}, WithPhraseMatching()), true},
+		{"Phrase out of order", Field("title").Contains([]string{"zoom", "air"}, WithPhraseMatching()), false},
+		{"Fuzzy", Field("brand").Contains("nkie", WithFuzzyMatching()), true},
+		{"Prefix", Field("brand").Contains("NI", WithPrefixMatching()), true},
+		{"Prefix inside value", Field("brand").Contains("ike", WithPrefixMatching()), false},
+		{"Near", Field("title").Contains([]string{"shoes", "pegasus"}, WithNearMatching()), true},
+		{"Near too far apart", Field("title").Contains([]string{"nike", "shoes"}, WithNearMatching()), false},
+		{"Near with repeated terms", Field("title").Contains([]string{"air", "air"}, WithNearMatching()), true},
+		{"Matches", &FieldCondition{Field: "title", Operator: MATCHES, Value: "^nike.*shoes$"}, true},
+		{"Map keys", Field("attributes").Key().Eq("color"), true},
+		{"Map values", Field("attributes").Value().Eq("mesh"), true},
+		{"Map entry", Field("attributes").ContainsEntry("color", Value().Eq("red")), true},
+		{"Map entry across entries", Field("attributes").ContainsEntry("color", Value().Eq("mesh")), false},
+		{"sameElement", Field("sizes").ContainsSameElement(Field("family").Eq("EU"), Field("stock").Gt(0)), true},
+		{"sameElement across elements", Field("sizes").ContainsSameElement(Field("family").Eq("US"), Field("stock").Gt(0)), false},
+		{"Struct array path", Field("sizes.size_value").Eq("44"), true},
+		{"Nested struct", Field("seller").Path("name").Contains("sports"), true},
+		{"Missing field", Field("color").Eq("red"), false},
+		{"Missing field not equal", Field("color").NotEq("red"), true},
+		{"Boolean logic", And(Field("brand").Eq("nike"), Or(Field("price").Gt(200), Not(Field("in_stock").Eq(false)))), true},
+		{"Junction", &JunctionCondition{Operator: "OR", Conditions: []WhereCondition{False(), Field("tags").Contains("road")}}, true},
+		{"Constant", False(), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, document := range []interface{}{product, &product} {
+				matched, err := Evaluate(tt.condition, document)
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if matched != tt.expected {
+					t.Errorf("Expected %v for %s, got %v", tt.expected, tt.condition.ToYQL(), matched)
+				}
+			}
+		})
+	}
+}
+
+func TestEvaluateMapDocument(t *testing.T) {
+	document := map[string]interface{}{
+		"title": "Trail shoes",
+		"price": 80,
+		"sizes": []interface{}{
+			map[string]interface{}{"family": "US", "size_value": "10"},
+			map[string]interface{}{"family": "EU", "size_value": "44"},
+		},
+		"stock": map[string]interface{}{
+			"oslo": map[string]interface{}{"count": 2},
+		},
+	}
+
+	tests := []struct {
+		condition WhereCondition
+		expected  bool
+	}{
+		{Field("price").Between(50, 100), true},
+		{Field("title").Contains("trail"), true},
+		{Field("sizes").ContainsSameElement(Field("family").Eq("US"), Field("size_value").Eq("10")), true},
+		{Field("sizes").ContainsSameElement(Field("family").Eq("US"), Field("size_value").Eq("44")), false},
+		{Field("stock").ContainsEntry("oslo", Value("count").Gt(0)), true},
+		{Field("stock").ContainsEntry("bergen", Value("count").Gt(0)), false},
+	}
+
+	for _, tt := range tests {
+		matched, err := Evaluate(tt.condition, document)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if matched != tt.expected {
+			t.Errorf("Expected %v for %s, got %v", tt.expected, tt.condition.ToYQL(), matched)
+		}
+	}
+}
+
+func TestEvaluateErrors(t *testing.T) {
+	conditions := []WhereCondition{
+		Field("embedding").NearestNeighbor("q", 10),
+		UserQuery(),
+		Custom("weakAnd(title contains 'x')"),
+		And(Field("a").Eq(1), nil),
+		Field("category").Eq(Param("category", StringParam)),
+		&FieldCondition{Field: "title", Operator: MATCHES, Value: "("},
+	}
+
+	for _, condition := range conditions {
+		if _, err := Evaluate(condition, map[string]interface{}{"a": 1}); err == nil {
+			t.Errorf("Expected error evaluating %T", condition)
+		}
+	}
+}
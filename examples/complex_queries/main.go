@@ -50,6 +50,8 @@ func sameElementQueries() {
 				vespa.Field("brand").Contains("nike"),
 			),
 		).
+		// Vespa rejects in inside sameElement, expand it into OR-ed sameElements
+		Rewrite(vespa.DistributeSameElement).
 		WithHits(25).
 		Build()
 
@@ -0,0 +1,99 @@
+// This example builds queries with field accessors generated from
+// schemas/product.sd. Regenerate the product package after changing the
+// schema with:
+//
+//	go generate ./examples/schema_codegen
+package main
+
+//go:generate go run ../../cmd/vespagen -out . schemas
+
+import (
+	"fmt"
+	"log"
+
+	vespa "github.com/vipulsodha/vespa-go"
+	"github.com/vipulsodha/vespa-go/examples/schema_codegen/product"
+)
+
+func main() {
+	fmt.Println("Generated Field Accessor Examples")
+	fmt.Println("=================================")
+
+	// Example 1: Typed filters
+	typedFilters()
+
+	// Example 2: sameElement over struct and map members
+	sameElementSearch()
+
+	// Example 3: Vector search and hit decoding
+	vectorSearch()
+}
+
+func typedFilters() {
+	fmt.Println("\n1. Typed Filters:")
+
+	// product.Price.Gte takes an int32, and product.Title has no Gte at all
+	yql, err := vespa.NewQueryBuilder().
+		Select("title", "price").
+		From(product.DocumentType).
+		Where(
+			vespa.And(
+				product.Default.Contains("running shoes"),
+				product.Price.Between(50, 150),
+				product.Brand.In("nike", "adidas"),
+				product.InStock.Eq(true),
+			),
+		).
+		OrderBy(product.Rating.Name(), vespa.Descending).
+		BuildYQL()
+	if err != nil {
+		log.Fatalf("Error building query: %v", err)
+	}
+	fmt.Println(yql)
+}
+
+func sameElementSearch() {
+	fmt.Println("\n2. sameElement over Members:")
+
+	yql, err := vespa.NewQueryBuilder().
+		From(product.DocumentType).
+		Where(
+			vespa.And(
+				product.Sizes.ContainsSameElement(
+					product.Sizes.Label.Eq("M"),
+					product.Sizes.Stock.Gt(0),
+				),
+				product.Attributes.ContainsEntry("color", product.Attributes.Value.Eq("red")),
+			),
+		).
+		BuildYQL()
+	if err != nil {
+		log.Fatalf("Error building query: %v", err)
+	}
+	fmt.Println(yql)
+}
+
+func vectorSearch() {
+	fmt.Println("\n3. Vector Search and Hit Decoding:")
+
+	yql, err := vespa.NewQueryBuilder().
+		From(product.DocumentType).
+		Where(product.Embedding.NearestNeighbor("q", 10)).
+		BuildYQL()
+	if err != nil {
+		log.Fatalf("Error building query: %v", err)
+	}
+	fmt.Println(yql)
+
+	// Hits from a search response decode into the generated Document
+	hit := vespa.Hit{Fields: map[string]interface{}{
+		"title": "Trail Runner",
+		"price": 120,
+		"sizes": []interface{}{map[string]interface{}{"label": "M", "stock": 3}},
+	}}
+	doc, err := product.DecodeHit(hit)
+	if err != nil {
+		log.Fatalf("Error decoding hit: %v", err)
+	}
+	fmt.Printf("%s costs %d, %d sizes\n", doc.Title, doc.Price, len(doc.Sizes))
+}
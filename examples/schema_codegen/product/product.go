@@ -0,0 +1,79 @@
+// Code generated by vespagen from product.sd. DO NOT EDIT.
+
+// Package product provides typed field accessors and document types for the
+// product schema.
+package product
+
+import (
+	"encoding/json"
+
+	vespa "github.com/vipulsodha/vespa-go"
+)
+
+// DocumentType is the document type, for QueryBuilder.From
+const DocumentType = "product"
+
+// Field accessors
+var (
+	// Title is the string field title (index, summary)
+	Title = vespa.TextField("title")
+	// Brand is the string field brand (attribute, summary)
+	Brand = vespa.StringField("brand")
+	// Price is the int field price (attribute, summary)
+	Price = vespa.IntegerField[int32]("price")
+	// Rating is the float field rating (attribute, summary)
+	Rating = vespa.NumericField[float32]("rating")
+	// InStock is the bool field in_stock (attribute, summary)
+	InStock = vespa.BoolField("in_stock")
+	// Sizes is the array<size> field sizes (summary)
+	Sizes = struct {
+		vespa.ArrayFieldBuilder
+		Label vespa.StringFieldBuilder
+		Stock vespa.IntegerFieldBuilder[int32]
+	}{vespa.ArrayField("sizes"), vespa.StringField("label"), vespa.IntegerField[int32]("stock")}
+	// Attributes is the map<string,string> field attributes (summary)
+	Attributes = struct {
+		vespa.MapFieldBuilder
+		Key   vespa.StringFieldBuilder
+		Value vespa.StringFieldBuilder
+	}{vespa.MapField("attributes"), vespa.StringField("key"), vespa.StringField("value")}
+	// Tags is the weightedset<string> field tags (attribute, summary)
+	Tags = vespa.StringField("tags")
+	// Embedding is the tensor<float>(x[4]) field embedding (index, attribute)
+	Embedding = vespa.TensorField("embedding")
+	// Default is the fieldset default (title, brand)
+	Default = vespa.TextField("default")
+)
+
+// Document holds the fields of a product document, for feeding and for
+// decoding search hits
+type Document struct {
+	Title      string            `json:"title"`
+	Brand      string            `json:"brand"`
+	Price      int32             `json:"price"`
+	Rating     float32           `json:"rating"`
+	InStock    bool              `json:"in_stock"`
+	Sizes      []Size            `json:"sizes,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Tags       map[string]int32  `json:"tags,omitempty"`
+	Embedding  interface{}       `json:"embedding,omitempty"`
+}
+
+// Size is the struct size
+type Size struct {
+	Label string `json:"label"`
+	Stock int32  `json:"stock"`
+}
+
+// DecodeHit decodes the fields of a search hit into a Document
+func DecodeHit(hit vespa.Hit) (*Document, error) {
+	data, err := json.Marshal(hit.Fields)
+	if err != nil {
+		return nil, err
+	}
+	var document Document
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	return &document, nil
+}
@@ -0,0 +1,198 @@
+package vespa
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// =============================================================================
+// Fingerprints
+// =============================================================================
+
+// maskedValue replaces a literal value in the shape of a condition. It renders
+// as ? and keeps whether the original value was a string, so equality on
+// strings (contains) and on numbers (=) stay distinguishable.
+type maskedValue struct {
+	text bool
+}
+
+// Fingerprint returns a hex-encoded SHA-256 hash of the canonical form of a
+// condition (see CanonicalYQL). Logically equal conditions have the same
+// fingerprint; any difference in fields, operators or values changes it.
+func Fingerprint(condition WhereCondition) string {
+	return hashString(CanonicalYQL(condition))
+}
+
+// ShapeFingerprint returns a hex-encoded SHA-256 hash of the shape of a
+// condition (see ShapeYQL), for grouping conditions that differ only in
+// their literal values.
+func ShapeFingerprint(condition WhereCondition) string {
+	return hashString(ShapeYQL(condition))
+}
+
+// ShapeYQL renders the canonical form of a condition with literal values
+// masked as ?:
+//
+//	ShapeYQL(And(Field("brand").Eq("nike"), Field("price").In(10, 20)))
+//	// ((brand contains ?) AND (price in (?)))
+//
+// in lists are masked as a whole, so lists of different lengths have the same
+// shape. Annotations such as targetHits and custom expressions are kept.
+func ShapeYQL(condition WhereCondition) string {
+	if condition == nil {
+		return ""
+	}
+	return shape(condition).ToYQL()
+}
+
+// Fingerprint returns a hex-encoded SHA-256 hash identifying the complete
+// request: the canonical form of the YQL, ranking, hits, inputs and all other
+// parameters. Queries that differ only in the order of commutative
+// conditions, selected fields or map iteration have the same fingerprint,
+// which makes it suitable as a result cache key. Inputs and parameters that
+// cannot be serialized as a request are still covered, encoded one by one.
+func (q *VespaQuery) Fingerprint() string {
+	return q.fingerprint(false)
+}
+
+// ShapeFingerprint returns a hex-encoded SHA-256 hash of the query shape for
+// analytics: literal values in the YQL, limit and timeout as well as the
+// values of the query text, inputs and other parameters are masked, and
+// pagination (hits and offsets) is ignored. The where structure, the names of
+// inputs and parameters, the ranking profile, default index and query
+// profile are kept.
+func (q *VespaQuery) ShapeFingerprint() string {
+	return q.fingerprint(true)
+}
+
+// shapeParameters are the request parameters whose value is part of the query shape
+var shapeParameters = map[string]bool{
+	"yql":             true,
+	"ranking.profile": true,
+	"defaultIndex":    true,
+	"queryProfile":    true,
+}
+
+// paginationParameters are ignored in the query shape
+var paginationParameters = map[string]bool{
+	"hits":   true,
+	"offset": true,
+}
+
+var (
+	// trailingLiteralPattern matches the numeric clauses following the where clause
+	trailingLiteralPattern = regexp.MustCompile(`\b(limit|timeout) \d+\b`)
+	offsetClausePattern    = regexp.MustCompile(` offset \d+\b`)
+)
+
+func (q *VespaQuery) fingerprint(masked bool) string {
+	params, err := q.RequestParameters()
+	if err != nil {
+		params = q.rawParameters()
+	}
+	params["yql"] = fingerprintYQL(q.YQL, masked)
+
+	if masked {
+		for name := range params {
+			if paginationParameters[name] {
+				delete(params, name)
+			} else if !shapeParameters[name] {
+				params[name] = "?"
+			}
+		}
+	}
+
+	// encoding/json writes map keys in sorted order
+	data, _ := json.Marshal(params)
+	return hashString(string(data))
+}
+
+// rawParameters returns the request parameters of a query whose inputs or
+// parameters cannot be serialized as a request, e.g. because a parameter
+// clashes with a standard key. Each input and parameter is encoded on its
+// own, so the key still covers every field; parameters are prefixed with
+// "parameters." to keep them apart from the standard keys.
+func (q *VespaQuery) rawParameters() map[string]interface{} {
+	standard := *q
+	standard.Input = nil
+	standard.Parameters = nil
+	params, err := standard.RequestParameters()
+	if err != nil {
+		params = map[string]interface{}{}
+	}
+
+	for name, value := range q.Input {
+		params[name] = rawValue(value)
+	}
+	for name, value := range q.Parameters {
+		params["parameters."+name] = rawValue(value)
+	}
+	return params
+}
+
+// rawValue returns the JSON encoding of a value, or its Go representation
+// if it has none
+func rawValue(value interface{}) interface{} {
+	if data, err := json.Marshal(value); err == nil {
+		return json.RawMessage(data)
+	}
+	return fmt.Sprintf("%T %v", value, value)
+}
+
+// fingerprintYQL returns the canonical or shape form of a YQL string, or the
+// string itself when it cannot be parsed
+func fingerprintYQL(yql string, masked bool) string {
+	parsed, err := ParseYQL(yql)
+	if err != nil {
+		return yql
+	}
+	qb, ok := parsed.Builder().(*QueryBuilderImpl)
+	if !ok {
+		return yql
+	}
+
+	if !masked {
+		if canonical, err := qb.BuildCanonicalYQL(); err == nil {
+			return canonical
+		}
+		return yql
+	}
+	rendered := offsetClausePattern.ReplaceAllString(qb.canonicalBuilder(shape).renderYQL(), "")
+	return trailingLiteralPattern.ReplaceAllString(rendered, "$1 ?")
+}
+
+// shape returns the canonical form of a condition with literal values masked
+func shape(condition WhereCondition) WhereCondition {
+	return Rewrite(canonicalize(condition), func(c WhereCondition) WhereCondition {
+		return canonicalNode(maskNode(c))
+	})
+}
+
+// maskNode replaces the literal values of a single condition
+func maskNode(condition WhereCondition) WhereCondition {
+	switch c := condition.(type) {
+	case *FieldCondition:
+		masked := *c
+		switch {
+		case c.Operator == IN || c.Operator == NOT_IN:
+			masked.Value = []interface{}{maskedValue{}}
+		case c.ContainsType == PhraseMatch || c.ContainsType == NearMatch:
+			masked.Value = "?"
+		default:
+			masked.Value = maskedValue{text: isStringValue(c.Value)}
+		}
+		return &masked
+	case *RangeCondition:
+		return &RangeCondition{Field: c.Field, Min: maskedValue{}, Max: maskedValue{}}
+	default:
+		return condition
+	}
+}
+
+func hashString(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,183 @@
+package vespa
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/vipulsodha/vespa-go/tensor"
+)
+
+func TestConditionFingerprint(t *testing.T) {
+	a := And(Field("brand").Eq("nike"), Field("price").In(10, 20))
+	b := And(Field("price").In(20, 10), Field("brand").Eq("nike"))
+	c := And(Field("brand").Eq("adidas"), Field("price").In(30))
+	d := And(Field("brand").Eq("adidas"), Field("price").Gt(30))
+
+	if Fingerprint(a) != Fingerprint(b) {
+		t.Error("Expected logically equal conditions to have the same fingerprint")
+	}
+	if Fingerprint(a) == Fingerprint(c) {
+		t.Error("Expected conditions with different values to have different fingerprints")
+	}
+	if len(Fingerprint(a)) != 64 {
+		t.Errorf("Expected hex-encoded SHA-256, got %q", Fingerprint(a))
+	}
+
+	if ShapeFingerprint(a) != ShapeFingerprint(c) {
+		t.Errorf("Expected equal shapes, got %q and %q", ShapeYQL(a), ShapeYQL(c))
+	}
+	if ShapeFingerprint(c) == ShapeFingerprint(d) {
+		t.Error("Expected conditions with different operators to have different shapes")
+	}
+
+	// contains matches tokens while in matches exact values, so the two differ
+	contains := Or(Field("title").Eq("a"), Field("title").Eq("b"))
+	in := Field("title").In("a", "b")
+	if Fingerprint(contains) == Fingerprint(in) {
+		t.Error("Expected OR-ed string equalities and in to have different fingerprints")
+	}
+	if ShapeFingerprint(contains) == ShapeFingerprint(in) {
+		t.Error("Expected OR-ed string equalities and in to have different shapes")
+	}
+}
+
+func TestShapeYQL(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition WhereCondition
+		expected  string
+	}{
+		{"Masked values", And(Field("brand").Eq("nike"), Field("price").In(10, 20)), "((brand contains ?) AND (price in (?)))"},
+		{"Numbers", Field("price").Gt(10.5), "(price > ?)"},
+		{"Not equal", Field("color").NotEq("red"), "!(color contains ?)"},
+		{"Range", Field("price").Between(10, 20), "((price >= ?) and (price <= ?))"},
+		{"Phrase", Field("title").Contains([]string{"a", "b"}, WithPhraseMatching()), "(title contains phrase('?'))"},
+		{"Nearest neighbor", Field("embedding").NearestNeighbor("q", 10), "({targetHits:10}nearestNeighbor(embedding, q))"},
+		{
+			"Sorted after masking",
+			Or(And(Field("a").Eq(2), Field("c").Eq(1)), And(Field("a").Eq(1), Field("b").Eq(1))),
+			"(((a = ?) AND (b = ?)) OR ((a = ?) AND (c = ?)))",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := ShapeYQL(tt.condition); actual != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestQueryFingerprint(t *testing.T) {
+	vectorType := tensor.MustType(tensor.Float, tensor.Indexed("x", 2))
+
+	build := func(brand string, hits, offset int, vector []float32, reorder bool) *VespaQuery {
+		qb := NewQueryBuilder().From("products").WithRanking("hybrid").WithHits(hits).WithOffset(offset)
+		if reorder {
+			qb.Where(Field("in_stock").Eq(true)).Where(Field("brand").Eq(brand)).
+				WithParameter("trace.level", 1).
+				WithQueryInput(ScalarInput("boost"), 2).
+				WithQueryInput(TensorInput("q", vectorType), vector)
+		} else {
+			qb.Where(And(Field("brand").Eq(brand), Field("in_stock").Eq(true))).
+				WithQueryInput(TensorInput("q", vectorType), vector).
+				WithQueryInput(ScalarInput("boost"), 2).
+				WithParameter("trace.level", 1)
+		}
+		query, err := qb.Build()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return query
+	}
+
+	a := build("nike", 10, 0, []float32{1, 2}, false)
+	b := build("nike", 10, 0, []float32{1, 2}, true)
+	c := build("adidas", 20, 20, []float32{3, 4}, false)
+
+	for i := 0; i < 10; i++ {
+		if a.Fingerprint() != b.Fingerprint() {
+			t.Fatal("Expected equal queries to have the same fingerprint")
+		}
+	}
+	if a.Fingerprint() == c.Fingerprint() {
+		t.Error("Expected queries with different values to have different fingerprints")
+	}
+	if a.ShapeFingerprint() != c.ShapeFingerprint() {
+		t.Error("Expected queries with the same shape to have the same shape fingerprint")
+	}
+
+	other := build("nike", 10, 0, []float32{1, 2}, false)
+	other.Ranking.Profile = "bm25"
+	if a.ShapeFingerprint() == other.ShapeFingerprint() {
+		t.Error("Expected the ranking profile to be part of the shape")
+	}
+
+	// A query read back from its request body has the same fingerprint
+	data, err := json.Marshal(a)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var restored VespaQuery
+	if err := json.Unmarshal(data, &restored); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if restored.Fingerprint() != a.Fingerprint() {
+		t.Error("Expected restored query to have the same fingerprint")
+	}
+}
+
+func TestQueryFingerprintUnserializableParameters(t *testing.T) {
+	query := func(parameters map[string]interface{}) *VespaQuery {
+		return &VespaQuery{
+			YQL:        "select * from sources products where true",
+			Input:      map[string]interface{}{"input.query(boost)": 2},
+			Parameters: parameters,
+		}
+	}
+
+	a := query(map[string]interface{}{"hits": 10, "trace.level": 1})
+	b := query(map[string]interface{}{"hits": 20, "trace.level": 1})
+	c := query(map[string]interface{}{"hits": 10, "trace.level": 2})
+	d := query(map[string]interface{}{"hits": 10, "trace.level": 1})
+	d.Input["input.query(boost)"] = 3
+
+	if _, err := a.RequestParameters(); err == nil {
+		t.Fatal("Expected clashing parameter to fail serialization")
+	}
+	if a.Fingerprint() != query(map[string]interface{}{"hits": 10, "trace.level": 1}).Fingerprint() {
+		t.Error("Expected equal queries to have the same fingerprint")
+	}
+	for _, other := range []*VespaQuery{b, c, d, query(nil)} {
+		if a.Fingerprint() == other.Fingerprint() {
+			t.Errorf("Expected different fingerprints for parameters %v and %v, inputs %v and %v",
+				a.Parameters, other.Parameters, a.Input, other.Input)
+		}
+	}
+
+	unencodable := query(map[string]interface{}{"callback": func() {}})
+	if unencodable.Fingerprint() == query(nil).Fingerprint() {
+		t.Error("Expected a parameter that cannot be encoded to be part of the fingerprint")
+	}
+}
+
+func TestQueryShapeFingerprintMasksPagination(t *testing.T) {
+	query := func(limit, offset int) *VespaQuery {
+		q, err := NewQueryBuilder().From("products").Where(Field("price").Lt(limit)).Limit(limit, offset).Build()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return q
+	}
+
+	if query(10, 0).ShapeFingerprint() != query(10, 20).ShapeFingerprint() {
+		t.Error("Expected offset to be masked in the shape")
+	}
+	if query(10, 0).Fingerprint() == query(10, 20).Fingerprint() {
+		t.Error("Expected offset to be part of the exact fingerprint")
+	}
+	if query(10, 0).ShapeFingerprint() == (&VespaQuery{YQL: "select * from sources products where true"}).ShapeFingerprint() {
+		t.Error("Expected different where clauses to have different shapes")
+	}
+}
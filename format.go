@@ -0,0 +1,287 @@
+package vespa
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// =============================================================================
+// Pretty YQL
+// =============================================================================
+
+const (
+	// prettyLineWidth is the width up to which the pretty printer keeps a
+	// condition on a single line
+	prettyLineWidth = 80
+	prettyIndent    = "  "
+)
+
+// PrettyYQL renders a condition as indented, multi-line YQL for logs and
+// debugging. Conditions that fit on a line are rendered as by ToYQL; longer
+// AND/OR, NOT and sameElement conditions are broken up with one operand per
+// line:
+//
+//	(
+//	  (brand contains 'nike')
+//	  OR ((price >= 10) and (price <= 100))
+//	)
+//
+// The output differs from ToYQL only in whitespace.
+func PrettyYQL(condition WhereCondition) string {
+	return prettyCondition(condition, "")
+}
+
+// BuildPrettyYQL builds the YQL string like BuildYQL, with each clause on its
+// own line and long conditions indented as by PrettyYQL.
+func (qb *QueryBuilderImpl) BuildPrettyYQL() (string, error) {
+	return qb.buildYQLWith(qb.renderPrettyYQL)
+}
+
+func (qb *QueryBuilderImpl) renderPrettyYQL() string {
+	lines := []string{qb.buildSelectClause(), qb.buildFromClause()}
+
+	var conditions []string
+	for _, condition := range qb.renderedWhereConditions() {
+		if condition.ToYQL() != "" {
+			conditions = append(conditions, prettyCondition(condition, prettyIndent))
+		}
+	}
+	if rank := qb.renderedRankExpression(); rank != nil && rank.ToYQL() != "" {
+		conditions = append(conditions, prettyRank(rank, prettyIndent))
+	}
+	if len(conditions) == 0 {
+		conditions = []string{"true"}
+	}
+	lines = append(lines, "where "+strings.Join(conditions, "\n"+prettyIndent+"and "))
+
+	lines = append(lines, qb.buildTrailingClauses()...)
+	if qb.grouping != "" {
+		lines = append(lines, "| "+qb.grouping)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// prettyCondition renders a condition starting on the current line; following
+// lines are indented relative to indent
+func prettyCondition(condition WhereCondition, indent string) string {
+	if condition == nil {
+		return ""
+	}
+
+	yql := condition.ToYQL()
+	if len(indent)+len(yql) <= prettyLineWidth {
+		return yql
+	}
+
+	inner := indent + prettyIndent
+	switch c := condition.(type) {
+	case *BooleanCondition:
+		return prettyJunction(c.Operator, []WhereCondition{c.Left, c.Right}, indent)
+	case *JunctionCondition:
+		return prettyJunction(c.Operator, c.Conditions, indent)
+	case *NotCondition:
+		return fmt.Sprintf("!(\n%s%s\n%s)", inner, prettyCondition(c.Condition, inner), indent)
+	case *SameElementCondition:
+		var parts []string
+		for _, element := range c.Conditions {
+			if element.ToYQL() != "" {
+				parts = append(parts, inner+prettyCondition(element, inner))
+			}
+		}
+		return fmt.Sprintf("(%s contains sameElement(\n%s\n%s))", c.Field, strings.Join(parts, ",\n"), indent)
+	default:
+		return yql
+	}
+}
+
+func prettyJunction(operator string, operands []WhereCondition, indent string) string {
+	inner := indent + prettyIndent
+	lines := make([]string, 0, len(operands))
+	for i, operand := range operands {
+		prefix := inner
+		if i > 0 {
+			prefix += operator + " "
+		}
+		lines = append(lines, prefix+prettyCondition(operand, inner))
+	}
+	return fmt.Sprintf("(\n%s\n%s)", strings.Join(lines, "\n"), indent)
+}
+
+func prettyRank(rank RankExpression, indent string) string {
+	yql := rank.ToYQL()
+	impl, ok := rank.(*RankExpressionImpl)
+	if !ok || len(indent)+len(yql) <= prettyLineWidth {
+		return yql
+	}
+
+	inner := indent + prettyIndent
+	var parts []string
+	for _, condition := range impl.conditions {
+		if condition.ToYQL() != "" {
+			parts = append(parts, inner+prettyCondition(condition, inner))
+		}
+	}
+	return fmt.Sprintf("rank(\n%s\n%s)", strings.Join(parts, ",\n"), indent)
+}
+
+// =============================================================================
+// Canonical YQL
+// =============================================================================
+
+// CanonicalYQL renders a condition in canonical form, so that logically equal
+// conditions render to identical strings. The condition is simplified (see
+// Simplify), then the operands of AND, OR and sameElement as well as the
+// values of in lists are sorted and deduplicated:
+//
+//	CanonicalYQL(Or(Field("b").Eq(2), Field("a").In(3, 1)))
+//	// ((a in (1, 3)) OR (b = 2))
+//
+// The canonical form is valid YQL, but may differ from ToYQL.
+func CanonicalYQL(condition WhereCondition) string {
+	if condition == nil {
+		return ""
+	}
+	return canonicalize(condition).ToYQL()
+}
+
+// BuildCanonicalYQL builds the YQL string in canonical form for caching and
+// deduplication: selected fields and sources are sorted, the where conditions
+// are simplified and sorted as by CanonicalYQL, and rank conditions are
+// canonicalized in place. The order by, limit, timeout and grouping clauses
+// are kept as-is.
+func (qb *QueryBuilderImpl) BuildCanonicalYQL() (string, error) {
+	return qb.buildYQLWith(qb.renderCanonicalYQL)
+}
+
+func (qb *QueryBuilderImpl) renderCanonicalYQL() string {
+	return qb.canonicalBuilder(canonicalize).renderYQL()
+}
+
+// canonicalBuilder returns a builder with the YQL clauses of qb in canonical
+// order, with where and rank conditions transformed by canonical
+func (qb *QueryBuilderImpl) canonicalBuilder(canonical func(WhereCondition) WhereCondition) *QueryBuilderImpl {
+	c := &QueryBuilderImpl{
+		selectFields: sortedUnique(qb.selectFields),
+		sources:      sortedUnique(qb.sources),
+		orderBy:      qb.orderBy,
+		limit:        qb.limit,
+		yqlOffset:    qb.yqlOffset,
+		timeout:      qb.timeout,
+		grouping:     qb.grouping,
+	}
+
+	if len(qb.whereConditions) > 0 {
+		where := canonical(&JunctionCondition{Conditions: qb.whereConditions, Operator: "AND"})
+		switch w := where.(type) {
+		case *ConstantCondition:
+			if !w.Value {
+				c.whereConditions = []WhereCondition{w}
+			}
+		case *JunctionCondition:
+			if w.Operator == "AND" {
+				c.whereConditions = w.Conditions
+			} else {
+				c.whereConditions = []WhereCondition{w}
+			}
+		default:
+			c.whereConditions = []WhereCondition{where}
+		}
+	}
+
+	if rank, ok := qb.rankExpression.(*RankExpressionImpl); ok {
+		conditions := make([]WhereCondition, 0, len(rank.conditions))
+		for _, condition := range rank.conditions {
+			conditions = append(conditions, canonical(condition))
+		}
+		c.rankExpression = &RankExpressionImpl{conditions: conditions}
+	} else {
+		c.rankExpression = qb.rankExpression
+	}
+
+	return c
+}
+
+// canonicalize simplifies a condition and sorts its commutative parts
+func canonicalize(condition WhereCondition) WhereCondition {
+	return Rewrite(Simplify(condition), canonicalNode)
+}
+
+// canonicalNode sorts the operands of a single condition whose children are
+// already canonical
+func canonicalNode(condition WhereCondition) WhereCondition {
+	switch c := condition.(type) {
+	case *BooleanCondition:
+		operands := sortedConditions([]WhereCondition{c.Left, c.Right})
+		if len(operands) == 1 {
+			return operands[0]
+		}
+		return &BooleanCondition{Left: operands[0], Right: operands[1], Operator: strings.ToUpper(c.Operator)}
+	case *JunctionCondition:
+		return &JunctionCondition{Conditions: sortedConditions(c.Conditions), Operator: strings.ToUpper(c.Operator)}
+	case *SameElementCondition:
+		return &SameElementCondition{Field: c.Field, Conditions: sortedConditions(c.Conditions)}
+	case *FieldCondition:
+		if c.Operator != IN && c.Operator != NOT_IN {
+			return c
+		}
+		rv := reflect.ValueOf(c.Value)
+		if rv.Kind() != reflect.Slice {
+			return c
+		}
+		seen := make(map[string]bool, rv.Len())
+		values := make([]interface{}, 0, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			value := rv.Index(i).Interface()
+			if key := formatValue(value); !seen[key] {
+				seen[key] = true
+				values = append(values, value)
+			}
+		}
+		sort.SliceStable(values, func(i, j int) bool {
+			return formatValue(values[i]) < formatValue(values[j])
+		})
+		canonical := *c
+		canonical.Value = values
+		return &canonical
+	default:
+		return condition
+	}
+}
+
+// sortedConditions returns a copy of conditions sorted by their YQL, without duplicates
+func sortedConditions(conditions []WhereCondition) []WhereCondition {
+	byKey := make(map[string]WhereCondition, len(conditions))
+	keys := make([]string, 0, len(conditions))
+	for _, condition := range conditions {
+		key := condition.ToYQL()
+		if _, seen := byKey[key]; !seen {
+			byKey[key] = condition
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	sorted := make([]WhereCondition, len(keys))
+	for i, key := range keys {
+		sorted[i] = byKey[key]
+	}
+	return sorted
+}
+
+func sortedUnique(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	sorted := append([]string(nil), values...)
+	sort.Strings(sorted)
+	unique := sorted[:1]
+	for _, value := range sorted[1:] {
+		if value != unique[len(unique)-1] {
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
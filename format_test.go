@@ -0,0 +1,215 @@
+package vespa
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPrettyYQL(t *testing.T) {
+	short := And(Field("a").Eq(1), Field("b").Eq(2))
+	if actual := PrettyYQL(short); actual != short.ToYQL() {
+		t.Errorf("Expected short condition on one line %q, got %q", short.ToYQL(), actual)
+	}
+
+	condition := And(
+		Or(Field("brand").Eq("nike"), Field("brand").Eq("adidas")),
+		Not(Field("description").Contains("refurbished and used products only")),
+		Field("sizes").ContainsSameElement(Field("family").Eq("clothing"), Field("size_value").Eq("M")),
+	)
+	expected := `(
+  (
+    ((brand contains 'nike') OR (brand contains 'adidas'))
+    AND !((description contains 'refurbished and used products only'))
+  )
+  AND (sizes contains sameElement(
+    (family contains 'clothing'),
+    (size_value contains 'M')
+  ))
+)`
+	actual := PrettyYQL(condition)
+	if actual != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, actual)
+	}
+
+	if strings.Join(strings.Fields(actual), "") != strings.Join(strings.Fields(condition.ToYQL()), "") {
+		t.Errorf("Expected pretty YQL to differ from ToYQL only in whitespace, got %q", actual)
+	}
+
+	parsed, err := ParseCondition(actual)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if parsed.ToYQL() != condition.ToYQL() {
+		t.Errorf("Expected pretty YQL to parse to %q, got %q", condition.ToYQL(), parsed.ToYQL())
+	}
+}
+
+func TestBuildPrettyYQL(t *testing.T) {
+	yql, err := NewImmutableQueryBuilder().
+		Select("title", "price").
+		From("products").
+		Where(Field("price").Between(10, 100)).
+		Where(Or(Field("category").Eq("running shoes"), Field("category").Eq("trail running shoes"))).
+		Rank(NewRank().AddCondition(UserQuery())).
+		OrderBy("price", Descending).
+		Limit(20, 0).
+		Grouping("all(group(brand) each(output(count())))").
+		BuildPrettyYQL()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := `select title, price
+from sources products
+where ((price >= 10) and (price <= 100))
+  and (
+    (category contains 'running shoes')
+    OR (category contains 'trail running shoes')
+  )
+  and rank(userQuery())
+order by price desc
+limit 20
+| all(group(brand) each(output(count())))`
+	if yql != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, yql)
+	}
+
+	if _, err := NewQueryBuilder().BuildPrettyYQL(); err == nil {
+		t.Error("Expected validation error")
+	}
+}
+
+func TestCanonicalYQL(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     WhereCondition
+		expected string
+	}{
+		{
+			"Commutative operands",
+			And(Field("b").Eq(2), Field("a").Eq(1)),
+			And(Field("a").Eq(1), Field("b").Eq(2)),
+			"((a = 1) AND (b = 2))",
+		},
+		{
+			"Associativity",
+			And(And(Field("a").Eq(1), Field("b").Eq(2)), Field("c").Eq(3)),
+			And(Field("c").Eq(3), And(Field("b").Eq(2), Field("a").Eq(1))),
+			"((a = 1) AND (b = 2) AND (c = 3))",
+		},
+		{
+			"In values",
+			Field("id").In(3, 1, 2, 1),
+			Or(Field("id").Eq(2), Field("id").In(1, 3)),
+			"(id in (1, 2, 3))",
+		},
+		{
+			"sameElement",
+			Field("s").ContainsSameElement(Field("b").Eq(2), Field("a").Eq(1)),
+			Field("s").ContainsSameElement(Field("a").Eq(1), Field("b").Eq(2)),
+			"(s contains sameElement((a = 1), (b = 2)))",
+		},
+		{
+			"Operator case",
+			&BooleanCondition{Left: Field("a").Eq(1), Right: Field("b").Eq(2), Operator: "or"},
+			Or(Field("b").Eq(2), Field("a").Eq(1)),
+			"((a = 1) OR (b = 2))",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := CanonicalYQL(tt.a), CanonicalYQL(tt.b)
+			if a != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, a)
+			}
+			if b != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, b)
+			}
+		})
+	}
+
+	if CanonicalYQL(Field("a").Eq(1)) == CanonicalYQL(Field("a").Eq(2)) {
+		t.Error("Expected different conditions to have different canonical forms")
+	}
+}
+
+func TestCanonicalYQLPreservesMatches(t *testing.T) {
+	documents := []testProduct{
+		{Title: "Nike running shoes", Brand: "Nike", Price: 120, InStock: true, Tags: []string{"running"}},
+		{Title: "Trail shoes", Brand: "Salomon", Price: 90, Tags: []string{"trail", "hiking"}},
+		{Title: "running", Brand: "Adidas", Price: 40, InStock: true},
+		{Title: "Walking boots", Brand: "Nike", Price: 200, Sizes: []testSize{{Family: "EU", Value: "44", Stock: 2}}},
+	}
+
+	conditions := []WhereCondition{
+		Or(Field("title").Eq("running"), Field("title").Eq("trail")),
+		Or(Field("brand").Eq("nike"), Field("brand").In("Adidas", "Salomon")),
+		Or(Field("price").Eq(40), Field("price").In(90, 40), Field("in_stock").Eq(true)),
+		And(Not(Not(Field("title").Contains("shoes"))), Or(Field("price").Lt(100), True())),
+		Or(Field("tags").In("trail"), Field("tags").In("running", "road")),
+		And(Field("brand").Eq("nike"), Field("brand").Eq("nike"), Field("price").Gt(100)),
+		Field("sizes").ContainsSameElement(Field("stock").Gt(1), Field("family").Eq("EU")),
+	}
+
+	for _, condition := range conditions {
+		canonical, err := ParseCondition(CanonicalYQL(condition))
+		if err != nil {
+			t.Fatalf("Unexpected error parsing %q: %v", CanonicalYQL(condition), err)
+		}
+		for i, document := range documents {
+			expected, err := Evaluate(condition, document)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			actual, err := Evaluate(canonical, document)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if actual != expected {
+				t.Errorf("Expected %q to match document %d like %q (%v), got %v",
+					canonical.ToYQL(), i, condition.ToYQL(), expected, actual)
+			}
+		}
+	}
+}
+
+func TestBuildCanonicalYQL(t *testing.T) {
+	a, err := NewQueryBuilder().
+		Select("title", "price").
+		From("products", "archive").
+		Where(Field("price").Gt(10)).
+		Where(Or(Field("brand").In("nike"), Field("brand").In("adidas"))).
+		Rank(NewRank().AddCondition(UserQuery()).AddCondition(Field("title").Contains("shoes"))).
+		BuildCanonicalYQL()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	b, err := NewQueryBuilder().
+		Select("price", "title", "price").
+		From("archive", "products").
+		Where(And(Field("brand").In("adidas", "nike"), Field("price").Gt(10))).
+		Rank(NewRank().AddCondition(UserQuery()).AddCondition(Field("title").Contains("shoes"))).
+		BuildCanonicalYQL()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := "select price, title from sources archive, products where (brand in ('adidas', 'nike')) and " +
+		"(price > 10) and rank(userQuery(), (title contains 'shoes'))"
+	if a != expected {
+		t.Errorf("Expected %q, got %q", expected, a)
+	}
+	if b != a {
+		t.Errorf("Expected equal canonical YQL %q, got %q", a, b)
+	}
+
+	yql, err := NewQueryBuilder().From("products").Where(Or(Field("a").Eq(1), True())).BuildCanonicalYQL()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if yql != "select * from sources products where true" {
+		t.Errorf("Unexpected canonical YQL %q", yql)
+	}
+}
@@ -0,0 +1,159 @@
+package vespa
+
+import (
+	"fmt"
+
+	"github.com/vipulsodha/vespa-go/schema"
+)
+
+// immutableQueryBuilder is a copy-on-write QueryBuilder. Every method leaves
+// the receiver untouched and returns a new builder, so a shared base query
+// can be extended concurrently from several goroutines without locks.
+type immutableQueryBuilder struct {
+	qb *QueryBuilderImpl
+}
+
+// NewImmutableQueryBuilder creates a copy-on-write query builder.
+//
+// Example:
+//
+//	base := NewImmutableQueryBuilder().From("products").Where(Field("in_stock").Eq(true))
+//	// Safe to call from concurrent request handlers:
+//	query, err := base.Where(Field("category").Eq(category)).WithHits(20).Build()
+func NewImmutableQueryBuilder() QueryBuilder {
+	return &immutableQueryBuilder{qb: newQueryBuilder()}
+}
+
+// Immutable returns a copy-on-write builder starting from a snapshot of a
+// builder created by NewQueryBuilder. It panics for other QueryBuilder
+// implementations, whose state cannot be copied.
+func Immutable(builder QueryBuilder) QueryBuilder {
+	switch b := builder.(type) {
+	case *immutableQueryBuilder:
+		return b
+	case *QueryBuilderImpl:
+		return &immutableQueryBuilder{qb: b.clone()}
+	default:
+		panic(fmt.Sprintf("vespa: Immutable requires a builder created by NewQueryBuilder, got %T", builder))
+	}
+}
+
+// with applies a mutation to a copy of the builder state
+func (ib *immutableQueryBuilder) with(mutate func(qb *QueryBuilderImpl)) QueryBuilder {
+	c := ib.qb.clone()
+	mutate(c)
+	return &immutableQueryBuilder{qb: c}
+}
+
+func (ib *immutableQueryBuilder) Select(fields ...string) QueryBuilder {
+	return ib.with(func(qb *QueryBuilderImpl) { qb.Select(fields...) })
+}
+
+func (ib *immutableQueryBuilder) From(sources ...string) QueryBuilder {
+	return ib.with(func(qb *QueryBuilderImpl) { qb.From(sources...) })
+}
+
+func (ib *immutableQueryBuilder) Where(condition WhereCondition) QueryBuilder {
+	return ib.with(func(qb *QueryBuilderImpl) { qb.Where(condition) })
+}
+
+// Rank stores a snapshot of the rank expression, so later AddCondition calls
+// on the caller's expression do not leak into the builder
+func (ib *immutableQueryBuilder) Rank(rankExpression RankExpression) QueryBuilder {
+	return ib.with(func(qb *QueryBuilderImpl) {
+		if rank, ok := rankExpression.(*RankExpressionImpl); ok {
+			rankExpression = rank.clone()
+		}
+		qb.Rank(rankExpression)
+	})
+}
+
+func (ib *immutableQueryBuilder) OrderBy(field string, order SortOrder) QueryBuilder {
+	return ib.with(func(qb *QueryBuilderImpl) { qb.OrderBy(field, order) })
+}
+
+func (ib *immutableQueryBuilder) Limit(limit, offset int) QueryBuilder {
+	return ib.with(func(qb *QueryBuilderImpl) { qb.Limit(limit, offset) })
+}
+
+func (ib *immutableQueryBuilder) Timeout(milliseconds int) QueryBuilder {
+	return ib.with(func(qb *QueryBuilderImpl) { qb.Timeout(milliseconds) })
+}
+
+func (ib *immutableQueryBuilder) Grouping(expression string) QueryBuilder {
+	return ib.with(func(qb *QueryBuilderImpl) { qb.Grouping(expression) })
+}
+
+func (ib *immutableQueryBuilder) WithRanking(profile string, opts ...RankingOption) QueryBuilder {
+	return ib.with(func(qb *QueryBuilderImpl) { qb.WithRanking(profile, opts...) })
+}
+
+func (ib *immutableQueryBuilder) WithHits(hits int) QueryBuilder {
+	return ib.with(func(qb *QueryBuilderImpl) { qb.WithHits(hits) })
+}
+
+func (ib *immutableQueryBuilder) WithOffset(offset int) QueryBuilder {
+	return ib.with(func(qb *QueryBuilderImpl) { qb.WithOffset(offset) })
+}
+
+func (ib *immutableQueryBuilder) WithDefaultIndex(index string) QueryBuilder {
+	return ib.with(func(qb *QueryBuilderImpl) { qb.WithDefaultIndex(index) })
+}
+
+func (ib *immutableQueryBuilder) WithInput(key string, value interface{}) QueryBuilder {
+	return ib.with(func(qb *QueryBuilderImpl) { qb.WithInput(key, value) })
+}
+
+func (ib *immutableQueryBuilder) WithQueryInput(input QueryInput, value interface{}) QueryBuilder {
+	return ib.with(func(qb *QueryBuilderImpl) { qb.WithQueryInput(input, value) })
+}
+
+func (ib *immutableQueryBuilder) WithParameter(name string, value interface{}) QueryBuilder {
+	return ib.with(func(qb *QueryBuilderImpl) { qb.WithParameter(name, value) })
+}
+
+func (ib *immutableQueryBuilder) WithQueryProfile(profile string) QueryBuilder {
+	return ib.with(func(qb *QueryBuilderImpl) { qb.WithQueryProfile(profile) })
+}
+
+func (ib *immutableQueryBuilder) WithQuery(query string) QueryBuilder {
+	return ib.with(func(qb *QueryBuilderImpl) { qb.WithQuery(query) })
+}
+
+func (ib *immutableQueryBuilder) WithSchema(schemas ...*schema.Schema) QueryBuilder {
+	return ib.with(func(qb *QueryBuilderImpl) { qb.WithSchema(schemas...) })
+}
+
+func (ib *immutableQueryBuilder) Rewrite(fn RewriteFunc) QueryBuilder {
+	return ib.with(func(qb *QueryBuilderImpl) { qb.Rewrite(fn) })
+}
+
+func (ib *immutableQueryBuilder) Optimize() QueryBuilder {
+	return ib.with(func(qb *QueryBuilderImpl) { qb.Optimize() })
+}
+
+// Clone returns the builder itself, since it can never change
+func (ib *immutableQueryBuilder) Clone() QueryBuilder {
+	return ib
+}
+
+// MarshalJSON serializes the builder state like QueryBuilderImpl.MarshalJSON
+func (ib *immutableQueryBuilder) MarshalJSON() ([]byte, error) {
+	return ib.qb.MarshalJSON()
+}
+
+func (ib *immutableQueryBuilder) Build() (*VespaQuery, error) {
+	return ib.qb.Build()
+}
+
+func (ib *immutableQueryBuilder) BuildYQL() (string, error) {
+	return ib.qb.BuildYQL()
+}
+
+func (ib *immutableQueryBuilder) BuildPrettyYQL() (string, error) {
+	return ib.qb.BuildPrettyYQL()
+}
+
+func (ib *immutableQueryBuilder) BuildCanonicalYQL() (string, error) {
+	return ib.qb.BuildCanonicalYQL()
+}
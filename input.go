@@ -0,0 +1,125 @@
+package vespa
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/vipulsodha/vespa-go/tensor"
+)
+
+// inputKeyPattern matches well-formed query input keys such as input.query(q_embedding)
+var inputKeyPattern = regexp.MustCompile(`^input\.query\(([A-Za-z_][A-Za-z0-9_]*)\)$`)
+
+// =============================================================================
+// QueryInput
+// =============================================================================
+
+// QueryInput describes a query input declared by a rank profile, e.g.
+// `query(q_embedding) tensor<float>(x[384])` or `query(boost) double`.
+// It builds the input.query(...) key and checks values against the declared type.
+type QueryInput struct {
+	Name       string
+	TensorType *tensor.Type // nil for scalar inputs
+}
+
+// ScalarInput declares a scalar (double) query input
+func ScalarInput(name string) QueryInput {
+	return QueryInput{Name: name}
+}
+
+// TensorInput declares a tensor query input of the given type
+func TensorInput(name string, tensorType tensor.Type) QueryInput {
+	return QueryInput{Name: name, TensorType: &tensorType}
+}
+
+// Key returns the request parameter key for the input, e.g. input.query(q_embedding)
+func (qi QueryInput) Key() string {
+	return fmt.Sprintf("input.query(%s)", qi.Name)
+}
+
+// IsTensor reports whether the input expects a tensor value
+func (qi QueryInput) IsTensor() bool {
+	return qi.TensorType != nil
+}
+
+// Serialize checks the value against the declared input type and converts it
+// into its request representation. Tensor inputs accept *tensor.Tensor values
+// of the declared type, []float32/[]float64 slices for dense types, or an
+// *Embedding computed by the server.
+func (qi QueryInput) Serialize(value interface{}) (interface{}, error) {
+	if !inputKeyPattern.MatchString(qi.Key()) {
+		return nil, &ValidationError{
+			Field:   "input",
+			Message: fmt.Sprintf("invalid query input name '%s'", qi.Name),
+		}
+	}
+	if qi.IsTensor() {
+		return qi.serializeTensor(value)
+	}
+	return qi.serializeScalar(value)
+}
+
+func (qi QueryInput) serializeScalar(value interface{}) (interface{}, error) {
+	switch value.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return value, nil
+	case *tensor.Tensor, []float32, []float64, *Embedding:
+		return nil, qi.errorf("scalar input cannot take a tensor value")
+	default:
+		return nil, qi.errorf("scalar input requires a numeric value, got %T", value)
+	}
+}
+
+func (qi QueryInput) serializeTensor(value interface{}) (interface{}, error) {
+	var t *tensor.Tensor
+	var err error
+
+	switch v := value.(type) {
+	case *Embedding:
+		// The embedding is computed server-side, so its shape cannot be checked here
+		return v, nil
+	case *tensor.Tensor:
+		t = v
+	case []float32:
+		t, err = tensor.NewDenseFloat32(*qi.TensorType, v)
+	case []float64:
+		t, err = tensor.NewDense(*qi.TensorType, v...)
+	default:
+		return nil, qi.errorf("tensor input of type %s cannot take a %T value", qi.TensorType, value)
+	}
+	if err != nil {
+		return nil, qi.errorf("%v", err)
+	}
+
+	if !t.Type().Equal(*qi.TensorType) {
+		return nil, qi.errorf("expected tensor of type %s, got %s", qi.TensorType, t.Type())
+	}
+	if err := t.Validate(); err != nil {
+		return nil, qi.errorf("%v", err)
+	}
+	return t, nil
+}
+
+func (qi QueryInput) errorf(format string, args ...interface{}) error {
+	return &ValidationError{
+		Field:   qi.Key(),
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// typedInputValue is an input value whose serialization is deferred to build time
+type typedInputValue struct {
+	input QueryInput
+	value interface{}
+}
+
+// sortedInputKeys returns input keys in deterministic order for validation
+func sortedInputKeys(params map[string]interface{}) []string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
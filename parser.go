@@ -0,0 +1,1002 @@
+package vespa
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// =============================================================================
+// ParsedQuery
+// =============================================================================
+
+// ParsedQuery is the result of parsing a YQL string with ParseYQL
+type ParsedQuery struct {
+	Select   []string         // nil for select *
+	Sources  []string         // nil for sources *
+	Where    []WhereCondition // top-level conditions joined with 'and'
+	Rank     RankExpression   // nil if the where clause has no rank()
+	OrderBy  []OrderBySpec
+	Limit    int
+	Offset   int
+	Timeout  int
+	Grouping string
+}
+
+// Builder returns a query builder reproducing the parsed query, so it can be
+// edited or extended with additional filters. For YQL produced by BuildYQL,
+// the returned builder renders the identical YQL string.
+func (pq *ParsedQuery) Builder() QueryBuilder {
+	qb := NewQueryBuilder()
+	if len(pq.Select) > 0 {
+		qb.Select(pq.Select...)
+	}
+	if len(pq.Sources) > 0 {
+		qb.From(pq.Sources...)
+	} else {
+		qb.From("*")
+	}
+	for _, condition := range pq.Where {
+		qb.Where(condition)
+	}
+	if pq.Rank != nil {
+		qb.Rank(pq.Rank)
+	}
+	for _, spec := range pq.OrderBy {
+		qb.OrderBy(spec.Field, spec.Order)
+	}
+	qb.Limit(pq.Limit, pq.Offset)
+	qb.Timeout(pq.Timeout)
+	qb.Grouping(pq.Grouping)
+	return qb
+}
+
+// ParseYQL parses a YQL query string into its clauses. Where conditions are
+// mapped onto the library's condition types; terms the parser does not
+// understand are kept verbatim as CustomFeature conditions.
+//
+// Example:
+//
+//	parsed, err := ParseYQL("select * from sources products where (price > 10)")
+//	query, err := parsed.Builder().Where(Field("in_stock").Eq(true)).Build()
+func ParseYQL(yql string) (*ParsedQuery, error) {
+	p, err := newParser(yql)
+	if err != nil {
+		return nil, err
+	}
+
+	pq := &ParsedQuery{}
+	if err := p.parseQuery(pq); err != nil {
+		return nil, err
+	}
+	return pq, nil
+}
+
+// ParseCondition parses a single YQL where expression, e.g.
+// "(price > 10) AND (brand contains 'nike')"
+func ParseCondition(expression string) (WhereCondition, error) {
+	p, err := newParser(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	condition, err := p.parseDisjunction()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, p.errorf("unexpected '%s'", t.text)
+	}
+	return condition, nil
+}
+
+// =============================================================================
+// Tokenizer
+// =============================================================================
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenNumber
+	tokenString
+	tokenPunct
+)
+
+type token struct {
+	kind  tokenKind
+	text  string // source text, or the unescaped value for strings
+	pos   int    // byte offset of the token start
+	end   int    // byte offset just past the token
+	quote byte   // quote character for strings
+}
+
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case isIdentStart(c):
+			start := i
+			for i < len(src) && (isIdentStart(src[i]) || isDigit(src[i]) || src[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: src[start:i], pos: start, end: i})
+		case isDigit(c):
+			start := i
+			for i < len(src) && (isDigit(src[i]) || src[i] == '.' || src[i] == 'e' || src[i] == 'E' ||
+				((src[i] == '-' || src[i] == '+') && (src[i-1] == 'e' || src[i-1] == 'E'))) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: src[start:i], pos: start, end: i})
+		case c == '\'' || c == '"':
+			start := i
+			var sb strings.Builder
+			i++
+			for i < len(src) && src[i] != c {
+				// Only escaped quotes and backslashes are unescaped, matching how values are rendered
+				if src[i] == '\\' && i+1 < len(src) && (src[i+1] == c || src[i+1] == '\\') {
+					i++
+				}
+				sb.WriteByte(src[i])
+				i++
+			}
+			if i >= len(src) {
+				return nil, fmt.Errorf("yql: unterminated string at position %d", start)
+			}
+			i++
+			tokens = append(tokens, token{kind: tokenString, text: sb.String(), pos: start, end: i, quote: c})
+		default:
+			start := i
+			if i+1 < len(src) && (src[i:i+2] == "!=" || src[i:i+2] == ">=" || src[i:i+2] == "<=") {
+				i += 2
+			} else {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenPunct, text: src[start:i], pos: start, end: i})
+		}
+	}
+	tokens = append(tokens, token{kind: tokenEOF, pos: len(src), end: len(src)})
+	return tokens, nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// =============================================================================
+// Parser
+// =============================================================================
+
+type parser struct {
+	src    string
+	tokens []token
+	pos    int
+}
+
+func newParser(src string) (*parser, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	return &parser{src: src, tokens: tokens}, nil
+}
+
+func (p *parser) peek() token {
+	return p.peekAt(0)
+}
+
+func (p *parser) peekAt(n int) token {
+	if p.pos+n >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.pos+n]
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	if p.pos < len(p.tokens)-1 {
+		p.pos++
+	}
+	return t
+}
+
+func isKeyword(t token, keyword string) bool {
+	return t.kind == tokenIdent && strings.EqualFold(t.text, keyword)
+}
+
+func isPunct(t token, punct string) bool {
+	return t.kind == tokenPunct && t.text == punct
+}
+
+func (p *parser) expectKeyword(keyword string) error {
+	if !isKeyword(p.peek(), keyword) {
+		return p.errorf("expected '%s'", keyword)
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) expectPunct(punct string) error {
+	if !isPunct(p.peek(), punct) {
+		return p.errorf("expected '%s'", punct)
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) expectIdent() (string, error) {
+	t := p.peek()
+	if t.kind != tokenIdent {
+		return "", p.errorf("expected identifier")
+	}
+	p.next()
+	return t.text, nil
+}
+
+func (p *parser) expectInt() (int, error) {
+	t := p.peek()
+	if t.kind != tokenNumber {
+		return 0, p.errorf("expected integer")
+	}
+	value, err := strconv.Atoi(t.text)
+	if err != nil {
+		return 0, p.errorf("expected integer")
+	}
+	p.next()
+	return value, nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	t := p.peek()
+	found := t.text
+	if t.kind == tokenEOF {
+		found = "end of input"
+	}
+	return fmt.Errorf("yql: %s at position %d (found '%s')", fmt.Sprintf(format, args...), t.pos, found)
+}
+
+// =============================================================================
+// Query clauses
+// =============================================================================
+
+func (p *parser) parseQuery(pq *ParsedQuery) error {
+	if err := p.expectKeyword("select"); err != nil {
+		return err
+	}
+	fields, err := p.parseNameList("from")
+	if err != nil {
+		return err
+	}
+	pq.Select = fields
+
+	if err := p.expectKeyword("from"); err != nil {
+		return err
+	}
+	if isKeyword(p.peek(), "sources") {
+		p.next()
+	}
+	sources, err := p.parseNameList("where")
+	if err != nil {
+		return err
+	}
+	pq.Sources = sources
+
+	if isKeyword(p.peek(), "where") {
+		p.next()
+		if err := p.parseWhere(pq); err != nil {
+			return err
+		}
+	}
+
+	return p.parseTrailingClauses(pq)
+}
+
+// parseNameList parses '*' or a comma separated list of names
+func (p *parser) parseNameList(terminator string) ([]string, error) {
+	if isPunct(p.peek(), "*") {
+		p.next()
+		return nil, nil
+	}
+
+	var names []string
+	for {
+		name, err := p.expectIdent()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		if !isPunct(p.peek(), ",") {
+			return names, nil
+		}
+		p.next()
+	}
+}
+
+func (p *parser) parseTrailingClauses(pq *ParsedQuery) error {
+	for {
+		t := p.peek()
+		switch {
+		case t.kind == tokenEOF:
+			return nil
+		case isKeyword(t, "order"):
+			p.next()
+			if err := p.expectKeyword("by"); err != nil {
+				return err
+			}
+			for {
+				field, err := p.expectIdent()
+				if err != nil {
+					return err
+				}
+				order := Ascending
+				if isKeyword(p.peek(), "desc") {
+					p.next()
+					order = Descending
+				} else if isKeyword(p.peek(), "asc") {
+					p.next()
+				}
+				pq.OrderBy = append(pq.OrderBy, OrderBySpec{Field: field, Order: order})
+				if !isPunct(p.peek(), ",") {
+					break
+				}
+				p.next()
+			}
+		case isKeyword(t, "limit"):
+			p.next()
+			limit, err := p.expectInt()
+			if err != nil {
+				return err
+			}
+			pq.Limit = limit
+		case isKeyword(t, "offset"):
+			p.next()
+			offset, err := p.expectInt()
+			if err != nil {
+				return err
+			}
+			pq.Offset = offset
+		case isKeyword(t, "timeout"):
+			p.next()
+			timeout, err := p.expectInt()
+			if err != nil {
+				return err
+			}
+			pq.Timeout = timeout
+		case isPunct(t, "|"):
+			pq.Grouping = strings.TrimSpace(p.src[t.end:])
+			p.pos = len(p.tokens) - 1
+			return nil
+		default:
+			return p.errorf("unexpected '%s'", t.text)
+		}
+	}
+}
+
+// isTrailingClause reports whether the parser is at the start of a clause following the where clause
+func (p *parser) isTrailingClause() bool {
+	t := p.peek()
+	switch {
+	case isKeyword(t, "order"):
+		return isKeyword(p.peekAt(1), "by")
+	case isKeyword(t, "limit"), isKeyword(t, "offset"), isKeyword(t, "timeout"):
+		return p.peekAt(1).kind == tokenNumber
+	default:
+		return isPunct(t, "|")
+	}
+}
+
+// parseWhere parses the where clause. Top-level 'and' operands become separate
+// where conditions, and a top-level rank() becomes the rank expression.
+func (p *parser) parseWhere(pq *ParsedQuery) error {
+	var operands []WhereCondition
+	for {
+		if isKeyword(p.peek(), "rank") && isPunct(p.peekAt(1), "(") && pq.Rank == nil {
+			rank, err := p.parseRank()
+			if err != nil {
+				return err
+			}
+			pq.Rank = rank
+		} else {
+			operand, err := p.parseUnary()
+			if err != nil {
+				return err
+			}
+			operands = append(operands, operand)
+		}
+
+		if !isKeyword(p.peek(), "and") {
+			break
+		}
+		p.next()
+	}
+
+	if !isKeyword(p.peek(), "or") {
+		pq.Where = operands
+		return nil
+	}
+
+	// A top-level OR makes the whole where clause a single condition
+	if pq.Rank != nil {
+		return p.errorf("rank() cannot be combined with 'or'")
+	}
+	disjuncts := []WhereCondition{combineOperands(operands, "AND", false)}
+	for isKeyword(p.peek(), "or") {
+		p.next()
+		disjunct, err := p.parseConjunction()
+		if err != nil {
+			return err
+		}
+		disjuncts = append(disjuncts, disjunct)
+	}
+	pq.Where = []WhereCondition{combineOperands(disjuncts, "OR", false)}
+	return nil
+}
+
+func (p *parser) parseRank() (RankExpression, error) {
+	p.next() // rank
+	p.next() // (
+	rank := NewRank()
+	for {
+		condition, err := p.parseDisjunction()
+		if err != nil {
+			return nil, err
+		}
+		rank.AddCondition(condition)
+		if !isPunct(p.peek(), ",") {
+			break
+		}
+		p.next()
+	}
+	if err := p.expectPunct(")"); err != nil {
+		return nil, err
+	}
+	return rank, nil
+}
+
+// =============================================================================
+// Conditions
+// =============================================================================
+
+func (p *parser) parseDisjunction() (WhereCondition, error) {
+	first, err := p.parseConjunction()
+	if err != nil {
+		return nil, err
+	}
+
+	operands := []WhereCondition{first}
+	for isKeyword(p.peek(), "or") {
+		p.next()
+		operand, err := p.parseConjunction()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, operand)
+	}
+	return combineOperands(operands, "OR", false), nil
+}
+
+func (p *parser) parseConjunction() (WhereCondition, error) {
+	first, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	operands := []WhereCondition{first}
+	lowercase := true
+	for isKeyword(p.peek(), "and") {
+		if p.next().text != "and" {
+			lowercase = false
+		}
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, operand)
+	}
+	return combineOperands(operands, "AND", lowercase), nil
+}
+
+// combineOperands builds the condition for a list of operands joined by the
+// same operator: a BooleanCondition for two operands and a JunctionCondition
+// for more. Two comparisons joined by a lowercase 'and' that bound the same
+// field from both sides are recognized as a RangeCondition.
+func combineOperands(operands []WhereCondition, operator string, lowercase bool) WhereCondition {
+	switch len(operands) {
+	case 1:
+		return operands[0]
+	case 2:
+		if operator == "AND" && lowercase {
+			if rc := asRange(operands[0], operands[1]); rc != nil {
+				return rc
+			}
+		}
+		return &BooleanCondition{Left: operands[0], Right: operands[1], Operator: operator}
+	default:
+		return &JunctionCondition{Conditions: operands, Operator: operator}
+	}
+}
+
+func asRange(left, right WhereCondition) *RangeCondition {
+	min, ok := left.(*FieldCondition)
+	if !ok || min.Operator != GTE {
+		return nil
+	}
+	max, ok := right.(*FieldCondition)
+	if !ok || max.Operator != LTE || max.Field != min.Field {
+		return nil
+	}
+	return &RangeCondition{Field: min.Field, Min: min.Value, Max: max.Value}
+}
+
+func (p *parser) parseUnary() (WhereCondition, error) {
+	if !isPunct(p.peek(), "!") {
+		return p.parsePrimary()
+	}
+
+	// !(field contains 'value') is how a string NotEq condition is rendered
+	if isPunct(p.peekAt(1), "(") && p.peekAt(2).kind == tokenIdent && isKeyword(p.peekAt(3), "contains") &&
+		p.peekAt(4).kind == tokenString && isPunct(p.peekAt(5), ")") {
+		condition := &FieldCondition{Field: p.peekAt(2).text, Operator: NEQ, Value: p.peekAt(4).text}
+		p.pos += 6
+		return condition, nil
+	}
+
+	p.next()
+	condition, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	return &NotCondition{Condition: condition}, nil
+}
+
+// parsePrimary parses a single term, falling back to a verbatim CustomFeature
+// for terms outside the supported grammar
+func (p *parser) parsePrimary() (WhereCondition, error) {
+	start := p.pos
+	condition, err := p.parseKnownPrimary()
+	if err == nil {
+		return condition, nil
+	}
+
+	p.pos = start
+	if custom, ok := p.parseRawTerm(); ok {
+		return custom, nil
+	}
+	return nil, err
+}
+
+func (p *parser) parseKnownPrimary() (WhereCondition, error) {
+	t := p.peek()
+	switch {
+	case isPunct(t, "("):
+		p.next()
+		inner, err := p.parseDisjunction()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		// Verbatim terms keep their parentheses so they render unchanged
+		if custom, ok := inner.(*CustomFeature); ok {
+			return &CustomFeature{Expression: "(" + custom.Expression + ")"}, nil
+		}
+		return inner, nil
+	case isPunct(t, "{"):
+		annotations, err := p.parseAnnotations()
+		if err != nil {
+			return nil, err
+		}
+		return p.parseAnnotatedTerm(annotations)
+	case t.kind == tokenIdent:
+		next := p.peekAt(1)
+		switch {
+		case isPunct(next, "("):
+			return p.parseAnnotatedTerm(nil)
+		case isKeyword(t, "true") || isKeyword(t, "false"):
+			p.next()
+			return &ConstantCondition{Value: strings.EqualFold(t.text, "true")}, nil
+		default:
+			return p.parseComparison()
+		}
+	default:
+		return nil, p.errorf("unexpected '%s'", t.text)
+	}
+}
+
+// parseAnnotatedTerm parses userQuery() and nearestNeighbor() with optional annotations
+func (p *parser) parseAnnotatedTerm(annotations map[string]interface{}) (WhereCondition, error) {
+	name, err := p.expectIdent()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+
+	switch name {
+	case "userQuery":
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		uq := &UserQueryFeature{}
+		for key, value := range annotations {
+			index, ok := value.(string)
+			if key != "defaultIndex" || !ok {
+				return nil, p.errorf("unsupported userQuery annotation '%s'", key)
+			}
+			uq.DefaultIndex = index
+		}
+		return uq, nil
+	case "nearestNeighbor":
+		field, err := p.expectIdent()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(","); err != nil {
+			return nil, err
+		}
+		queryVector, err := p.expectIdent()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		return p.nearestNeighbor(field, queryVector, annotations)
+	default:
+		return nil, p.errorf("unsupported operator '%s'", name)
+	}
+}
+
+func (p *parser) nearestNeighbor(field, queryVector string, annotations map[string]interface{}) (WhereCondition, error) {
+	nn := &NearestNeighbor{Field: field, QueryVector: queryVector}
+	for key, value := range annotations {
+		switch key {
+		case "targetHits":
+			hits, ok := value.(int64)
+			if !ok {
+				return nil, p.errorf("targetHits must be an integer")
+			}
+			nn.TargetHits = int(hits)
+		case "label":
+			label, ok := value.(string)
+			if !ok {
+				return nil, p.errorf("label must be a string")
+			}
+			nn.Label = label
+		case "distanceThreshold":
+			threshold, ok := toFloat64(value)
+			if !ok {
+				return nil, p.errorf("distanceThreshold must be a number")
+			}
+			nn.DistanceThreshold = &threshold
+		case "approximate":
+			approximate, ok := value.(bool)
+			if !ok {
+				return nil, p.errorf("approximate must be a boolean")
+			}
+			nn.Approximate = &approximate
+		default:
+			return nil, p.errorf("unsupported nearestNeighbor annotation '%s'", key)
+		}
+	}
+	return nn, nil
+}
+
+// parseAnnotations parses {key:value, ...}
+func (p *parser) parseAnnotations() (map[string]interface{}, error) {
+	if err := p.expectPunct("{"); err != nil {
+		return nil, err
+	}
+
+	annotations := make(map[string]interface{})
+	for !isPunct(p.peek(), "}") {
+		key, err := p.expectIdent()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(":"); err != nil {
+			return nil, err
+		}
+		value, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		annotations[key] = value
+		if !isPunct(p.peek(), ",") {
+			break
+		}
+		p.next()
+	}
+	if err := p.expectPunct("}"); err != nil {
+		return nil, err
+	}
+	return annotations, nil
+}
+
+// parseComparison parses 'field operator value'
+func (p *parser) parseComparison() (WhereCondition, error) {
+	field, err := p.expectIdent()
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.next()
+	switch {
+	case t.kind == tokenPunct:
+		operators := map[string]Operator{"=": EQ, "!=": NEQ, ">": GT, ">=": GTE, "<": LT, "<=": LTE}
+		operator, ok := operators[t.text]
+		if !ok {
+			return nil, p.errorf("unsupported operator '%s'", t.text)
+		}
+		value, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		return &FieldCondition{Field: field, Operator: operator, Value: value}, nil
+	case isKeyword(t, "contains"):
+		return p.parseContains(field)
+	case isKeyword(t, "in"):
+		values, err := p.parseValueList()
+		if err != nil {
+			return nil, err
+		}
+		return &FieldCondition{Field: field, Operator: IN, Value: values}, nil
+	case isKeyword(t, "not"):
+		switch operator := p.next(); {
+		case isKeyword(operator, "in"):
+			values, err := p.parseValueList()
+			if err != nil {
+				return nil, err
+			}
+			return &FieldCondition{Field: field, Operator: NOT_IN, Value: values}, nil
+		case isKeyword(operator, "contains"):
+			value, err := p.parseString()
+			if err != nil {
+				return nil, err
+			}
+			return &FieldCondition{Field: field, Operator: NOT_CONTAINS, Value: value}, nil
+		default:
+			return nil, p.errorf("unsupported operator 'not %s'", operator.text)
+		}
+	case isKeyword(t, "matches"):
+		value, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		return &FieldCondition{Field: field, Operator: MATCHES, Value: value}, nil
+	default:
+		return nil, p.errorf("unsupported operator '%s'", t.text)
+	}
+}
+
+// parseContains parses the right-hand side of 'field contains ...'
+func (p *parser) parseContains(field string) (WhereCondition, error) {
+	t := p.peek()
+	if isPunct(t, "(") && isPunct(p.peekAt(1), "{") {
+		return p.parseAnnotatedContains(field)
+	}
+	if t.kind != tokenIdent || !isPunct(p.peekAt(1), "(") || isKeyword(t, "true") || isKeyword(t, "false") {
+		value, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		return &FieldCondition{Field: field, Operator: CONTAINS, Value: value, ContainsType: ExactMatch}, nil
+	}
+
+	p.next()
+	p.next()
+	switch t.text {
+	case "phrase":
+		keywords, err := p.parseKeywords()
+		if err != nil {
+			return nil, err
+		}
+		var value interface{} = keywords
+		if len(keywords) == 1 {
+			value = keywords[0]
+		}
+		return &FieldCondition{Field: field, Operator: CONTAINS, Value: value, ContainsType: PhraseMatch}, nil
+	case "near":
+		keywords, err := p.parseKeywords()
+		if err != nil {
+			return nil, err
+		}
+		return &FieldCondition{Field: field, Operator: CONTAINS, Value: keywords, ContainsType: NearMatch}, nil
+	case "fuzzy":
+		value, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		return &FieldCondition{Field: field, Operator: CONTAINS, Value: value, ContainsType: FuzzyMatch}, nil
+	case "sameElement":
+		var conditions []WhereCondition
+		for {
+			condition, err := p.parseDisjunction()
+			if err != nil {
+				return nil, err
+			}
+			conditions = append(conditions, condition)
+			if !isPunct(p.peek(), ",") {
+				break
+			}
+			p.next()
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		return &SameElementCondition{Field: field, Conditions: conditions}, nil
+	default:
+		return nil, p.errorf("unsupported contains operator '%s'", t.text)
+	}
+}
+
+// parseKeywords parses the keywords of phrase() and near() up to the closing parenthesis
+func (p *parser) parseKeywords() ([]string, error) {
+	var keywords []string
+	for {
+		keyword, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		keywords = append(keywords, keyword)
+		if !isPunct(p.peek(), ",") {
+			break
+		}
+		p.next()
+	}
+	if err := p.expectPunct(")"); err != nil {
+		return nil, err
+	}
+	return keywords, nil
+}
+
+// parseAnnotatedContains parses ({prefix:true}'term')
+func (p *parser) parseAnnotatedContains(field string) (WhereCondition, error) {
+	p.next()
+	annotations, err := p.parseAnnotations()
+	if err != nil {
+		return nil, err
+	}
+	value, err := p.parseString()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectPunct(")"); err != nil {
+		return nil, err
+	}
+
+	containsType := ExactMatch
+	for key, v := range annotations {
+		if key != "prefix" || v != true {
+			return nil, p.errorf("unsupported contains annotation '%s'", key)
+		}
+		containsType = PrefixMatch
+	}
+	return &FieldCondition{Field: field, Operator: CONTAINS, Value: value, ContainsType: containsType}, nil
+}
+
+// parseValueList parses ('a', 'b', ...) or (1, 2, ...)
+func (p *parser) parseValueList() ([]interface{}, error) {
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+	var values []interface{}
+	for {
+		value, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		if !isPunct(p.peek(), ",") {
+			break
+		}
+		p.next()
+	}
+	if err := p.expectPunct(")"); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func (p *parser) parseString() (string, error) {
+	t := p.peek()
+	if t.kind != tokenString {
+		return "", p.errorf("expected string")
+	}
+	p.next()
+	return t.text, nil
+}
+
+// parseLiteral parses a string, number, boolean or null value.
+// Integers are returned as int64 and other numbers as float64.
+func (p *parser) parseLiteral() (interface{}, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokenString:
+		p.next()
+		return t.text, nil
+	case t.kind == tokenNumber:
+		p.next()
+		return parseNumber(t.text)
+	case isPunct(t, "-") && p.peekAt(1).kind == tokenNumber:
+		p.next()
+		return parseNumber("-" + p.next().text)
+	case isKeyword(t, "true"), isKeyword(t, "false"):
+		p.next()
+		return strings.EqualFold(t.text, "true"), nil
+	case isKeyword(t, "null"):
+		p.next()
+		return nil, nil
+	default:
+		return nil, p.errorf("expected value")
+	}
+}
+
+func parseNumber(text string) (interface{}, error) {
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return i, nil
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, fmt.Errorf("yql: invalid number '%s'", text)
+	}
+	return f, nil
+}
+
+// parseRawTerm consumes tokens up to the end of the current term, balancing
+// brackets, and returns the source text as a CustomFeature
+func (p *parser) parseRawTerm() (*CustomFeature, bool) {
+	start := p.pos
+	depth := 0
+	for {
+		t := p.peek()
+		if t.kind == tokenEOF {
+			break
+		}
+		if depth == 0 {
+			if isPunct(t, ",") || isPunct(t, ")") || isPunct(t, "]") || isPunct(t, "}") ||
+				isKeyword(t, "and") || isKeyword(t, "or") || p.isTrailingClause() {
+				break
+			}
+		}
+		switch {
+		case isPunct(t, "("), isPunct(t, "["), isPunct(t, "{"):
+			depth++
+		case isPunct(t, ")"), isPunct(t, "]"), isPunct(t, "}"):
+			depth--
+		}
+		p.next()
+	}
+
+	if p.pos == start || depth != 0 {
+		p.pos = start
+		return nil, false
+	}
+	expression := p.src[p.tokens[start].pos:p.tokens[p.pos-1].end]
+	return &CustomFeature{Expression: strings.TrimSpace(expression)}, true
+}
+
+func toFloat64(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
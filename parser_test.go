@@ -0,0 +1,307 @@
+package vespa
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseYQLRoundTrip(t *testing.T) {
+	threshold := 0.8
+	approximate := false
+
+	builders := map[string]QueryBuilder{
+		"default where": NewQueryBuilder().From("products"),
+		"select fields": NewQueryBuilder().Select("title", "price").From("products", "offers").
+			Where(Field("price").Gt(10)),
+		"comparisons": NewQueryBuilder().From("products").
+			Where(Field("price").Gte(10.5)).
+			Where(Field("stock").Lt(-3)).
+			Where(Field("rating").Lte(5)).
+			Where(Field("status").NotEq(2)).
+			Where(Field("brand").NotEq("nike")).
+			Where(Field("in_stock").Eq(true)),
+		"strings": NewQueryBuilder().From("products").
+			Where(Field("title").Contains("it's")).
+			Where(Field("title").NotContains("refurbished")).
+			Where(Field("sku").Matches("^AB[0-9]+$")),
+		"escapes": NewQueryBuilder().From("products").
+			Where(Field("a").Eq("x\\")).
+			Where(Field("path").Contains(`C:\dir\it's`)).
+			Where(Field("embedding").NearestNeighbor("q", 10, WithLabel("it's"))),
+		"contains types": NewQueryBuilder().From("products").
+			Where(Field("title").Contains("running shoes", WithPhraseMatching())).
+			Where(Field("title").Contains([]string{"trail", "running"}, WithPhraseMatching())).
+			Where(Field("brand").Contains("nkie", WithFuzzyMatching())).
+			Where(Field("brand").Contains("ni", WithPrefixMatching())).
+			Where(Field("title").Contains([]string{"running", "shoes"}, WithNearMatching())),
+		"in lists": NewQueryBuilder().From("products").
+			Where(Field("brand").In("nike", "adidas")).
+			Where(Field("category").NotIn(1, 2, 3)),
+		"boolean": NewQueryBuilder().From("products").
+			Where(Or(Field("brand").Eq("nike"), And(Field("price").Lt(50), Field("sale").Eq(true)))),
+		"junction": NewQueryBuilder().From("products").
+			Where(Or(Field("a").Eq(1), Field("b").Eq(2), Field("c").Eq(3))).
+			Optimize(),
+		"range and not": NewQueryBuilder().From("products").
+			Where(Field("price").Between(10, 100)).
+			Where(Not(Or(Field("color").Eq("red"), Field("color").Eq("blue")))),
+		"same element": NewQueryBuilder().From("products").
+			Where(Field("sizes").ContainsSameElement(Field("family").Eq("US"), Field("value").Gte(10))),
+		"user query": NewQueryBuilder().From("products").
+			Where(UserQuery()).
+			Where(UserQuery("title")),
+		"nearest neighbor": NewQueryBuilder().From("products").
+			Where(Field("embedding").NearestNeighbor("q", 100,
+				WithLabel("main"), WithThreshold(threshold), WithApproximate(approximate))),
+		"rank": NewQueryBuilder().From("products").
+			Where(Field("price").Gt(10)).
+			Rank(NewRank().
+				AddCondition(Field("embedding").NearestNeighbor("q", 10)).
+				AddCondition(Custom("bm25(title) > 0")).
+				AddCondition(UserQuery())),
+		"custom": NewQueryBuilder().From("products").
+			Where(Custom("weakAnd(title contains 'a', title contains 'b')")).
+			Where(Field("price").Gt(1)),
+		"constants": NewQueryBuilder().From("products").
+			Where(Or(Field("a").Eq(1), False())),
+		"trailing clauses": NewQueryBuilder().From("products").
+			Where(Field("price").Gt(10)).
+			OrderBy("price", Descending).
+			OrderBy("title", Ascending).
+			Limit(20, 40).
+			Timeout(500).
+			Grouping("all(group(brand) each(output(count())))"),
+	}
+
+	for name, builder := range builders {
+		t.Run(name, func(t *testing.T) {
+			yql, err := builder.BuildYQL()
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			parsed, err := ParseYQL(yql)
+			if err != nil {
+				t.Fatalf("Unexpected error parsing %q: %v", yql, err)
+			}
+
+			rebuilt, err := parsed.Builder().BuildYQL()
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if rebuilt != yql {
+				t.Errorf("Expected %q, got %q", yql, rebuilt)
+			}
+		})
+	}
+}
+
+func TestParseYQLEscapedValues(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition WhereCondition
+		expected  string
+		value     string
+	}{
+		{"Trailing backslash", Field("a").Eq("x\\"), `(a contains 'x\\')`, "x\\"},
+		{"Backslash before quote", Field("a").Eq(`x\'y`), `(a contains 'x\\\'y')`, `x\'y`},
+		{"Quoted label", Field("embedding").NearestNeighbor("q", 10, WithLabel("it's")),
+			`({targetHits:10,label:'it\'s'}nearestNeighbor(embedding, q))`, "it's"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yql := tt.condition.ToYQL()
+			if yql != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, yql)
+			}
+
+			parsed, err := ParseCondition(yql)
+			if err != nil {
+				t.Fatalf("Unexpected error parsing %q: %v", yql, err)
+			}
+			var value interface{}
+			switch c := parsed.(type) {
+			case *FieldCondition:
+				value = c.Value
+			case *NearestNeighbor:
+				value = c.Label
+			}
+			if value != tt.value {
+				t.Errorf("Expected %q, got %q", tt.value, value)
+			}
+			if parsed.ToYQL() != yql {
+				t.Errorf("Expected %q, got %q", yql, parsed.ToYQL())
+			}
+		})
+	}
+}
+
+func TestParseYQLClauses(t *testing.T) {
+	parsed, err := ParseYQL("select title, price from sources products where (price > 10) and (brand contains 'nike') " +
+		"order by price desc, title limit 10 offset 5 timeout 200 | all(group(brand) each(output(count())))")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if strings.Join(parsed.Select, ",") != "title,price" {
+		t.Errorf("Expected select fields title,price, got %v", parsed.Select)
+	}
+	if strings.Join(parsed.Sources, ",") != "products" {
+		t.Errorf("Expected source products, got %v", parsed.Sources)
+	}
+	if len(parsed.Where) != 2 {
+		t.Fatalf("Expected 2 where conditions, got %d", len(parsed.Where))
+	}
+	fc, ok := parsed.Where[0].(*FieldCondition)
+	if !ok || fc.Field != "price" || fc.Operator != GT || fc.Value != int64(10) {
+		t.Errorf("Expected (price > 10) as a FieldCondition, got %#v", parsed.Where[0])
+	}
+	if len(parsed.OrderBy) != 2 || parsed.OrderBy[0] != (OrderBySpec{"price", Descending}) ||
+		parsed.OrderBy[1] != (OrderBySpec{"title", Ascending}) {
+		t.Errorf("Unexpected order by: %v", parsed.OrderBy)
+	}
+	if parsed.Limit != 10 || parsed.Offset != 5 || parsed.Timeout != 200 {
+		t.Errorf("Expected limit 10 offset 5 timeout 200, got %d %d %d", parsed.Limit, parsed.Offset, parsed.Timeout)
+	}
+	if parsed.Grouping != "all(group(brand) each(output(count())))" {
+		t.Errorf("Unexpected grouping: %q", parsed.Grouping)
+	}
+}
+
+func TestParseYQLConditionTypes(t *testing.T) {
+	tests := []struct {
+		expression string
+		check      func(WhereCondition) bool
+	}{
+		{"((price >= 10) and (price <= 100))", func(c WhereCondition) bool {
+			rc, ok := c.(*RangeCondition)
+			return ok && rc.Field == "price" && rc.Min == int64(10) && rc.Max == int64(100)
+		}},
+		{"((price >= 10) AND (price <= 100))", func(c WhereCondition) bool {
+			_, ok := c.(*BooleanCondition)
+			return ok
+		}},
+		{"!(brand contains 'nike')", func(c WhereCondition) bool {
+			fc, ok := c.(*FieldCondition)
+			return ok && fc.Operator == NEQ && fc.Value == "nike"
+		}},
+		{"!((a = 1) OR (b = 2))", func(c WhereCondition) bool {
+			_, ok := c.(*NotCondition)
+			return ok
+		}},
+		{"(a = 1) or (b = 2) or (c = 3)", func(c WhereCondition) bool {
+			jc, ok := c.(*JunctionCondition)
+			return ok && jc.Operator == "OR" && len(jc.Conditions) == 3
+		}},
+		{"(title contains phrase('a', 'b'))", func(c WhereCondition) bool {
+			fc, ok := c.(*FieldCondition)
+			return ok && fc.ContainsType == PhraseMatch && len(fc.Value.([]string)) == 2
+		}},
+		{"(brand contains ({prefix:true}'ni'))", func(c WhereCondition) bool {
+			fc, ok := c.(*FieldCondition)
+			return ok && fc.ContainsType == PrefixMatch && fc.Value == "ni"
+		}},
+		{"(title contains near('a'))", func(c WhereCondition) bool {
+			fc, ok := c.(*FieldCondition)
+			return ok && fc.ContainsType == NearMatch && len(fc.Value.([]string)) == 1
+		}},
+		{"({targetHits:10}nearestNeighbor(embedding, q))", func(c WhereCondition) bool {
+			nn, ok := c.(*NearestNeighbor)
+			return ok && nn.Field == "embedding" && nn.QueryVector == "q" && nn.TargetHits == 10
+		}},
+		{"({defaultIndex:\"title\"}userQuery())", func(c WhereCondition) bool {
+			uq, ok := c.(*UserQueryFeature)
+			return ok && uq.DefaultIndex == "title"
+		}},
+		{"(brand in ('a', 'b'))", func(c WhereCondition) bool {
+			fc, ok := c.(*FieldCondition)
+			return ok && fc.Operator == IN && len(fc.Value.([]interface{})) == 2
+		}},
+		{"true", func(c WhereCondition) bool {
+			cc, ok := c.(*ConstantCondition)
+			return ok && cc.Value
+		}},
+	}
+
+	for _, tt := range tests {
+		condition, err := ParseCondition(tt.expression)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %v", tt.expression, err)
+			continue
+		}
+		if !tt.check(condition) {
+			t.Errorf("Unexpected condition for %q: %#v", tt.expression, condition)
+		}
+	}
+}
+
+func TestParseYQLCustomFallback(t *testing.T) {
+	tests := []struct {
+		expression string
+		expected   string
+	}{
+		{"weakAnd(title contains 'a', title contains 'b')", "weakAnd(title contains 'a', title contains 'b')"},
+		{"({prefix:true}title contains 'sho')", "({prefix:true}title contains 'sho')"},
+		{"(title contains ({stem:false}'shoes'))", "(title contains ({stem:false}'shoes'))"},
+		{"({targetHits:10}wand(tags, {a:1}))", "({targetHits:10}wand(tags, {a:1}))"},
+	}
+
+	for _, tt := range tests {
+		condition, err := ParseCondition(tt.expression)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %v", tt.expression, err)
+			continue
+		}
+		custom, ok := condition.(*CustomFeature)
+		if !ok {
+			t.Errorf("Expected %q to fall back to CustomFeature, got %#v", tt.expression, condition)
+			continue
+		}
+		if custom.Expression != tt.expected {
+			t.Errorf("Expected %q, got %q", tt.expected, custom.Expression)
+		}
+	}
+
+	parsed, err := ParseYQL("select * from sources * where weakAnd(a contains 'x', a contains 'y') and (price > 1) limit 5")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(parsed.Where) != 2 || parsed.Limit != 5 {
+		t.Errorf("Expected 2 where conditions and limit 5, got %d and %d", len(parsed.Where), parsed.Limit)
+	}
+}
+
+func TestParsedQueryBuilderExtends(t *testing.T) {
+	parsed, err := ParseYQL("select * from sources products where (price > 10) limit 10")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	yql, err := parsed.Builder().Where(Field("in_stock").Eq(true)).BuildYQL()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := "select * from sources products where (price > 10) and (in_stock = true) limit 10"
+	if yql != expected {
+		t.Errorf("Expected %q, got %q", expected, yql)
+	}
+}
+
+func TestParseYQLErrors(t *testing.T) {
+	invalid := []string{
+		"",
+		"from sources *",
+		"select * where true",
+		"select * from sources * where (title contains 'unterminated",
+		"select * from sources * where true limit ten",
+		"select * from sources * where (a = 1",
+	}
+
+	for _, yql := range invalid {
+		if _, err := ParseYQL(yql); err == nil {
+			t.Errorf("Expected error parsing %q", yql)
+		}
+	}
+}
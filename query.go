@@ -0,0 +1,131 @@
+package vespa
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// vespaQueryAlias prevents MarshalJSON from recursing into itself
+type vespaQueryAlias VespaQuery
+
+// MarshalJSON serializes the query as a Vespa request body. Additional
+// Parameters are written as top-level keys next to the standard fields;
+// a parameter named like a standard field is an error.
+func (q VespaQuery) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(vespaQueryAlias(q))
+	if err != nil || len(q.Parameters) == 0 {
+		return data, err
+	}
+
+	var merged map[string]json.RawMessage
+	if err := json.Unmarshal(data, &merged); err != nil {
+		return nil, err
+	}
+	for _, key := range standardQueryKeys() {
+		if _, ok := q.Parameters[key]; ok {
+			return nil, fmt.Errorf("vespa: parameter '%s' clashes with a standard request key", key)
+		}
+	}
+	for k, v := range q.Parameters {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		merged[k] = raw
+	}
+	return json.Marshal(merged)
+}
+
+// UnmarshalJSON parses a Vespa request body. Keys that do not map onto a
+// standard field are collected into Parameters.
+func (q *VespaQuery) UnmarshalJSON(data []byte) error {
+	var alias vespaQueryAlias
+	if err := json.Unmarshal(data, &alias); err != nil {
+		return err
+	}
+
+	var all map[string]interface{}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+	for _, known := range standardQueryKeys() {
+		delete(all, known)
+	}
+
+	*q = VespaQuery(alias)
+	if len(all) > 0 {
+		q.Parameters = all
+	}
+	return nil
+}
+
+// RequestParameters returns the query as flat request parameters with dotted
+// names, e.g. ranking.profile or ranking.matchPhase.maxHits. Input values are
+// keyed by their full input.query(...) name.
+func (q *VespaQuery) RequestParameters() (map[string]interface{}, error) {
+	data, err := json.Marshal(q)
+	if err != nil {
+		return nil, err
+	}
+
+	var nested map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&nested); err != nil {
+		return nil, err
+	}
+
+	params := make(map[string]interface{})
+	for key, value := range nested {
+		switch key {
+		case "input":
+			// Input keys already carry their full input.query(...) name
+			for name, v := range value.(map[string]interface{}) {
+				params[name] = v
+			}
+		case "ranking":
+			if profile, ok := value.(string); ok {
+				params["ranking.profile"] = profile
+				continue
+			}
+			flattenParameters(params, key, value)
+		default:
+			flattenParameters(params, key, value)
+		}
+	}
+	return params, nil
+}
+
+func flattenParameters(params map[string]interface{}, prefix string, value interface{}) {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		if number, isNumber := value.(json.Number); isNumber {
+			if i, err := number.Int64(); err == nil {
+				value = i
+			} else if f, err := number.Float64(); err == nil {
+				value = f
+			}
+		}
+		params[prefix] = value
+		return
+	}
+	for k, v := range object {
+		flattenParameters(params, prefix+"."+k, v)
+	}
+}
+
+// standardQueryKeys returns the JSON keys of the standard VespaQuery fields
+func standardQueryKeys() []string {
+	var keys []string
+	t := reflect.TypeOf(VespaQuery{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			keys = append(keys, name)
+		}
+	}
+	return keys
+}
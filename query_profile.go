@@ -0,0 +1,303 @@
+package vespa
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// =============================================================================
+// Query Profiles
+// =============================================================================
+
+// QueryProfile is the Go representation of a query profile XML file from an
+// application package (search/query-profiles/*.xml):
+//
+//	<query-profile id="mobile" inherits="default">
+//	    <dimensions>region,device</dimensions>
+//	    <field name="hits">5</field>
+//	    <query-profile for="us,*">
+//	        <field name="ranking.profile">us_ranking</field>
+//	    </query-profile>
+//	</query-profile>
+type QueryProfile struct {
+	XMLName    xml.Name              `xml:"query-profile"`
+	ID         string                `xml:"id,attr"`
+	Inherits   string                `xml:"inherits,attr,omitempty"`
+	Dimensions string                `xml:"dimensions,omitempty"`
+	Fields     []QueryProfileField   `xml:"field"`
+	Variants   []QueryProfileVariant `xml:"query-profile"`
+}
+
+// QueryProfileField is a single field of a query profile or variant
+type QueryProfileField struct {
+	Name        string `xml:"name,attr"`
+	Value       string `xml:",chardata"`
+	Overridable *bool  `xml:"overridable,attr"`
+}
+
+// QueryProfileVariant holds field values that apply for specific dimension values.
+// For lists one value per dimension, with "*" matching any value.
+type QueryProfileVariant struct {
+	For      string              `xml:"for,attr"`
+	Inherits string              `xml:"inherits,attr,omitempty"`
+	Fields   []QueryProfileField `xml:"field"`
+}
+
+// ParseQueryProfile parses a query profile XML document
+func ParseQueryProfile(data []byte) (*QueryProfile, error) {
+	var profile QueryProfile
+	if err := xml.Unmarshal(data, &profile); err != nil {
+		return nil, fmt.Errorf("invalid query profile: %w", err)
+	}
+	if profile.ID == "" {
+		return nil, fmt.Errorf("invalid query profile: missing id attribute")
+	}
+	for i := range profile.Fields {
+		profile.Fields[i].Value = strings.TrimSpace(profile.Fields[i].Value)
+	}
+	for i := range profile.Variants {
+		for j := range profile.Variants[i].Fields {
+			profile.Variants[i].Fields[j].Value = strings.TrimSpace(profile.Variants[i].Fields[j].Value)
+		}
+	}
+	return &profile, nil
+}
+
+// DimensionNames returns the variant dimensions of the profile in declaration order
+func (p *QueryProfile) DimensionNames() []string {
+	var names []string
+	for _, name := range strings.Split(p.Dimensions, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// =============================================================================
+// QueryProfileRegistry
+// =============================================================================
+
+// QueryProfileRegistry holds the query profiles of an application and resolves
+// inheritance and variants
+type QueryProfileRegistry struct {
+	profiles map[string]*QueryProfile
+}
+
+// NewQueryProfileRegistry creates a registry of the given profiles
+func NewQueryProfileRegistry(profiles ...*QueryProfile) *QueryProfileRegistry {
+	r := &QueryProfileRegistry{profiles: make(map[string]*QueryProfile)}
+	for _, p := range profiles {
+		r.Add(p)
+	}
+	return r
+}
+
+// LoadQueryProfiles reads all query profile XML files in a directory,
+// e.g. the search/query-profiles directory of an application package
+func LoadQueryProfiles(dir string) (*QueryProfileRegistry, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.xml"))
+	if err != nil {
+		return nil, err
+	}
+
+	registry := NewQueryProfileRegistry()
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		profile, err := ParseQueryProfile(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		registry.Add(profile)
+	}
+	return registry, nil
+}
+
+// Add registers a profile, replacing any profile with the same id
+func (r *QueryProfileRegistry) Add(profile *QueryProfile) {
+	r.profiles[profile.ID] = profile
+}
+
+// Get returns the profile with the given id
+func (r *QueryProfileRegistry) Get(id string) (*QueryProfile, bool) {
+	p, ok := r.profiles[id]
+	return p, ok
+}
+
+// resolvedField is a profile field value after inheritance and variants
+type resolvedField struct {
+	value       string
+	overridable bool
+}
+
+// Resolve returns the field values of a profile for the given dimension
+// values. Inherited profiles are applied first, then the profile's own
+// fields, then matching variants from least to most specific.
+func (r *QueryProfileRegistry) Resolve(id string, dimensions map[string]string) (map[string]string, error) {
+	fields, err := r.resolve(id, dimensions, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(fields))
+	for name, f := range fields {
+		values[name] = f.value
+	}
+	return values, nil
+}
+
+func (r *QueryProfileRegistry) resolve(id string, dimensions map[string]string, visiting []string) (map[string]resolvedField, error) {
+	for _, v := range visiting {
+		if v == id {
+			return nil, fmt.Errorf("query profile '%s' inherits itself", id)
+		}
+	}
+	profile, ok := r.profiles[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown query profile '%s'", id)
+	}
+	visiting = append(visiting, id)
+
+	fields := make(map[string]resolvedField)
+	if err := r.inherit(fields, profile.Inherits, dimensions, visiting); err != nil {
+		return nil, err
+	}
+	applyFields(fields, profile.Fields)
+
+	for _, variant := range matchingVariants(profile, dimensions) {
+		if err := r.inherit(fields, variant.Inherits, dimensions, visiting); err != nil {
+			return nil, err
+		}
+		applyFields(fields, variant.Fields)
+	}
+	return fields, nil
+}
+
+func (r *QueryProfileRegistry) inherit(fields map[string]resolvedField, inherits string, dimensions map[string]string, visiting []string) error {
+	for _, parent := range strings.Fields(inherits) {
+		inherited, err := r.resolve(parent, dimensions, visiting)
+		if err != nil {
+			return err
+		}
+		for name, f := range inherited {
+			fields[name] = f
+		}
+	}
+	return nil
+}
+
+func applyFields(fields map[string]resolvedField, profileFields []QueryProfileField) {
+	for _, f := range profileFields {
+		fields[f.Name] = resolvedField{
+			value:       f.Value,
+			overridable: f.Overridable == nil || *f.Overridable,
+		}
+	}
+}
+
+// matchingVariants returns the variants matching the dimension values,
+// ordered from least to most specific. Like Vespa, specificity is decided
+// from the leftmost dimension: a variant with a value where another has a
+// wildcard is more specific, whatever the later dimensions hold.
+func matchingVariants(profile *QueryProfile, dimensions map[string]string) []QueryProfileVariant {
+	names := profile.DimensionNames()
+
+	type match struct {
+		variant  QueryProfileVariant
+		specific []bool
+	}
+	var matches []match
+	for _, variant := range profile.Variants {
+		specific, ok := variantSpecificity(variant.For, names, dimensions)
+		if ok {
+			matches = append(matches, match{variant: variant, specific: specific})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		for d := range names {
+			if matches[i].specific[d] != matches[j].specific[d] {
+				return matches[j].specific[d]
+			}
+		}
+		return false
+	})
+	variants := make([]QueryProfileVariant, len(matches))
+	for i, m := range matches {
+		variants[i] = m.variant
+	}
+	return variants
+}
+
+// variantSpecificity reports whether a variant's for-values match the
+// dimension values, and for each dimension whether the variant has a
+// non-wildcard value for it
+func variantSpecificity(forValues string, names []string, dimensions map[string]string) ([]bool, bool) {
+	specific := make([]bool, len(names))
+	for i, value := range strings.Split(forValues, ",") {
+		value = strings.TrimSpace(value)
+		if value == "*" || value == "" {
+			continue
+		}
+		if i >= len(names) || dimensions[names[i]] != value {
+			return nil, false
+		}
+		specific[i] = true
+	}
+	return specific, true
+}
+
+// EffectiveRequest merges the defaults of the query's profile with the
+// query's own parameters, showing the request Vespa will effectively execute.
+// The profile is q.QueryProfile, or "default" when unset and registered.
+// Variant dimension values are read from the query parameters. Query
+// parameters override profile values unless a field is not overridable.
+func (r *QueryProfileRegistry) EffectiveRequest(q *VespaQuery) (map[string]interface{}, error) {
+	params, err := q.RequestParameters()
+	if err != nil {
+		return nil, err
+	}
+
+	id := q.QueryProfile
+	if id == "" {
+		if _, ok := r.profiles["default"]; !ok {
+			return params, nil
+		}
+		id = "default"
+	}
+	profile, ok := r.profiles[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown query profile '%s'", id)
+	}
+
+	dimensions := make(map[string]string)
+	for _, name := range profile.DimensionNames() {
+		if v, ok := params[name]; ok {
+			dimensions[name] = fmt.Sprintf("%v", v)
+		}
+	}
+
+	fields, err := r.resolve(id, dimensions, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	effective := make(map[string]interface{}, len(fields)+len(params))
+	for name, f := range fields {
+		effective[name] = f.value
+	}
+	for name, v := range params {
+		if f, ok := fields[name]; ok && !f.overridable {
+			continue
+		}
+		effective[name] = v
+	}
+	return effective, nil
+}
@@ -0,0 +1,229 @@
+package vespa
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const defaultProfileXML = `
+<query-profile id="default">
+    <field name="hits">20</field>
+    <field name="ranking.profile">bm25</field>
+    <field name="timeout">500ms</field>
+</query-profile>`
+
+const mobileProfileXML = `
+<query-profile id="mobile" inherits="default">
+    <dimensions>region,device</dimensions>
+    <field name="hits">5</field>
+    <field name="model.defaultIndex" overridable="false">title</field>
+    <query-profile for="us,*">
+        <field name="ranking.profile">us_ranking</field>
+    </query-profile>
+    <query-profile for="us,tablet">
+        <field name="hits">10</field>
+    </query-profile>
+</query-profile>`
+
+func newTestProfileRegistry(t *testing.T) *QueryProfileRegistry {
+	t.Helper()
+
+	var profiles []*QueryProfile
+	for _, data := range []string{defaultProfileXML, mobileProfileXML} {
+		profile, err := ParseQueryProfile([]byte(data))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		profiles = append(profiles, profile)
+	}
+	return NewQueryProfileRegistry(profiles...)
+}
+
+func TestParseQueryProfile(t *testing.T) {
+	profile, err := ParseQueryProfile([]byte(mobileProfileXML))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if profile.ID != "mobile" || profile.Inherits != "default" {
+		t.Errorf("Unexpected profile header: id=%q inherits=%q", profile.ID, profile.Inherits)
+	}
+	if dims := profile.DimensionNames(); len(dims) != 2 || dims[0] != "region" || dims[1] != "device" {
+		t.Errorf("Unexpected dimensions %v", dims)
+	}
+	if len(profile.Fields) != 2 || profile.Fields[0].Name != "hits" || profile.Fields[0].Value != "5" {
+		t.Errorf("Unexpected fields %+v", profile.Fields)
+	}
+	if len(profile.Variants) != 2 || profile.Variants[1].For != "us,tablet" {
+		t.Errorf("Unexpected variants %+v", profile.Variants)
+	}
+
+	if _, err := ParseQueryProfile([]byte(`<query-profile><field name="hits">5</field></query-profile>`)); err == nil {
+		t.Error("Expected error for profile without id")
+	}
+}
+
+func TestQueryProfileRegistryResolve(t *testing.T) {
+	registry := newTestProfileRegistry(t)
+
+	tests := []struct {
+		name       string
+		dimensions map[string]string
+		expected   map[string]string
+	}{
+		{
+			"No variant",
+			nil,
+			map[string]string{"hits": "5", "ranking.profile": "bm25", "timeout": "500ms", "model.defaultIndex": "title"},
+		},
+		{
+			"Wildcard variant",
+			map[string]string{"region": "us", "device": "phone"},
+			map[string]string{"hits": "5", "ranking.profile": "us_ranking", "timeout": "500ms", "model.defaultIndex": "title"},
+		},
+		{
+			"Most specific variant wins",
+			map[string]string{"region": "us", "device": "tablet"},
+			map[string]string{"hits": "10", "ranking.profile": "us_ranking", "timeout": "500ms", "model.defaultIndex": "title"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := registry.Resolve("mobile", tt.dimensions)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(values) != len(tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, values)
+			}
+			for k, v := range tt.expected {
+				if values[k] != v {
+					t.Errorf("Expected %s=%q, got %q", k, v, values[k])
+				}
+			}
+		})
+	}
+}
+
+func TestQueryProfileVariantDimensionOrder(t *testing.T) {
+	profile, err := ParseQueryProfile([]byte(`
+<query-profile id="search">
+    <dimensions>region,device</dimensions>
+    <query-profile for="us,*">
+        <field name="ranking.profile">us_ranking</field>
+    </query-profile>
+    <query-profile for="*,mobile">
+        <field name="ranking.profile">mobile_ranking</field>
+        <field name="hits">5</field>
+    </query-profile>
+</query-profile>`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	registry := NewQueryProfileRegistry(profile)
+
+	// Both variants have one value; the one specific in the leftmost
+	// dimension wins regardless of declaration order
+	values, err := registry.Resolve("search", map[string]string{"region": "us", "device": "mobile"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if values["ranking.profile"] != "us_ranking" {
+		t.Errorf("Expected ranking.profile %q, got %q", "us_ranking", values["ranking.profile"])
+	}
+	if values["hits"] != "5" {
+		t.Errorf("Expected hits %q from the less specific variant, got %q", "5", values["hits"])
+	}
+}
+
+func TestQueryProfileRegistryErrors(t *testing.T) {
+	loop, _ := ParseQueryProfile([]byte(`<query-profile id="a" inherits="b"/>`))
+	back, _ := ParseQueryProfile([]byte(`<query-profile id="b" inherits="a"/>`))
+	registry := NewQueryProfileRegistry(loop, back)
+
+	if _, err := registry.Resolve("a", nil); err == nil {
+		t.Error("Expected error for inheritance cycle")
+	}
+	if _, err := registry.Resolve("missing", nil); err == nil {
+		t.Error("Expected error for unknown profile")
+	}
+}
+
+func TestQueryProfileEffectiveRequest(t *testing.T) {
+	registry := newTestProfileRegistry(t)
+
+	query, err := NewQueryBuilder().
+		From("products").
+		WithQueryProfile("mobile").
+		WithParameter("region", "us").
+		WithParameter("device", "tablet").
+		WithParameter("model.defaultIndex", "description").
+		WithHits(3).
+		Build()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	effective, err := registry.EffectiveRequest(query)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"yql":                "select * from sources products where true",
+		"queryProfile":       "mobile",
+		"region":             "us",
+		"device":             "tablet",
+		"hits":               int64(3),
+		"ranking.profile":    "us_ranking",
+		"timeout":            "500ms",
+		"model.defaultIndex": "title",
+	}
+	if len(effective) != len(expected) {
+		t.Errorf("Expected %v, got %v", expected, effective)
+	}
+	for k, v := range expected {
+		if effective[k] != v {
+			t.Errorf("Expected %s=%v (%T), got %v (%T)", k, v, v, effective[k], effective[k])
+		}
+	}
+}
+
+func TestQueryProfileEffectiveRequestUsesDefaultProfile(t *testing.T) {
+	registry := newTestProfileRegistry(t)
+
+	query, err := NewQueryBuilder().From("products").WithRanking("semantic").Build()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	effective, err := registry.EffectiveRequest(query)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if effective["ranking.profile"] != "semantic" || effective["hits"] != "20" {
+		t.Errorf("Expected default profile merged with query ranking, got %v", effective)
+	}
+}
+
+func TestLoadQueryProfiles(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{"default.xml": defaultProfileXML, "mobile.xml": mobileProfileXML} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	registry, err := LoadQueryProfiles(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := registry.Get("mobile"); !ok {
+		t.Error("Expected mobile profile to be loaded")
+	}
+	if _, ok := registry.Get("default"); !ok {
+		t.Error("Expected default profile to be loaded")
+	}
+}
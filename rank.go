@@ -40,6 +40,18 @@ func (r *RankExpressionImpl) AddCondition(condition WhereCondition) RankExpressi
 	return r
 }
 
+// Conditions returns the conditions of the rank expression, in rendering order
+func (r *RankExpressionImpl) Conditions() []WhereCondition {
+	return append(make([]WhereCondition, 0, len(r.conditions)), r.conditions...)
+}
+
+// clone returns a copy of the rank expression with its own condition list
+func (r *RankExpressionImpl) clone() *RankExpressionImpl {
+	return &RankExpressionImpl{
+		conditions: append(make([]WhereCondition, 0, len(r.conditions)), r.conditions...),
+	}
+}
+
 // ToYQL converts the rank expression to YQL format
 func (r *RankExpressionImpl) ToYQL() string {
 	if len(r.conditions) == 0 {
@@ -0,0 +1,520 @@
+// Package rankexpr builds, renders and parses Vespa ranking expressions, as
+// used in rank profiles and query-time overrides:
+//
+//	expr := rankexpr.Add(
+//		rankexpr.BM25("title"),
+//		rankexpr.Mul(rankexpr.Number(0.3), rankexpr.Closeness("embedding")),
+//		rankexpr.Attribute("popularity"),
+//	)
+//	expr.String() // bm25(title) + 0.3 * closeness(field,embedding) + attribute(popularity)
+//
+// Parse reads expressions back into the same tree.
+package rankexpr
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vipulsodha/vespa-go/tensor"
+)
+
+// Operator precedence, lowest first, as in Vespa's grammar: % binds tighter
+// than * and /, so a * b % c is a * (b % c), and unary minus and not apply to
+// their operand before ^, so -x ^ 2 is (-x) ^ 2.
+const (
+	precOr = iota + 1
+	precAnd
+	precCompare
+	precAdd
+	precMul
+	precMod
+	precPow
+	precUnary
+	precPrimary
+)
+
+// Node is a ranking expression
+type Node interface {
+	// String renders the expression in Vespa syntax
+	String() string
+	precedence() int
+}
+
+// NumberLiteral is a numeric constant
+type NumberLiteral struct {
+	Value float64
+}
+
+// StringLiteral is a string constant, compared against string attributes in
+// conditions and switch cases
+type StringLiteral struct {
+	Value string
+}
+
+// TensorLiteral is a constant tensor, e.g. tensor(x[2]):[1,2]
+type TensorLiteral struct {
+	Tensor *tensor.Tensor
+}
+
+// Feature is a rank feature or a call of a rank profile function, e.g.
+// bm25(title), attribute(popularity), fieldMatch(title).completeness or
+// firstPhase. Arguments are kept as written, since feature arguments are
+// field names and labels rather than expressions.
+type Feature struct {
+	Name   string
+	Args   []string
+	Output string
+}
+
+// Variable is a parameter of a lambda, e.g. x in f(x)(x * 2)
+type Variable struct {
+	Name string
+}
+
+// BinaryOp is an arithmetic, comparison or logical operation
+type BinaryOp struct {
+	Operator string // + - * / % ^ == != < <= > >= ~= && ||
+	Left     Node
+	Right    Node
+}
+
+// UnaryOp is a negation (-) or logical not (!)
+type UnaryOp struct {
+	Operator string
+	Operand  Node
+}
+
+// Call is a call of a built-in math or tensor function, e.g. max(a, b),
+// sigmoid(x) or reduce(t, sum, x). Dimension names and aggregators are
+// Feature nodes without arguments.
+type Call struct {
+	Name string
+	Args []Node
+}
+
+// LambdaExpr is a function argument of a tensor operation, e.g. f(x,y)(x * y)
+type LambdaExpr struct {
+	Params []string
+	Body   Node
+}
+
+// IfExpr is if (condition, then, else)
+type IfExpr struct {
+	Condition Node
+	Then      Node
+	Else      Node
+}
+
+// SwitchExpr selects the result of the first case matching a value, e.g.
+// switch (attribute(category)) { case "shoes": 1, default: 0 }
+type SwitchExpr struct {
+	Value   Node
+	Cases   []Case
+	Default Node
+}
+
+// Case is a case of a switch expression
+type Case struct {
+	Match  Node
+	Result Node
+}
+
+// =============================================================================
+// Constructors
+// =============================================================================
+
+// Number returns a numeric constant
+func Number(value float64) *NumberLiteral {
+	return &NumberLiteral{Value: value}
+}
+
+// String returns a string constant
+func String(value string) *StringLiteral {
+	return &StringLiteral{Value: value}
+}
+
+// Tensor returns a constant tensor
+func Tensor(t *tensor.Tensor) *TensorLiteral {
+	return &TensorLiteral{Tensor: t}
+}
+
+// NewFeature returns a rank feature, e.g. NewFeature("fieldMatch", "title")
+func NewFeature(name string, args ...string) *Feature {
+	return &Feature{Name: name, Args: args}
+}
+
+// Dot returns the feature with an output selected, e.g.
+// NewFeature("fieldMatch", "title").Dot("completeness")
+func (f *Feature) Dot(output string) *Feature {
+	return &Feature{Name: f.Name, Args: f.Args, Output: output}
+}
+
+// BM25 returns bm25(field)
+func BM25(field string) *Feature {
+	return NewFeature("bm25", field)
+}
+
+// Attribute returns attribute(name)
+func Attribute(name string) *Feature {
+	return NewFeature("attribute", name)
+}
+
+// Query returns query(name), a query input
+func Query(name string) *Feature {
+	return NewFeature("query", name)
+}
+
+// Closeness returns closeness(field,name), the closeness of the nearest
+// neighbor match on a tensor field
+func Closeness(field string) *Feature {
+	return NewFeature("closeness", "field", field)
+}
+
+// Distance returns distance(field,name)
+func Distance(field string) *Feature {
+	return NewFeature("distance", "field", field)
+}
+
+// NativeRank returns nativeRank(fields...)
+func NativeRank(fields ...string) *Feature {
+	return NewFeature("nativeRank", fields...)
+}
+
+// FieldMatch returns fieldMatch(field)
+func FieldMatch(field string) *Feature {
+	return NewFeature("fieldMatch", field)
+}
+
+// Constant returns constant(name), a constant declared in the schema
+func Constant(name string) *Feature {
+	return NewFeature("constant", name)
+}
+
+// Var returns a lambda parameter
+func Var(name string) *Variable {
+	return &Variable{Name: name}
+}
+
+// Add returns a + b + ...
+func Add(a, b Node, more ...Node) *BinaryOp { return chain("+", a, b, more) }
+
+// Sub returns a - b
+func Sub(a, b Node) *BinaryOp { return &BinaryOp{Operator: "-", Left: a, Right: b} }
+
+// Mul returns a * b * ...
+func Mul(a, b Node, more ...Node) *BinaryOp { return chain("*", a, b, more) }
+
+// Div returns a / b
+func Div(a, b Node) *BinaryOp { return &BinaryOp{Operator: "/", Left: a, Right: b} }
+
+// Mod returns a % b
+func Mod(a, b Node) *BinaryOp { return &BinaryOp{Operator: "%", Left: a, Right: b} }
+
+// Pow returns a ^ b
+func Pow(a, b Node) *BinaryOp { return &BinaryOp{Operator: "^", Left: a, Right: b} }
+
+// Eq returns a == b
+func Eq(a, b Node) *BinaryOp { return &BinaryOp{Operator: "==", Left: a, Right: b} }
+
+// NotEq returns a != b
+func NotEq(a, b Node) *BinaryOp { return &BinaryOp{Operator: "!=", Left: a, Right: b} }
+
+// Lt returns a < b
+func Lt(a, b Node) *BinaryOp { return &BinaryOp{Operator: "<", Left: a, Right: b} }
+
+// Lte returns a <= b
+func Lte(a, b Node) *BinaryOp { return &BinaryOp{Operator: "<=", Left: a, Right: b} }
+
+// Gt returns a > b
+func Gt(a, b Node) *BinaryOp { return &BinaryOp{Operator: ">", Left: a, Right: b} }
+
+// Gte returns a >= b
+func Gte(a, b Node) *BinaryOp { return &BinaryOp{Operator: ">=", Left: a, Right: b} }
+
+// Approx returns a ~= b, true when a and b differ by less than 1e-6 relative
+func Approx(a, b Node) *BinaryOp { return &BinaryOp{Operator: "~=", Left: a, Right: b} }
+
+// And returns a && b && ...
+func And(a, b Node, more ...Node) *BinaryOp { return chain("&&", a, b, more) }
+
+// Or returns a || b || ...
+func Or(a, b Node, more ...Node) *BinaryOp { return chain("||", a, b, more) }
+
+// Neg returns -operand
+func Neg(operand Node) *UnaryOp { return &UnaryOp{Operator: "-", Operand: operand} }
+
+// Not returns !operand
+func Not(operand Node) *UnaryOp { return &UnaryOp{Operator: "!", Operand: operand} }
+
+func chain(operator string, a, b Node, more []Node) *BinaryOp {
+	result := &BinaryOp{Operator: operator, Left: a, Right: b}
+	for _, n := range more {
+		result = &BinaryOp{Operator: operator, Left: result, Right: n}
+	}
+	return result
+}
+
+// Func returns a call of a built-in function, e.g. Func("max", a, b)
+func Func(name string, args ...Node) *Call {
+	return &Call{Name: name, Args: args}
+}
+
+// Lambda returns a lambda for map and join, e.g.
+// Lambda(Mul(Var("x"), Var("y")), "x", "y") for f(x,y)(x * y)
+func Lambda(body Node, params ...string) *LambdaExpr {
+	return &LambdaExpr{Params: params, Body: body}
+}
+
+// Reduce returns reduce(t, aggregator, dimensions...), e.g.
+// Reduce(t, "sum", "x"). Without dimensions all dimensions are reduced.
+func Reduce(t Node, aggregator string, dimensions ...string) *Call {
+	args := []Node{t, NewFeature(aggregator)}
+	for _, d := range dimensions {
+		args = append(args, NewFeature(d))
+	}
+	return Func("reduce", args...)
+}
+
+// Map returns map(t, f), applying f to every cell
+func Map(t Node, f *LambdaExpr) *Call {
+	return Func("map", t, f)
+}
+
+// Join returns join(a, b, f), combining cells with matching addresses
+func Join(a, b Node, f *LambdaExpr) *Call {
+	return Func("join", a, b, f)
+}
+
+// If returns if (condition, then, else)
+func If(condition, then, otherwise Node) *IfExpr {
+	return &IfExpr{Condition: condition, Then: then, Else: otherwise}
+}
+
+// Switch returns a switch on value; add cases with Case and the default
+// result with Otherwise
+func Switch(value Node) *SwitchExpr {
+	return &SwitchExpr{Value: value}
+}
+
+// Case adds a case to the switch
+func (s *SwitchExpr) Case(match, result Node) *SwitchExpr {
+	s.Cases = append(s.Cases, Case{Match: match, Result: result})
+	return s
+}
+
+// Otherwise sets the default result, used when no case matches
+func (s *SwitchExpr) Otherwise(result Node) *SwitchExpr {
+	s.Default = result
+	return s
+}
+
+// =============================================================================
+// Rendering
+// =============================================================================
+
+func (n *NumberLiteral) String() string {
+	return strconv.FormatFloat(n.Value, 'g', -1, 64)
+}
+
+func (n *NumberLiteral) precedence() int {
+	if n.Value < 0 {
+		return precUnary
+	}
+	return precPrimary
+}
+
+func (s *StringLiteral) String() string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s.Value) + `"`
+}
+
+func (s *StringLiteral) precedence() int { return precPrimary }
+
+func (t *TensorLiteral) String() string { return t.Tensor.Literal() }
+
+func (t *TensorLiteral) precedence() int { return precPrimary }
+
+// String renders the feature in Vespa's canonical form, without spaces, as
+// used for the keys of summaryfeatures and matchfeatures
+func (f *Feature) String() string {
+	s := f.Name
+	if len(f.Args) > 0 {
+		s += "(" + strings.Join(f.Args, ",") + ")"
+	}
+	if f.Output != "" {
+		s += "." + f.Output
+	}
+	return s
+}
+
+func (f *Feature) precedence() int { return precPrimary }
+
+func (v *Variable) String() string { return v.Name }
+
+func (v *Variable) precedence() int { return precPrimary }
+
+func (b *BinaryOp) String() string {
+	prec := b.precedence()
+	left, right := render(b.Left), render(b.Right)
+
+	// ^ is right-associative, the other operators left-associative
+	if b.Left.precedence() < prec || (b.Operator == "^" && b.Left.precedence() == prec) {
+		left = "(" + left + ")"
+	}
+	if b.Right.precedence() < prec || (b.Operator != "^" && b.Right.precedence() == prec) {
+		right = "(" + right + ")"
+	}
+	return left + " " + b.Operator + " " + right
+}
+
+func (b *BinaryOp) precedence() int {
+	switch b.Operator {
+	case "||":
+		return precOr
+	case "&&":
+		return precAnd
+	case "+", "-":
+		return precAdd
+	case "*", "/":
+		return precMul
+	case "%":
+		return precMod
+	case "^":
+		return precPow
+	default:
+		return precCompare
+	}
+}
+
+func (u *UnaryOp) String() string {
+	operand := render(u.Operand)
+	if u.Operand.precedence() < precUnary {
+		operand = "(" + operand + ")"
+	}
+	return u.Operator + operand
+}
+
+func (u *UnaryOp) precedence() int { return precUnary }
+
+func (c *Call) String() string {
+	return c.Name + "(" + renderList(c.Args) + ")"
+}
+
+func (c *Call) precedence() int { return precPrimary }
+
+func (l *LambdaExpr) String() string {
+	return "f(" + strings.Join(l.Params, ",") + ")(" + render(l.Body) + ")"
+}
+
+func (l *LambdaExpr) precedence() int { return precPrimary }
+
+func (i *IfExpr) String() string {
+	return "if (" + renderList([]Node{i.Condition, i.Then, i.Else}) + ")"
+}
+
+func (i *IfExpr) precedence() int { return precPrimary }
+
+func (s *SwitchExpr) String() string {
+	var parts []string
+	for _, c := range s.Cases {
+		parts = append(parts, "case "+render(c.Match)+": "+render(c.Result))
+	}
+	if s.Default != nil {
+		parts = append(parts, "default: "+render(s.Default))
+	}
+	return "switch (" + render(s.Value) + ") { " + strings.Join(parts, ", ") + " }"
+}
+
+func (s *SwitchExpr) precedence() int { return precPrimary }
+
+// render renders a possibly nil node
+func render(n Node) string {
+	if n == nil {
+		return ""
+	}
+	return n.String()
+}
+
+func renderList(nodes []Node) string {
+	parts := make([]string, len(nodes))
+	for i, n := range nodes {
+		parts[i] = render(n)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// =============================================================================
+// Inspection
+// =============================================================================
+
+// Children returns the direct sub-expressions of a node
+func Children(n Node) []Node {
+	switch n := n.(type) {
+	case *BinaryOp:
+		return []Node{n.Left, n.Right}
+	case *UnaryOp:
+		return []Node{n.Operand}
+	case *Call:
+		return n.Args
+	case *LambdaExpr:
+		return []Node{n.Body}
+	case *IfExpr:
+		return []Node{n.Condition, n.Then, n.Else}
+	case *SwitchExpr:
+		children := []Node{n.Value}
+		for _, c := range n.Cases {
+			children = append(children, c.Match, c.Result)
+		}
+		if n.Default != nil {
+			children = append(children, n.Default)
+		}
+		return children
+	default:
+		return nil
+	}
+}
+
+// nameArgs gives, for tensor functions taking dimension names or
+// aggregators, the index of the first such argument
+var nameArgs = map[string]int{
+	"reduce": 1, "rename": 1, "cell_cast": 1, "expand": 1,
+	"argmax": 1, "argmin": 1, "avg": 1, "count": 1, "prod": 1, "sum": 1,
+	"softmax": 1, "l1_normalize": 1, "l2_normalize": 1,
+	"concat": 2, "matmul": 2, "cosine_similarity": 2, "euclidean_distance": 2,
+	"xw_plus_b": 3,
+}
+
+// Features returns the rank features an expression references, in canonical
+// form and sorted, e.g. [attribute(popularity) bm25(title)]. Dimension names
+// and aggregators of tensor functions are not features and are left out.
+func Features(n Node) []string {
+	seen := make(map[string]bool)
+	var collect func(Node)
+	collect = func(n Node) {
+		switch n := n.(type) {
+		case nil:
+		case *Feature:
+			seen[n.String()] = true
+		case *Call:
+			first, ok := nameArgs[n.Name]
+			for i, arg := range n.Args {
+				if ok && i >= first {
+					continue
+				}
+				collect(arg)
+			}
+		default:
+			for _, child := range Children(n) {
+				collect(child)
+			}
+		}
+	}
+	collect(n)
+
+	features := make([]string, 0, len(seen))
+	for f := range seen {
+		features = append(features, f)
+	}
+	sort.Strings(features)
+	return features
+}
@@ -0,0 +1,931 @@
+package rankexpr
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	vespa "github.com/vipulsodha/vespa-go"
+	"github.com/vipulsodha/vespa-go/schema"
+	"github.com/vipulsodha/vespa-go/tensor"
+)
+
+// =============================================================================
+// Evaluation
+// =============================================================================
+
+// Evaluate computes an expression offline from rank feature values, to
+// reproduce and debug the scores Vespa computes:
+//
+//	features, _ := rankexpr.HitFeatures(hit) // summaryfeatures and matchfeatures
+//	score, err := rankexpr.Evaluate(rankexpr.MustParse(profile.FirstPhase.Expression), features,
+//		rankexpr.WithRankProfile(s, "hybrid"))
+//
+// Features are keyed by their canonical name, e.g. "bm25(title)" or
+// "closeness(field,embedding)", and hold numbers, strings (compared with ==
+// in switch and if) or *tensor.Tensor values. Evaluate returns an error if a
+// feature has no value or the expression evaluates to a tensor; use
+// EvaluateTensor for tensor results.
+func Evaluate(n Node, features map[string]interface{}, opts ...EvaluateOption) (float64, error) {
+	result, err := evaluate(n, features, opts)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case result.str != nil:
+		return 0, fmt.Errorf("rankexpr: expression evaluates to the string '%s'", *result.str)
+	case result.tensor != nil:
+		return 0, fmt.Errorf("rankexpr: expression evaluates to a tensor of type %s, use EvaluateTensor", result.tensor.Type())
+	}
+	return result.number, nil
+}
+
+// EvaluateTensor is like Evaluate for expressions computing tensors. Numbers
+// are returned as tensors without dimensions.
+func EvaluateTensor(n Node, features map[string]interface{}, opts ...EvaluateOption) (*tensor.Tensor, error) {
+	result, err := evaluate(n, features, opts)
+	if err != nil {
+		return nil, err
+	}
+	if result.str != nil {
+		return nil, fmt.Errorf("rankexpr: expression evaluates to the string '%s'", *result.str)
+	}
+	return result.asTensor()
+}
+
+// EvaluateOption configures the evaluation of an expression
+type EvaluateOption func(*evaluator)
+
+// WithFunction defines a rank profile function for the evaluation, called as
+// name or name(args...) in expressions. Feature values given for a function
+// take precedence over its body, so functions reported in summaryfeatures
+// evaluate to the reported value.
+func WithFunction(name string, body Node, params ...string) EvaluateOption {
+	return func(e *evaluator) {
+		if e != nil {
+			e.functions[name] = &function{params: params, body: body}
+		}
+	}
+}
+
+// WithRankProfile defines the functions of a rank profile of the schema,
+// including inherited ones, and makes its phases available as the
+// firstPhase, secondPhase and globalPhase features. Query inputs declared by the profile default to
+// their declared default value, or zero.
+func WithRankProfile(s *schema.Schema, profile string) EvaluateOption {
+	return func(e *evaluator) {
+		if e != nil && e.err == nil {
+			e.err = e.addRankProfile(s, profile, nil)
+		}
+	}
+}
+
+// HitFeatures returns the summaryfeatures and matchfeatures of a hit as
+// feature values for Evaluate, decoding tensor features to *tensor.Tensor
+func HitFeatures(hit vespa.Hit) (map[string]interface{}, error) {
+	features := make(map[string]interface{})
+	for _, field := range []string{"matchfeatures", "summaryfeatures"} {
+		values, ok := hit.Fields[field].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for name, v := range values {
+			encoded, ok := v.(map[string]interface{})
+			if !ok {
+				features[name] = v
+				continue
+			}
+			t, err := decodeTensorFeature(encoded)
+			if err != nil {
+				return nil, fmt.Errorf("rankexpr: feature '%s': %v", name, err)
+			}
+			features[name] = t
+		}
+	}
+	return features, nil
+}
+
+// decodeTensorFeature decodes a tensor in Vespa's JSON form with its type,
+// as returned in summaryfeatures
+func decodeTensorFeature(encoded map[string]interface{}) (*tensor.Tensor, error) {
+	spec, ok := encoded["type"].(string)
+	if !ok {
+		return nil, fmt.Errorf("tensor without type")
+	}
+	t, err := tensor.ParseType(spec)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(encoded)
+	if err != nil {
+		return nil, err
+	}
+	return tensor.Decode(t, data)
+}
+
+// function is a rank profile function
+type function struct {
+	params []string
+	body   Node
+}
+
+type evaluator struct {
+	features  map[string]interface{}
+	functions map[string]*function
+	phases    map[string]Node
+	inputs    map[string]*schema.Input
+	calls     []string // functions being evaluated, to detect recursion
+	err       error
+}
+
+// value is a number, a string or a tensor with dimensions
+type value struct {
+	number float64
+	str    *string
+	tensor *tensor.Tensor
+}
+
+func numberValue(v float64) value {
+	return value{number: v}
+}
+
+// tensorValue returns the tensor, or its single cell for a tensor without
+// dimensions
+func tensorValue(t *tensor.Tensor) value {
+	if len(t.Type().Dimensions) == 0 {
+		v, _ := t.Get(tensor.Address{})
+		return numberValue(v)
+	}
+	return value{tensor: t}
+}
+
+func (v value) asTensor() (*tensor.Tensor, error) {
+	if v.tensor != nil {
+		return v.tensor, nil
+	}
+	t, err := newTensor(tensor.Double, nil)
+	if err != nil {
+		return nil, err
+	}
+	return t, t.Set(tensor.Address{}, v.number)
+}
+
+func (v value) String() string {
+	switch {
+	case v.str != nil:
+		return strconv.Quote(*v.str)
+	case v.tensor != nil:
+		return v.tensor.Literal()
+	default:
+		return strconv.FormatFloat(v.number, 'g', -1, 64)
+	}
+}
+
+func evaluate(n Node, features map[string]interface{}, opts []EvaluateOption) (value, error) {
+	e := &evaluator{
+		features:  make(map[string]interface{}, len(features)),
+		functions: make(map[string]*function),
+		phases:    make(map[string]Node),
+		inputs:    make(map[string]*schema.Input),
+	}
+	for name, v := range features {
+		e.features[canonicalFeature(name)] = v
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.err != nil {
+		return value{}, e.err
+	}
+	return e.eval(n, nil)
+}
+
+// canonicalFeature normalizes a feature name, so "closeness(field, embedding)"
+// and "closeness(field,embedding)" address the same value
+func canonicalFeature(name string) string {
+	if n, err := Parse(name); err == nil {
+		if f, ok := n.(*Feature); ok {
+			return f.String()
+		}
+	}
+	return name
+}
+
+// addRankProfile adds the functions, phases and inputs of a rank profile,
+// after those of the profiles it inherits so that its own override them
+func (e *evaluator) addRankProfile(s *schema.Schema, profile string, visiting []string) error {
+	rp, ok := s.RankProfile(profile)
+	if !ok {
+		if profile == "default" {
+			return nil
+		}
+		return fmt.Errorf("rankexpr: rank profile '%s' not found in schema '%s'", profile, s.Name)
+	}
+	for _, name := range visiting {
+		if name == profile {
+			return nil
+		}
+	}
+	for _, parent := range rp.Inherits {
+		if err := e.addRankProfile(s, parent, append(visiting, profile)); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range rp.Functions {
+		body, err := Parse(f.Expression)
+		if err != nil {
+			return fmt.Errorf("rankexpr: function '%s' of rank profile '%s': %v", f.Name, profile, err)
+		}
+		e.functions[f.Name] = &function{params: f.Parameters, body: body}
+	}
+	phases := map[string]*schema.Phase{
+		"firstPhase":  rp.FirstPhase,
+		"secondPhase": rp.SecondPhase,
+		"globalPhase": rp.GlobalPhase,
+	}
+	for name, phase := range phases {
+		if phase == nil {
+			continue
+		}
+		body, err := Parse(phase.Expression)
+		if err != nil {
+			return fmt.Errorf("rankexpr: %s of rank profile '%s': %v", name, profile, err)
+		}
+		e.phases[name] = body
+	}
+	for _, input := range rp.Inputs {
+		e.inputs[input.Name] = input
+	}
+	return nil
+}
+
+func (e *evaluator) eval(n Node, scope map[string]value) (value, error) {
+	switch n := n.(type) {
+	case *NumberLiteral:
+		return numberValue(n.Value), nil
+	case *StringLiteral:
+		s := n.Value
+		return value{str: &s}, nil
+	case *TensorLiteral:
+		return tensorValue(n.Tensor), nil
+	case *Variable:
+		if v, ok := scope[n.Name]; ok {
+			return v, nil
+		}
+		return value{}, fmt.Errorf("rankexpr: unbound variable '%s'", n.Name)
+	case *Feature:
+		return e.feature(n, scope)
+	case *UnaryOp:
+		operand, err := e.eval(n.Operand, scope)
+		if err != nil {
+			return value{}, err
+		}
+		if n.Operator == "!" {
+			return apply1(operand, func(x float64) float64 { return boolNumber(x == 0) })
+		}
+		return apply1(operand, func(x float64) float64 { return -x })
+	case *BinaryOp:
+		return e.binary(n, scope)
+	case *Call:
+		return e.call(n, scope)
+	case *IfExpr:
+		condition, err := e.eval(n.Condition, scope)
+		if err != nil {
+			return value{}, err
+		}
+		if condition.str != nil || condition.tensor != nil {
+			return value{}, fmt.Errorf("rankexpr: if condition %s is not a number", n.Condition)
+		}
+		if condition.number != 0 {
+			return e.eval(n.Then, scope)
+		}
+		return e.eval(n.Else, scope)
+	case *SwitchExpr:
+		return e.switchExpr(n, scope)
+	case *LambdaExpr:
+		return value{}, fmt.Errorf("rankexpr: lambda %s outside map, join or merge", n)
+	default:
+		return value{}, fmt.Errorf("rankexpr: cannot evaluate %T", n)
+	}
+}
+
+// feature resolves a feature from, in order: lambda and function parameters,
+// the given feature values, functions, phases and query input defaults
+func (e *evaluator) feature(f *Feature, scope map[string]value) (value, error) {
+	if len(f.Args) == 0 && f.Output == "" {
+		if v, ok := scope[f.Name]; ok {
+			return v, nil
+		}
+	}
+	name := f.String()
+	if v, ok := e.features[name]; ok {
+		return featureValue(name, v)
+	}
+
+	if f.Output == "" {
+		if fn, ok := e.functions[f.Name]; ok && len(fn.params) == len(f.Args) {
+			return e.callFunction(f.Name, fn, f.Args, scope)
+		}
+		if f.Name == "rankingExpression" && len(f.Args) == 1 {
+			if fn, ok := e.functions[f.Args[0]]; ok && len(fn.params) == 0 {
+				return e.callFunction(f.Args[0], fn, nil, scope)
+			}
+		}
+		if phase, ok := e.phases[f.Name]; ok && len(f.Args) == 0 {
+			return e.callFunction(f.Name, &function{body: phase}, nil, scope)
+		}
+		if input, ok := e.inputs[strings.Join(f.Args, ",")]; ok && f.Name == "query" {
+			return inputDefault(input)
+		}
+	}
+	return value{}, fmt.Errorf("rankexpr: no value for feature '%s'", name)
+}
+
+func (e *evaluator) callFunction(name string, fn *function, args []string, scope map[string]value) (value, error) {
+	for _, call := range e.calls {
+		if call == name {
+			return value{}, fmt.Errorf("rankexpr: function '%s' is recursive", name)
+		}
+	}
+
+	bound := make(map[string]value, len(fn.params))
+	for i, arg := range args {
+		n, err := Parse(arg)
+		if err != nil {
+			return value{}, fmt.Errorf("rankexpr: argument '%s' of function '%s': %v", arg, name, err)
+		}
+		if bound[fn.params[i]], err = e.eval(n, scope); err != nil {
+			return value{}, err
+		}
+	}
+
+	e.calls = append(e.calls, name)
+	defer func() { e.calls = e.calls[:len(e.calls)-1] }()
+	return e.eval(fn.body, bound)
+}
+
+func featureValue(name string, v interface{}) (value, error) {
+	switch v := v.(type) {
+	case float64:
+		return numberValue(v), nil
+	case float32:
+		return numberValue(float64(v)), nil
+	case int:
+		return numberValue(float64(v)), nil
+	case int8:
+		return numberValue(float64(v)), nil
+	case int16:
+		return numberValue(float64(v)), nil
+	case int32:
+		return numberValue(float64(v)), nil
+	case int64:
+		return numberValue(float64(v)), nil
+	case uint:
+		return numberValue(float64(v)), nil
+	case uint8:
+		return numberValue(float64(v)), nil
+	case uint16:
+		return numberValue(float64(v)), nil
+	case uint32:
+		return numberValue(float64(v)), nil
+	case uint64:
+		return numberValue(float64(v)), nil
+	case bool:
+		return numberValue(boolNumber(v)), nil
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return value{}, fmt.Errorf("rankexpr: invalid number '%s' for feature '%s'", v, name)
+		}
+		return numberValue(f), nil
+	case string:
+		return value{str: &v}, nil
+	case *tensor.Tensor:
+		if v == nil {
+			break
+		}
+		return tensorValue(v), nil
+	}
+	return value{}, fmt.Errorf("rankexpr: unsupported value type %T for feature '%s'", v, name)
+}
+
+// inputDefault returns the value of a query input not set by the query
+func inputDefault(input *schema.Input) (value, error) {
+	if input.Type.Kind != schema.Tensor {
+		if input.Default == "" {
+			return numberValue(0), nil
+		}
+		f, err := strconv.ParseFloat(input.Default, 64)
+		if err != nil {
+			return value{}, fmt.Errorf("rankexpr: invalid default '%s' of input '%s'", input.Default, input.Name)
+		}
+		return numberValue(f), nil
+	}
+
+	if input.Default == "" {
+		return tensorValue(tensor.New(input.Type.Tensor)), nil
+	}
+	t, err := tensor.ParseLiteral(input.Type.Tensor.String() + ":" + input.Default)
+	if err != nil {
+		return value{}, fmt.Errorf("rankexpr: default of input '%s': %v", input.Name, err)
+	}
+	return tensorValue(t), nil
+}
+
+// binaryOperators are the operators on numbers, applied cell by cell to
+// tensors
+var binaryOperators = map[string]func(x, y float64) float64{
+	"+":  func(x, y float64) float64 { return x + y },
+	"-":  func(x, y float64) float64 { return x - y },
+	"*":  func(x, y float64) float64 { return x * y },
+	"/":  func(x, y float64) float64 { return x / y },
+	"%":  math.Mod,
+	"^":  math.Pow,
+	"==": func(x, y float64) float64 { return boolNumber(x == y) },
+	"!=": func(x, y float64) float64 { return boolNumber(x != y) },
+	"<":  func(x, y float64) float64 { return boolNumber(x < y) },
+	"<=": func(x, y float64) float64 { return boolNumber(x <= y) },
+	">":  func(x, y float64) float64 { return boolNumber(x > y) },
+	">=": func(x, y float64) float64 { return boolNumber(x >= y) },
+	"~=": func(x, y float64) float64 { return boolNumber(approxEqual(x, y)) },
+	"&&": func(x, y float64) float64 { return boolNumber(x != 0 && y != 0) },
+	"||": func(x, y float64) float64 { return boolNumber(x != 0 || y != 0) },
+}
+
+func (e *evaluator) binary(n *BinaryOp, scope map[string]value) (value, error) {
+	left, err := e.eval(n.Left, scope)
+	if err != nil {
+		return value{}, err
+	}
+	right, err := e.eval(n.Right, scope)
+	if err != nil {
+		return value{}, err
+	}
+
+	if left.str != nil || right.str != nil {
+		if left.str == nil || right.str == nil || (n.Operator != "==" && n.Operator != "!=") {
+			return value{}, fmt.Errorf("rankexpr: cannot apply '%s' to %s and %s", n.Operator, left, right)
+		}
+		return numberValue(boolNumber((*left.str == *right.str) == (n.Operator == "=="))), nil
+	}
+	operator, ok := binaryOperators[n.Operator]
+	if !ok {
+		return value{}, fmt.Errorf("rankexpr: unknown operator '%s'", n.Operator)
+	}
+	return apply2(left, right, operator)
+}
+
+func (e *evaluator) switchExpr(n *SwitchExpr, scope map[string]value) (value, error) {
+	v, err := e.eval(n.Value, scope)
+	if err != nil {
+		return value{}, err
+	}
+	if v.tensor != nil {
+		return value{}, fmt.Errorf("rankexpr: switch value %s is a tensor", n.Value)
+	}
+	for _, c := range n.Cases {
+		match, err := e.eval(c.Match, scope)
+		if err != nil {
+			return value{}, err
+		}
+		if match.tensor != nil || (match.str == nil) != (v.str == nil) {
+			return value{}, fmt.Errorf("rankexpr: cannot compare switch value %s with case %s", v, match)
+		}
+		if (v.str != nil && *v.str == *match.str) || (v.str == nil && v.number == match.number) {
+			return e.eval(c.Result, scope)
+		}
+	}
+	if n.Default == nil {
+		return value{}, fmt.Errorf("rankexpr: no case of %s matches %s", n, v)
+	}
+	return e.eval(n.Default, scope)
+}
+
+// =============================================================================
+// Functions
+// =============================================================================
+
+var unaryFunctions = map[string]func(float64) float64{
+	"abs":   math.Abs,
+	"acos":  math.Acos,
+	"asin":  math.Asin,
+	"atan":  math.Atan,
+	"ceil":  math.Ceil,
+	"cos":   math.Cos,
+	"cosh":  math.Cosh,
+	"erf":   math.Erf,
+	"exp":   math.Exp,
+	"fabs":  math.Abs,
+	"floor": math.Floor,
+	"log":   math.Log,
+	"log10": math.Log10,
+	"sin":   math.Sin,
+	"sinh":  math.Sinh,
+	"sqrt":  math.Sqrt,
+	"tan":   math.Tan,
+	"tanh":  math.Tanh,
+	"elu": func(x float64) float64 {
+		if x < 0 {
+			return math.Exp(x) - 1
+		}
+		return x
+	},
+	"isNan":   func(x float64) float64 { return boolNumber(math.IsNaN(x)) },
+	"relu":    func(x float64) float64 { return math.Max(0, x) },
+	"sigmoid": func(x float64) float64 { return 1 / (1 + math.Exp(-x)) },
+}
+
+var binaryFunctions = map[string]func(x, y float64) float64{
+	"atan2": math.Atan2,
+	"fmod":  math.Mod,
+	"ldexp": func(x, y float64) float64 { return math.Ldexp(x, int(y)) },
+	"max":   math.Max,
+	"min":   math.Min,
+	"pow":   math.Pow,
+	"bit":   func(x, y float64) float64 { return float64((int8(x) >> uint(y)) & 1) },
+	"hamming": func(x, y float64) float64 {
+		return float64(bits.OnesCount8(uint8(int8(x)) ^ uint8(int8(y))))
+	},
+}
+
+func (e *evaluator) call(c *Call, scope map[string]value) (value, error) {
+	if c.Name == "max" || c.Name == "min" {
+		// max(t, x) reduces t over the dimension x, max(a, b) is the larger
+		// of two numbers or the cell-wise maximum of two tensors
+		if len(c.Args) == 0 {
+			return value{}, fmt.Errorf("rankexpr: %s has too few arguments", c)
+		}
+		t, err := e.eval(c.Args[0], scope)
+		if err != nil {
+			return value{}, err
+		}
+		if dimensions, ok := reduction(t, c.Args[1:], scope); ok {
+			return reduceValue(t, c.Name, dimensions)
+		}
+	}
+
+	first, ok := nameArgs[c.Name]
+	if !ok {
+		first = len(c.Args)
+	}
+	if len(c.Args) < first || first == 0 {
+		return value{}, fmt.Errorf("rankexpr: %s has too few arguments", c)
+	}
+
+	// Lambdas are only evaluated by map, join and merge, as their last
+	// argument; every other argument is a value
+	args := make([]value, 0, first)
+	for i, arg := range c.Args[:first] {
+		if _, ok := arg.(*LambdaExpr); ok {
+			if (c.Name == "map" || c.Name == "join" || c.Name == "merge") && i == len(c.Args)-1 {
+				continue
+			}
+			return value{}, fmt.Errorf("rankexpr: %s has a lambda argument, only the last argument of map, join or merge may be a lambda", c)
+		}
+		v, err := e.eval(arg, scope)
+		if err != nil {
+			return value{}, err
+		}
+		if v.str != nil {
+			return value{}, fmt.Errorf("rankexpr: %s has the string argument %s", c, v)
+		}
+		args = append(args, v)
+	}
+	var names []string
+	for _, arg := range c.Args[first:] {
+		name, ok := nameArg(arg)
+		if !ok {
+			return value{}, fmt.Errorf("rankexpr: expected a name in %s, got %s", c, arg)
+		}
+		names = append(names, name)
+	}
+
+	switch c.Name {
+	case "map":
+		return e.mapCall(c, args, scope)
+	case "join", "merge":
+		return e.joinCall(c, args, scope)
+	case "reduce":
+		if len(names) == 0 {
+			return value{}, fmt.Errorf("rankexpr: %s requires an aggregator", c)
+		}
+		return reduceValue(args[0], names[0], names[1:])
+	case "avg", "count", "prod", "sum":
+		return reduceValue(args[0], c.Name, names)
+	case "matmul":
+		if len(names) != 1 {
+			return value{}, fmt.Errorf("rankexpr: %s requires one dimension", c)
+		}
+		product, err := apply2(args[0], args[1], binaryOperators["*"])
+		if err != nil {
+			return value{}, err
+		}
+		return reduceValue(product, "sum", names)
+	case "xw_plus_b":
+		if len(names) != 1 {
+			return value{}, fmt.Errorf("rankexpr: %s requires one dimension", c)
+		}
+		product, err := apply2(args[0], args[1], binaryOperators["*"])
+		if err != nil {
+			return value{}, err
+		}
+		sum, err := reduceValue(product, "sum", names)
+		if err != nil {
+			return value{}, err
+		}
+		return apply2(sum, args[2], binaryOperators["+"])
+	case "softmax", "l1_normalize", "l2_normalize", "argmax", "argmin":
+		return normalizeCall(c, args[0], names)
+	case "cosine_similarity", "euclidean_distance":
+		return distanceCall(c, args[0], args[1], names)
+	case "rename", "expand", "cell_cast":
+		return dimensionCall(c, args[0], names)
+	}
+
+	if f, ok := unaryFunctions[c.Name]; ok {
+		if len(args) != 1 {
+			return value{}, fmt.Errorf("rankexpr: %s requires 1 argument", c)
+		}
+		return apply1(args[0], f)
+	}
+	if f, ok := binaryFunctions[c.Name]; ok {
+		if len(args) != 2 {
+			return value{}, fmt.Errorf("rankexpr: %s requires 2 arguments", c)
+		}
+		return apply2(args[0], args[1], f)
+	}
+	return value{}, fmt.Errorf("rankexpr: function '%s' is not supported", c.Name)
+}
+
+// nameArg returns a dimension name, aggregator or cell type argument
+func nameArg(arg Node) (string, bool) {
+	if f, ok := arg.(*Feature); ok && len(f.Args) == 0 && f.Output == "" {
+		return f.Name, true
+	}
+	return "", false
+}
+
+// reduction returns the dimensions named by args if they are dimensions of
+// the tensor v, rather than expressions
+func reduction(v value, args []Node, scope map[string]value) ([]string, bool) {
+	if len(args) == 0 {
+		return nil, true
+	}
+	if v.tensor == nil {
+		return nil, false
+	}
+	var dimensions []string
+	for _, arg := range args {
+		name, ok := nameArg(arg)
+		if !ok {
+			return nil, false
+		}
+		if _, bound := scope[name]; bound {
+			return nil, false
+		}
+		if _, ok := v.tensor.Type().Dimension(name); !ok {
+			return nil, false
+		}
+		dimensions = append(dimensions, name)
+	}
+	return dimensions, true
+}
+
+func (e *evaluator) mapCall(c *Call, args []value, scope map[string]value) (value, error) {
+	lambda, ok := c.Args[len(c.Args)-1].(*LambdaExpr)
+	if len(args) != 1 || !ok || len(lambda.Params) != 1 {
+		return value{}, fmt.Errorf("rankexpr: %s requires a tensor and a lambda of one parameter", c)
+	}
+	var lambdaErr error
+	result, err := apply1(args[0], func(x float64) float64 {
+		v, err := e.lambda(lambda, scope, x)
+		if err != nil && lambdaErr == nil {
+			lambdaErr = err
+		}
+		return v
+	})
+	if lambdaErr != nil {
+		return value{}, lambdaErr
+	}
+	return result, err
+}
+
+func (e *evaluator) joinCall(c *Call, args []value, scope map[string]value) (value, error) {
+	lambda, ok := c.Args[len(c.Args)-1].(*LambdaExpr)
+	if len(args) != 2 || !ok || len(lambda.Params) != 2 {
+		return value{}, fmt.Errorf("rankexpr: %s requires two tensors and a lambda of two parameters", c)
+	}
+	var lambdaErr error
+	f := func(x, y float64) float64 {
+		v, err := e.lambda(lambda, scope, x, y)
+		if err != nil && lambdaErr == nil {
+			lambdaErr = err
+		}
+		return v
+	}
+
+	var result value
+	var err error
+	if c.Name == "merge" && args[0].tensor != nil && args[1].tensor != nil {
+		var t *tensor.Tensor
+		if t, err = mergeTensors(args[0].tensor, args[1].tensor, f); err == nil {
+			result = tensorValue(t)
+		}
+	} else {
+		result, err = apply2(args[0], args[1], f)
+	}
+	if lambdaErr != nil {
+		return value{}, lambdaErr
+	}
+	return result, err
+}
+
+// lambda evaluates the body of a lambda for cell values
+func (e *evaluator) lambda(l *LambdaExpr, scope map[string]value, values ...float64) (float64, error) {
+	inner := make(map[string]value, len(scope)+len(values))
+	for k, v := range scope {
+		inner[k] = v
+	}
+	for i, param := range l.Params {
+		inner[param] = numberValue(values[i])
+	}
+	result, err := e.eval(l.Body, inner)
+	if err != nil {
+		return 0, err
+	}
+	if result.str != nil || result.tensor != nil {
+		return 0, fmt.Errorf("rankexpr: lambda %s must compute a number, got %s", l, result)
+	}
+	return result.number, nil
+}
+
+func reduceValue(v value, aggregator string, dimensions []string) (value, error) {
+	if v.tensor == nil {
+		if _, ok := aggregators[aggregator]; !ok {
+			return value{}, fmt.Errorf("rankexpr: unknown aggregator '%s'", aggregator)
+		}
+		if len(dimensions) > 0 {
+			return value{}, fmt.Errorf("rankexpr: cannot reduce a number over dimension '%s'", dimensions[0])
+		}
+		if aggregator == "count" {
+			return numberValue(1), nil
+		}
+		return v, nil
+	}
+	return reduceTensor(v.tensor, aggregator, dimensions)
+}
+
+// normalizeCall evaluates softmax, l1_normalize, l2_normalize, argmax and
+// argmin, which combine cells with their reduction over a dimension
+func normalizeCall(c *Call, t value, names []string) (value, error) {
+	if len(names) == 0 || (len(names) > 1 && c.Name != "argmax" && c.Name != "argmin") {
+		return value{}, fmt.Errorf("rankexpr: %s requires one dimension", c)
+	}
+
+	cells, aggregator, combine := t, "sum", binaryOperators["/"]
+	var err error
+	switch c.Name {
+	case "softmax":
+		if cells, err = apply1(t, math.Exp); err != nil {
+			return value{}, err
+		}
+	case "l2_normalize":
+		combine = func(x, y float64) float64 { return x / math.Sqrt(y) }
+		if cells, err = apply2(t, t, binaryOperators["*"]); err != nil {
+			return value{}, err
+		}
+	case "argmax":
+		aggregator, combine = "max", binaryOperators["=="]
+	case "argmin":
+		aggregator, combine = "min", binaryOperators["=="]
+	}
+
+	reduced, err := reduceValue(cells, aggregator, names)
+	if err != nil {
+		return value{}, err
+	}
+	if c.Name == "softmax" {
+		t = cells
+	}
+	return apply2(t, reduced, combine)
+}
+
+func distanceCall(c *Call, a, b value, names []string) (value, error) {
+	if len(names) != 1 {
+		return value{}, fmt.Errorf("rankexpr: %s requires one dimension", c)
+	}
+	sumOver := func(x, y value, f func(x, y float64) float64) (value, error) {
+		joined, err := apply2(x, y, f)
+		if err != nil {
+			return value{}, err
+		}
+		return reduceValue(joined, "sum", names)
+	}
+
+	if c.Name == "euclidean_distance" {
+		squares, err := sumOver(a, b, func(x, y float64) float64 { return (x - y) * (x - y) })
+		if err != nil {
+			return value{}, err
+		}
+		return apply1(squares, math.Sqrt)
+	}
+
+	dot, err := sumOver(a, b, binaryOperators["*"])
+	if err != nil {
+		return value{}, err
+	}
+	aa, err := sumOver(a, a, binaryOperators["*"])
+	if err != nil {
+		return value{}, err
+	}
+	bb, err := sumOver(b, b, binaryOperators["*"])
+	if err != nil {
+		return value{}, err
+	}
+	norms, err := apply2(aa, bb, func(x, y float64) float64 { return math.Sqrt(x * y) })
+	if err != nil {
+		return value{}, err
+	}
+	return apply2(dot, norms, binaryOperators["/"])
+}
+
+// dimensionCall evaluates rename, expand and cell_cast
+func dimensionCall(c *Call, v value, names []string) (value, error) {
+	t, err := v.asTensor()
+	if err != nil {
+		return value{}, err
+	}
+	var result *tensor.Tensor
+	switch {
+	case c.Name == "rename" && len(names) == 2:
+		result, err = renameDimension(t, names[0], names[1])
+	case c.Name == "expand" && len(names) == 1:
+		result, err = expandDimension(t, names[0])
+	case c.Name == "cell_cast" && len(names) == 1:
+		result, err = castCells(t, tensor.CellType(names[0]))
+	default:
+		return value{}, fmt.Errorf("rankexpr: invalid arguments in %s", c)
+	}
+	if err != nil {
+		return value{}, err
+	}
+	if len(result.Type().Dimensions) == 0 {
+		return tensorValue(result), nil
+	}
+	return value{tensor: result}, nil
+}
+
+// apply1 applies f to a number or to every cell of a tensor
+func apply1(v value, f func(float64) float64) (value, error) {
+	if v.str != nil {
+		return value{}, fmt.Errorf("rankexpr: expected a number or tensor, got %s", v)
+	}
+	if v.tensor == nil {
+		return numberValue(f(v.number)), nil
+	}
+	t, err := mapTensor(v.tensor, f)
+	if err != nil {
+		return value{}, err
+	}
+	return tensorValue(t), nil
+}
+
+// apply2 applies f to two numbers, or joins tensors with it
+func apply2(a, b value, f func(x, y float64) float64) (value, error) {
+	if a.str != nil || b.str != nil {
+		return value{}, fmt.Errorf("rankexpr: expected numbers or tensors, got %s and %s", a, b)
+	}
+	switch {
+	case a.tensor == nil && b.tensor == nil:
+		return numberValue(f(a.number, b.number)), nil
+	case b.tensor == nil:
+		return apply1(a, func(x float64) float64 { return f(x, b.number) })
+	case a.tensor == nil:
+		return apply1(b, func(y float64) float64 { return f(a.number, y) })
+	}
+	t, err := joinTensors(a.tensor, b.tensor, f)
+	if err != nil {
+		return value{}, err
+	}
+	return tensorValue(t), nil
+}
+
+func boolNumber(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// approxEqual reports whether x and y are equal within a relative
+// tolerance, as for Vespa's ~= operator
+func approxEqual(x, y float64) bool {
+	if x == y {
+		return true
+	}
+	return math.Abs(x-y) <= 1e-6*math.Max(math.Abs(x), math.Abs(y))
+}
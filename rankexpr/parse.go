@@ -0,0 +1,637 @@
+package rankexpr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/vipulsodha/vespa-go/tensor"
+)
+
+// builtinFunctions are parsed as calls with expression arguments; other names
+// followed by arguments are rank features or rank profile functions
+var builtinFunctions = map[string]bool{
+	// Math functions
+	"abs": true, "acos": true, "asin": true, "atan": true, "atan2": true,
+	"bit": true, "ceil": true, "cos": true, "cosh": true, "elu": true,
+	"erf": true, "exp": true, "fabs": true, "floor": true, "fmod": true,
+	"hamming": true, "isNan": true, "ldexp": true, "log": true, "log10": true,
+	"max": true, "min": true, "pow": true, "relu": true, "sigmoid": true,
+	"sin": true, "sinh": true, "sqrt": true, "tan": true, "tanh": true,
+
+	// Tensor functions
+	"argmax": true, "argmin": true, "avg": true, "cell_cast": true,
+	"concat": true, "cosine_similarity": true, "count": true, "euclidean_distance": true,
+	"expand": true, "join": true, "l1_normalize": true, "l2_normalize": true,
+	"map": true, "matmul": true, "merge": true, "prod": true, "reduce": true,
+	"rename": true, "softmax": true, "sum": true, "xw_plus_b": true,
+}
+
+// Parse parses a ranking expression, such as the first-phase expression of a
+// rank profile:
+//
+//	expr, err := rankexpr.Parse("bm25(title) + 0.3 * closeness(field, embedding)")
+//
+// Feature arguments are normalized, so closeness(field, embedding) renders
+// as closeness(field,embedding). Tensor generators and the in operator are
+// not supported.
+func Parse(expression string) (Node, error) {
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{src: expression, tokens: tokens}
+	n, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, p.errorf("unexpected '%s'", t.text)
+	}
+	return n, nil
+}
+
+// MustParse is like Parse but panics on error.
+// It is intended for package-level expression declarations.
+func MustParse(expression string) Node {
+	n, err := Parse(expression)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// =============================================================================
+// Tokenizer
+// =============================================================================
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenIdent
+	tokenPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string // string tokens hold the unquoted value
+	pos  int
+}
+
+// twoCharPuncts are operators of two characters, matched before single ones
+var twoCharPuncts = []string{"==", "!=", "<=", ">=", "~=", "&&", "||"}
+
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case isDigit(c) || (c == '.' && i+1 < len(src) && isDigit(src[i+1])):
+			start := i
+			for i < len(src) && (isDigit(src[i]) || src[i] == '.') {
+				i++
+			}
+			if i < len(src) && (src[i] == 'e' || src[i] == 'E') {
+				j := i + 1
+				if j < len(src) && (src[j] == '+' || src[j] == '-') {
+					j++
+				}
+				if j < len(src) && isDigit(src[j]) {
+					for i = j; i < len(src) && isDigit(src[i]); i++ {
+					}
+				}
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: src[start:i], pos: start})
+		case c == '"' || c == '\'':
+			start := i
+			var b strings.Builder
+			for i++; i < len(src) && src[i] != c; i++ {
+				if src[i] == '\\' && i+1 < len(src) {
+					i++
+				}
+				b.WriteByte(src[i])
+			}
+			if i >= len(src) {
+				return nil, fmt.Errorf("rankexpr: unterminated string at position %d", start)
+			}
+			i++
+			tokens = append(tokens, token{kind: tokenString, text: b.String(), pos: start})
+		case isIdentStart(c):
+			start := i
+			for i < len(src) && (isIdentStart(src[i]) || isDigit(src[i])) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: src[start:i], pos: start})
+		default:
+			text := ""
+			for _, punct := range twoCharPuncts {
+				if strings.HasPrefix(src[i:], punct) {
+					text = punct
+					break
+				}
+			}
+			if text == "" {
+				if !strings.ContainsRune("+-*/%^<>!(),[]{}:.", rune(c)) {
+					return nil, fmt.Errorf("rankexpr: unexpected character '%c' at position %d", c, i)
+				}
+				text = string(c)
+			}
+			tokens = append(tokens, token{kind: tokenPunct, text: text, pos: i})
+			i += len(text)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(src)}), nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// =============================================================================
+// Parser
+// =============================================================================
+
+type parser struct {
+	src    string
+	tokens []token
+	pos    int
+	params []string // parameters of the enclosing lambdas
+}
+
+func (p *parser) peek() token {
+	return p.peekAt(0)
+}
+
+func (p *parser) peekAt(n int) token {
+	if p.pos+n >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.pos+n]
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func isPunct(t token, punct string) bool {
+	return t.kind == tokenPunct && t.text == punct
+}
+
+func isIdent(t token, name string) bool {
+	return t.kind == tokenIdent && t.text == name
+}
+
+func (p *parser) expectPunct(punct string) error {
+	if !isPunct(p.peek(), punct) {
+		return p.errorf("expected '%s'", punct)
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	t := p.peek()
+	found := t.text
+	if t.kind == tokenEOF {
+		found = "end of input"
+	}
+	return fmt.Errorf("rankexpr: %s at position %d (found '%s')", fmt.Sprintf(format, args...), t.pos, found)
+}
+
+// binaryLevels lists the binary operators by precedence, lowest first
+var binaryLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", "<=", ">", ">=", "~="},
+	{"+", "-"},
+	{"*", "/"},
+	{"%"},
+}
+
+func (p *parser) parseExpression() (Node, error) {
+	return p.parseBinary(0)
+}
+
+// parseBinary parses left-associative operators of a precedence level and up
+func (p *parser) parseBinary(level int) (Node, error) {
+	if level == len(binaryLevels) {
+		return p.parsePower()
+	}
+
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != tokenPunct || !containsString(binaryLevels[level], t.text) {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryOp{Operator: t.text, Left: left, Right: right}
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePower parses the right-associative ^ operator, whose operands may be
+// negated
+func (p *parser) parsePower() (Node, error) {
+	base, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	if !isPunct(p.peek(), "^") {
+		return base, nil
+	}
+	p.next()
+	exponent, err := p.parsePower()
+	if err != nil {
+		return nil, err
+	}
+	return &BinaryOp{Operator: "^", Left: base, Right: exponent}, nil
+}
+
+func (p *parser) parseUnary() (Node, error) {
+	t := p.peek()
+	if !isPunct(t, "-") && !isPunct(t, "!") {
+		return p.parsePrimary()
+	}
+	p.next()
+	operand, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	// Fold negative numbers into literals
+	if n, ok := operand.(*NumberLiteral); ok && t.text == "-" && n.Value >= 0 {
+		return &NumberLiteral{Value: -n.Value}, nil
+	}
+	return &UnaryOp{Operator: t.text, Operand: operand}, nil
+}
+
+func (p *parser) parsePrimary() (Node, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokenNumber:
+		p.next()
+		value, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("rankexpr: invalid number '%s' at position %d", t.text, t.pos)
+		}
+		return &NumberLiteral{Value: value}, nil
+	case tokenString:
+		p.next()
+		return &StringLiteral{Value: t.text}, nil
+	case tokenIdent:
+		return p.parseIdent()
+	}
+
+	if isPunct(t, "(") {
+		p.next()
+		inner, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	}
+	return nil, p.errorf("unexpected '%s'", t.text)
+}
+
+func (p *parser) parseIdent() (Node, error) {
+	t := p.peek()
+	next := p.peekAt(1)
+
+	switch {
+	case t.text == "if" && isPunct(next, "("):
+		return p.parseIf()
+	case t.text == "switch" && isPunct(next, "("):
+		return p.parseSwitch()
+	case t.text == "tensor" && (isPunct(next, "(") || isPunct(next, "<")):
+		return p.parseTensor()
+	case t.text == "f" && p.isLambda():
+		return nil, p.errorf("a lambda is only allowed as the last argument of map, join or merge")
+	case builtinFunctions[t.text] && isPunct(next, "("):
+		return p.parseCall()
+	}
+
+	p.next()
+	if !isPunct(next, "(") {
+		for i := len(p.params) - 1; i >= 0; i-- {
+			if p.params[i] == t.text {
+				return &Variable{Name: t.text}, nil
+			}
+		}
+	}
+
+	feature := &Feature{Name: t.text}
+	if isPunct(next, "(") {
+		args, err := p.parseFeatureArgs()
+		if err != nil {
+			return nil, err
+		}
+		feature.Args = args
+	}
+	var outputs []string
+	for isPunct(p.peek(), ".") && p.peekAt(1).kind == tokenIdent {
+		p.next()
+		outputs = append(outputs, p.next().text)
+	}
+	feature.Output = strings.Join(outputs, ".")
+	return feature, nil
+}
+
+// parseFeatureArgs reads the arguments of a feature as written, trimmed
+func (p *parser) parseFeatureArgs() ([]string, error) {
+	p.next() // (
+	var args []string
+	start := p.peek().pos
+	depth := 0
+	for {
+		t := p.next()
+		switch {
+		case t.kind == tokenEOF:
+			return nil, p.errorf("expected ')'")
+		case isPunct(t, "(") || isPunct(t, "[") || isPunct(t, "{"):
+			depth++
+		case depth > 0 && (isPunct(t, ")") || isPunct(t, "]") || isPunct(t, "}")):
+			depth--
+		case depth == 0 && (isPunct(t, ",") || isPunct(t, ")")):
+			arg := strings.TrimSpace(p.src[start:t.pos])
+			if arg == "" && (isPunct(t, ",") || len(args) > 0) {
+				return nil, fmt.Errorf("rankexpr: empty feature argument at position %d", t.pos)
+			}
+			if arg != "" {
+				args = append(args, normalizeArg(arg))
+			}
+			if isPunct(t, ")") {
+				return args, nil
+			}
+			start = p.peek().pos
+		}
+	}
+}
+
+// normalizeArg renders arguments that are features themselves canonically,
+// so blend(closeness(field, embedding)) and blend(closeness(field,embedding))
+// are the same feature
+func normalizeArg(arg string) string {
+	if n, err := Parse(arg); err == nil {
+		if f, ok := n.(*Feature); ok {
+			return f.String()
+		}
+	}
+	return arg
+}
+
+// parseArgs parses a parenthesized argument list. If lambdaLast is set, the
+// last argument may be a lambda.
+func (p *parser) parseArgs(lambdaLast bool) ([]Node, error) {
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+	var args []Node
+	if isPunct(p.peek(), ")") {
+		p.next()
+		return args, nil
+	}
+	for {
+		var arg Node
+		var err error
+		if lambdaLast && p.peek().text == "f" && p.isLambda() {
+			if arg, err = p.parseLambda(); err == nil && !isPunct(p.peek(), ")") {
+				err = p.errorf("a lambda is only allowed as the last argument of map, join or merge")
+			}
+		} else {
+			arg, err = p.parseExpression()
+		}
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if !isPunct(p.peek(), ",") {
+			break
+		}
+		p.next()
+	}
+	if err := p.expectPunct(")"); err != nil {
+		return nil, err
+	}
+	return args, nil
+}
+
+func (p *parser) parseCall() (Node, error) {
+	name := p.next().text
+	args, err := p.parseArgs(name == "map" || name == "join" || name == "merge")
+	if err != nil {
+		return nil, err
+	}
+	return &Call{Name: name, Args: args}, nil
+}
+
+func (p *parser) parseIf() (Node, error) {
+	p.next()
+	args, err := p.parseArgs(false)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != 3 {
+		return nil, p.errorf("if requires a condition, a then and an else expression, got %d arguments", len(args))
+	}
+	return &IfExpr{Condition: args[0], Then: args[1], Else: args[2]}, nil
+}
+
+func (p *parser) parseSwitch() (Node, error) {
+	p.next()
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+	value, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectPunct(")"); err != nil {
+		return nil, err
+	}
+	if err := p.expectPunct("{"); err != nil {
+		return nil, err
+	}
+
+	s := &SwitchExpr{Value: value}
+	for !isPunct(p.peek(), "}") {
+		isCase := isIdent(p.peek(), "case")
+		if !isCase && !isIdent(p.peek(), "default") {
+			return nil, p.errorf("expected 'case' or 'default'")
+		}
+		if !isCase && s.Default != nil {
+			return nil, p.errorf("duplicate default")
+		}
+		p.next()
+
+		var match Node
+		if isCase {
+			if match, err = p.parseExpression(); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.expectPunct(":"); err != nil {
+			return nil, err
+		}
+		result, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		if isCase {
+			s.Cases = append(s.Cases, Case{Match: match, Result: result})
+		} else {
+			s.Default = result
+		}
+		if !isPunct(p.peek(), ",") {
+			break
+		}
+		p.next()
+	}
+	if err := p.expectPunct("}"); err != nil {
+		return nil, err
+	}
+	if len(s.Cases) == 0 {
+		return nil, p.errorf("switch requires at least one case")
+	}
+	return s, nil
+}
+
+// isLambda reports whether the tokens at the current position are
+// f(params)(, the start of a lambda
+func (p *parser) isLambda() bool {
+	if !isPunct(p.peekAt(1), "(") {
+		return false
+	}
+	for i := 2; ; i += 2 {
+		if p.peekAt(i).kind != tokenIdent {
+			return false
+		}
+		if t := p.peekAt(i + 1); isPunct(t, ")") {
+			return isPunct(p.peekAt(i+2), "(")
+		} else if !isPunct(t, ",") {
+			return false
+		}
+	}
+}
+
+func (p *parser) parseLambda() (Node, error) {
+	p.next()
+	p.next()
+	var params []string
+	for {
+		params = append(params, p.next().text)
+		if isPunct(p.next(), ")") {
+			break
+		}
+	}
+
+	p.params = append(p.params, params...)
+	defer func() { p.params = p.params[:len(p.params)-len(params)] }()
+
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+	body, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectPunct(")"); err != nil {
+		return nil, err
+	}
+	return &LambdaExpr{Params: params, Body: body}, nil
+}
+
+// parseTensor parses a tensor literal, delegating the literal syntax to the
+// tensor package
+func (p *parser) parseTensor() (Node, error) {
+	start := p.peek().pos
+	typeEnd := strings.Index(p.src[start:], ")")
+	if typeEnd < 0 {
+		return nil, p.errorf("unterminated tensor type")
+	}
+	end := start + typeEnd + 1
+	for end < len(p.src) && strings.ContainsRune(" \t\r\n", rune(p.src[end])) {
+		end++
+	}
+	if end >= len(p.src) || p.src[end] != ':' {
+		return nil, fmt.Errorf("rankexpr: tensor generators are not supported at position %d", start)
+	}
+	end = literalEnd(p.src, end+1)
+
+	t, err := tensor.ParseLiteral(p.src[start:end])
+	if err != nil {
+		return nil, fmt.Errorf("rankexpr: %v at position %d", err, start)
+	}
+	for p.peek().kind != tokenEOF && p.peek().pos < end {
+		p.next()
+	}
+	return &TensorLiteral{Tensor: t}, nil
+}
+
+// literalEnd returns the end of a tensor literal body starting at pos: a
+// bracketed block or a single number
+func literalEnd(src string, pos int) int {
+	for pos < len(src) && strings.ContainsRune(" \t\r\n", rune(src[pos])) {
+		pos++
+	}
+	if pos < len(src) && src[pos] != '[' && src[pos] != '{' {
+		for pos < len(src) && (isDigit(src[pos]) || strings.ContainsRune(".-+eE", rune(src[pos]))) {
+			pos++
+		}
+		return pos
+	}
+
+	depth := 0
+	var quote byte
+	for ; pos < len(src); pos++ {
+		c := src[pos]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				pos++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+			if depth == 0 {
+				return pos + 1
+			}
+		}
+	}
+	return pos
+}
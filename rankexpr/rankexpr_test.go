@@ -0,0 +1,584 @@
+package rankexpr
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	vespa "github.com/vipulsodha/vespa-go"
+	"github.com/vipulsodha/vespa-go/schema"
+	"github.com/vipulsodha/vespa-go/tensor"
+)
+
+func TestBuilders(t *testing.T) {
+	tests := []struct {
+		name     string
+		node     Node
+		expected string
+	}{
+		{"number", Number(0.3), "0.3"},
+		{"negative number", Number(-2), "-2"},
+		{"string", String("shoes"), `"shoes"`},
+		{"feature", BM25("title"), "bm25(title)"},
+		{"feature output", FieldMatch("title").Dot("completeness"), "fieldMatch(title).completeness"},
+		{"closeness", Closeness("embedding"), "closeness(field,embedding)"},
+		{"native rank", NativeRank("title", "description"), "nativeRank(title,description)"},
+		{"feature without args", NewFeature("firstPhase"), "firstPhase"},
+		{
+			"weighted sum",
+			Add(BM25("title"), Mul(Number(0.3), Closeness("embedding")), Attribute("popularity")),
+			"bm25(title) + 0.3 * closeness(field,embedding) + attribute(popularity)",
+		},
+		{"parenthesized sum", Mul(Add(Var("a"), Var("b")), Var("c")), "(a + b) * c"},
+		{"left associative", Sub(Var("a"), Sub(Var("b"), Var("c"))), "a - (b - c)"},
+		{"left nested", Sub(Sub(Var("a"), Var("b")), Var("c")), "a - b - c"},
+		{"right associative power", Pow(Var("a"), Pow(Var("b"), Var("c"))), "a ^ b ^ c"},
+		{"left nested power", Pow(Pow(Var("a"), Var("b")), Var("c")), "(a ^ b) ^ c"},
+		{"negative base", Pow(Number(-1), Number(2)), "-1 ^ 2"},
+		{"negated power", Neg(Pow(Var("x"), Number(2))), "-(x ^ 2)"},
+		{"power of negation", Pow(Neg(Var("x")), Number(2)), "-x ^ 2"},
+		{"modulo of product", Mod(Mul(Var("a"), Var("b")), Var("c")), "(a * b) % c"},
+		{"product of modulo", Mul(Var("a"), Mod(Var("b"), Var("c"))), "a * b % c"},
+		{"quotient of modulo", Div(Var("a"), Mod(Var("b"), Var("c"))), "a / b % c"},
+		{"negation", Neg(Add(Var("a"), Var("b"))), "-(a + b)"},
+		{"logical", Or(Not(And(Gt(Var("a"), Number(1)), Lt(Var("b"), Number(2)))), Eq(Var("c"), Number(3))), "!(a > 1 && b < 2) || c == 3"},
+		{"function", Func("max", BM25("title"), Number(1)), "max(bm25(title), 1)"},
+		{"reduce", Reduce(Query("q"), "sum", "x"), "reduce(query(q), sum, x)"},
+		{"map", Map(Attribute("v"), Lambda(Mul(Var("x"), Number(2)), "x")), "map(attribute(v), f(x)(x * 2))"},
+		{"join", Join(Attribute("v"), Query("q"), Lambda(Mul(Var("a"), Var("b")), "a", "b")), "join(attribute(v), query(q), f(a,b)(a * b))"},
+		{"if", If(Eq(Attribute("in_stock"), Number(1)), NewFeature("firstPhase"), Number(0)), "if (attribute(in_stock) == 1, firstPhase, 0)"},
+		{
+			"switch",
+			Switch(Attribute("category")).Case(String("shoes"), Number(1.5)).Case(String("bags"), Number(1)).Otherwise(Number(0.5)),
+			`switch (attribute(category)) { case "shoes": 1.5, case "bags": 1, default: 0.5 }`,
+		},
+		{"tensor", Tensor(denseTensor(t, "tensor<float>(x[3])", 1, 2, 3)), "tensor<float>(x[3]):[1,2,3]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := tt.node.String(); result != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestParseRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"weighted sum", "bm25(title) + 0.3 * closeness(field, embedding) + attribute(popularity)", "bm25(title) + 0.3 * closeness(field,embedding) + attribute(popularity)"},
+		{"redundant parentheses", "((a + b)) + (c * d)", "a + b + c * d"},
+		{"needed parentheses", "(a + b) * c", "(a + b) * c"},
+		{"subtraction", "a - (b - c)", "a - (b - c)"},
+		{"power", "a ^ b ^ c", "a ^ b ^ c"},
+		{"negative power", "-x ^ 2", "-x ^ 2"},
+		{"negated power", "-(x ^ 2)", "-(x ^ 2)"},
+		{"modulo", "7 * 5 % 3", "7 * 5 % 3"},
+		{"modulo of product", "(7 * 5) % 3", "(7 * 5) % 3"},
+		{"negative number", "1 - -2", "1 - -2"},
+		{"logical", "!(a > 1 && b < 2) || c == 3", "!(a > 1 && b < 2) || c == 3"},
+		{"approximate", "attribute(score) ~= 0.5", "attribute(score) ~= 0.5"},
+		{"exponent", "max(bm25(title), 1e-3)", "max(bm25(title), 0.001)"},
+		{"feature output", "fieldMatch(title).completeness * 2", "fieldMatch(title).completeness * 2"},
+		{"feature with spaces", "nativeRank( title , description )", "nativeRank(title,description)"},
+		{"feature with nested args", "foo(bar(1, 2), baz)", "foo(bar(1,2),baz)"},
+		{"feature with feature args", "blend(text_score, closeness(field, embedding))", "blend(text_score,closeness(field,embedding))"},
+		{"if", "if (attribute(in_stock) == 1, firstPhase, 0)", "if (attribute(in_stock) == 1, firstPhase, 0)"},
+		{
+			"switch",
+			`switch(attribute(category)){case "shoes":1.5,case 'bags':1,default:0.5}`,
+			`switch (attribute(category)) { case "shoes": 1.5, case "bags": 1, default: 0.5 }`,
+		},
+		{"reduce", "reduce(map(query(q), f(x)(x * 2)), sum, x)", "reduce(map(query(q), f(x)(x * 2)), sum, x)"},
+		{"join", "join(attribute(v), query(q), f(a, b)(a * b))", "join(attribute(v), query(q), f(a,b)(a * b))"},
+		{"dense tensor", "sum(query(q) * tensor<float>(x[3]):[1, 2, 3])", "sum(query(q) * tensor<float>(x[3]):[1,2,3])"},
+		{"sparse tensor", "tensor(cat{}):{a:1, 'b c':2} * 2", "tensor(cat{}):{a:1,'b c':2} * 2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, err := Parse(tt.input)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result := node.String(); result != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+
+			// The rendered expression parses back to the same expression
+			again, err := Parse(node.String())
+			if err != nil {
+				t.Fatalf("Unexpected error parsing %q: %v", node.String(), err)
+			}
+			if result := again.String(); result != tt.expected {
+				t.Errorf("Expected %q after round trip, got %q", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestParseStructure(t *testing.T) {
+	node := MustParse("map(query(q), f(x)(x + y))")
+	call, ok := node.(*Call)
+	if !ok || call.Name != "map" || len(call.Args) != 2 {
+		t.Fatalf("Expected map call, got %#v", node)
+	}
+	lambda, ok := call.Args[1].(*LambdaExpr)
+	if !ok {
+		t.Fatalf("Expected lambda, got %#v", call.Args[1])
+	}
+	body := lambda.Body.(*BinaryOp)
+	if _, ok := body.Left.(*Variable); !ok {
+		t.Errorf("Expected lambda parameter x to be a variable, got %#v", body.Left)
+	}
+	if _, ok := body.Right.(*Feature); !ok {
+		t.Errorf("Expected y to be a feature, got %#v", body.Right)
+	}
+
+	if b := MustParse("7 * 5 % 3").(*BinaryOp); b.Operator != "*" || b.Right.String() != "5 % 3" {
+		t.Errorf("Expected %% to bind tighter than *, got %s %s %s", b.Left, b.Operator, b.Right)
+	}
+	if b := MustParse("-x ^ 2").(*BinaryOp); b.Operator != "^" || b.Left.String() != "-x" {
+		t.Errorf("Expected unary minus to bind tighter than ^, got %s %s %s", b.Left, b.Operator, b.Right)
+	}
+	if b := MustParse("2 ^ -x ^ 2").(*BinaryOp); b.Right.String() != "-x ^ 2" {
+		t.Errorf("Expected a negated exponent, got %s", b.Right)
+	}
+
+	if n := MustParse("-2").(*NumberLiteral); n.Value != -2 {
+		t.Errorf("Expected -2, got %v", n.Value)
+	}
+	if f := MustParse("fieldMatch(title).weight.x").(*Feature); f.Output != "weight.x" {
+		t.Errorf("Expected output %q, got %q", "weight.x", f.Output)
+	}
+}
+
+func TestFeatures(t *testing.T) {
+	node := MustParse("bm25(title) + reduce(query(q) * attribute(embedding), sum, x) + if (bm25(title) > 1, closeness(field, embedding), 0)")
+	expected := []string{"attribute(embedding)", "bm25(title)", "closeness(field,embedding)", "query(q)"}
+	if result := Features(node); !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+
+	if result := Features(MustParse("map(query(q), f(x)(x * 2))")); !reflect.DeepEqual(result, []string{"query(q)"}) {
+		t.Errorf("Expected lambda parameters to be skipped, got %v", result)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"empty", "", "unexpected '' at position 0"},
+		{"trailing operator", "bm25(title) +", "at position 13 (found 'end of input')"},
+		{"unbalanced", "(a + b", "expected ')'"},
+		{"trailing tokens", "a b", "unexpected 'b' at position 2"},
+		{"unterminated string", `"shoes`, "unterminated string at position 0"},
+		{"invalid character", "a # b", "unexpected character '#' at position 2"},
+		{"if arguments", "if (a, b)", "if requires a condition, a then and an else expression, got 2 arguments"},
+		{"switch without case", "switch (a) { default: 1 }", "switch requires at least one case"},
+		{"switch keyword", "switch (a) { when 1: 2 }", "expected 'case' or 'default'"},
+		{"unterminated feature", "bm25(title", "expected ')'"},
+		{"empty feature argument", "foo(a,,b)", "empty feature argument"},
+		{"tensor generator", "tensor(x[3])(x + 1)", "tensor generators are not supported"},
+		{"invalid tensor", "tensor(x[3]):[1,2]", "tensor: invalid literal"},
+		{"lambda in reduction", "sum(f(x)(x))", "a lambda is only allowed as the last argument of map, join or merge"},
+		{"lambda in matmul", "matmul(a, f(x)(x), d)", "a lambda is only allowed as the last argument of map, join or merge"},
+		{"lambda in distance", "cosine_similarity(f(x)(x), a, d)", "a lambda is only allowed as the last argument of map, join or merge"},
+		{"lambdas in xw_plus_b", "xw_plus_b(a, f(x)(x), f(x)(x), d)", "a lambda is only allowed as the last argument of map, join or merge"},
+		{"lambda before tensor", "map(f(x)(x), query(q))", "a lambda is only allowed as the last argument of map, join or merge"},
+		{"lambda in expression", "f(x)(x) + 1", "a lambda is only allowed as the last argument of map, join or merge"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.input)
+			if err == nil {
+				t.Fatal("Expected error")
+			}
+			if !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected error containing %q, got %q", tt.expected, err.Error())
+			}
+		})
+	}
+}
+
+func TestParseRankProfile(t *testing.T) {
+	s, err := schema.Parse([]byte(`
+schema product {
+    document product {
+        field title type string {
+            indexing: summary | index
+        }
+    }
+    rank-profile hybrid {
+        function text_score() {
+            expression: bm25(title) + nativeRank(title)
+        }
+        function inline blend(a, b) {
+            expression {
+                query(alpha) * a +
+                (1 - query(alpha)) * b
+            }
+        }
+        first-phase {
+            expression: blend(text_score, closeness(field, embedding))
+        }
+    }
+}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	rp, _ := s.RankProfile("hybrid")
+
+	expected := map[string]string{
+		"text_score": "bm25(title) + nativeRank(title)",
+		"blend":      "query(alpha) * a + (1 - query(alpha)) * b",
+	}
+	for _, f := range rp.Functions {
+		node, err := Parse(f.Expression)
+		if err != nil {
+			t.Fatalf("Unexpected error parsing function %s: %v", f.Name, err)
+		}
+		if result := node.String(); result != expected[f.Name] {
+			t.Errorf("Expected %q, got %q", expected[f.Name], result)
+		}
+	}
+
+	node, err := Parse(rp.FirstPhase.Expression)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result := node.String(); result != "blend(text_score,closeness(field,embedding))" {
+		t.Errorf("Expected %q, got %q", "blend(text_score,closeness(field,embedding))", result)
+	}
+}
+
+func denseTensor(t *testing.T, spec string, values ...float64) *tensor.Tensor {
+	typ, err := tensor.ParseType(spec)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	result, err := tensor.NewDense(typ, values...)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return result
+}
+
+func TestEvaluate(t *testing.T) {
+	features := map[string]interface{}{
+		"bm25(title)":                    2.5,
+		"closeness(field, embedding)":    0.8,
+		"attribute(popularity)":          int32(40),
+		"attribute(in_stock)":            true,
+		"attribute(category)":            "shoes",
+		"fieldMatch(title).completeness": json.Number("0.5"),
+		"query(q)":                       literal(t, "tensor<float>(x[3]):[1,2,3]"),
+		"attribute(embedding)":           literal(t, "tensor<float>(x[3]):[0.5,0,2]"),
+		"query(weights)":                 literal(t, "tensor(cat{}):{shoes:2,bags:3}"),
+		"attribute(scores)":              literal(t, "tensor(cat{}):{shoes:0.5,hats:1}"),
+	}
+
+	tests := []struct {
+		name       string
+		expression string
+		expected   float64
+	}{
+		{"weighted sum", "bm25(title) + 0.3 * closeness(field, embedding) + attribute(popularity) / 100", 3.14},
+		{"precedence", "2 + 3 * 4 ^ 2 - -1", 51},
+		{"right associative power", "2 ^ 3 ^ 2", 512},
+		{"modulo", "7 % 4", 3},
+		{"modulo binds tighter than product", "7 * 5 % 3", 14},
+		{"modulo binds tighter than quotient", "8 / 4 % 3", 8},
+		{"negation binds tighter than power", "-2 ^ 2", 4},
+		{"negated power", "-(2 ^ 2)", -4},
+		{"comparison", "(bm25(title) > 2) + (bm25(title) == 2.5) + (1 ~= 1.0000000001)", 3},
+		{"logical", "attribute(in_stock) && !(bm25(title) < 1) || 0", 1},
+		{"feature output", "fieldMatch(title).completeness * 4", 2},
+		{"if", "if (attribute(in_stock), bm25(title), 0)", 2.5},
+		{"if on string", `if (attribute(category) == "bags", 1, 2)`, 2},
+		{"switch", `switch (attribute(category)) { case "bags": 1, case "shoes": 2, default: 3 }`, 2},
+		{"switch default", "switch (bm25(title)) { case 1: 1, default: 3 }", 3},
+		{"math", "max(bm25(title), 3) + min(1, 2) + abs(-1) + floor(1.7) + sqrt(16)", 10},
+		{"sigmoid", "sigmoid(0)", 0.5},
+		{"log", "log10(1000) + log(1)", 3},
+		{"dot product", "sum(query(q) * attribute(embedding))", 6.5},
+		{"reduce", "reduce(query(q), max, x)", 3},
+		{"reduce all dimensions", "reduce(query(q), avg)", 2},
+		{"count", "count(query(q))", 3},
+		{"max of tensor", "max(query(q), x)", 3},
+		{"map", "sum(map(query(q), f(x)(x * x)))", 14},
+		{"join", "sum(join(query(q), attribute(embedding), f(a,b)(a - b)))", 3.5},
+		{"sparse join", "sum(query(weights) * attribute(scores))", 1},
+		{"sparse merge", "sum(merge(query(weights), attribute(scores), f(a,b)(a + b)))", 6.5},
+		{"tensor literal", "sum(query(q) * tensor(x[3]):[1,0,1])", 4},
+		{"matmul", "matmul(query(q), attribute(embedding), x)", 6.5},
+		{"cosine similarity", "cosine_similarity(query(q), query(q), x)", 1},
+		{"euclidean distance", "euclidean_distance(tensor(x[2]):[0,0], tensor(x[2]):[3,4], x)", 5},
+		{"softmax", "reduce(softmax(query(q), x), sum)", 1},
+		{"l1 normalize", "sum(l1_normalize(query(q), x) * tensor(x[3]):[0,0,1])", 0.5},
+		{"l2 normalize", "sum(map(l2_normalize(tensor(x[2]):[3,4], x), f(v)(v * v)))", 1},
+		{"argmax", "sum(argmax(query(q), x) * tensor(x[3]):[10,20,30])", 30},
+		{"rename", "sum(rename(query(q), x, y) * tensor(y[3]):[1,1,1])", 6},
+		{"sparse reduce", "sum(query(weights), cat)", 5},
+		{"number reduce", "sum(bm25(title))", 2.5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Evaluate(MustParse(tt.expression), features)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if math.Abs(result-tt.expected) > 1e-6 {
+				t.Errorf("Expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestEvaluateTensor(t *testing.T) {
+	features := map[string]interface{}{
+		"query(q)":         literal(t, "tensor<float>(x[2],y[2]):[[1,2],[3,4]]"),
+		"attribute(bias)":  literal(t, "tensor(y[2]):[10,20]"),
+		"attribute(title)": literal(t, "tensor(key{},x[2]):{a:[1,2],b:[3,4]}"),
+	}
+
+	tests := []struct {
+		name       string
+		expression string
+		expected   string
+	}{
+		{"map", "map(query(q), f(x)(x * 2))", "tensor<float>(x[2],y[2]):[[2,4],[6,8]]"},
+		{"reduce one dimension", "reduce(query(q), sum, x)", "tensor<float>(y[2]):[4,6]"},
+		{"join with broadcast", "query(q) + attribute(bias)", "tensor(x[2],y[2]):[[11,22],[13,24]]"},
+		{"mixed reduce", "reduce(attribute(title), max, x)", "tensor(key{}):{a:2,b:4}"},
+		{"expand", "expand(attribute(bias), z)", "tensor(y[2],z[1]):[[10],[20]]"},
+		{"cell cast", "cell_cast(attribute(bias) / 3, float)", "tensor<float>(y[2]):[3.3333333,6.6666665]"},
+		{"number", "1 + 2", "tensor():{{}:3}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := EvaluateTensor(MustParse(tt.expression), features)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result.Literal() != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result.Literal())
+			}
+		})
+	}
+}
+
+func TestEvaluateRankProfile(t *testing.T) {
+	s, err := schema.Parse([]byte(`
+schema product {
+    document product {
+        field title type string {
+            indexing: summary | index
+        }
+    }
+    rank-profile base {
+        inputs {
+            query(alpha) double: 0.25
+            query(boost) double
+            query(q) tensor<float>(x[2]): [1, 2]
+        }
+        function text_score() {
+            expression: bm25(title)
+        }
+        function inline blend(a, b) {
+            expression: query(alpha) * a + (1 - query(alpha)) * b
+        }
+    }
+    rank-profile hybrid inherits base {
+        function text_score() {
+            expression: 2 * bm25(title)
+        }
+        first-phase {
+            expression: blend(text_score, closeness(field, embedding)) + query(boost)
+        }
+        second-phase {
+            expression: firstPhase + sum(query(q))
+        }
+        global-phase {
+            expression: 2 * secondPhase
+            rerank-count: 100
+        }
+    }
+}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	rp, _ := s.RankProfile("hybrid")
+	features := map[string]interface{}{
+		"bm25(title)":                2.0,
+		"closeness(field,embedding)": 0.8,
+	}
+
+	// text_score of hybrid overrides the inherited one: 0.25 * 4 + 0.75 * 0.8
+	score, err := Evaluate(MustParse(rp.FirstPhase.Expression), features, WithRankProfile(s, "hybrid"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if math.Abs(score-1.6) > 1e-9 {
+		t.Errorf("Expected %v, got %v", 1.6, score)
+	}
+
+	score, err = Evaluate(MustParse(rp.SecondPhase.Expression), features, WithRankProfile(s, "hybrid"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if math.Abs(score-4.6) > 1e-9 {
+		t.Errorf("Expected %v, got %v", 4.6, score)
+	}
+
+	score, err = Evaluate(MustParse(rp.GlobalPhase.Expression), features, WithRankProfile(s, "hybrid"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if math.Abs(score-9.2) > 1e-9 {
+		t.Errorf("Expected %v, got %v", 9.2, score)
+	}
+	score, err = Evaluate(MustParse("globalPhase"), features, WithRankProfile(s, "hybrid"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if math.Abs(score-9.2) > 1e-9 {
+		t.Errorf("Expected %v, got %v", 9.2, score)
+	}
+
+	// Values given for functions and inputs take precedence
+	features["text_score"] = 10.0
+	features["query(alpha)"] = 1.0
+	score, err = Evaluate(MustParse(rp.FirstPhase.Expression), features, WithRankProfile(s, "hybrid"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if score != 10 {
+		t.Errorf("Expected %v, got %v", 10, score)
+	}
+
+	score, err = Evaluate(MustParse("rankingExpression(double) + 1"), nil,
+		WithFunction("double", MustParse("2 * x"), "x"), WithFunction("double", MustParse("4")))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if score != 5 {
+		t.Errorf("Expected %v, got %v", 5, score)
+	}
+}
+
+func TestHitFeatures(t *testing.T) {
+	var hit vespa.Hit
+	err := json.Unmarshal([]byte(`{
+		"relevance": 3.14,
+		"fields": {
+			"matchfeatures": {"bm25(title)": 2.5, "closeness(field,embedding)": 0.8},
+			"summaryfeatures": {
+				"attribute(popularity)": 40,
+				"query(q)": {"type": "tensor<float>(x[3])", "values": [1, 2, 3]},
+				"vespa.summaryFeatures.cached": 0
+			}
+		}
+	}`), &hit)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	features, err := HitFeatures(hit)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	score, err := Evaluate(MustParse("bm25(title) + 0.3 * closeness(field,embedding) + attribute(popularity) / 100 + sum(query(q))"), features)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if math.Abs(score-9.14) > 1e-9 {
+		t.Errorf("Expected %v, got %v", 9.14, score)
+	}
+
+	hit.Fields["summaryfeatures"] = map[string]interface{}{"query(q)": map[string]interface{}{"values": []interface{}{1}}}
+	if _, err := HitFeatures(hit); err == nil || !strings.Contains(err.Error(), "feature 'query(q)'") {
+		t.Errorf("Expected error for tensor without type, got %v", err)
+	}
+}
+
+func TestEvaluateErrors(t *testing.T) {
+	features := map[string]interface{}{
+		"attribute(category)": "shoes",
+		"query(q)":            literal(t, "tensor(x[2]):[1,2]"),
+		"query(k)":            literal(t, "tensor(key{}):{a:1}"),
+		"attribute(list)":     []int{1},
+	}
+
+	tests := []struct {
+		name       string
+		expression string
+		expected   string
+	}{
+		{"missing feature", "bm25(title) + 1", "no value for feature 'bm25(title)'"},
+		{"unsupported value", "attribute(list)", "unsupported value type []int for feature 'attribute(list)'"},
+		{"string arithmetic", "attribute(category) + 1", `cannot apply '+' to "shoes" and 1`},
+		{"tensor result", "query(q) * 2", "evaluates to a tensor of type tensor(x[2]), use EvaluateTensor"},
+		{"string result", "attribute(category)", "evaluates to the string 'shoes'"},
+		{"no matching case", "switch (1) { case 2: 3 }", "no case of switch (1) { case 2: 3 } matches 1"},
+		{"unknown dimension", "reduce(query(q), sum, y)", "unknown dimension 'y'"},
+		{"unknown aggregator", "reduce(query(q), total)", "unknown aggregator 'total'"},
+		{"incompatible dimensions", "query(q) * rename(query(k), key, x)", "dimension 'x' is mapped in one and indexed in the other"},
+		{"map without lambda", "map(query(q), 2)", "requires a tensor and a lambda of one parameter"},
+		{"unsupported function", "concat(query(q), query(q), x)", "function 'concat' is not supported"},
+		{"recursive function", "loop", "function 'loop' is recursive"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Evaluate(MustParse(tt.expression), features, WithFunction("loop", MustParse("loop + 1")))
+			if err == nil {
+				t.Fatal("Expected error")
+			}
+			if !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected error containing %q, got %q", tt.expected, err.Error())
+			}
+		})
+	}
+
+	// Expressions built directly may still misplace lambdas
+	lambda := Lambda(Var("x"), "x")
+	calls := []*Call{
+		{Name: "sum", Args: []Node{lambda}},
+		{Name: "matmul", Args: []Node{MustParse("query(q)"), lambda, MustParse("x")}},
+		{Name: "cosine_similarity", Args: []Node{lambda, MustParse("query(q)"), MustParse("x")}},
+		{Name: "xw_plus_b", Args: []Node{MustParse("query(q)"), lambda, lambda, MustParse("x")}},
+		{Name: "map", Args: []Node{lambda, MustParse("query(q)")}},
+	}
+	for _, call := range calls {
+		_, err := EvaluateTensor(call, features)
+		if err == nil || !strings.Contains(err.Error(), "only the last argument of map, join or merge may be a lambda") {
+			t.Errorf("Expected misplaced lambda error for %s, got %v", call, err)
+		}
+	}
+
+	s, _ := schema.Parse([]byte("schema product {\n  document product {\n  }\n}"))
+	if _, err := Evaluate(MustParse("1"), nil, WithRankProfile(s, "missing")); err == nil || !strings.Contains(err.Error(), "rank profile 'missing' not found") {
+		t.Errorf("Expected missing rank profile error, got %v", err)
+	}
+}
+
+func literal(t *testing.T, s string) *tensor.Tensor {
+	result, err := tensor.ParseLiteral(s)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return result
+}
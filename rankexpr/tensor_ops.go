@@ -0,0 +1,445 @@
+package rankexpr
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vipulsodha/vespa-go/tensor"
+)
+
+// =============================================================================
+// Tensor Operations
+// =============================================================================
+
+// The tensor functions of ranking expressions are built on three primitives,
+// as in Vespa: map applies a function to every cell, join combines the cells
+// of two tensors with matching labels in their common dimensions, and reduce
+// aggregates cells over dimensions. Unset cells of indexed dimensions read
+// as zero.
+
+// aggregators are the aggregators of reduce
+var aggregators = map[string]func([]float64) float64{
+	"avg": func(values []float64) float64 {
+		if len(values) == 0 {
+			return 0
+		}
+		return sumOf(values) / float64(len(values))
+	},
+	"count": func(values []float64) float64 { return float64(len(values)) },
+	"max": func(values []float64) float64 {
+		if len(values) == 0 {
+			return 0
+		}
+		result := math.Inf(-1)
+		for _, v := range values {
+			result = math.Max(result, v)
+		}
+		return result
+	},
+	"median": func(values []float64) float64 {
+		if len(values) == 0 {
+			return 0
+		}
+		sorted := append([]float64(nil), values...)
+		sort.Float64s(sorted)
+		middle := len(sorted) / 2
+		if len(sorted)%2 == 0 {
+			return (sorted[middle-1] + sorted[middle]) / 2
+		}
+		return sorted[middle]
+	},
+	"min": func(values []float64) float64 {
+		if len(values) == 0 {
+			return 0
+		}
+		result := math.Inf(1)
+		for _, v := range values {
+			result = math.Min(result, v)
+		}
+		return result
+	},
+	"prod": func(values []float64) float64 {
+		result := 1.0
+		for _, v := range values {
+			result *= v
+		}
+		return result
+	},
+	"sum": sumOf,
+}
+
+func sumOf(values []float64) float64 {
+	result := 0.0
+	for _, v := range values {
+		result += v
+	}
+	return result
+}
+
+// mapTensor applies f to every cell
+func mapTensor(t *tensor.Tensor, f func(float64) float64) (*tensor.Tensor, error) {
+	result, err := newTensor(promote(t.Type().CellType), t.Type().Dimensions)
+	if err != nil {
+		return nil, err
+	}
+	for _, cell := range allCells(t) {
+		if err := setCell(result, cell.Address, f(cell.Value)); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// joinTensors combines every pair of cells of a and b agreeing on the labels
+// of their common dimensions. The result has the union of the dimensions;
+// indexed dimensions of different sizes are cut to the smaller size.
+func joinTensors(a, b *tensor.Tensor, f func(x, y float64) float64) (*tensor.Tensor, error) {
+	dims := make(map[string]tensor.Dimension)
+	for _, d := range a.Type().Dimensions {
+		dims[d.Name] = d
+	}
+	var common []string
+	for _, d := range b.Type().Dimensions {
+		other, ok := dims[d.Name]
+		if !ok {
+			dims[d.Name] = d
+			continue
+		}
+		if other.IsMapped() != d.IsMapped() {
+			return nil, fmt.Errorf("rankexpr: cannot join %s and %s: dimension '%s' is mapped in one and indexed in the other", a.Type(), b.Type(), d.Name)
+		}
+		if d.Size < other.Size {
+			dims[d.Name] = d
+		}
+		common = append(common, d.Name)
+	}
+
+	cellType := tensor.Float
+	if a.Type().CellType == tensor.Double || b.Type().CellType == tensor.Double {
+		cellType = tensor.Double
+	}
+	result, err := newTensor(cellType, dimensionList(dims))
+	if err != nil {
+		return nil, err
+	}
+
+	matching := make(map[string][]tensor.Cell)
+	for _, cell := range allCells(b) {
+		key := labelKey(cell.Address, common)
+		matching[key] = append(matching[key], cell)
+	}
+	for _, x := range allCells(a) {
+		for _, y := range matching[labelKey(x.Address, common)] {
+			address := make(tensor.Address, len(dims))
+			for k, v := range x.Address {
+				address[k] = v
+			}
+			for k, v := range y.Address {
+				address[k] = v
+			}
+			if !inRange(result.Type(), address) {
+				continue
+			}
+			if err := setCell(result, address, f(x.Value, y.Value)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return result, nil
+}
+
+// mergeTensors returns the cells of both tensors, combining cells present in
+// both with f. The tensors must have the same dimensions.
+func mergeTensors(a, b *tensor.Tensor, f func(x, y float64) float64) (*tensor.Tensor, error) {
+	if dimensionsOf(a.Type()) != dimensionsOf(b.Type()) {
+		return nil, fmt.Errorf("rankexpr: cannot merge %s and %s: dimensions differ", a.Type(), b.Type())
+	}
+	cellType := tensor.Float
+	if a.Type().CellType == tensor.Double || b.Type().CellType == tensor.Double {
+		cellType = tensor.Double
+	}
+	result, err := newTensor(cellType, a.Type().Dimensions)
+	if err != nil {
+		return nil, err
+	}
+
+	names := dimensionNames(a.Type())
+	right := make(map[string]float64)
+	for _, cell := range b.Cells() {
+		right[labelKey(cell.Address, names)] = cell.Value
+	}
+	for _, cell := range a.Cells() {
+		value := cell.Value
+		if other, ok := right[labelKey(cell.Address, names)]; ok {
+			value = f(value, other)
+		}
+		if err := setCell(result, cell.Address, value); err != nil {
+			return nil, err
+		}
+	}
+	for _, cell := range b.Cells() {
+		if _, ok := a.Get(cell.Address); ok {
+			continue
+		}
+		if err := setCell(result, cell.Address, cell.Value); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// reduceTensor aggregates the cells over the given dimensions, or over all
+// dimensions if none are given, in which case the result is a number
+func reduceTensor(t *tensor.Tensor, aggregator string, dimensions []string) (value, error) {
+	aggregate, ok := aggregators[aggregator]
+	if !ok {
+		return value{}, fmt.Errorf("rankexpr: unknown aggregator '%s'", aggregator)
+	}
+	reduced := make(map[string]bool)
+	for _, name := range dimensions {
+		if _, ok := t.Type().Dimension(name); !ok {
+			return value{}, fmt.Errorf("rankexpr: cannot reduce %s over unknown dimension '%s'", t.Type(), name)
+		}
+		reduced[name] = true
+	}
+
+	var remaining []tensor.Dimension
+	for _, d := range t.Type().Dimensions {
+		if len(reduced) > 0 && !reduced[d.Name] {
+			remaining = append(remaining, d)
+		}
+	}
+	if len(remaining) == 0 {
+		var values []float64
+		for _, cell := range allCells(t) {
+			values = append(values, cell.Value)
+		}
+		return numberValue(aggregate(values)), nil
+	}
+
+	names := make([]string, len(remaining))
+	for i, d := range remaining {
+		names[i] = d.Name
+	}
+	groups := make(map[string][]float64)
+	addresses := make(map[string]tensor.Address)
+	for _, cell := range allCells(t) {
+		key := labelKey(cell.Address, names)
+		if _, ok := addresses[key]; !ok {
+			address := make(tensor.Address, len(names))
+			for _, name := range names {
+				address[name] = cell.Address[name]
+			}
+			addresses[key] = address
+		}
+		groups[key] = append(groups[key], cell.Value)
+	}
+
+	result, err := newTensor(promote(t.Type().CellType), remaining)
+	if err != nil {
+		return value{}, err
+	}
+	for key, values := range groups {
+		if err := setCell(result, addresses[key], aggregate(values)); err != nil {
+			return value{}, err
+		}
+	}
+	return tensorValue(result), nil
+}
+
+// renameDimension renames a dimension of the tensor
+func renameDimension(t *tensor.Tensor, from, to string) (*tensor.Tensor, error) {
+	if _, ok := t.Type().Dimension(from); !ok {
+		return nil, fmt.Errorf("rankexpr: cannot rename unknown dimension '%s' of %s", from, t.Type())
+	}
+	dims := make([]tensor.Dimension, len(t.Type().Dimensions))
+	for i, d := range t.Type().Dimensions {
+		if d.Name == from {
+			d.Name = to
+		}
+		dims[i] = d
+	}
+	result, err := newTensor(t.Type().CellType, dims)
+	if err != nil {
+		return nil, err
+	}
+	for _, cell := range t.Cells() {
+		address := make(tensor.Address, len(cell.Address))
+		for k, v := range cell.Address {
+			if k == from {
+				k = to
+			}
+			address[k] = v
+		}
+		if err := result.Set(address, cell.Value); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// expandDimension adds an indexed dimension of size 1
+func expandDimension(t *tensor.Tensor, name string) (*tensor.Tensor, error) {
+	if _, ok := t.Type().Dimension(name); ok {
+		return nil, fmt.Errorf("rankexpr: cannot expand %s with existing dimension '%s'", t.Type(), name)
+	}
+	result, err := newTensor(t.Type().CellType, append(append([]tensor.Dimension(nil), t.Type().Dimensions...), tensor.Indexed(name, 1)))
+	if err != nil {
+		return nil, err
+	}
+	for _, cell := range t.Cells() {
+		address := tensor.Address{name: "0"}
+		for k, v := range cell.Address {
+			address[k] = v
+		}
+		if err := result.Set(address, cell.Value); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// castCells converts the tensor to another cell type, rounding values
+func castCells(t *tensor.Tensor, cellType tensor.CellType) (*tensor.Tensor, error) {
+	result, err := newTensor(cellType, t.Type().Dimensions)
+	if err != nil {
+		return nil, err
+	}
+	for _, cell := range t.Cells() {
+		if err := setCell(result, cell.Address, cell.Value); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// allCells returns the cells of the tensor, including the unset cells of
+// indexed dimensions as zero
+func allCells(t *tensor.Tensor) []tensor.Cell {
+	var indexed, mapped []tensor.Dimension
+	for _, d := range t.Type().Dimensions {
+		if d.IsMapped() {
+			mapped = append(mapped, d)
+		} else {
+			indexed = append(indexed, d)
+		}
+	}
+	if len(indexed) == 0 {
+		return t.Cells()
+	}
+
+	blocks := []tensor.Address{{}}
+	if len(mapped) > 0 {
+		blocks = nil
+		names := dimensionNames(tensor.Type{Dimensions: mapped})
+		seen := make(map[string]bool)
+		for _, cell := range t.Cells() {
+			key := labelKey(cell.Address, names)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			block := make(tensor.Address, len(names))
+			for _, name := range names {
+				block[name] = cell.Address[name]
+			}
+			blocks = append(blocks, block)
+		}
+	}
+
+	size := 1
+	for _, d := range indexed {
+		size *= d.Size
+	}
+	var cells []tensor.Cell
+	for _, block := range blocks {
+		for i := 0; i < size; i++ {
+			address := make(tensor.Address, len(t.Type().Dimensions))
+			for k, v := range block {
+				address[k] = v
+			}
+			rest := i
+			for d := len(indexed) - 1; d >= 0; d-- {
+				address[indexed[d].Name] = strconv.Itoa(rest % indexed[d].Size)
+				rest /= indexed[d].Size
+			}
+			value, _ := t.Get(address)
+			cells = append(cells, tensor.Cell{Address: address, Value: value})
+		}
+	}
+	return cells
+}
+
+// setCell sets a cell, rounding the value to the cell type
+func setCell(t *tensor.Tensor, address tensor.Address, v float64) error {
+	switch t.Type().CellType {
+	case tensor.Float:
+		v = float64(float32(v))
+	case tensor.BFloat16:
+		v = float64(math.Float32frombits(math.Float32bits(float32(v)) &^ 0xFFFF))
+	case tensor.Int8:
+		v = math.Max(math.MinInt8, math.Min(math.MaxInt8, math.Trunc(v)))
+	}
+	return t.Set(address, v)
+}
+
+// promote returns the cell type of computed cells: int8 and bfloat16 cells
+// are computed as float
+func promote(cellType tensor.CellType) tensor.CellType {
+	if cellType == tensor.Int8 || cellType == tensor.BFloat16 {
+		return tensor.Float
+	}
+	return cellType
+}
+
+func newTensor(cellType tensor.CellType, dims []tensor.Dimension) (*tensor.Tensor, error) {
+	t, err := tensor.NewType(cellType, dims...)
+	if err != nil {
+		return nil, err
+	}
+	return tensor.New(t), nil
+}
+
+func inRange(t tensor.Type, address tensor.Address) bool {
+	for _, d := range t.Dimensions {
+		if d.IsMapped() {
+			continue
+		}
+		if index, err := strconv.Atoi(address[d.Name]); err != nil || index >= d.Size {
+			return false
+		}
+	}
+	return true
+}
+
+func labelKey(address tensor.Address, names []string) string {
+	labels := make([]string, len(names))
+	for i, name := range names {
+		labels[i] = address[name]
+	}
+	return strings.Join(labels, "\x00")
+}
+
+func dimensionList(dims map[string]tensor.Dimension) []tensor.Dimension {
+	list := make([]tensor.Dimension, 0, len(dims))
+	for _, d := range dims {
+		list = append(list, d)
+	}
+	return list
+}
+
+func dimensionNames(t tensor.Type) []string {
+	names := make([]string, len(t.Dimensions))
+	for i, d := range t.Dimensions {
+		names[i] = d.Name
+	}
+	return names
+}
+
+// dimensionsOf returns the dimension list of a type, ignoring the cell type
+func dimensionsOf(t tensor.Type) string {
+	return tensor.Type{Dimensions: t.Dimensions}.String()
+}
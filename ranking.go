@@ -0,0 +1,162 @@
+package vespa
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// =============================================================================
+// Ranking
+// =============================================================================
+
+// Ranking represents the ranking object of a Vespa query request.
+// Fields map one-to-one onto the nested `ranking.*` request parameters.
+type Ranking struct {
+	Profile      string       `json:"profile,omitempty"`
+	ListFeatures bool         `json:"listFeatures,omitempty"`
+	Sorting      string       `json:"sorting,omitempty"`
+	Freshness    string       `json:"freshness,omitempty"`
+	RerankCount  int          `json:"rerankCount,omitempty"`
+	MatchPhase   *MatchPhase  `json:"matchPhase,omitempty"`
+	Diversity    *Diversity   `json:"diversity,omitempty"`
+	GlobalPhase  *GlobalPhase `json:"globalPhase,omitempty"`
+}
+
+// MatchPhase configures match-phase degradation (ranking.matchPhase.*)
+type MatchPhase struct {
+	Attribute         string   `json:"attribute,omitempty"`
+	MaxHits           int      `json:"maxHits,omitempty"`
+	Ascending         *bool    `json:"ascending,omitempty"`
+	MaxFilterCoverage *float64 `json:"maxFilterCoverage,omitempty"`
+}
+
+// Diversity configures result diversity (ranking.diversity.*)
+type Diversity struct {
+	Attribute string `json:"attribute,omitempty"`
+	MinGroups int    `json:"minGroups,omitempty"`
+}
+
+// GlobalPhase configures global-phase reranking (ranking.globalPhase.*)
+type GlobalPhase struct {
+	RerankCount int `json:"rerankCount,omitempty"`
+}
+
+// rankingAlias prevents MarshalJSON from recursing into itself
+type rankingAlias Ranking
+
+// MarshalJSON serializes the ranking as Vespa's nested request object.
+// A ranking with only a profile is serialized as the plain profile name,
+// which Vespa accepts as shorthand for ranking.profile.
+func (r Ranking) MarshalJSON() ([]byte, error) {
+	if r.isProfileOnly() {
+		return json.Marshal(r.Profile)
+	}
+	return json.Marshal(rankingAlias(r))
+}
+
+// UnmarshalJSON accepts both the profile-name shorthand and the nested object.
+func (r *Ranking) UnmarshalJSON(data []byte) error {
+	var profile string
+	if err := json.Unmarshal(data, &profile); err == nil {
+		*r = Ranking{Profile: profile}
+		return nil
+	}
+
+	var alias rankingAlias
+	if err := json.Unmarshal(data, &alias); err != nil {
+		return err
+	}
+	*r = Ranking(alias)
+	return nil
+}
+
+func (r Ranking) isProfileOnly() bool {
+	return !r.ListFeatures &&
+		r.Sorting == "" &&
+		r.Freshness == "" &&
+		r.RerankCount == 0 &&
+		r.MatchPhase == nil &&
+		r.Diversity == nil &&
+		r.GlobalPhase == nil
+}
+
+func (r Ranking) isZero() bool {
+	return r.Profile == "" && r.isProfileOnly()
+}
+
+// clone returns a deep copy of the ranking configuration
+func (r Ranking) clone() Ranking {
+	c := r
+	if r.MatchPhase != nil {
+		mp := *r.MatchPhase
+		if mp.Ascending != nil {
+			ascending := *mp.Ascending
+			mp.Ascending = &ascending
+		}
+		if mp.MaxFilterCoverage != nil {
+			coverage := *mp.MaxFilterCoverage
+			mp.MaxFilterCoverage = &coverage
+		}
+		c.MatchPhase = &mp
+	}
+	if r.Diversity != nil {
+		d := *r.Diversity
+		c.Diversity = &d
+	}
+	if r.GlobalPhase != nil {
+		gp := *r.GlobalPhase
+		c.GlobalPhase = &gp
+	}
+	return c
+}
+
+// validate checks the ranking configuration for values Vespa would reject
+func (r Ranking) validate() error {
+	if r.RerankCount < 0 {
+		return &ValidationError{
+			Field:   "ranking.rerankCount",
+			Message: fmt.Sprintf("rerank count must not be negative, got %d", r.RerankCount),
+		}
+	}
+	if r.MatchPhase != nil {
+		if r.MatchPhase.Attribute == "" {
+			return &ValidationError{
+				Field:   "ranking.matchPhase.attribute",
+				Message: "match phase requires an attribute",
+			}
+		}
+		if r.MatchPhase.MaxHits < 0 {
+			return &ValidationError{
+				Field:   "ranking.matchPhase.maxHits",
+				Message: fmt.Sprintf("max hits must not be negative, got %d", r.MatchPhase.MaxHits),
+			}
+		}
+		if c := r.MatchPhase.MaxFilterCoverage; c != nil && (*c < 0 || *c > 1) {
+			return &ValidationError{
+				Field:   "ranking.matchPhase.maxFilterCoverage",
+				Message: fmt.Sprintf("max filter coverage must be between 0 and 1, got %v", *c),
+			}
+		}
+	}
+	if r.Diversity != nil {
+		if r.Diversity.Attribute == "" {
+			return &ValidationError{
+				Field:   "ranking.diversity.attribute",
+				Message: "diversity requires an attribute",
+			}
+		}
+		if r.Diversity.MinGroups < 0 {
+			return &ValidationError{
+				Field:   "ranking.diversity.minGroups",
+				Message: fmt.Sprintf("min groups must not be negative, got %d", r.Diversity.MinGroups),
+			}
+		}
+	}
+	if r.GlobalPhase != nil && r.GlobalPhase.RerankCount < 0 {
+		return &ValidationError{
+			Field:   "ranking.globalPhase.rerankCount",
+			Message: fmt.Sprintf("rerank count must not be negative, got %d", r.GlobalPhase.RerankCount),
+		}
+	}
+	return nil
+}
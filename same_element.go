@@ -2,6 +2,7 @@ package vespa
 
 import (
 	"fmt"
+	"reflect"
 	"strings"
 )
 
@@ -30,6 +31,9 @@ type SameElementCondition struct {
 //     sizes.ContainsSameElement(Field("family").Eq("US"), Field("size_value").Eq("10")),
 //     sizes.ContainsSameElement(Field("family").Eq("US"), Field("size_value").Eq("11"))
 //   )
+//
+// Build reports IN and OR inside sameElement as validation errors.
+// DistributeSameElement performs the rewrite above automatically.
 func (f FieldBuilder) ContainsSameElement(conditions ...WhereCondition) WhereCondition {
 	return &SameElementCondition{
 		Field:      f.field,
@@ -58,6 +62,59 @@ func (se *SameElementCondition) ToYQL() string {
 	return fmt.Sprintf("(%s contains sameElement(%s))", se.Field, strings.Join(conditionStrings, ", "))
 }
 
+// =============================================================================
+// Map and Struct Fields
+// =============================================================================
+
+// Key addresses the key of a map entry inside sameElement:
+//
+//	Field("attributes").ContainsSameElement(Key().Eq("color"), Value().Eq("red"))
+//	// (attributes contains sameElement(key contains 'color', value contains 'red'))
+func Key() FieldBuilder {
+	return Field("key")
+}
+
+// Value addresses the value of a map entry inside sameElement. For map
+// values of struct type, path selects a struct field: Value("name")
+// addresses value.name.
+func Value(path ...string) FieldBuilder {
+	return Field("value").Path(path...)
+}
+
+// Path addresses a nested struct field, e.g. Field("person").Path("address", "city")
+// addresses person.address.city.
+func (f FieldBuilder) Path(path ...string) FieldBuilder {
+	if len(path) == 0 {
+		return f
+	}
+	return FieldBuilder{field: f.field + "." + strings.Join(path, ".")}
+}
+
+// Key addresses the keys of a map field outside sameElement, e.g.
+// Field("attributes").Key().Eq("color") matches documents with a color entry.
+func (f FieldBuilder) Key() FieldBuilder {
+	return f.Path("key")
+}
+
+// Value addresses the values of a map field outside sameElement, optionally
+// followed by a struct path. Use ContainsEntry to match a key and its value
+// in the same entry.
+func (f FieldBuilder) Value(path ...string) FieldBuilder {
+	return f.Path(append([]string{"value"}, path...)...)
+}
+
+// ContainsEntry matches map entries with the given key whose value satisfies
+// all conditions. Conditions address the value with Value():
+//
+//	Field("attributes").ContainsEntry("color", Value().Eq("red"))
+//	// (attributes contains sameElement(key contains 'color', value contains 'red'))
+//
+//	Field("stock").ContainsEntry("oslo", Value("count").Gt(0), Value("sizes").ContainsSameElement(...))
+//	// (stock contains sameElement(key contains 'oslo', value.count > 0, value.sizes contains sameElement(...)))
+func (f FieldBuilder) ContainsEntry(key interface{}, conditions ...WhereCondition) WhereCondition {
+	return f.ContainsSameElement(append([]WhereCondition{Key().Eq(key)}, conditions...)...)
+}
+
 // And/Or methods for SameElementCondition
 func (se *SameElementCondition) And(condition WhereCondition) WhereCondition {
 	return And(se, condition)
@@ -66,3 +123,146 @@ func (se *SameElementCondition) And(condition WhereCondition) WhereCondition {
 func (se *SameElementCondition) Or(condition WhereCondition) WhereCondition {
 	return Or(se, condition)
 }
+
+// maxSameElementAlternatives bounds the number of sameElement conditions
+// DistributeSameElement expands a single condition into.
+const maxSameElementAlternatives = 256
+
+// DistributeSameElement rewrites a sameElement condition containing IN or OR
+// into a top-level OR of sameElement conditions, which Vespa accepts:
+//
+//	sizes contains sameElement(family contains 'US', size in ('10', '11'))
+//
+// becomes
+//
+//	(sizes contains sameElement(family contains 'US', size contains '10')) OR
+//	(sizes contains sameElement(family contains 'US', size contains '11'))
+//
+// Other conditions are returned unchanged, so it can be passed to Rewrite or
+// QueryBuilder.Rewrite to distribute every sameElement in a tree:
+//
+//	builder.Rewrite(vespa.DistributeSameElement)
+//
+// NOT IN, IN with a non-list value and expansions of more than 256
+// alternatives are left as-is and still rejected by Build.
+func DistributeSameElement(condition WhereCondition) WhereCondition {
+	se, ok := condition.(*SameElementCondition)
+	if !ok || len(se.Conditions) == 0 {
+		return condition
+	}
+
+	alternatives := [][]WhereCondition{nil}
+	for _, inner := range se.Conditions {
+		innerAlternatives, ok := sameElementAlternatives(inner, maxSameElementAlternatives)
+		if !ok {
+			return condition
+		}
+		if alternatives, ok = crossAlternatives(alternatives, innerAlternatives, maxSameElementAlternatives); !ok {
+			return condition
+		}
+	}
+
+	if len(alternatives) == 1 && sameConditions(alternatives[0], se.Conditions) {
+		return condition
+	}
+
+	distributed := make([]WhereCondition, len(alternatives))
+	for i, conditions := range alternatives {
+		distributed[i] = &SameElementCondition{Field: se.Field, Conditions: conditions}
+	}
+	return combineOperands(distributed, "OR", false)
+}
+
+// sameElementAlternatives returns the alternatives a sameElement operand
+// matches, each a list of conditions that must all hold. It stops and
+// reports false as soon as there would be more than limit alternatives.
+func sameElementAlternatives(condition WhereCondition, limit int) ([][]WhereCondition, bool) {
+	switch c := condition.(type) {
+	case *FieldCondition:
+		if c.Operator != IN {
+			break
+		}
+		rv := reflect.ValueOf(c.Value)
+		if rv.Kind() != reflect.Slice || rv.Len() == 0 {
+			break
+		}
+		if rv.Len() > limit {
+			return nil, false
+		}
+		alternatives := make([][]WhereCondition, rv.Len())
+		for i := range alternatives {
+			alternatives[i] = []WhereCondition{&FieldCondition{Field: c.Field, Operator: EQ, Value: rv.Index(i).Interface()}}
+		}
+		return alternatives, true
+	case *BooleanCondition:
+		return junctionAlternatives(c.Operator, []WhereCondition{c.Left, c.Right}, condition, limit)
+	case *JunctionCondition:
+		return junctionAlternatives(c.Operator, c.Conditions, condition, limit)
+	}
+	return [][]WhereCondition{{condition}}, true
+}
+
+func junctionAlternatives(operator string, operands []WhereCondition, condition WhereCondition, limit int) ([][]WhereCondition, bool) {
+	var alternatives [][]WhereCondition
+	switch strings.ToUpper(operator) {
+	case "OR":
+		for _, operand := range operands {
+			operandAlternatives, ok := sameElementAlternatives(operand, limit)
+			if !ok {
+				return nil, false
+			}
+			alternatives = append(alternatives, operandAlternatives...)
+			if len(alternatives) > limit {
+				return nil, false
+			}
+		}
+	case "AND":
+		alternatives = [][]WhereCondition{nil}
+		for _, operand := range operands {
+			operandAlternatives, ok := sameElementAlternatives(operand, limit)
+			if !ok {
+				return nil, false
+			}
+			if alternatives, ok = crossAlternatives(alternatives, operandAlternatives, limit); !ok {
+				return nil, false
+			}
+		}
+		if len(alternatives) == 1 && sameConditions(alternatives[0], operands) {
+			return [][]WhereCondition{{condition}}, true
+		}
+	}
+	if len(alternatives) == 0 {
+		return [][]WhereCondition{{condition}}, true
+	}
+	return alternatives, true
+}
+
+// crossAlternatives combines every alternative in left with every alternative
+// in right, or reports false without combining them if there would be more
+// than limit combinations
+func crossAlternatives(left, right [][]WhereCondition, limit int) ([][]WhereCondition, bool) {
+	if len(right) > 0 && len(left) > limit/len(right) {
+		return nil, false
+	}
+	combined := make([][]WhereCondition, 0, len(left)*len(right))
+	for _, l := range left {
+		for _, r := range right {
+			conditions := make([]WhereCondition, 0, len(l)+len(r))
+			conditions = append(conditions, l...)
+			combined = append(combined, append(conditions, r...))
+		}
+	}
+	return combined, true
+}
+
+func sameConditions(a, b []WhereCondition) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
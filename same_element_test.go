@@ -0,0 +1,90 @@
+package vespa
+
+import "testing"
+
+func TestMapFieldConditions(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition WhereCondition
+		expected  string
+	}{
+		{
+			"Key and value",
+			Field("attributes").ContainsSameElement(Key().Eq("color"), Value().Eq("red")),
+			"(attributes contains sameElement((key contains 'color'), (value contains 'red')))",
+		},
+		{
+			"Entry",
+			Field("attributes").ContainsEntry("color", Value().Eq("red")),
+			"(attributes contains sameElement((key contains 'color'), (value contains 'red')))",
+		},
+		{
+			"Struct value",
+			Field("stock").ContainsEntry("oslo", Value("count").Gt(0), Value("warehouse", "zone").Eq("A")),
+			"(stock contains sameElement((key contains 'oslo'), (value.count > 0), (value.warehouse.zone contains 'A')))",
+		},
+		{
+			"Nested sameElement",
+			Field("stock").ContainsEntry("oslo", Value("sizes").ContainsSameElement(Field("family").Eq("US"), Field("size").Gte(10))),
+			"(stock contains sameElement((key contains 'oslo'), (value.sizes contains sameElement((family contains 'US'), (size >= 10)))))",
+		},
+		{
+			"Keys outside sameElement",
+			Field("attributes").Key().Eq("color"),
+			"(attributes.key contains 'color')",
+		},
+		{
+			"Values outside sameElement",
+			Field("stock").Value("count").Gt(0),
+			"(stock.value.count > 0)",
+		},
+		{
+			"Struct path",
+			Field("person").Path("address", "city").Eq("Oslo"),
+			"(person.address.city contains 'Oslo')",
+		},
+		{
+			"Empty path",
+			Field("title").Path().Eq("shoes"),
+			"(title contains 'shoes')",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := tt.condition.ToYQL(); actual != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, actual)
+			}
+			if err := Validate(tt.condition); err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+
+			parsed, err := ParseCondition(tt.expected)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if parsed.ToYQL() != tt.expected {
+				t.Errorf("Expected parsed condition to render %q, got %q", tt.expected, parsed.ToYQL())
+			}
+		})
+	}
+}
+
+func TestNestedSameElementValidation(t *testing.T) {
+	condition := Field("stock").ContainsEntry("oslo",
+		Value("sizes").ContainsSameElement(Field("family").Eq("US"), Field("size").In(10, 11)))
+
+	if err := Validate(condition); err == nil {
+		t.Fatal("Expected error for in inside nested sameElement")
+	}
+
+	distributed := Rewrite(condition, DistributeSameElement)
+	if err := Validate(distributed); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := "((stock contains sameElement((key contains 'oslo'), (value.sizes contains sameElement((family contains 'US'), (size = 10))))) OR " +
+		"(stock contains sameElement((key contains 'oslo'), (value.sizes contains sameElement((family contains 'US'), (size = 11))))))"
+	if actual := distributed.ToYQL(); actual != expected {
+		t.Errorf("Expected %q, got %q", expected, actual)
+	}
+}
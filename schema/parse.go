@@ -0,0 +1,600 @@
+package schema
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// =============================================================================
+// Loading
+// =============================================================================
+
+// Parse parses the contents of a schema file
+func Parse(data []byte) (*Schema, error) {
+	nodes, err := parseNodes(string(data))
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) != 1 || !nodes[0].block || !(nodes[0].keyword() == "schema" || nodes[0].keyword() == "search") {
+		return nil, fmt.Errorf("schema: expected a single 'schema <name> { ... }' block")
+	}
+	return buildSchema(nodes[0])
+}
+
+// ParseFile parses a schema file
+func ParseFile(path string) (*Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Load parses all schema files in a directory, e.g. the schemas directory of
+// an application package, ordered by name. Rank profiles in separate
+// <schema>/*.profile files are added to their schema.
+func Load(dir string) ([]*Schema, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.sd"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	var schemas []*Schema
+	for _, path := range paths {
+		s, err := ParseFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		profiles, err := filepath.Glob(filepath.Join(dir, s.Name, "*.profile"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(profiles)
+		for _, profile := range profiles {
+			rp, err := parseProfileFile(profile)
+			if err != nil {
+				return nil, err
+			}
+			s.RankProfiles = append(s.RankProfiles, rp)
+		}
+		schemas = append(schemas, s)
+	}
+	return schemas, nil
+}
+
+// parseProfileFile parses a file holding a single rank-profile block
+func parseProfileFile(path string) (*RankProfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := parseNodes(string(data))
+	if err == nil && (len(nodes) != 1 || !nodes[0].block || nodes[0].keyword() != "rank-profile") {
+		err = fmt.Errorf("schema: expected a single 'rank-profile <name> { ... }' block")
+	}
+	var rp *RankProfile
+	if err == nil {
+		rp, err = buildRankProfile(nodes[0])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rp, nil
+}
+
+// =============================================================================
+// Statements
+// =============================================================================
+
+// node is a statement of a schema file: a header followed by a block
+// ("field title type string { ... }"), a value ("indexing: summary") or
+// nothing ("fast-search")
+type node struct {
+	line     int
+	header   string
+	value    string
+	hasValue bool
+	block    bool
+	body     string // raw text of blocks holding expressions
+	children []*node
+}
+
+// rawBlocks hold text in their own syntax rather than statements
+var rawBlocks = map[string]bool{
+	"expression":       true,
+	"indexing":         true,
+	"match-features":   true,
+	"summary-features": true,
+	"rank-features":    true,
+}
+
+// keyword returns the first word of the header
+func (n *node) keyword() string {
+	if fields := strings.Fields(n.header); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+// text returns the value of a statement or the raw body of its block
+func (n *node) text() string {
+	if n.block {
+		return n.body
+	}
+	return n.value
+}
+
+func (n *node) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("schema: line %d: %s", n.line, fmt.Sprintf(format, args...))
+}
+
+// nameAndInherits splits a header such as "rank-profile hybrid inherits a, b"
+func (n *node) nameAndInherits() (string, []string, error) {
+	fields := strings.Fields(strings.ReplaceAll(n.header, ",", " "))
+	switch {
+	case len(fields) == 2:
+		return fields[1], nil, nil
+	case len(fields) > 3 && fields[2] == "inherits":
+		return fields[1], fields[3:], nil
+	default:
+		return "", nil, n.errorf("expected '%s <name> [inherits <name>, ...]', got '%s'", fields[0], n.header)
+	}
+}
+
+type scanner struct {
+	src  string
+	pos  int
+	line int
+}
+
+func parseNodes(src string) ([]*node, error) {
+	s := &scanner{src: src, line: 1}
+	return s.statements(false)
+}
+
+// statements parses statements up to the end of the input or, inside a
+// block, the closing brace
+func (s *scanner) statements(inBlock bool) ([]*node, error) {
+	var nodes []*node
+	for {
+		s.skipSpace()
+		if s.pos >= len(s.src) {
+			if inBlock {
+				return nil, fmt.Errorf("schema: line %d: missing '}'", s.line)
+			}
+			return nodes, nil
+		}
+		if s.src[s.pos] == '}' {
+			if !inBlock {
+				return nil, fmt.Errorf("schema: line %d: unexpected '}'", s.line)
+			}
+			s.pos++
+			return nodes, nil
+		}
+
+		n := &node{line: s.line, header: s.until(":{\n}", false)}
+		if n.header == "" {
+			return nil, fmt.Errorf("schema: line %d: unexpected '%c'", s.line, s.src[s.pos])
+		}
+
+		if s.pos < len(s.src) {
+			switch s.src[s.pos] {
+			case ':':
+				s.pos++
+				n.value = s.until("\n}", true)
+				n.hasValue = true
+			case '{':
+				s.pos++
+				n.block = true
+				var err error
+				if rawBlocks[n.keyword()] {
+					n.body, err = s.raw()
+				} else {
+					n.children, err = s.statements(true)
+				}
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		nodes = append(nodes, n)
+	}
+}
+
+// skipSpace skips whitespace and comments
+func (s *scanner) skipSpace() {
+	for s.pos < len(s.src) {
+		switch c := s.src[s.pos]; {
+		case c == '\n':
+			s.line++
+			s.pos++
+		case c == ' ' || c == '\t' || c == '\r' || c == ';':
+			s.pos++
+		case c == '#':
+			for s.pos < len(s.src) && s.src[s.pos] != '\n' {
+				s.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// until reads up to one of the stop characters outside parentheses, brackets
+// and strings, dropping comments. With braces set, braces nest as well, so
+// values such as tensor literals can contain them.
+func (s *scanner) until(stop string, braces bool) string {
+	var text strings.Builder
+	depth := 0
+	for s.pos < len(s.src) {
+		c := s.src[s.pos]
+		if depth == 0 && strings.IndexByte(stop, c) >= 0 {
+			break
+		}
+		switch {
+		case c == '#':
+			for s.pos < len(s.src) && s.src[s.pos] != '\n' {
+				s.pos++
+			}
+			continue
+		case c == '"' || c == '\'':
+			text.WriteString(s.quoted())
+			continue
+		case c == '(' || c == '[' || (braces && c == '{'):
+			depth++
+		case (c == ')' || c == ']' || (braces && c == '}')) && depth > 0:
+			depth--
+		}
+		text.WriteByte(c)
+		s.pos++
+	}
+	return strings.TrimSpace(text.String())
+}
+
+// raw reads the text of a block up to its closing brace
+func (s *scanner) raw() (string, error) {
+	line := s.line
+	body := s.until("}", true)
+	if s.pos >= len(s.src) {
+		return "", fmt.Errorf("schema: line %d: missing '}'", line)
+	}
+	s.pos++
+	return strings.Join(strings.Fields(body), " "), nil
+}
+
+// quoted reads a quoted string including its quotes
+func (s *scanner) quoted() string {
+	quote := s.src[s.pos]
+	start := s.pos
+	for s.pos++; s.pos < len(s.src) && s.src[s.pos] != quote; s.pos++ {
+		switch s.src[s.pos] {
+		case '\\':
+			s.pos++
+		case '\n':
+			s.line++
+		}
+	}
+	if s.pos < len(s.src) {
+		s.pos++
+	}
+	return s.src[start:s.pos]
+}
+
+// =============================================================================
+// Model
+// =============================================================================
+
+func buildSchema(n *node) (*Schema, error) {
+	name, inherits, err := n.nameAndInherits()
+	if err != nil {
+		return nil, err
+	}
+	s := &Schema{Name: name, Inherits: inherits, Document: Document{Name: name}}
+
+	for _, child := range n.children {
+		switch child.keyword() {
+		case "document":
+			if err := buildDocument(&s.Document, child); err != nil {
+				return nil, err
+			}
+		case "field":
+			f, err := buildField(child)
+			if err != nil {
+				return nil, err
+			}
+			s.Fields = append(s.Fields, f)
+		case "fieldset":
+			fs, err := buildFieldset(child)
+			if err != nil {
+				return nil, err
+			}
+			s.Fieldsets = append(s.Fieldsets, fs)
+		case "rank-profile":
+			rp, err := buildRankProfile(child)
+			if err != nil {
+				return nil, err
+			}
+			s.RankProfiles = append(s.RankProfiles, rp)
+		}
+	}
+	return s, nil
+}
+
+func buildDocument(d *Document, n *node) error {
+	if fields := strings.Fields(n.header); len(fields) > 1 {
+		name, inherits, err := n.nameAndInherits()
+		if err != nil {
+			return err
+		}
+		d.Name, d.Inherits = name, inherits
+	}
+
+	for _, child := range n.children {
+		switch child.keyword() {
+		case "field":
+			f, err := buildField(child)
+			if err != nil {
+				return err
+			}
+			d.Fields = append(d.Fields, f)
+		case "struct":
+			name, inherits, err := child.nameAndInherits()
+			if err != nil {
+				return err
+			}
+			st := &StructDefinition{Name: name, Inherits: inherits}
+			for _, member := range child.children {
+				if member.keyword() != "field" {
+					continue
+				}
+				f, err := buildField(member)
+				if err != nil {
+					return err
+				}
+				st.Fields = append(st.Fields, f)
+			}
+			d.Structs = append(d.Structs, st)
+		}
+	}
+	return nil
+}
+
+// buildField builds a field from "field <name> type <type> { ... }" or a
+// struct field from "struct-field <name> { ... }"
+func buildField(n *node) (*Field, error) {
+	fields := strings.Fields(n.header)
+	f := &Field{Line: n.line}
+
+	switch {
+	case fields[0] == "struct-field" && len(fields) == 2:
+		f.Name = fields[1]
+	case fields[0] == "field" && len(fields) > 3 && fields[2] == "type":
+		f.Name = fields[1]
+		t, err := ParseType(strings.Join(fields[3:], " "))
+		if err != nil {
+			return nil, n.errorf("field '%s': %v", f.Name, strings.TrimPrefix(err.Error(), "schema: "))
+		}
+		f.Type = t
+	default:
+		return nil, n.errorf("expected 'field <name> type <type>', got '%s'", n.header)
+	}
+
+	for _, child := range n.children {
+		switch child.keyword() {
+		case "indexing":
+			for _, statement := range strings.Split(child.text(), ";") {
+				for _, step := range strings.Split(statement, "|") {
+					if step = strings.TrimSpace(step); step != "" {
+						f.Indexing = append(f.Indexing, step)
+					}
+				}
+			}
+		case "attribute":
+			f.Attribute = settings(f.Attribute, child)
+		case "index":
+			f.Index = settings(f.Index, child)
+		case "match":
+			f.Match = settings(f.Match, child)
+		case "rank":
+			f.Rank = settings(f.Rank, child)
+		case "struct-field":
+			member, err := buildField(child)
+			if err != nil {
+				return nil, err
+			}
+			f.StructFields = append(f.StructFields, member)
+		}
+	}
+	return f, nil
+}
+
+// settings collects "attribute: fast-search" or the statements of an
+// "attribute { ... }" block into a map
+func settings(values map[string]string, n *node) map[string]string {
+	if values == nil {
+		values = make(map[string]string)
+	}
+	if n.hasValue {
+		values[n.value] = ""
+	}
+	for _, child := range n.children {
+		collectSetting(values, "", child)
+	}
+	return values
+}
+
+func collectSetting(values map[string]string, prefix string, n *node) {
+	name := prefix + n.header
+	switch {
+	case n.block && len(n.children) > 0:
+		for _, child := range n.children {
+			collectSetting(values, name+".", child)
+		}
+	default:
+		values[name] = n.value
+	}
+}
+
+func buildFieldset(n *node) (*Fieldset, error) {
+	name, _, err := n.nameAndInherits()
+	if err != nil {
+		return nil, err
+	}
+	fs := &Fieldset{Name: name}
+	for _, child := range n.children {
+		if child.keyword() != "fields" {
+			continue
+		}
+		for _, field := range strings.Split(child.value, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				fs.Fields = append(fs.Fields, field)
+			}
+		}
+	}
+	return fs, nil
+}
+
+func buildRankProfile(n *node) (*RankProfile, error) {
+	name, inherits, err := n.nameAndInherits()
+	if err != nil {
+		return nil, err
+	}
+	rp := &RankProfile{Name: name, Inherits: inherits}
+
+	for _, child := range n.children {
+		switch child.keyword() {
+		case "inputs":
+			for _, declaration := range child.children {
+				input, err := buildInput(declaration)
+				if err != nil {
+					return nil, err
+				}
+				rp.Inputs = append(rp.Inputs, input)
+			}
+		case "function", "macro":
+			f, err := buildFunction(child)
+			if err != nil {
+				return nil, err
+			}
+			rp.Functions = append(rp.Functions, f)
+		case "first-phase":
+			if rp.FirstPhase, err = buildPhase(child); err != nil {
+				return nil, err
+			}
+		case "second-phase":
+			if rp.SecondPhase, err = buildPhase(child); err != nil {
+				return nil, err
+			}
+		case "global-phase":
+			if rp.GlobalPhase, err = buildPhase(child); err != nil {
+				return nil, err
+			}
+		case "match-features":
+			rp.MatchFeatures = append(rp.MatchFeatures, features(child.text())...)
+		case "summary-features":
+			rp.SummaryFeatures = append(rp.SummaryFeatures, features(child.text())...)
+		}
+	}
+	return rp, nil
+}
+
+// buildInput builds an input from "query(q) tensor<float>(x[384])" or
+// "query(alpha) double: 0.5"; the query(...) wrapper is optional
+func buildInput(n *node) (*Input, error) {
+	header := n.header
+	var name string
+	if strings.HasPrefix(header, "query(") {
+		end := strings.Index(header, ")")
+		if end < 0 {
+			return nil, n.errorf("invalid input '%s'", header)
+		}
+		name, header = header[len("query("):end], header[end+1:]
+	} else {
+		fields := strings.Fields(header)
+		name, header = fields[0], strings.TrimPrefix(header, fields[0])
+	}
+
+	input := &Input{Name: strings.TrimSpace(name), Type: Type{Kind: Double}, Default: n.value}
+	if spec := strings.TrimSpace(header); spec != "" {
+		t, err := ParseType(spec)
+		if err != nil {
+			return nil, n.errorf("input '%s': %v", input.Name, strings.TrimPrefix(err.Error(), "schema: "))
+		}
+		input.Type = t
+	}
+	return input, nil
+}
+
+// buildFunction builds a function from "function [inline] name(a, b) { ... }"
+func buildFunction(n *node) (*Function, error) {
+	signature := strings.TrimSpace(strings.TrimPrefix(n.header, n.keyword()))
+	signature = strings.TrimSpace(strings.TrimPrefix(signature, "inline "))
+	open := strings.Index(signature, "(")
+	if open <= 0 || !strings.HasSuffix(signature, ")") {
+		return nil, n.errorf("expected 'function <name>(<parameters>)', got '%s'", n.header)
+	}
+
+	f := &Function{Name: strings.TrimSpace(signature[:open])}
+	for _, parameter := range strings.Split(signature[open+1:len(signature)-1], ",") {
+		if parameter = strings.TrimSpace(parameter); parameter != "" {
+			f.Parameters = append(f.Parameters, parameter)
+		}
+	}
+	for _, child := range n.children {
+		if child.keyword() == "expression" {
+			f.Expression = child.text()
+		}
+	}
+	return f, nil
+}
+
+func buildPhase(n *node) (*Phase, error) {
+	phase := &Phase{}
+	for _, child := range n.children {
+		switch child.keyword() {
+		case "expression":
+			phase.Expression = child.text()
+		case "rerank-count":
+			count, err := strconv.Atoi(child.value)
+			if err != nil || count < 0 {
+				return nil, child.errorf("invalid rerank-count '%s'", child.value)
+			}
+			phase.RerankCount = count
+		}
+	}
+	return phase, nil
+}
+
+// features splits a feature list at whitespace and commas outside parentheses
+func features(list string) []string {
+	var result []string
+	depth, start := 0, -1
+	for i, c := range list + " " {
+		switch {
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case depth == 0 && (c == ' ' || c == ','):
+			if start >= 0 {
+				result = append(result, list[start:i])
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	return result
+}
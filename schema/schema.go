@@ -0,0 +1,479 @@
+// Package schema parses Vespa schema (.sd) files into a Go model of document
+// fields, types, structs, fieldsets and rank profiles, for validating queries
+// and generating code against an application package.
+//
+// The parser covers the parts of the schema language that describe what can
+// be queried and ranked:
+//
+//	schema product {
+//	    document product {
+//	        field title type string {
+//	            indexing: summary | index
+//	            index: enable-bm25
+//	        }
+//	        field price type int {
+//	            indexing: summary | attribute
+//	            attribute: fast-search
+//	        }
+//	    }
+//	    fieldset default {
+//	        fields: title
+//	    }
+//	    rank-profile bm25 {
+//	        first-phase {
+//	            expression: bm25(title)
+//	        }
+//	    }
+//	}
+//
+// Other elements, such as document summaries, annotations, constants and
+// ONNX models, are skipped.
+package schema
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vipulsodha/vespa-go/tensor"
+)
+
+// =============================================================================
+// Schema
+// =============================================================================
+
+// Schema is the Go representation of a schema file
+type Schema struct {
+	Name     string
+	Inherits []string
+	Document Document
+
+	// Fields are declared outside the document block, e.g. embeddings
+	// computed at feed time with "indexing: input title | embed | attribute"
+	Fields       []*Field
+	Fieldsets    []*Fieldset
+	RankProfiles []*RankProfile
+}
+
+// Document is the document block of a schema
+type Document struct {
+	Name     string
+	Inherits []string
+	Fields   []*Field
+	Structs  []*StructDefinition
+}
+
+// StructDefinition is a struct definition inside a document
+type StructDefinition struct {
+	Name     string
+	Inherits []string
+	Fields   []*Field
+}
+
+// Fieldset groups fields that are searched together, e.g. by userQuery()
+type Fieldset struct {
+	Name   string
+	Fields []string
+}
+
+// AllFields returns the document fields followed by the fields declared
+// outside the document
+func (s *Schema) AllFields() []*Field {
+	fields := make([]*Field, 0, len(s.Document.Fields)+len(s.Fields))
+	fields = append(fields, s.Document.Fields...)
+	return append(fields, s.Fields...)
+}
+
+// Field resolves a field by name or by dotted path into structs and maps,
+// e.g. "sizes.label" for an array of structs or "attributes.key" for a map.
+// Nested fields carry the settings of their struct-field declaration, if any.
+func (s *Schema) Field(path string) (*Field, bool) {
+	parts := strings.Split(path, ".")
+
+	var field *Field
+	for _, f := range s.AllFields() {
+		if f.Name == parts[0] {
+			field = f
+			break
+		}
+	}
+	if field == nil {
+		return nil, false
+	}
+
+	for i, part := range parts[1:] {
+		memberType, ok := s.memberType(field.Type, part)
+		if !ok {
+			return nil, false
+		}
+		member := &Field{Name: strings.Join(parts[:i+2], "."), Type: memberType}
+		for _, declared := range field.StructFields {
+			if declared.Name == part {
+				member.Indexing = declared.Indexing
+				member.Attribute = declared.Attribute
+				member.Index = declared.Index
+				member.Match = declared.Match
+				member.Rank = declared.Rank
+				member.StructFields = declared.StructFields
+				member.Line = declared.Line
+			}
+		}
+		field = member
+	}
+	return field, true
+}
+
+// memberType returns the type of a named member of a struct or map,
+// looking through arrays
+func (s *Schema) memberType(t Type, name string) (Type, bool) {
+	switch t.Kind {
+	case Array:
+		return s.memberType(*t.Element, name)
+	case Map:
+		switch name {
+		case "key":
+			return *t.Key, true
+		case "value":
+			return *t.Value, true
+		}
+	case Struct:
+		if st, ok := s.Struct(t.Name); ok {
+			for _, f := range s.structFields(st, nil) {
+				if f.Name == name {
+					return f.Type, true
+				}
+			}
+		}
+	}
+	return Type{}, false
+}
+
+// structFields returns the fields of a struct including inherited fields
+func (s *Schema) structFields(st *StructDefinition, visiting []string) []*Field {
+	for _, name := range visiting {
+		if name == st.Name {
+			return nil
+		}
+	}
+	visiting = append(visiting, st.Name)
+
+	var fields []*Field
+	for _, parent := range st.Inherits {
+		if inherited, ok := s.Struct(parent); ok {
+			fields = append(fields, s.structFields(inherited, visiting)...)
+		}
+	}
+	return append(fields, st.Fields...)
+}
+
+// Struct returns the struct definition with the given name
+func (s *Schema) Struct(name string) (*StructDefinition, bool) {
+	for _, st := range s.Document.Structs {
+		if st.Name == name {
+			return st, true
+		}
+	}
+	return nil, false
+}
+
+// Fieldset returns the fieldset with the given name
+func (s *Schema) Fieldset(name string) (*Fieldset, bool) {
+	for _, fs := range s.Fieldsets {
+		if fs.Name == name {
+			return fs, true
+		}
+	}
+	return nil, false
+}
+
+// RankProfile returns the rank profile with the given name
+func (s *Schema) RankProfile(name string) (*RankProfile, bool) {
+	for _, rp := range s.RankProfiles {
+		if rp.Name == name {
+			return rp, true
+		}
+	}
+	return nil, false
+}
+
+// =============================================================================
+// Fields
+// =============================================================================
+
+// Field is a document field, a field outside the document or a struct field.
+// Settings without a value, such as "fast-search", map to an empty string;
+// nested settings use dotted names, e.g. "hnsw.max-links-per-node".
+type Field struct {
+	Name string
+	Type Type
+
+	// Indexing holds the steps of the indexing statement, e.g.
+	// ["summary", "attribute"] for "indexing: summary | attribute"
+	Indexing  []string
+	Attribute map[string]string
+	Index     map[string]string
+	Match     map[string]string
+	Rank      map[string]string
+
+	// StructFields are the struct-field declarations of a struct, array of
+	// struct or map field
+	StructFields []*Field
+
+	Line int // line of the declaration in the schema file
+}
+
+// IsAttribute reports whether the field is stored as an attribute, which
+// allows range queries, sorting and grouping
+func (f *Field) IsAttribute() bool {
+	return f.hasIndexing("attribute")
+}
+
+// IsIndex reports whether the field has a text index or, for tensors, an
+// HNSW index
+func (f *Field) IsIndex() bool {
+	return f.hasIndexing("index")
+}
+
+// IsSummary reports whether the field is returned in document summaries
+func (f *Field) IsSummary() bool {
+	return f.hasIndexing("summary")
+}
+
+func (f *Field) hasIndexing(step string) bool {
+	for _, s := range f.Indexing {
+		if s == step {
+			return true
+		}
+	}
+	return false
+}
+
+// =============================================================================
+// Types
+// =============================================================================
+
+// Kind is the kind of a field type
+type Kind string
+
+const (
+	String      Kind = "string"
+	Int         Kind = "int"
+	Long        Kind = "long"
+	Float       Kind = "float"
+	Double      Kind = "double"
+	Bool        Kind = "bool"
+	Byte        Kind = "byte"
+	Position    Kind = "position"
+	Predicate   Kind = "predicate"
+	Raw         Kind = "raw"
+	URI         Kind = "uri"
+	Tensor      Kind = "tensor"
+	Array       Kind = "array"
+	WeightedSet Kind = "weightedset"
+	Map         Kind = "map"
+	Struct      Kind = "struct"
+	Reference   Kind = "reference"
+)
+
+// primitiveKinds are the kinds written as a plain keyword
+var primitiveKinds = map[string]Kind{
+	"string":    String,
+	"int":       Int,
+	"long":      Long,
+	"float":     Float,
+	"double":    Double,
+	"bool":      Bool,
+	"byte":      Byte,
+	"position":  Position,
+	"predicate": Predicate,
+	"raw":       Raw,
+	"uri":       URI,
+}
+
+// Type is a field type such as int, array<string>, map<string,int> or
+// tensor<float>(x[384])
+type Type struct {
+	Kind Kind
+
+	// Name is the struct name of a Struct type or the document type of a
+	// Reference type
+	Name string
+
+	// Element is the element type of an Array or WeightedSet
+	Element *Type
+
+	// Key and Value are the types of a Map
+	Key   *Type
+	Value *Type
+
+	// Tensor is the type of a Tensor
+	Tensor tensor.Type
+}
+
+// ParseType parses a field type specification, e.g. "array<string>" or
+// "tensor<float>(x[384])". Names that are not type keywords are struct types.
+func ParseType(spec string) (Type, error) {
+	s := strings.TrimSpace(spec)
+
+	if kind, ok := primitiveKinds[s]; ok {
+		return Type{Kind: kind}, nil
+	}
+	if strings.HasPrefix(s, "tensor") {
+		t, err := tensor.ParseType(s)
+		if err != nil {
+			return Type{}, err
+		}
+		return Type{Kind: Tensor, Tensor: t}, nil
+	}
+
+	if open := strings.Index(s, "<"); open > 0 && strings.HasSuffix(s, ">") {
+		name, args := s[:open], splitTypeArguments(s[open+1:len(s)-1])
+		switch {
+		case (name == "array" || name == "weightedset") && len(args) == 1:
+			element, err := ParseType(args[0])
+			if err != nil {
+				return Type{}, err
+			}
+			return Type{Kind: Kind(name), Element: &element}, nil
+		case name == "map" && len(args) == 2:
+			key, err := ParseType(args[0])
+			if err != nil {
+				return Type{}, err
+			}
+			value, err := ParseType(args[1])
+			if err != nil {
+				return Type{}, err
+			}
+			return Type{Kind: Map, Key: &key, Value: &value}, nil
+		case name == "reference" && len(args) == 1 && tensor.IsIdentifier(args[0]):
+			return Type{Kind: Reference, Name: args[0]}, nil
+		}
+	} else if tensor.IsIdentifier(s) {
+		return Type{Kind: Struct, Name: s}, nil
+	}
+	return Type{}, fmt.Errorf("schema: invalid type '%s'", spec)
+}
+
+// splitTypeArguments splits the arguments of a generic type at top-level commas
+func splitTypeArguments(s string) []string {
+	var args []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '<', '(':
+			depth++
+		case '>', ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				args = append(args, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(args, strings.TrimSpace(s[start:]))
+}
+
+// String returns the type in schema syntax
+func (t Type) String() string {
+	switch t.Kind {
+	case Tensor:
+		return t.Tensor.String()
+	case Array, WeightedSet:
+		return fmt.Sprintf("%s<%s>", t.Kind, t.Element)
+	case Map:
+		return fmt.Sprintf("map<%s,%s>", t.Key, t.Value)
+	case Reference:
+		return fmt.Sprintf("reference<%s>", t.Name)
+	case Struct:
+		return t.Name
+	default:
+		return string(t.Kind)
+	}
+}
+
+// IsNumeric reports whether the type is a number
+func (t Type) IsNumeric() bool {
+	switch t.Kind {
+	case Int, Long, Float, Double, Byte:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsCollection reports whether the type is an array, weighted set or map
+func (t Type) IsCollection() bool {
+	return t.Kind == Array || t.Kind == WeightedSet || t.Kind == Map
+}
+
+// ElementType returns the type of the values of a collection, or the type
+// itself for other kinds: the element of an array or weighted set and the
+// value of a map
+func (t Type) ElementType() Type {
+	switch t.Kind {
+	case Array, WeightedSet:
+		return *t.Element
+	case Map:
+		return *t.Value
+	default:
+		return t
+	}
+}
+
+// =============================================================================
+// Rank Profiles
+// =============================================================================
+
+// RankProfile is a rank-profile block
+type RankProfile struct {
+	Name            string
+	Inherits        []string
+	Inputs          []*Input
+	Functions       []*Function
+	FirstPhase      *Phase
+	SecondPhase     *Phase
+	GlobalPhase     *Phase
+	MatchFeatures   []string
+	SummaryFeatures []string
+}
+
+// Input is a query input declared by a rank profile, set with
+// input.query(name) in queries
+type Input struct {
+	Name    string
+	Type    Type   // double unless declared
+	Default string // default value, empty if none
+}
+
+// Function is a function (or macro) of a rank profile
+type Function struct {
+	Name       string
+	Parameters []string
+	Expression string
+}
+
+// Phase is a ranking phase
+type Phase struct {
+	Expression  string
+	RerankCount int // second and global phase only; 0 if not set
+}
+
+// Input returns the input with the given name
+func (rp *RankProfile) Input(name string) (*Input, bool) {
+	for _, input := range rp.Inputs {
+		if input.Name == name {
+			return input, true
+		}
+	}
+	return nil, false
+}
+
+// Function returns the function with the given name
+func (rp *RankProfile) Function(name string) (*Function, bool) {
+	for _, f := range rp.Functions {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return nil, false
+}
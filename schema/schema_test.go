@@ -0,0 +1,413 @@
+package schema
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const productSchema = `
+# Product catalog
+schema product {
+
+    document product {
+
+        struct size {
+            field label type string {}
+            field stock type int {}
+        }
+
+        field title type string {
+            indexing: summary | index
+            index: enable-bm25
+        }
+
+        field brand type string {
+            indexing: summary | attribute
+            attribute: fast-search
+            match: exact
+            rank: filter
+        }
+
+        field price type int {
+            indexing: summary | attribute
+        }
+
+        field tags type array<string> {
+            indexing: attribute
+        }
+
+        field categories type weightedset<string> {
+            indexing: attribute
+        }
+
+        field attributes type map<string, string> {
+            indexing: summary
+            struct-field key { indexing: attribute }
+            struct-field value {
+                indexing: attribute
+                attribute: fast-search
+            }
+        }
+
+        field sizes type array<size> {
+            indexing: summary
+            struct-field label { indexing: attribute }
+        }
+
+        field embedding type tensor<float>(x[4]) {
+            indexing: attribute | index
+            attribute {
+                distance-metric: angular
+            }
+            index {
+                hnsw {
+                    max-links-per-node: 16
+                }
+            }
+        }
+    }
+
+    field title_embedding type tensor<float>(x[4]) {
+        indexing {
+            input title | embed | attribute | index;
+        }
+    }
+
+    fieldset default {
+        fields: title, brand
+    }
+
+    rank-profile hybrid inherits default {
+        inputs {
+            query(q) tensor<float>(x[4])
+            query(alpha) double: 0.5
+            user_profile tensor<float>(cat{})
+        }
+
+        function text_score() {
+            expression: bm25(title)
+        }
+
+        function inline blend(a, b) {
+            expression {
+                query(alpha) * a +
+                (1 - query(alpha)) * b   # weighted sum
+            }
+        }
+
+        first-phase {
+            expression: blend(text_score, closeness(field, embedding))
+        }
+
+        second-phase {
+            expression: firstPhase
+            rerank-count: 100
+        }
+
+        match-features: bm25(title) closeness(field, embedding)
+        summary-features {
+            text_score
+            attribute(price)
+        }
+    }
+}
+`
+
+func parseProductSchema(t *testing.T) *Schema {
+	s, err := Parse([]byte(productSchema))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return s
+}
+
+func TestParseSchema(t *testing.T) {
+	s := parseProductSchema(t)
+
+	if s.Name != "product" || s.Document.Name != "product" {
+		t.Errorf("Expected schema and document 'product', got %q and %q", s.Name, s.Document.Name)
+	}
+
+	var names []string
+	for _, f := range s.AllFields() {
+		names = append(names, f.Name)
+	}
+	expected := "title,brand,price,tags,categories,attributes,sizes,embedding,title_embedding"
+	if got := strings.Join(names, ","); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+
+	if len(s.Document.Structs) != 1 || s.Document.Structs[0].Name != "size" || len(s.Document.Structs[0].Fields) != 2 {
+		t.Errorf("Expected struct 'size' with 2 fields, got %+v", s.Document.Structs)
+	}
+
+	fieldset, ok := s.Fieldset("default")
+	if !ok || !reflect.DeepEqual(fieldset.Fields, []string{"title", "brand"}) {
+		t.Errorf("Expected fieldset default with title and brand, got %+v", fieldset)
+	}
+}
+
+func TestParseFieldSettings(t *testing.T) {
+	s := parseProductSchema(t)
+
+	tests := []struct {
+		path      string
+		indexing  []string
+		attribute map[string]string
+		index     map[string]string
+		line      int
+	}{
+		{path: "title", indexing: []string{"summary", "index"}, index: map[string]string{"enable-bm25": ""}, line: 12},
+		{path: "brand", indexing: []string{"summary", "attribute"}, attribute: map[string]string{"fast-search": ""}, line: 17},
+		{
+			path:      "embedding",
+			indexing:  []string{"attribute", "index"},
+			attribute: map[string]string{"distance-metric": "angular"},
+			index:     map[string]string{"hnsw.max-links-per-node": "16"},
+			line:      50,
+		},
+		{path: "title_embedding", indexing: []string{"input title", "embed", "attribute", "index"}, line: 63},
+		{path: "attributes.value", indexing: []string{"attribute"}, attribute: map[string]string{"fast-search": ""}, line: 39},
+		{path: "sizes.label", indexing: []string{"attribute"}, line: 47},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			f, ok := s.Field(tt.path)
+			if !ok {
+				t.Fatalf("Expected field %q", tt.path)
+			}
+			if !reflect.DeepEqual(f.Indexing, tt.indexing) {
+				t.Errorf("Expected indexing %q, got %q", tt.indexing, f.Indexing)
+			}
+			if !reflect.DeepEqual(f.Attribute, tt.attribute) {
+				t.Errorf("Expected attribute settings %v, got %v", tt.attribute, f.Attribute)
+			}
+			if !reflect.DeepEqual(f.Index, tt.index) {
+				t.Errorf("Expected index settings %v, got %v", tt.index, f.Index)
+			}
+			if f.Line != tt.line {
+				t.Errorf("Expected line %d, got %d", tt.line, f.Line)
+			}
+		})
+	}
+
+	brand, _ := s.Field("brand")
+	if !brand.IsAttribute() || brand.IsIndex() || !brand.IsSummary() {
+		t.Errorf("Expected brand to be an attribute and summary field")
+	}
+	if brand.Match["exact"] != "" || len(brand.Match) != 1 || len(brand.Rank) != 1 {
+		t.Errorf("Expected match exact and rank filter, got %v and %v", brand.Match, brand.Rank)
+	}
+}
+
+func TestFieldTypes(t *testing.T) {
+	s := parseProductSchema(t)
+
+	tests := []struct {
+		path     string
+		expected string
+		kind     Kind
+	}{
+		{"price", "int", Int},
+		{"tags", "array<string>", Array},
+		{"categories", "weightedset<string>", WeightedSet},
+		{"attributes", "map<string,string>", Map},
+		{"attributes.key", "string", String},
+		{"sizes", "array<size>", Array},
+		{"sizes.stock", "int", Int},
+		{"embedding", "tensor<float>(x[4])", Tensor},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			f, ok := s.Field(tt.path)
+			if !ok {
+				t.Fatalf("Expected field %q", tt.path)
+			}
+			if got := f.Type.String(); got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+			if f.Type.Kind != tt.kind {
+				t.Errorf("Expected kind %q, got %q", tt.kind, f.Type.Kind)
+			}
+		})
+	}
+
+	for _, path := range []string{"missing", "price.value", "attributes.label", "sizes.missing"} {
+		if _, ok := s.Field(path); ok {
+			t.Errorf("Expected no field for %q", path)
+		}
+	}
+}
+
+func TestParseType(t *testing.T) {
+	tests := []struct {
+		spec     string
+		expected string
+	}{
+		{"string", "string"},
+		{"array<array<int>>", "array<array<int>>"},
+		{"map<string, array<size>>", "map<string,array<size>>"},
+		{"reference<brand>", "reference<brand>"},
+		{"tensor(cat{}, x[2])", "tensor(cat{},x[2])"},
+		{"my_struct", "my_struct"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			typ, err := ParseType(tt.spec)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got := typ.String(); got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+
+	for _, spec := range []string{"", "array<int", "map<string>", "tensor<float>", "array<int, int>"} {
+		if _, err := ParseType(spec); err == nil {
+			t.Errorf("Expected error for %q", spec)
+		}
+	}
+}
+
+func TestParseRankProfile(t *testing.T) {
+	s := parseProductSchema(t)
+
+	rp, ok := s.RankProfile("hybrid")
+	if !ok {
+		t.Fatal("Expected rank profile 'hybrid'")
+	}
+	if !reflect.DeepEqual(rp.Inherits, []string{"default"}) {
+		t.Errorf("Expected inherits [default], got %q", rp.Inherits)
+	}
+
+	inputs := []struct {
+		name, typ, defaultValue string
+	}{
+		{"q", "tensor<float>(x[4])", ""},
+		{"alpha", "double", "0.5"},
+		{"user_profile", "tensor<float>(cat{})", ""},
+	}
+	if len(rp.Inputs) != len(inputs) {
+		t.Fatalf("Expected %d inputs, got %d", len(inputs), len(rp.Inputs))
+	}
+	for i, expected := range inputs {
+		input := rp.Inputs[i]
+		if input.Name != expected.name || input.Type.String() != expected.typ || input.Default != expected.defaultValue {
+			t.Errorf("Expected input %v, got %s %s: %q", expected, input.Name, input.Type, input.Default)
+		}
+	}
+
+	blend, ok := rp.Function("blend")
+	if !ok {
+		t.Fatal("Expected function 'blend'")
+	}
+	if !reflect.DeepEqual(blend.Parameters, []string{"a", "b"}) {
+		t.Errorf("Expected parameters [a b], got %q", blend.Parameters)
+	}
+	if expected := "query(alpha) * a + (1 - query(alpha)) * b"; blend.Expression != expected {
+		t.Errorf("Expected %q, got %q", expected, blend.Expression)
+	}
+
+	if expected := "blend(text_score, closeness(field, embedding))"; rp.FirstPhase == nil || rp.FirstPhase.Expression != expected {
+		t.Errorf("Expected first phase %q, got %+v", expected, rp.FirstPhase)
+	}
+	if rp.SecondPhase == nil || rp.SecondPhase.Expression != "firstPhase" || rp.SecondPhase.RerankCount != 100 {
+		t.Errorf("Expected second phase firstPhase with rerank-count 100, got %+v", rp.SecondPhase)
+	}
+	if expected := []string{"bm25(title)", "closeness(field, embedding)"}; !reflect.DeepEqual(rp.MatchFeatures, expected) {
+		t.Errorf("Expected match features %q, got %q", expected, rp.MatchFeatures)
+	}
+	if expected := []string{"text_score", "attribute(price)"}; !reflect.DeepEqual(rp.SummaryFeatures, expected) {
+		t.Errorf("Expected summary features %q, got %q", expected, rp.SummaryFeatures)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "no schema block",
+			input:    "document product {}",
+			expected: "expected a single 'schema <name> { ... }' block",
+		},
+		{
+			name:     "missing brace",
+			input:    "schema product {\n  document product {\n",
+			expected: "line 3: missing '}'",
+		},
+		{
+			name:     "unexpected brace",
+			input:    "schema product {}\n}",
+			expected: "line 2: unexpected '}'",
+		},
+		{
+			name:     "invalid field",
+			input:    "schema product {\n  document product {\n    field title string {}\n  }\n}",
+			expected: "line 3: expected 'field <name> type <type>'",
+		},
+		{
+			name:     "invalid type",
+			input:    "schema product {\n  document product {\n    field tags type array<string {}\n  }\n}",
+			expected: "line 3: field 'tags': invalid type 'array<string'",
+		},
+		{
+			name:     "invalid rerank count",
+			input:    "schema product {\n  rank-profile p {\n    second-phase {\n      rerank-count: many\n    }\n  }\n}",
+			expected: "line 4: invalid rerank-count 'many'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.input))
+			if err == nil {
+				t.Fatal("Expected error")
+			}
+			if !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected error containing %q, got %q", tt.expected, err.Error())
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("product.sd", productSchema)
+	write("article.sd", "schema article {\n  document article {\n    field body type string { indexing: index }\n  }\n}")
+	write("product/freshness.profile", "rank-profile freshness inherits hybrid {\n  first-phase { expression: freshness(timestamp) }\n}")
+
+	schemas, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(schemas) != 2 || schemas[0].Name != "article" || schemas[1].Name != "product" {
+		t.Fatalf("Expected schemas article and product, got %d", len(schemas))
+	}
+	rp, ok := schemas[1].RankProfile("freshness")
+	if !ok || rp.FirstPhase.Expression != "freshness(timestamp)" {
+		t.Errorf("Expected rank profile from product/freshness.profile, got %+v", rp)
+	}
+
+	write("broken.sd", "schema broken {")
+	if _, err := Load(dir); err == nil || !strings.Contains(err.Error(), "broken.sd") {
+		t.Errorf("Expected error naming broken.sd, got %v", err)
+	}
+}
@@ -0,0 +1,387 @@
+package vespa
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vipulsodha/vespa-go/schema"
+)
+
+// =============================================================================
+// Schema Validation
+// =============================================================================
+
+// builtinSummaryFields can be selected without being declared in a schema
+var builtinSummaryFields = map[string]bool{
+	"*":               true,
+	"documentid":      true,
+	"sddocname":       true,
+	"relevance":       true,
+	"matchfeatures":   true,
+	"summaryfeatures": true,
+	"rankfeatures":    true,
+}
+
+// ValidateSchema checks a condition tree against schemas parsed with the
+// schema package:
+//
+//   - fields exist in at least one schema (contains also accepts fieldsets)
+//   - contains targets indexed or attribute fields, prefix matching targets
+//     attributes and near targets indexed fields
+//   - numeric comparisons and ranges target numeric attributes, and in and
+//     matches target attributes
+//   - nearestNeighbor targets a tensor attribute with an HNSW index, unless
+//     approximate is false
+//   - sameElement targets an array of struct, a map or a struct, and its
+//     conditions refer to the fields of the elements
+//
+// All problems are reported together as ValidationErrors. Builders check the
+// complete query, including selected fields, order by and inputs, with
+// WithSchema. With Optimize, builders check the simplified conditions that
+// are rendered. Schemas see the declarations of the schemas and documents
+// they inherit, which must be passed as well.
+func ValidateSchema(condition WhereCondition, schemas ...*schema.Schema) error {
+	v := &schemaValidator{schemas: inheritSchemas(schemas)}
+	v.condition(condition, "", "")
+	return v.errs.err()
+}
+
+// validateSchema checks the builder state against its schemas
+func (qb *QueryBuilderImpl) validateSchema() ValidationErrors {
+	v := &schemaValidator{}
+	schemas := inheritSchemas(qb.schemas)
+	for _, source := range qb.sources {
+		if source == "*" {
+			v.schemas = schemas
+			break
+		}
+		s := findSchema(schemas, source)
+		if s == nil {
+			v.fail("sources", "", "no schema for source '%s'", source)
+			continue
+		}
+		v.schemas = append(v.schemas, s)
+	}
+	if len(v.schemas) == 0 {
+		return v.errs
+	}
+
+	for _, field := range qb.selectFields {
+		if !builtinSummaryFields[field] {
+			v.fields(field, "", false)
+		}
+	}
+	for _, spec := range qb.orderBy {
+		for _, f := range v.fields(spec.Field, "", false) {
+			if !f.IsAttribute() {
+				v.fail(spec.Field, "", "order by requires an attribute, '%s' is not an attribute field", spec.Field)
+			}
+		}
+	}
+	if qb.defaultIndex != "" {
+		v.fields(qb.defaultIndex, "", true)
+	}
+
+	// Check the conditions as they are rendered, i.e. after Optimize
+	// simplified them, with paths relative to the rendered conditions
+	for i, condition := range qb.renderedWhereConditions() {
+		v.condition(condition, fmt.Sprintf("where[%d]", i), "")
+	}
+	if rank, ok := qb.renderedRankExpression().(*RankExpressionImpl); ok {
+		for i, condition := range rank.conditions {
+			v.condition(condition, fmt.Sprintf("rank[%d]", i), "")
+		}
+	}
+
+	if inputs, _, err := qb.resolveInputs(); err == nil {
+		v.inputs(qb.ranking.Profile, inputs)
+	}
+	return v.errs
+}
+
+// findSchema returns the schema for a source, matched by schema or document name
+func findSchema(schemas []*schema.Schema, source string) *schema.Schema {
+	for _, s := range schemas {
+		if s.Name == source || s.Document.Name == source {
+			return s
+		}
+	}
+	return nil
+}
+
+// inheritSchemas returns the schemas with the declarations of the schemas
+// and documents they inherit merged in, so that lookups see inherited
+// fields, structs, fieldsets and rank profiles. Parents are resolved among
+// the given schemas; own declarations come first and take precedence.
+func inheritSchemas(schemas []*schema.Schema) []*schema.Schema {
+	resolved := make([]*schema.Schema, len(schemas))
+	for i, s := range schemas {
+		resolved[i] = inheritSchema(schemas, s, nil)
+	}
+	return resolved
+}
+
+func inheritSchema(schemas []*schema.Schema, s *schema.Schema, visiting []string) *schema.Schema {
+	if len(s.Inherits) == 0 && len(s.Document.Inherits) == 0 {
+		return s
+	}
+	for _, name := range visiting {
+		if name == s.Name {
+			return s
+		}
+	}
+	visiting = append(visiting, s.Name)
+
+	merged := *s
+	merged.Document.Fields = append([]*schema.Field(nil), s.Document.Fields...)
+	merged.Document.Structs = append([]*schema.StructDefinition(nil), s.Document.Structs...)
+	merged.Fields = append([]*schema.Field(nil), s.Fields...)
+	merged.Fieldsets = append([]*schema.Fieldset(nil), s.Fieldsets...)
+	merged.RankProfiles = append([]*schema.RankProfile(nil), s.RankProfiles...)
+
+	documents := map[string]bool{s.Document.Name: true}
+	inheritDocument := func(parent *schema.Schema) {
+		if documents[parent.Document.Name] {
+			return
+		}
+		documents[parent.Document.Name] = true
+		merged.Document.Fields = append(merged.Document.Fields, parent.Document.Fields...)
+		merged.Document.Structs = append(merged.Document.Structs, parent.Document.Structs...)
+	}
+
+	// A schema inherits everything, including the document of its parent
+	for _, name := range s.Inherits {
+		for _, candidate := range schemas {
+			if candidate.Name != name {
+				continue
+			}
+			parent := inheritSchema(schemas, candidate, visiting)
+			inheritDocument(parent)
+			merged.Fields = append(merged.Fields, parent.Fields...)
+			merged.Fieldsets = append(merged.Fieldsets, parent.Fieldsets...)
+			merged.RankProfiles = append(merged.RankProfiles, parent.RankProfiles...)
+			break
+		}
+	}
+	for _, name := range s.Document.Inherits {
+		for _, candidate := range schemas {
+			if candidate.Document.Name == name {
+				inheritDocument(inheritSchema(schemas, candidate, visiting))
+				break
+			}
+		}
+	}
+	return &merged
+}
+
+type schemaValidator struct {
+	schemas []*schema.Schema
+	errs    ValidationErrors
+	seen    map[string]bool
+}
+
+// fail records an error once, since a field in several schemas or
+// conditions can cause the same problem repeatedly
+func (v *schemaValidator) fail(field, path, format string, args ...interface{}) {
+	err := &ValidationError{Field: field, Path: path, Message: fmt.Sprintf(format, args...)}
+	if v.seen == nil {
+		v.seen = make(map[string]bool)
+	}
+	if key := err.Error(); !v.seen[key] {
+		v.seen[key] = true
+		v.errs = append(v.errs, err)
+	}
+}
+
+// fields resolves a field in every schema declaring it, reporting an error
+// if no schema does. With fieldsets set, a fieldset of that name also counts
+// as declared; it resolves to no fields.
+func (v *schemaValidator) fields(name, path string, fieldsets bool) []*schema.Field {
+	var fields []*schema.Field
+	found := false
+	for _, s := range v.schemas {
+		if f, ok := s.Field(name); ok {
+			fields = append(fields, f)
+			found = true
+		} else if _, ok := s.Fieldset(name); ok && fieldsets {
+			found = true
+		}
+	}
+	if !found {
+		v.fail(name, path, "field '%s' does not exist in %s", name, v.schemaNames())
+	}
+	return fields
+}
+
+func (v *schemaValidator) schemaNames() string {
+	names := make([]string, len(v.schemas))
+	for i, s := range v.schemas {
+		names[i] = fmt.Sprintf("'%s'", s.Name)
+	}
+	if len(names) == 1 {
+		return "schema " + names[0]
+	}
+	return "schemas " + strings.Join(names, ", ")
+}
+
+// condition checks a condition and its children. Inside sameElement, prefix
+// holds the sameElement field, since inner conditions name element fields.
+func (v *schemaValidator) condition(condition WhereCondition, path, prefix string) {
+	if isNilCondition(condition) {
+		return
+	}
+
+	switch c := condition.(type) {
+	case *FieldCondition:
+		v.fieldCondition(c, path, prefix)
+	case *RangeCondition:
+		for _, f := range v.fields(prefix+c.Field, path, false) {
+			v.numericAttribute(f, "range", path)
+		}
+	case *NearestNeighbor:
+		approximate := c.Approximate == nil || *c.Approximate
+		for _, f := range v.fields(prefix+c.Field, path, false) {
+			switch {
+			case f.Type.Kind != schema.Tensor:
+				v.fail(f.Name, path, "nearestNeighbor requires a tensor field, '%s' has type %s", f.Name, f.Type)
+			case !f.IsAttribute():
+				v.fail(f.Name, path, "nearestNeighbor requires an attribute, '%s' is not an attribute field", f.Name)
+			case approximate && !f.IsIndex():
+				v.fail(f.Name, path, "nearestNeighbor on '%s' requires an HNSW index (indexing: index) or approximate:false", f.Name)
+			}
+		}
+	case *UserQueryFeature:
+		if c.DefaultIndex != "" {
+			v.fields(c.DefaultIndex, path, true)
+		}
+	case *SameElementCondition:
+		name := prefix + c.Field
+		for _, f := range v.fields(name, path, false) {
+			t := f.Type
+			if t.Kind == schema.Array {
+				t = *t.Element
+			}
+			if t.Kind != schema.Map && t.Kind != schema.Struct {
+				v.fail(name, path, "sameElement requires an array of struct, map or struct field, '%s' has type %s", name, f.Type)
+			}
+		}
+		for i, inner := range c.Conditions {
+			v.condition(inner, joinPath(path, fmt.Sprintf("conditions[%d]", i)), name+".")
+		}
+	case Node:
+		for i, child := range c.Children() {
+			v.condition(child, joinPath(path, childSegment(c, i)), prefix)
+		}
+	}
+}
+
+// childSegment returns the path segment of the i-th child of a node, matching
+// the paths used by Validate
+func childSegment(node Node, i int) string {
+	switch node.(type) {
+	case *BooleanCondition:
+		if i == 0 {
+			return "left"
+		}
+		return "right"
+	case *NotCondition:
+		return "condition"
+	case *JunctionCondition:
+		return fmt.Sprintf("conditions[%d]", i)
+	default:
+		return fmt.Sprintf("children[%d]", i)
+	}
+}
+
+func (v *schemaValidator) fieldCondition(c *FieldCondition, path, prefix string) {
+	name := prefix + c.Field
+
+	textMatch := c.Operator == CONTAINS || c.Operator == NOT_CONTAINS ||
+		((c.Operator == EQ || c.Operator == NEQ) && isStringValue(c.Value))
+	fields := v.fields(name, path, textMatch)
+
+	for _, f := range fields {
+		switch {
+		case c.Operator == CONTAINS && c.ContainsType == PrefixMatch:
+			if !f.IsAttribute() {
+				v.fail(name, path, "prefix requires an attribute, '%s' is not an attribute field", name)
+			}
+		case c.Operator == CONTAINS && c.ContainsType == NearMatch:
+			if !f.IsIndex() {
+				v.fail(name, path, "near requires an indexed field, '%s' is not indexed", name)
+			}
+		case textMatch:
+			if !f.IsIndex() && !f.IsAttribute() {
+				v.fail(name, path, "contains requires an indexed or attribute field, '%s' is neither", name)
+			}
+		case c.Operator == IN || c.Operator == NOT_IN || c.Operator == MATCHES:
+			if !f.IsAttribute() {
+				v.fail(name, path, "%s requires an attribute, '%s' is not an attribute field", c.Operator, name)
+			}
+		default:
+			if _, ok := c.Value.(bool); ok {
+				if f.Type.ElementType().Kind != schema.Bool {
+					v.fail(name, path, "%s with a bool value requires a bool field, '%s' has type %s", c.Operator, name, f.Type)
+				}
+				continue
+			}
+			v.numericAttribute(f, string(c.Operator), path)
+		}
+	}
+}
+
+func (v *schemaValidator) numericAttribute(f *schema.Field, operator, path string) {
+	if !f.Type.ElementType().IsNumeric() {
+		v.fail(f.Name, path, "%s requires a numeric field, '%s' has type %s", operator, f.Name, f.Type)
+	} else if !f.IsAttribute() {
+		v.fail(f.Name, path, "%s requires an attribute, '%s' is not an attribute field", operator, f.Name)
+	}
+}
+
+// inputs checks that every input.query(name) is declared by the rank profile
+// or a profile it inherits, in each schema
+func (v *schemaValidator) inputs(profile string, inputs map[string]interface{}) {
+	if profile == "" {
+		profile = "default"
+	}
+
+	for _, s := range v.schemas {
+		declared, ok := declaredInputs(s, profile, nil)
+		if !ok {
+			v.fail("ranking.profile", "", "rank profile '%s' does not exist in schema '%s'", profile, s.Name)
+			continue
+		}
+		for _, key := range sortedInputKeys(inputs) {
+			name := strings.TrimSuffix(strings.TrimPrefix(key, "input.query("), ")")
+			if !declared[name] {
+				v.fail(key, "", "input '%s' is not declared in rank profile '%s' of schema '%s'", name, profile, s.Name)
+			}
+		}
+	}
+}
+
+// declaredInputs returns the inputs of a rank profile including inherited
+// ones. The default profile exists implicitly.
+func declaredInputs(s *schema.Schema, profile string, visiting []string) (map[string]bool, bool) {
+	declared := make(map[string]bool)
+	rp, ok := s.RankProfile(profile)
+	if !ok {
+		return declared, profile == "default"
+	}
+	for _, name := range visiting {
+		if name == profile {
+			return declared, true
+		}
+	}
+
+	for _, parent := range rp.Inherits {
+		inherited, _ := declaredInputs(s, parent, append(visiting, profile))
+		for name := range inherited {
+			declared[name] = true
+		}
+	}
+	for _, input := range rp.Inputs {
+		declared[input.Name] = true
+	}
+	return declared, true
+}
@@ -0,0 +1,409 @@
+package vespa
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/vipulsodha/vespa-go/schema"
+)
+
+const testProductSchema = `
+schema product {
+    document product {
+        struct size {
+            field label type string {}
+            field stock type int {}
+        }
+        field title type string {
+            indexing: summary | index
+        }
+        field description type string {
+            indexing: summary
+        }
+        field brand type string {
+            indexing: summary | attribute
+        }
+        field price type int {
+            indexing: summary | attribute
+        }
+        field weight type float {
+            indexing: summary
+        }
+        field in_stock type bool {
+            indexing: attribute
+        }
+        field sizes type array<size> {
+            indexing: summary
+            struct-field label { indexing: attribute }
+            struct-field stock { indexing: attribute }
+        }
+        field attributes type map<string, string> {
+            indexing: summary
+            struct-field key { indexing: attribute }
+            struct-field value { indexing: attribute }
+        }
+        field tags type array<string> {
+            indexing: attribute
+        }
+        field embedding type tensor<float>(x[4]) {
+            indexing: attribute | index
+        }
+        field image_embedding type tensor<float>(x[4]) {
+            indexing: attribute
+        }
+    }
+    fieldset default {
+        fields: title, brand
+    }
+    rank-profile base {
+        inputs {
+            query(q) tensor<float>(x[4])
+        }
+    }
+    rank-profile hybrid inherits base {
+        inputs {
+            query(alpha) double: 0.5
+        }
+    }
+}
+`
+
+func testSchema(t *testing.T) *schema.Schema {
+	s, err := schema.Parse([]byte(testProductSchema))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return s
+}
+
+func TestValidateSchema(t *testing.T) {
+	s := testSchema(t)
+	exact := false
+
+	tests := []struct {
+		name      string
+		condition WhereCondition
+		expected  string // empty if valid
+	}{
+		{"text match on index", Field("title").Contains("shoes"), ""},
+		{"string equality on attribute", Field("brand").Eq("nike"), ""},
+		{"fieldset", Field("default").Contains("shoes"), ""},
+		{"numeric attribute", Field("price").Gt(10), ""},
+		{"range", Field("price").Between(10, 20), ""},
+		{"bool attribute", Field("in_stock").Eq(true), ""},
+		{"in on attribute", Field("tags").In("a", "b"), ""},
+		{"nearestNeighbor with HNSW", Field("embedding").NearestNeighbor("q", 10), ""},
+		{"exact nearestNeighbor", Field("image_embedding").NearestNeighbor("q", 10, WithApproximate(exact)), ""},
+		{"sameElement on array of struct", Field("sizes").ContainsSameElement(Field("label").Eq("M"), Field("stock").Gt(0)), ""},
+		{"sameElement on map", Field("attributes").ContainsEntry("color", Value().Eq("red")), ""},
+		{"prefix on attribute", StringField("brand").Prefix("ni"), ""},
+		{"near on index", TextField("title").Near("running", "shoes"), ""},
+		{
+			name:      "unknown field",
+			condition: Field("colour").Eq("red"),
+			expected:  "field 'colour' does not exist in schema 'product'",
+		},
+		{
+			name:      "contains on summary field",
+			condition: Field("description").Contains("soft"),
+			expected:  "contains requires an indexed or attribute field, 'description' is neither",
+		},
+		{
+			name:      "prefix on index",
+			condition: StringField("title").Prefix("ru"),
+			expected:  "prefix requires an attribute, 'title' is not an attribute field",
+		},
+		{
+			name:      "near on attribute",
+			condition: TextField("brand").Near("nike", "air"),
+			expected:  "near requires an indexed field, 'brand' is not indexed",
+		},
+		{
+			name:      "numeric comparison on string",
+			condition: Field("title").Gt(10),
+			expected:  "> requires a numeric field, 'title' has type string",
+		},
+		{
+			name:      "numeric comparison without attribute",
+			condition: Field("weight").Lte(2.5),
+			expected:  "<= requires an attribute, 'weight' is not an attribute field",
+		},
+		{
+			name:      "range on string",
+			condition: Field("brand").Between(1, 2),
+			expected:  "range requires a numeric field, 'brand' has type string",
+		},
+		{
+			name:      "bool value on numeric field",
+			condition: Field("price").Eq(true),
+			expected:  "= with a bool value requires a bool field, 'price' has type int",
+		},
+		{
+			name:      "in on index",
+			condition: Field("title").In("a"),
+			expected:  "in requires an attribute, 'title' is not an attribute field",
+		},
+		{
+			name:      "nearestNeighbor on string",
+			condition: Field("title").NearestNeighbor("q", 10),
+			expected:  "nearestNeighbor requires a tensor field, 'title' has type string",
+		},
+		{
+			name:      "nearestNeighbor without HNSW",
+			condition: Field("image_embedding").NearestNeighbor("q", 10),
+			expected:  "nearestNeighbor on 'image_embedding' requires an HNSW index (indexing: index) or approximate:false",
+		},
+		{
+			name:      "sameElement on array of string",
+			condition: Field("tags").ContainsSameElement(Field("label").Eq("M")),
+			expected:  "sameElement requires an array of struct, map or struct field, 'tags' has type array<string>",
+		},
+		{
+			name:      "unknown struct field",
+			condition: Field("sizes").ContainsSameElement(Field("color").Eq("red")),
+			expected:  "field 'sizes.color' does not exist in schema 'product'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateSchema(tt.condition, s)
+			if tt.expected == "" {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Expected error containing %q", tt.expected)
+			}
+			if !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected error containing %q, got %q", tt.expected, err.Error())
+			}
+		})
+	}
+}
+
+func TestValidateSchemaPaths(t *testing.T) {
+	s := testSchema(t)
+
+	condition := And(
+		Field("price").Gt(10),
+		Not(Or(Field("brand").Eq("nike"), Field("colour").Eq("red"))),
+		Field("sizes").ContainsSameElement(Field("stock").Gt(0), Field("color").Eq("red")),
+	)
+	err := ValidateSchema(condition, s)
+
+	var errs ValidationErrors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("Expected 2 validation errors, got %v", err)
+	}
+	expected := []struct{ field, path string }{
+		{"colour", "left.right.condition.right"},
+		{"sizes.color", "right.conditions[1]"},
+	}
+	for i, e := range expected {
+		if errs[i].Field != e.field || errs[i].Path != e.path {
+			t.Errorf("Expected error on %q at %q, got %q at %q", e.field, e.path, errs[i].Field, errs[i].Path)
+		}
+	}
+}
+
+func TestBuilderWithSchema(t *testing.T) {
+	s := testSchema(t)
+	q := []float32{1, 0, 0, 0}
+
+	tests := []struct {
+		name     string
+		builder  QueryBuilder
+		expected []string // empty if valid
+	}{
+		{
+			name: "valid query",
+			builder: NewQueryBuilder().Select("title", "price", "documentid").From("product").
+				Where(Field("embedding").NearestNeighbor("q", 10)).
+				OrderBy("price", Descending).
+				WithRanking("hybrid").
+				WithInput("input.query(q)", q).
+				WithInput("input.query(alpha)", 0.3).
+				WithDefaultIndex("default"),
+		},
+		{
+			name:     "unknown source",
+			builder:  NewQueryBuilder().From("article").Where(Field("title").Contains("x")),
+			expected: []string{"no schema for source 'article'"},
+		},
+		{
+			name:     "all sources",
+			builder:  NewQueryBuilder().From("*").Where(Field("colour").Contains("x")),
+			expected: []string{"field 'colour' does not exist"},
+		},
+		{
+			name:     "selected and sorted fields",
+			builder:  NewQueryBuilder().Select("name").From("product").Where(True()).OrderBy("title", Ascending),
+			expected: []string{"field 'name' does not exist", "order by requires an attribute, 'title' is not an attribute field"},
+		},
+		{
+			name:     "unknown default index",
+			builder:  NewQueryBuilder().From("product").Where(UserQuery()).WithDefaultIndex("body"),
+			expected: []string{"field 'body' does not exist"},
+		},
+		{
+			name: "undeclared input",
+			builder: NewQueryBuilder().From("product").Where(True()).
+				WithRanking("base").WithInput("input.query(alpha)", 0.3),
+			expected: []string{"input 'alpha' is not declared in rank profile 'base' of schema 'product'"},
+		},
+		{
+			name: "input in default profile",
+			builder: NewQueryBuilder().From("product").Where(True()).
+				WithInput("input.query(q)", q),
+			expected: []string{"input 'q' is not declared in rank profile 'default' of schema 'product'"},
+		},
+		{
+			name:     "unknown rank profile",
+			builder:  NewQueryBuilder().From("product").Where(True()).WithRanking("missing"),
+			expected: []string{"rank profile 'missing' does not exist in schema 'product'"},
+		},
+		{
+			name: "rank conditions",
+			builder: NewQueryBuilder().From("product").Where(True()).
+				Rank(NewRank().AddCondition(Field("description").Contains("soft"))),
+			expected: []string{"at rank[0]: contains requires an indexed or attribute field"},
+		},
+		{
+			name: "optimized away",
+			builder: NewQueryBuilder().From("product").
+				Where(Or(Field("colour").Eq("red"), True())).
+				Optimize(),
+		},
+		{
+			name: "optimized conditions",
+			builder: NewQueryBuilder().From("product").
+				Where(Or(Field("title").In("a"), Field("title").In("b"))).
+				Rank(NewRank().AddCondition(Not(Not(Field("description").Contains("soft"))))).
+				Optimize(),
+			expected: []string{
+				"at where[0]: in requires an attribute, 'title' is not an attribute field",
+				"at rank[0]: contains requires an indexed or attribute field",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.builder.WithSchema(s).Build()
+			if len(tt.expected) == 0 {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Expected errors %q", tt.expected)
+			}
+			for _, expected := range tt.expected {
+				if !strings.Contains(err.Error(), expected) {
+					t.Errorf("Expected error containing %q, got %q", expected, err.Error())
+				}
+			}
+		})
+	}
+}
+
+func TestBuilderWithSchemaInheritance(t *testing.T) {
+	parent, err := schema.Parse([]byte(`
+schema base {
+    document base {
+        field price type int {
+            indexing: attribute
+        }
+    }
+    rank-profile shared {
+        inputs {
+            query(alpha) double: 0.5
+        }
+    }
+}
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	child, err := schema.Parse([]byte(`
+schema product inherits base {
+    document product inherits base {
+        field title type string {
+            indexing: index
+        }
+    }
+    rank-profile hybrid inherits shared {
+    }
+}
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		builder  QueryBuilder
+		expected string // empty if valid
+	}{
+		{
+			name: "inherited field",
+			builder: NewQueryBuilder().From("product").
+				Where(And(Field("price").Gte(10), Field("title").Contains("shoe"))).
+				OrderBy("price", Descending),
+		},
+		{
+			name: "inherited rank profile",
+			builder: NewQueryBuilder().From("product").Where(True()).
+				WithRanking("shared").WithInput("input.query(alpha)", 0.3),
+		},
+		{
+			name: "rank profile inheriting across schemas",
+			builder: NewQueryBuilder().From("product").Where(True()).
+				WithRanking("hybrid").WithInput("input.query(alpha)", 0.3),
+		},
+		{
+			name:     "parent does not see child fields",
+			builder:  NewQueryBuilder().From("base").Where(Field("title").Contains("shoe")),
+			expected: "field 'title' does not exist in schema 'base'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.builder.WithSchema(child, parent).Build()
+			if tt.expected == "" {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+
+	if err := ValidateSchema(Field("price").Gte(10), child, parent); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestBuilderWithoutSchema(t *testing.T) {
+	// Without schemas, unknown fields are not reported
+	if _, err := NewQueryBuilder().From("product").Where(Field("colour").Eq("red")).Build(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	base := NewImmutableQueryBuilder().From("product").Where(Field("colour").Eq("red"))
+	if _, err := base.WithSchema(testSchema(t)).Build(); err == nil {
+		t.Error("Expected error for unknown field")
+	}
+	if _, err := base.Build(); err != nil {
+		t.Errorf("Expected immutable base without schema to stay valid, got %v", err)
+	}
+}
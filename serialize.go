@@ -0,0 +1,650 @@
+package vespa
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/vipulsodha/vespa-go/tensor"
+)
+
+// JSONFormatVersion is the version of the JSON representation of conditions
+// and builders. Documents written by a newer version are rejected.
+const JSONFormatVersion = 1
+
+// Condition type discriminators of the JSON representation
+const (
+	conditionTypeField           = "field"
+	conditionTypeRange           = "range"
+	conditionTypeBoolean         = "boolean"
+	conditionTypeJunction        = "junction"
+	conditionTypeNot             = "not"
+	conditionTypeSameElement     = "sameElement"
+	conditionTypeNearestNeighbor = "nearestNeighbor"
+	conditionTypeUserQuery       = "userQuery"
+	conditionTypeCustom          = "custom"
+	conditionTypeConstant        = "constant"
+)
+
+// =============================================================================
+// Conditions
+// =============================================================================
+
+// conditionJSON is the JSON representation of all condition types.
+// Type selects the condition; only the fields of that condition are set.
+type conditionJSON struct {
+	Type              string           `json:"type"`
+	Field             string           `json:"field,omitempty"`
+	Operator          string           `json:"operator,omitempty"`
+	Match             ContainsType     `json:"match,omitempty"`
+	Value             json.RawMessage  `json:"value,omitempty"`
+	Min               json.RawMessage  `json:"min,omitempty"`
+	Max               json.RawMessage  `json:"max,omitempty"`
+	Conditions        []*conditionJSON `json:"conditions,omitempty"`
+	QueryVector       string           `json:"queryVector,omitempty"`
+	TargetHits        int              `json:"targetHits,omitempty"`
+	Label             string           `json:"label,omitempty"`
+	DistanceThreshold *float64         `json:"distanceThreshold,omitempty"`
+	Approximate       *bool            `json:"approximate,omitempty"`
+	Embedding         *embeddingJSON   `json:"embedding,omitempty"`
+	DefaultIndex      string           `json:"defaultIndex,omitempty"`
+	Expression        string           `json:"expression,omitempty"`
+}
+
+type embeddingJSON struct {
+	EmbedderID string `json:"embedderId,omitempty"`
+	Text       string `json:"text"`
+	Param      string `json:"param,omitempty"`
+}
+
+// conditionDocument is the versioned envelope written by MarshalCondition
+type conditionDocument struct {
+	Version   int            `json:"version"`
+	Condition *conditionJSON `json:"condition"`
+}
+
+// MarshalCondition serializes a condition tree as versioned JSON, e.g.
+//
+//	{"version":1,"condition":{"type":"field","field":"price","operator":">","value":10}}
+//
+// Only the built-in condition types can be serialized.
+func MarshalCondition(condition WhereCondition) ([]byte, error) {
+	encoded, err := encodeCondition(condition)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(conditionDocument{Version: JSONFormatVersion, Condition: encoded})
+}
+
+// UnmarshalCondition restores a condition tree written by MarshalCondition.
+// Numbers are restored as int64 when integral and float64 otherwise.
+func UnmarshalCondition(data []byte) (WhereCondition, error) {
+	var doc conditionDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid condition document: %w", err)
+	}
+	if err := checkJSONFormatVersion(doc.Version); err != nil {
+		return nil, err
+	}
+	return decodeCondition(doc.Condition)
+}
+
+func checkJSONFormatVersion(version int) error {
+	if version < 1 || version > JSONFormatVersion {
+		return fmt.Errorf("unsupported JSON format version %d (supported: 1 to %d)", version, JSONFormatVersion)
+	}
+	return nil
+}
+
+func encodeCondition(condition WhereCondition) (*conditionJSON, error) {
+	switch c := condition.(type) {
+	case *FieldCondition:
+		value, err := json.Marshal(c.Value)
+		if err != nil {
+			return nil, fmt.Errorf("cannot serialize value of field '%s': %w", c.Field, err)
+		}
+		encoded := &conditionJSON{Type: conditionTypeField, Field: c.Field, Operator: string(c.Operator), Value: value}
+		if c.Operator == CONTAINS {
+			encoded.Match = c.ContainsType
+		}
+		return encoded, nil
+	case *RangeCondition:
+		min, err := json.Marshal(c.Min)
+		if err != nil {
+			return nil, fmt.Errorf("cannot serialize range of field '%s': %w", c.Field, err)
+		}
+		max, err := json.Marshal(c.Max)
+		if err != nil {
+			return nil, fmt.Errorf("cannot serialize range of field '%s': %w", c.Field, err)
+		}
+		return &conditionJSON{Type: conditionTypeRange, Field: c.Field, Min: min, Max: max}, nil
+	case *BooleanCondition:
+		conditions, err := encodeConditions([]WhereCondition{c.Left, c.Right})
+		if err != nil {
+			return nil, err
+		}
+		return &conditionJSON{Type: conditionTypeBoolean, Operator: c.Operator, Conditions: conditions}, nil
+	case *JunctionCondition:
+		conditions, err := encodeConditions(c.Conditions)
+		if err != nil {
+			return nil, err
+		}
+		return &conditionJSON{Type: conditionTypeJunction, Operator: c.Operator, Conditions: conditions}, nil
+	case *NotCondition:
+		conditions, err := encodeConditions([]WhereCondition{c.Condition})
+		if err != nil {
+			return nil, err
+		}
+		return &conditionJSON{Type: conditionTypeNot, Conditions: conditions}, nil
+	case *SameElementCondition:
+		conditions, err := encodeConditions(c.Conditions)
+		if err != nil {
+			return nil, err
+		}
+		return &conditionJSON{Type: conditionTypeSameElement, Field: c.Field, Conditions: conditions}, nil
+	case *NearestNeighbor:
+		encoded := &conditionJSON{
+			Type:              conditionTypeNearestNeighbor,
+			Field:             c.Field,
+			QueryVector:       c.QueryVector,
+			TargetHits:        c.TargetHits,
+			Label:             c.Label,
+			DistanceThreshold: c.DistanceThreshold,
+			Approximate:       c.Approximate,
+		}
+		if c.Embedding != nil {
+			encoded.Embedding = encodeEmbedding(c.Embedding)
+		}
+		return encoded, nil
+	case *UserQueryFeature:
+		return &conditionJSON{Type: conditionTypeUserQuery, DefaultIndex: c.DefaultIndex}, nil
+	case *CustomFeature:
+		return &conditionJSON{Type: conditionTypeCustom, Expression: c.Expression}, nil
+	case *ConstantCondition:
+		value, _ := json.Marshal(c.Value)
+		return &conditionJSON{Type: conditionTypeConstant, Value: value}, nil
+	case nil:
+		return nil, fmt.Errorf("cannot serialize nil condition")
+	default:
+		return nil, fmt.Errorf("cannot serialize condition of type %T", condition)
+	}
+}
+
+func encodeConditions(conditions []WhereCondition) ([]*conditionJSON, error) {
+	encoded := make([]*conditionJSON, len(conditions))
+	for i, condition := range conditions {
+		c, err := encodeCondition(condition)
+		if err != nil {
+			return nil, err
+		}
+		encoded[i] = c
+	}
+	return encoded, nil
+}
+
+func encodeEmbedding(e *Embedding) *embeddingJSON {
+	return &embeddingJSON{EmbedderID: e.EmbedderID, Text: e.Text, Param: e.Param}
+}
+
+func decodeCondition(c *conditionJSON) (WhereCondition, error) {
+	if c == nil {
+		return nil, fmt.Errorf("invalid condition: missing condition")
+	}
+
+	switch c.Type {
+	case conditionTypeField:
+		operator := Operator(c.Operator)
+		if !isKnownOperator(operator) {
+			return nil, fmt.Errorf("invalid condition: unknown operator '%s'", c.Operator)
+		}
+		value, err := decodeJSONValue(c.Value)
+		if err != nil {
+			return nil, err
+		}
+		if c.Match == PhraseMatch || c.Match == NearMatch {
+			value = phraseKeywords(value)
+		}
+		return &FieldCondition{Field: c.Field, Operator: operator, Value: value, ContainsType: c.Match}, nil
+	case conditionTypeRange:
+		min, err := decodeJSONValue(c.Min)
+		if err != nil {
+			return nil, err
+		}
+		max, err := decodeJSONValue(c.Max)
+		if err != nil {
+			return nil, err
+		}
+		return &RangeCondition{Field: c.Field, Min: min, Max: max}, nil
+	case conditionTypeBoolean:
+		conditions, err := decodeConditions(c.Conditions)
+		if err != nil {
+			return nil, err
+		}
+		if len(conditions) != 2 {
+			return nil, fmt.Errorf("invalid condition: boolean condition requires 2 conditions, got %d", len(conditions))
+		}
+		return &BooleanCondition{Left: conditions[0], Right: conditions[1], Operator: c.Operator}, nil
+	case conditionTypeJunction:
+		conditions, err := decodeConditions(c.Conditions)
+		if err != nil {
+			return nil, err
+		}
+		return &JunctionCondition{Conditions: conditions, Operator: c.Operator}, nil
+	case conditionTypeNot:
+		conditions, err := decodeConditions(c.Conditions)
+		if err != nil {
+			return nil, err
+		}
+		if len(conditions) != 1 {
+			return nil, fmt.Errorf("invalid condition: not condition requires 1 condition, got %d", len(conditions))
+		}
+		return &NotCondition{Condition: conditions[0]}, nil
+	case conditionTypeSameElement:
+		conditions, err := decodeConditions(c.Conditions)
+		if err != nil {
+			return nil, err
+		}
+		return &SameElementCondition{Field: c.Field, Conditions: conditions}, nil
+	case conditionTypeNearestNeighbor:
+		nn := &NearestNeighbor{
+			Field:             c.Field,
+			QueryVector:       c.QueryVector,
+			TargetHits:        c.TargetHits,
+			Label:             c.Label,
+			DistanceThreshold: c.DistanceThreshold,
+			Approximate:       c.Approximate,
+		}
+		if c.Embedding != nil {
+			nn.Embedding = &Embedding{EmbedderID: c.Embedding.EmbedderID, Text: c.Embedding.Text, Param: c.Embedding.Param}
+		}
+		return nn, nil
+	case conditionTypeUserQuery:
+		return &UserQueryFeature{DefaultIndex: c.DefaultIndex}, nil
+	case conditionTypeCustom:
+		return &CustomFeature{Expression: c.Expression}, nil
+	case conditionTypeConstant:
+		var value bool
+		if err := json.Unmarshal(c.Value, &value); err != nil {
+			return nil, fmt.Errorf("invalid condition: constant requires a boolean value")
+		}
+		return &ConstantCondition{Value: value}, nil
+	default:
+		return nil, fmt.Errorf("invalid condition: unknown type '%s'", c.Type)
+	}
+}
+
+func decodeConditions(encoded []*conditionJSON) ([]WhereCondition, error) {
+	conditions := make([]WhereCondition, len(encoded))
+	for i, c := range encoded {
+		condition, err := decodeCondition(c)
+		if err != nil {
+			return nil, err
+		}
+		conditions[i] = condition
+	}
+	return conditions, nil
+}
+
+func isKnownOperator(operator Operator) bool {
+	switch operator {
+	case EQ, NEQ, GT, GTE, LT, LTE, IN, NOT_IN, CONTAINS, NOT_CONTAINS, MATCHES:
+		return true
+	default:
+		return false
+	}
+}
+
+// phraseKeywords restores the []string value of near and multi-keyword phrases
+func phraseKeywords(value interface{}) interface{} {
+	list, ok := value.([]interface{})
+	if !ok {
+		return value
+	}
+	keywords := make([]string, len(list))
+	for i, v := range list {
+		s, ok := v.(string)
+		if !ok {
+			return value
+		}
+		keywords[i] = s
+	}
+	return keywords
+}
+
+// decodeJSONValue decodes a JSON value, restoring integral numbers as int64
+// and other numbers as float64
+func decodeJSONValue(data json.RawMessage) (interface{}, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("invalid value: %w", err)
+	}
+	return restoreNumbers(value), nil
+}
+
+func restoreNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		for i := range v {
+			v[i] = restoreNumbers(v[i])
+		}
+		return v
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = restoreNumbers(v[k])
+		}
+		return v
+	default:
+		return value
+	}
+}
+
+// =============================================================================
+// Input and parameter values
+// =============================================================================
+
+// Value kinds that cannot be restored from plain JSON
+const (
+	valueKindTensor    = "tensor"
+	valueKindEmbedding = "embedding"
+	valueKindFloat32s  = "[]float32"
+	valueKindFloat64s  = "[]float64"
+)
+
+// valueJSON is the JSON representation of an input or parameter value.
+// Kind is set for values that need their Go type restored; Input is set for
+// values added with WithQueryInput.
+type valueJSON struct {
+	Kind       string          `json:"kind,omitempty"`
+	TensorType string          `json:"tensorType,omitempty"`
+	Input      *queryInputJSON `json:"input,omitempty"`
+	Value      json.RawMessage `json:"value"`
+}
+
+type queryInputJSON struct {
+	Name       string `json:"name"`
+	TensorType string `json:"tensorType,omitempty"`
+}
+
+func encodeValue(value interface{}) (*valueJSON, error) {
+	if typed, ok := value.(typedInputValue); ok {
+		encoded, err := encodeValue(typed.value)
+		if err != nil {
+			return nil, err
+		}
+		encoded.Input = &queryInputJSON{Name: typed.input.Name}
+		if typed.input.TensorType != nil {
+			encoded.Input.TensorType = typed.input.TensorType.String()
+		}
+		return encoded, nil
+	}
+
+	encoded := &valueJSON{}
+	var data []byte
+	var err error
+	switch v := value.(type) {
+	case *tensor.Tensor:
+		encoded.Kind = valueKindTensor
+		encoded.TensorType = v.Type().String()
+		data, err = v.Encode(tensor.LongForm)
+	case *Embedding:
+		encoded.Kind = valueKindEmbedding
+		data, err = json.Marshal(encodeEmbedding(v))
+	case []float32:
+		encoded.Kind = valueKindFloat32s
+		data, err = json.Marshal(v)
+	case []float64:
+		encoded.Kind = valueKindFloat64s
+		data, err = json.Marshal(v)
+	default:
+		data, err = json.Marshal(v)
+	}
+	if err != nil {
+		return nil, err
+	}
+	encoded.Value = data
+	return encoded, nil
+}
+
+func decodeValue(v *valueJSON) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	var value interface{}
+	var err error
+	switch v.Kind {
+	case valueKindTensor:
+		var t tensor.Type
+		if t, err = tensor.ParseType(v.TensorType); err == nil {
+			value, err = tensor.Decode(t, v.Value)
+		}
+	case valueKindEmbedding:
+		var e embeddingJSON
+		if err = json.Unmarshal(v.Value, &e); err == nil {
+			value = &Embedding{EmbedderID: e.EmbedderID, Text: e.Text, Param: e.Param}
+		}
+	case valueKindFloat32s:
+		var floats []float32
+		err = json.Unmarshal(v.Value, &floats)
+		value = floats
+	case valueKindFloat64s:
+		var floats []float64
+		err = json.Unmarshal(v.Value, &floats)
+		value = floats
+	case "":
+		value, err = decodeJSONValue(v.Value)
+	default:
+		err = fmt.Errorf("unknown value kind '%s'", v.Kind)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid value: %w", err)
+	}
+
+	if v.Input == nil {
+		return value, nil
+	}
+	input := QueryInput{Name: v.Input.Name}
+	if v.Input.TensorType != "" {
+		t, err := tensor.ParseType(v.Input.TensorType)
+		if err != nil {
+			return nil, fmt.Errorf("invalid query input '%s': %w", v.Input.Name, err)
+		}
+		input.TensorType = &t
+	}
+	return typedInputValue{input: input, value: value}, nil
+}
+
+func encodeValues(values map[string]interface{}) (map[string]*valueJSON, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	encoded := make(map[string]*valueJSON, len(values))
+	for _, key := range sortedInputKeys(values) {
+		v, err := encodeValue(values[key])
+		if err != nil {
+			return nil, fmt.Errorf("cannot serialize '%s': %w", key, err)
+		}
+		encoded[key] = v
+	}
+	return encoded, nil
+}
+
+func decodeValues(encoded map[string]*valueJSON) (map[string]interface{}, error) {
+	keys := make([]string, 0, len(encoded))
+	for key := range encoded {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	values := make(map[string]interface{}, len(encoded))
+	for _, key := range keys {
+		v, err := decodeValue(encoded[key])
+		if err != nil {
+			return nil, fmt.Errorf("'%s': %w", key, err)
+		}
+		values[key] = v
+	}
+	return values, nil
+}
+
+// =============================================================================
+// QueryBuilder
+// =============================================================================
+
+// builderJSON is the JSON representation of the full builder state
+type builderJSON struct {
+	Version      int                   `json:"version"`
+	Select       []string              `json:"select,omitempty"`
+	Sources      []string              `json:"sources,omitempty"`
+	Where        []*conditionJSON      `json:"where,omitempty"`
+	Rank         []*conditionJSON      `json:"rank,omitempty"`
+	OrderBy      []OrderBySpec         `json:"orderBy,omitempty"`
+	Limit        int                   `json:"limit,omitempty"`
+	YQLOffset    int                   `json:"yqlOffset,omitempty"`
+	Timeout      int                   `json:"timeout,omitempty"`
+	Grouping     string                `json:"grouping,omitempty"`
+	Ranking      *Ranking              `json:"ranking,omitempty"`
+	Hits         int                   `json:"hits,omitempty"`
+	Offset       int                   `json:"offset,omitempty"`
+	DefaultIndex string                `json:"defaultIndex,omitempty"`
+	Inputs       map[string]*valueJSON `json:"inputs,omitempty"`
+	Parameters   map[string]*valueJSON `json:"parameters,omitempty"`
+	Query        string                `json:"query,omitempty"`
+	QueryProfile string                `json:"queryProfile,omitempty"`
+	Optimize     bool                  `json:"optimize,omitempty"`
+}
+
+// MarshalJSON serializes the complete builder state as versioned JSON, so a
+// builder can be stored and restored with UnmarshalJSON. The rank expression
+// must be created with NewRank.
+func (qb *QueryBuilderImpl) MarshalJSON() ([]byte, error) {
+	where, err := encodeConditions(qb.whereConditions)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := builderJSON{
+		Version:      JSONFormatVersion,
+		Select:       qb.selectFields,
+		Sources:      qb.sources,
+		Where:        where,
+		OrderBy:      qb.orderBy,
+		Limit:        qb.limit,
+		YQLOffset:    qb.yqlOffset,
+		Timeout:      qb.timeout,
+		Grouping:     qb.grouping,
+		Hits:         qb.hits,
+		Offset:       qb.offset,
+		DefaultIndex: qb.defaultIndex,
+		Query:        qb.query,
+		QueryProfile: qb.queryProfile,
+		Optimize:     qb.optimize,
+	}
+
+	if qb.rankExpression != nil {
+		rank, ok := qb.rankExpression.(*RankExpressionImpl)
+		if !ok {
+			return nil, fmt.Errorf("cannot serialize rank expression of type %T", qb.rankExpression)
+		}
+		if doc.Rank, err = encodeConditions(rank.Conditions()); err != nil {
+			return nil, err
+		}
+	}
+	if !qb.ranking.isZero() {
+		ranking := qb.ranking.clone()
+		doc.Ranking = &ranking
+	}
+	if doc.Inputs, err = encodeValues(qb.inputParams); err != nil {
+		return nil, err
+	}
+	if doc.Parameters, err = encodeValues(qb.parameters); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(doc)
+}
+
+// UnmarshalQueryBuilder restores a builder serialized with json.Marshal
+//
+// Example:
+//
+//	data, err := json.Marshal(builder)
+//	restored, err := UnmarshalQueryBuilder(data)
+func UnmarshalQueryBuilder(data []byte) (QueryBuilder, error) {
+	qb := &QueryBuilderImpl{}
+	if err := qb.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+	return qb, nil
+}
+
+// UnmarshalJSON restores a builder state written by MarshalJSON, replacing
+// the current state of the builder
+func (qb *QueryBuilderImpl) UnmarshalJSON(data []byte) error {
+	var doc builderJSON
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("invalid query builder document: %w", err)
+	}
+	if err := checkJSONFormatVersion(doc.Version); err != nil {
+		return err
+	}
+
+	restored := newQueryBuilder()
+	restored.selectFields = append(restored.selectFields, doc.Select...)
+	restored.sources = append(restored.sources, doc.Sources...)
+	restored.orderBy = doc.OrderBy
+	restored.limit = doc.Limit
+	restored.yqlOffset = doc.YQLOffset
+	restored.timeout = doc.Timeout
+	restored.grouping = doc.Grouping
+	restored.hits = doc.Hits
+	restored.offset = doc.Offset
+	restored.defaultIndex = doc.DefaultIndex
+	restored.query = doc.Query
+	restored.queryProfile = doc.QueryProfile
+	restored.optimize = doc.Optimize
+	if doc.Ranking != nil {
+		restored.ranking = *doc.Ranking
+	}
+
+	where, err := decodeConditions(doc.Where)
+	if err != nil {
+		return err
+	}
+	restored.whereConditions = append(restored.whereConditions, where...)
+
+	if doc.Rank != nil {
+		conditions, err := decodeConditions(doc.Rank)
+		if err != nil {
+			return err
+		}
+		rank := NewRank()
+		for _, condition := range conditions {
+			rank.AddCondition(condition)
+		}
+		restored.rankExpression = rank
+	}
+
+	if restored.inputParams, err = decodeValues(doc.Inputs); err != nil {
+		return fmt.Errorf("invalid input %w", err)
+	}
+	if restored.parameters, err = decodeValues(doc.Parameters); err != nil {
+		return fmt.Errorf("invalid parameter %w", err)
+	}
+
+	*qb = *restored
+	return nil
+}
@@ -0,0 +1,206 @@
+package vespa
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/vipulsodha/vespa-go/tensor"
+)
+
+func TestConditionJSONRoundTrip(t *testing.T) {
+	conditions := map[string]WhereCondition{
+		"field":        Field("price").Gt(10.5),
+		"string eq":    Field("brand").Eq("nike"),
+		"in":           Field("category").In("a", "b"),
+		"not in":       Field("id").NotIn(1, 2, 3),
+		"phrase":       Field("title").Contains([]string{"running", "shoes"}, WithPhraseMatching()),
+		"fuzzy":        Field("brand").Contains("nkie", WithFuzzyMatching()),
+		"prefix":       Field("brand").Contains("ni", WithPrefixMatching()),
+		"near":         Field("title").Contains([]string{"running", "shoes"}, WithNearMatching()),
+		"null":         Field("deleted").Eq(nil),
+		"range":        Field("price").Between(10, 99.5),
+		"boolean":      Or(Field("a").Eq(1), And(Field("b").Eq(2), Field("c").Eq(true))),
+		"junction":     Simplify(And(Field("a").Eq(1), Field("b").Eq(2), Field("c").Eq(3))),
+		"not":          Not(Field("color").Eq("red")),
+		"same element": Field("sizes").ContainsSameElement(Field("family").Eq("US"), Field("value").Gte(10)),
+		"nearest neighbor": Field("embedding").NearestNeighbor("q", 100,
+			WithLabel("main"), WithThreshold(0.5), WithApproximate(false),
+			WithQueryEmbedding(Embed("e5", "shoes").BindTo("q_text"))),
+		"user query": UserQuery("title"),
+		"custom":     Custom("weakAnd(a contains 'x')"),
+		"constant":   False(),
+	}
+
+	for name, condition := range conditions {
+		t.Run(name, func(t *testing.T) {
+			data, err := MarshalCondition(condition)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			restored, err := UnmarshalCondition(data)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if restored.ToYQL() != condition.ToYQL() {
+				t.Errorf("Expected %q, got %q", condition.ToYQL(), restored.ToYQL())
+			}
+
+			again, err := MarshalCondition(restored)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if string(again) != string(data) {
+				t.Errorf("Expected stable JSON %s, got %s", data, again)
+			}
+		})
+	}
+}
+
+func TestConditionJSONFormat(t *testing.T) {
+	data, err := MarshalCondition(And(Field("price").Eq(10), Not(Field("brand").Contains("x"))))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := `{"version":1,"condition":{"type":"boolean","operator":"AND","conditions":[` +
+		`{"type":"field","field":"price","operator":"=","value":10},` +
+		`{"type":"not","conditions":[{"type":"field","field":"brand","operator":"contains","match":"exact","value":"x"}]}]}}`
+	if string(data) != expected {
+		t.Errorf("Expected %s, got %s", expected, data)
+	}
+
+	restored, err := UnmarshalCondition(data)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	fc := restored.(*BooleanCondition).Left.(*FieldCondition)
+	if fc.Value != int64(10) {
+		t.Errorf("Expected integral number to be restored as int64, got %T", fc.Value)
+	}
+}
+
+func TestConditionJSONErrors(t *testing.T) {
+	if _, err := MarshalCondition(&struct{ CustomFeature }{}); err == nil {
+		t.Error("Expected error serializing an unknown condition type")
+	}
+	if _, err := MarshalCondition(And(Field("a").Eq(1), nil)); err == nil {
+		t.Error("Expected error serializing a nil condition")
+	}
+
+	invalid := []string{
+		`not json`,
+		`{"condition":{"type":"custom","expression":"x"}}`,
+		`{"version":2,"condition":{"type":"custom","expression":"x"}}`,
+		`{"version":1}`,
+		`{"version":1,"condition":{"type":"unknown"}}`,
+		`{"version":1,"condition":{"type":"field","field":"a","operator":"~","value":1}}`,
+		`{"version":1,"condition":{"type":"boolean","operator":"AND","conditions":[{"type":"custom","expression":"x"}]}}`,
+		`{"version":1,"condition":{"type":"constant","value":"yes"}}`,
+	}
+	for _, data := range invalid {
+		if _, err := UnmarshalCondition([]byte(data)); err == nil {
+			t.Errorf("Expected error unmarshalling %s", data)
+		}
+	}
+}
+
+func TestQueryBuilderJSONRoundTrip(t *testing.T) {
+	vectorType := tensor.MustType(tensor.Float, tensor.Indexed("x", 3))
+	vector, _ := tensor.NewDense(vectorType, 0.1, 0.2, 0.3)
+
+	builder := NewQueryBuilder().
+		Select("title", "price").
+		From("products").
+		Where(Field("price").Between(10, 100)).
+		Where(Or(Field("brand").Eq("nike"), Field("brand").Eq("adidas"))).
+		Rank(NewRank().AddCondition(UserQuery()).AddCondition(Field("embedding").NearestNeighbor("q", 10))).
+		OrderBy("price", Descending).
+		Limit(20, 10).
+		Timeout(300).
+		Grouping("all(group(brand) each(output(count())))").
+		WithRanking("hybrid", WithRerankCount(50)).
+		WithHits(5).
+		WithOffset(15).
+		WithDefaultIndex("title").
+		WithInput("input.query(raw)", []float64{1, 2}).
+		WithInput("input.query(text)", Embed("e5", "shoes")).
+		WithQueryInput(TensorInput("q", vectorType), vector).
+		WithQueryInput(TensorInput("f32", vectorType), []float32{1, 2, 3}).
+		WithQueryInput(ScalarInput("boost"), 2.5).
+		WithParameter("trace.level", 3).
+		WithQueryProfile("mobile").
+		WithQuery("running shoes").
+		Optimize()
+
+	expected, err := builder.Build()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	data, err := json.Marshal(builder)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	restored, err := UnmarshalQueryBuilder(data)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	actual, err := restored.Build()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expectedJSON, _ := json.Marshal(expected)
+	actualJSON, _ := json.Marshal(actual)
+	if string(actualJSON) != string(expectedJSON) {
+		t.Errorf("Expected %s, got %s", expectedJSON, actualJSON)
+	}
+
+	again, err := json.Marshal(restored)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(again) != string(data) {
+		t.Errorf("Expected stable JSON %s, got %s", data, again)
+	}
+}
+
+func TestImmutableQueryBuilderJSON(t *testing.T) {
+	base := NewImmutableQueryBuilder().From("products").Where(Field("in_stock").Eq(true))
+
+	data, err := json.Marshal(base)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var restored QueryBuilderImpl
+	if err := json.Unmarshal(data, &restored); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected, _ := base.BuildYQL()
+	actual, err := Immutable(&restored).BuildYQL()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if actual != expected {
+		t.Errorf("Expected %q, got %q", expected, actual)
+	}
+}
+
+func TestQueryBuilderJSONErrors(t *testing.T) {
+	invalid := []string{
+		`{"sources":["products"]}`,
+		`{"version":99,"sources":["products"]}`,
+		`{"version":1,"where":[{"type":"unknown"}]}`,
+		`{"version":1,"inputs":{"input.query(q)":{"kind":"tensor","tensorType":"tensor(x[2])","value":{"values":[1]}}}}`,
+		`{"version":1,"parameters":{"p":{"kind":"unknown","value":1}}}`,
+	}
+	for _, data := range invalid {
+		if _, err := UnmarshalQueryBuilder([]byte(data)); err == nil {
+			t.Errorf("Expected error unmarshalling %s", data)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package vespa
+
+import (
+	"reflect"
+	"strings"
+)
+
+// =============================================================================
+// Simplify
+// =============================================================================
+
+// Simplify returns a logically equivalent, simplified condition tree:
+//
+//   - chains of AND/OR are flattened into a single JunctionCondition,
+//     e.g. ((a AND b) AND c) becomes (a AND b AND c)
+//   - double negation is removed: !(!(a)) becomes a
+//   - true/false constants are folded: (a AND true) becomes a, (a OR true) becomes true
+//   - identical operands of an AND/OR are deduplicated
+//   - OR of integer equality and IN conditions on the same field is merged
+//     into IN, e.g. ((size = 10) OR (size = 11)) becomes (size in (10, 11));
+//     string equalities render as contains and are left as ORs
+//
+// The input tree is not modified.
+func Simplify(condition WhereCondition) WhereCondition {
+	if condition == nil {
+		return nil
+	}
+
+	switch c := condition.(type) {
+	case *BooleanCondition:
+		return simplifyJunction(c.Operator, []WhereCondition{c.Left, c.Right})
+	case *JunctionCondition:
+		return simplifyJunction(c.Operator, c.Conditions)
+	case *NotCondition:
+		return simplifyNot(c)
+	case *SameElementCondition:
+		conditions := make([]WhereCondition, 0, len(c.Conditions))
+		for _, inner := range c.Conditions {
+			if simplified := Simplify(inner); simplified != nil {
+				conditions = append(conditions, simplified)
+			}
+		}
+		return &SameElementCondition{Field: c.Field, Conditions: conditions}
+	default:
+		return condition
+	}
+}
+
+func simplifyNot(nc *NotCondition) WhereCondition {
+	if nc.Condition == nil {
+		return nc
+	}
+
+	inner := Simplify(nc.Condition)
+	switch c := inner.(type) {
+	case *ConstantCondition:
+		return &ConstantCondition{Value: !c.Value}
+	case *NotCondition:
+		return c.Condition
+	default:
+		return &NotCondition{Condition: inner}
+	}
+}
+
+func simplifyJunction(operator string, conditions []WhereCondition) WhereCondition {
+	op := strings.ToUpper(operator)
+	// identity is the constant that can be dropped, the opposite absorbs everything
+	identity := op == "AND"
+
+	var operands []WhereCondition
+	seen := make(map[string]bool)
+	for _, condition := range flattenOperands(op, conditions) {
+		simplified := Simplify(condition)
+		for _, operand := range flattenOperands(op, []WhereCondition{simplified}) {
+			if constant, ok := operand.(*ConstantCondition); ok {
+				if constant.Value == identity {
+					continue
+				}
+				return &ConstantCondition{Value: !identity}
+			}
+			key := operand.ToYQL()
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			operands = append(operands, operand)
+		}
+	}
+
+	if op == "OR" {
+		operands = mergeEqualitiesIntoIn(operands)
+	}
+
+	switch len(operands) {
+	case 0:
+		return &ConstantCondition{Value: identity}
+	case 1:
+		return operands[0]
+	default:
+		return &JunctionCondition{Conditions: operands, Operator: op}
+	}
+}
+
+// flattenOperands expands nested conditions with the same operator into a single operand list
+func flattenOperands(op string, conditions []WhereCondition) []WhereCondition {
+	var operands []WhereCondition
+	for _, condition := range conditions {
+		switch c := condition.(type) {
+		case *BooleanCondition:
+			if strings.EqualFold(c.Operator, op) {
+				operands = append(operands, flattenOperands(op, []WhereCondition{c.Left, c.Right})...)
+				continue
+			}
+		case *JunctionCondition:
+			if strings.EqualFold(c.Operator, op) {
+				operands = append(operands, flattenOperands(op, c.Conditions)...)
+				continue
+			}
+		}
+		if condition != nil {
+			operands = append(operands, condition)
+		}
+	}
+	return operands
+}
+
+// mergeEqualitiesIntoIn merges OR-ed equality and IN conditions on the same
+// field into a single IN condition at the position of the first one. Only
+// string and integer values are merged, since Vespa's in operator supports
+// those; each field must use a single kind of value. String equalities are
+// left alone: they render as contains, a linguistic match that in (an exact
+// attribute match) cannot replace.
+func mergeEqualitiesIntoIn(operands []WhereCondition) []WhereCondition {
+	type group struct {
+		index  int
+		kind   string
+		values []interface{}
+		count  int
+	}
+	groups := make(map[string]*group)
+	var order []string
+
+	for i, operand := range operands {
+		field, kind, values, ok := inValues(operand)
+		if !ok {
+			continue
+		}
+		g, exists := groups[field]
+		if !exists {
+			g = &group{index: i, kind: kind}
+			groups[field] = g
+			order = append(order, field)
+		}
+		if g.kind != kind {
+			// Mixed value kinds cannot share an IN list, leave the field untouched
+			g.kind = ""
+		}
+		g.values = append(g.values, values...)
+		g.count++
+	}
+
+	merged := make(map[int]WhereCondition)
+	skip := make(map[int]bool)
+	for _, field := range order {
+		g := groups[field]
+		if g.count < 2 || g.kind == "" {
+			continue
+		}
+		merged[g.index] = &FieldCondition{Field: field, Operator: IN, Value: dedupeValues(g.values)}
+		for i, operand := range operands {
+			if f, _, _, ok := inValues(operand); ok && f == field && i != g.index {
+				skip[i] = true
+			}
+		}
+	}
+
+	if len(merged) == 0 {
+		return operands
+	}
+
+	result := make([]WhereCondition, 0, len(operands))
+	for i, operand := range operands {
+		if skip[i] {
+			continue
+		}
+		if m, ok := merged[i]; ok {
+			operand = m
+		}
+		result = append(result, operand)
+	}
+	return result
+}
+
+// inValues returns the field and values of an integer equality or of an IN
+// condition whose values are all strings or all integers
+func inValues(condition WhereCondition) (field string, kind string, values []interface{}, ok bool) {
+	fc, isField := condition.(*FieldCondition)
+	if !isField {
+		return "", "", nil, false
+	}
+
+	switch fc.Operator {
+	case EQ:
+		if valueKind(fc.Value) != "integer" {
+			return "", "", nil, false
+		}
+		values = []interface{}{fc.Value}
+	case IN:
+		rv := reflect.ValueOf(fc.Value)
+		if rv.Kind() != reflect.Slice {
+			return "", "", nil, false
+		}
+		for i := 0; i < rv.Len(); i++ {
+			values = append(values, rv.Index(i).Interface())
+		}
+	default:
+		return "", "", nil, false
+	}
+
+	for _, v := range values {
+		k := valueKind(v)
+		if k == "" || (kind != "" && k != kind) {
+			return "", "", nil, false
+		}
+		kind = k
+	}
+	if kind == "" {
+		return "", "", nil, false
+	}
+	return fc.Field, kind, values, true
+}
+
+func valueKind(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return "integer"
+	default:
+		return ""
+	}
+}
+
+func dedupeValues(values []interface{}) []interface{} {
+	seen := make(map[string]bool)
+	result := make([]interface{}, 0, len(values))
+	for _, v := range values {
+		key := formatValue(v)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, v)
+	}
+	return result
+}
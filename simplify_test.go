@@ -0,0 +1,178 @@
+package vespa
+
+import "testing"
+
+func TestSimplify(t *testing.T) {
+	a := Field("a").Gt(1)
+	b := Field("b").Gt(2)
+	c := Field("c").Gt(3)
+
+	tests := []struct {
+		name      string
+		condition WhereCondition
+		expected  string
+	}{
+		{
+			"Flatten AND chain",
+			And(a, b, c, Field("d").Gt(4), Field("e").Gt(5)),
+			"((a > 1) AND (b > 2) AND (c > 3) AND (d > 4) AND (e > 5))",
+		},
+		{
+			"Flatten nested OR",
+			Or(a, Or(b, Or(c, Field("d").Gt(4)))),
+			"((a > 1) OR (b > 2) OR (c > 3) OR (d > 4))",
+		},
+		{
+			"Keep mixed operators nested",
+			And(a, Or(b, c)),
+			"((a > 1) AND ((b > 2) OR (c > 3)))",
+		},
+		{
+			"Remove double negation",
+			Not(Not(a)),
+			"(a > 1)",
+		},
+		{
+			"Keep single negation",
+			Not(Not(Not(a))),
+			"!((a > 1))",
+		},
+		{
+			"Drop true from AND",
+			And(a, True(), b),
+			"((a > 1) AND (b > 2))",
+		},
+		{
+			"False absorbs AND",
+			And(a, False(), b),
+			"false",
+		},
+		{
+			"True absorbs OR",
+			Or(a, True()),
+			"true",
+		},
+		{
+			"Drop false from OR",
+			Or(a, False()),
+			"(a > 1)",
+		},
+		{
+			"Negated constant",
+			And(a, Not(False())),
+			"(a > 1)",
+		},
+		{
+			"Dedupe identical operands",
+			And(a, b, Field("a").Gt(1)),
+			"((a > 1) AND (b > 2))",
+		},
+		{
+			"Do not merge string equalities",
+			Or(Field("title").Eq("a"), Field("title").Eq("b")),
+			"((title contains 'a') OR (title contains 'b'))",
+		},
+		{
+			"Do not merge string equality into in",
+			Or(Field("brand").Eq("nike"), Field("brand").In("adidas", "puma")),
+			"((brand contains 'nike') OR (brand in ('adidas', 'puma')))",
+		},
+		{
+			"Merge string in conditions",
+			Or(Field("brand").In("nike", "adidas"), Field("brand").In("puma", "nike")),
+			"(brand in ('nike', 'adidas', 'puma'))",
+		},
+		{
+			"Merge integer equalities and in",
+			Or(Field("size").Eq(10), a, Field("size").In(11, 10)),
+			"((size in (10, 11)) OR (a > 1))",
+		},
+		{
+			"Do not merge floats",
+			Or(Field("rating").Eq(4.5), Field("rating").Eq(5.0)),
+			"((rating = 4.5) OR (rating = 5))",
+		},
+		{
+			"Do not merge mixed kinds",
+			Or(Field("size").Eq(10), Field("size").Eq("XL")),
+			"((size = 10) OR (size contains 'XL'))",
+		},
+		{
+			"Do not merge equalities under AND",
+			And(Field("brand").Eq("nike"), Field("brand").Eq("adidas")),
+			"((brand contains 'nike') AND (brand contains 'adidas'))",
+		},
+		{
+			"Simplify inside sameElement",
+			Field("sizes").ContainsSameElement(Not(Not(Field("family").Eq("US")))),
+			"(sizes contains sameElement((family contains 'US')))",
+		},
+		{
+			"Flatten after removing double negation",
+			And(a, Not(Not(And(b, c)))),
+			"((a > 1) AND (b > 2) AND (c > 3))",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := Simplify(tt.condition).ToYQL()
+			if result != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestSimplifyDoesNotModifyInput(t *testing.T) {
+	condition := And(Field("a").Gt(1), And(Field("b").Gt(2), True()))
+	before := condition.ToYQL()
+
+	Simplify(condition)
+
+	if condition.ToYQL() != before {
+		t.Errorf("Expected input to be unchanged, got %q", condition.ToYQL())
+	}
+}
+
+func TestQueryBuilder_Optimize(t *testing.T) {
+	tests := []struct {
+		name     string
+		builder  QueryBuilder
+		expected string
+	}{
+		{
+			"Flatten and merge",
+			NewQueryBuilder().
+				From("products").
+				Where(And(Field("a").Gt(1), Field("b").Gt(2), Field("c").Gt(3))).
+				Where(Or(Field("brand").In("nike"), Field("brand").In("adidas"))).
+				Where(Field("a").Gt(1)).
+				Rank(NewRank().AddCondition(Not(Not(Field("color").Contains("red"))))).
+				Optimize(),
+			"select * from sources products where (a > 1) and (b > 2) and (c > 3) and (brand in ('nike', 'adidas')) and rank((color contains 'red'))",
+		},
+		{
+			"All conditions true",
+			NewQueryBuilder().From("products").Where(True()).Where(Or(Field("a").Gt(1), True())).Optimize(),
+			"select * from sources products where true",
+		},
+		{
+			"Contradiction",
+			NewQueryBuilder().From("products").Where(Field("a").Gt(1)).Where(False()).Optimize(),
+			"select * from sources products where false",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yql, err := tt.builder.BuildYQL()
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if yql != tt.expected {
+				t.Errorf("Expected YQL %q, got %q", tt.expected, yql)
+			}
+		})
+	}
+}
@@ -0,0 +1,487 @@
+package vespa
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vipulsodha/vespa-go/tensor"
+)
+
+// placeholderMarker delimits placeholder names in YQL rendered for a template
+const placeholderMarker = "\x00"
+
+// =============================================================================
+// Placeholders
+// =============================================================================
+
+// ParamKind is the value type of a template parameter
+type ParamKind string
+
+const (
+	StringParam     ParamKind = "string"
+	IntParam        ParamKind = "int"
+	FloatParam      ParamKind = "float"
+	BoolParam       ParamKind = "bool"
+	StringListParam ParamKind = "[]string" // the values of an in/not in condition
+	IntListParam    ParamKind = "[]int"    // the values of an in/not in condition
+	VectorParam     ParamKind = "vector"   // a query input or request parameter value
+)
+
+// Placeholder is a typed hole in a query template, filled in when the
+// template is executed. Use it as a condition value, as the value of an
+// in/not in condition, or as an input or parameter value.
+type Placeholder struct {
+	Name string
+	Kind ParamKind
+}
+
+// Param creates a placeholder for a value supplied when the template is executed.
+//
+// Example:
+//
+//	Field("category").Eq(Param("category", StringParam))
+//	Field("brand").In(Param("brands", StringListParam))
+//	WithQueryInput(TensorInput("q", vectorType), Param("vector", VectorParam))
+func Param(name string, kind ParamKind) *Placeholder {
+	return &Placeholder{Name: name, Kind: kind}
+}
+
+// marker renders the placeholder as it appears in template YQL
+func (p *Placeholder) marker() string {
+	return placeholderMarker + p.Name + placeholderMarker
+}
+
+func (p *Placeholder) isList() bool {
+	return p.Kind == StringListParam || p.Kind == IntListParam
+}
+
+// isStringValue reports whether a condition value renders as a string
+func isStringValue(value interface{}) bool {
+	switch v := value.(type) {
+	case string:
+		return true
+	case *Placeholder:
+		return v.Kind == StringParam
+	case maskedValue:
+		return v.text
+	default:
+		return false
+	}
+}
+
+// check reports whether a value can fill a placeholder of the kind
+func (k ParamKind) check(value interface{}) bool {
+	switch k {
+	case StringParam:
+		_, ok := value.(string)
+		return ok
+	case IntParam:
+		return valueKind(value) == "integer"
+	case FloatParam:
+		switch value.(type) {
+		case float32, float64:
+			return true
+		}
+		return valueKind(value) == "integer"
+	case BoolParam:
+		_, ok := value.(bool)
+		return ok
+	case StringListParam:
+		list, ok := value.([]string)
+		return ok && len(list) > 0
+	case IntListParam:
+		switch list := value.(type) {
+		case []int:
+			return len(list) > 0
+		case []int64:
+			return len(list) > 0
+		}
+		return false
+	case VectorParam:
+		switch value.(type) {
+		case []float32, []float64, *tensor.Tensor, *Embedding:
+			return true
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// render formats a checked value as YQL
+func (k ParamKind) render(value interface{}) string {
+	switch list := value.(type) {
+	case []string:
+		formatted := make([]string, len(list))
+		for i, v := range list {
+			formatted[i] = formatValue(v)
+		}
+		return strings.Join(formatted, ", ")
+	case []int, []int64:
+		return strings.Trim(formatInValues(list), "()")
+	default:
+		return formatValue(value)
+	}
+}
+
+// =============================================================================
+// Template
+// =============================================================================
+
+// Template is a query shape with typed placeholders that is validated and
+// rendered once, then executed per request by filling in the placeholders.
+// Templates are immutable and safe for concurrent use.
+type Template struct {
+	segments    []string // YQL split at placeholders: literal, name, literal, ...
+	base        *VespaQuery
+	params      map[string]ParamKind
+	inputs      []templateInput
+	parameters  map[string]string // request parameter name -> placeholder name
+	hitsParam   string
+	offsetParam string
+}
+
+// templateInput is a query input whose value is a placeholder
+type templateInput struct {
+	key   string
+	input *QueryInput // nil for inputs added with WithInput
+	param string
+}
+
+// TemplateOption configures a template
+type TemplateOption func(*Template)
+
+// WithHitsParam sets the number of hits from the named IntParam on execution
+func WithHitsParam(name string) TemplateOption {
+	return func(t *Template) {
+		if t != nil {
+			t.hitsParam = name
+		}
+	}
+}
+
+// WithOffsetParam sets the hit offset from the named IntParam on execution
+func WithOffsetParam(name string) TemplateOption {
+	return func(t *Template) {
+		if t != nil {
+			t.offsetParam = name
+		}
+	}
+}
+
+// NewTemplate validates and renders a builder containing placeholders into a
+// reusable template. The builder is not modified.
+//
+// Example:
+//
+//	browse, err := NewTemplate(
+//		NewQueryBuilder().
+//			From("products").
+//			Where(Field("category").Eq(Param("category", StringParam))).
+//			Where(Field("price").Lte(Param("max_price", FloatParam))),
+//		WithHitsParam("hits"),
+//	)
+//	query, err := browse.Execute(map[string]interface{}{"category": "shoes", "max_price": 100.0, "hits": 20})
+func NewTemplate(builder QueryBuilder, opts ...TemplateOption) (*Template, error) {
+	var qb *QueryBuilderImpl
+	switch b := builder.(type) {
+	case *QueryBuilderImpl:
+		qb = b.clone()
+	case *immutableQueryBuilder:
+		qb = b.qb.clone()
+	default:
+		return nil, fmt.Errorf("templates require a builder created by NewQueryBuilder or NewImmutableQueryBuilder, got %T", builder)
+	}
+
+	t := &Template{params: make(map[string]ParamKind), parameters: make(map[string]string)}
+	for _, opt := range opts {
+		opt(t)
+	}
+	for _, name := range []string{t.hitsParam, t.offsetParam} {
+		if name != "" {
+			if err := t.declare(Param(name, IntParam)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := t.collectConditionParams(qb); err != nil {
+		return nil, err
+	}
+	if err := t.extractValueParams(qb); err != nil {
+		return nil, err
+	}
+
+	if err := qb.validate(); err != nil {
+		return nil, err
+	}
+	yql := qb.renderYQL()
+	t.segments = strings.Split(yql, placeholderMarker)
+	for i := 1; i < len(t.segments); i += 2 {
+		if _, ok := t.params[t.segments[i]]; !ok {
+			return nil, &ValidationError{
+				Field:   "yql",
+				Message: fmt.Sprintf("unexpected placeholder '%s' in YQL", t.segments[i]),
+			}
+		}
+	}
+	t.base = qb.buildQuery(yql)
+	return t, nil
+}
+
+// declare registers a placeholder, rejecting names used with different kinds
+func (t *Template) declare(p *Placeholder) error {
+	if !tensor.IsIdentifier(p.Name) {
+		return &ValidationError{Field: "template", Message: fmt.Sprintf("invalid parameter name '%s'", p.Name)}
+	}
+	if kind, ok := t.params[p.Name]; ok && kind != p.Kind {
+		return &ValidationError{
+			Field:   p.Name,
+			Message: fmt.Sprintf("parameter is declared as both %s and %s", kind, p.Kind),
+		}
+	}
+	t.params[p.Name] = p.Kind
+	return nil
+}
+
+// collectConditionParams declares the placeholders of the where and rank
+// conditions and checks that each is used where its kind can be rendered
+func (t *Template) collectConditionParams(qb *QueryBuilderImpl) error {
+	conditions := append([]WhereCondition(nil), qb.whereConditions...)
+	if rank, ok := qb.rankExpression.(*RankExpressionImpl); ok {
+		conditions = append(conditions, rank.Conditions()...)
+	}
+
+	var err error
+	check := func(value interface{}, listAllowed bool) {
+		p, ok := value.(*Placeholder)
+		if !ok || err != nil {
+			return
+		}
+		switch {
+		case p.Kind == VectorParam:
+			err = &ValidationError{Field: p.Name, Message: "vector parameters can only be used as input or parameter values"}
+		case p.isList() && !listAllowed:
+			err = &ValidationError{Field: p.Name, Message: "list parameters can only be used as the values of in and not in"}
+		default:
+			err = t.declare(p)
+		}
+	}
+
+	for _, condition := range conditions {
+		Inspect(condition, func(c WhereCondition) bool {
+			switch v := c.(type) {
+			case *FieldCondition:
+				if list, ok := v.Value.([]interface{}); ok && (v.Operator == IN || v.Operator == NOT_IN) {
+					for _, item := range list {
+						check(item, len(list) == 1)
+					}
+				} else {
+					check(v.Value, false)
+				}
+			case *RangeCondition:
+				check(v.Min, false)
+				check(v.Max, false)
+			}
+			return err == nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractValueParams removes inputs and parameters whose value is a
+// placeholder from the builder, so the remaining state can be built once
+func (t *Template) extractValueParams(qb *QueryBuilderImpl) error {
+	for _, key := range sortedInputKeys(qb.inputParams) {
+		value := qb.inputParams[key]
+		var input *QueryInput
+		if typed, ok := value.(typedInputValue); ok {
+			typedInput := typed.input
+			input = &typedInput
+			value = typed.value
+		}
+		p, ok := value.(*Placeholder)
+		if !ok {
+			continue
+		}
+		if err := t.declare(p); err != nil {
+			return err
+		}
+		t.inputs = append(t.inputs, templateInput{key: key, input: input, param: p.Name})
+		delete(qb.inputParams, key)
+	}
+
+	for _, name := range sortedInputKeys(qb.parameters) {
+		p, ok := qb.parameters[name].(*Placeholder)
+		if !ok {
+			continue
+		}
+		if err := t.declare(p); err != nil {
+			return err
+		}
+		t.parameters[name] = p.Name
+		delete(qb.parameters, name)
+	}
+	return nil
+}
+
+// Params returns the parameters of the template and their kinds
+func (t *Template) Params() map[string]ParamKind {
+	params := make(map[string]ParamKind, len(t.params))
+	for name, kind := range t.params {
+		params[name] = kind
+	}
+	return params
+}
+
+// Execute fills the placeholders with the given values and returns the
+// query. Every parameter must be given a value of its declared kind.
+func (t *Template) Execute(values map[string]interface{}) (*VespaQuery, error) {
+	if err := t.checkValues(values); err != nil {
+		return nil, err
+	}
+
+	// Every query gets its own maps and ranking, so results never share
+	// state with the template or with each other
+	query := *t.base
+	query.Input = copyValues(t.base.Input)
+	query.Parameters = copyValues(t.base.Parameters)
+	if t.base.Ranking != nil {
+		ranking := t.base.Ranking.clone()
+		query.Ranking = &ranking
+	}
+
+	var yql strings.Builder
+	for i, segment := range t.segments {
+		if i%2 == 0 {
+			yql.WriteString(segment)
+		} else {
+			yql.WriteString(t.params[segment].render(values[segment]))
+		}
+	}
+	query.YQL = yql.String()
+
+	if len(t.parameters) > 0 {
+		if query.Parameters == nil {
+			query.Parameters = make(map[string]interface{}, len(t.parameters))
+		}
+		for name, param := range t.parameters {
+			query.Parameters[name] = values[param]
+		}
+	}
+
+	if len(t.inputs) > 0 {
+		if query.Input == nil {
+			query.Input = make(map[string]interface{}, len(t.inputs))
+		}
+		for _, in := range t.inputs {
+			value := values[in.param]
+			if in.input != nil {
+				serialized, err := in.input.Serialize(value)
+				if err != nil {
+					return nil, err
+				}
+				value = serialized
+			}
+			// Like the builder, embed() texts are bound to their request parameter
+			if embedding, ok := value.(*Embedding); ok {
+				if query.Parameters == nil {
+					query.Parameters = make(map[string]interface{})
+				}
+				if err := embedding.bind(in.key, query.Parameters); err != nil {
+					return nil, err
+				}
+			}
+			query.Input[in.key] = value
+		}
+	}
+
+	if t.hitsParam != "" {
+		query.Hits = int(toInt64(values[t.hitsParam]))
+	}
+	if t.offsetParam != "" {
+		query.Offset = int(toInt64(values[t.offsetParam]))
+	}
+
+	return &query, nil
+}
+
+// checkValues checks that values match the declared parameters exactly
+func (t *Template) checkValues(values map[string]interface{}) error {
+	names := make([]string, 0, len(t.params))
+	for name := range t.params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value, ok := values[name]
+		if !ok {
+			return &ValidationError{Field: name, Message: "missing template parameter"}
+		}
+		if kind := t.params[name]; !kind.check(value) {
+			return &ValidationError{
+				Field:   name,
+				Message: fmt.Sprintf("expected a %s value, got %T", kind, value),
+			}
+		}
+	}
+
+	for _, name := range sortedInputKeys(values) {
+		if _, ok := t.params[name]; !ok {
+			return &ValidationError{Field: name, Message: "unknown template parameter"}
+		}
+	}
+
+	if t.hitsParam != "" && toInt64(values[t.hitsParam]) < 0 {
+		return &ValidationError{Field: t.hitsParam, Message: "hits must not be negative"}
+	}
+	if t.offsetParam != "" && toInt64(values[t.offsetParam]) < 0 {
+		return &ValidationError{Field: t.offsetParam, Message: "offset must not be negative"}
+	}
+	return nil
+}
+
+// copyValues returns a shallow copy of values, or nil if values is nil
+func copyValues(values map[string]interface{}) map[string]interface{} {
+	if values == nil {
+		return nil
+	}
+	copied := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		copied[k] = v
+	}
+	return copied
+}
+
+// toInt64 converts an integer value of any Go integer type
+func toInt64(value interface{}) int64 {
+	switch v := value.(type) {
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case uint:
+		return int64(v)
+	case uint8:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case uint64:
+		return int64(v)
+	default:
+		return 0
+	}
+}
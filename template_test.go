@@ -0,0 +1,286 @@
+package vespa
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/vipulsodha/vespa-go/tensor"
+)
+
+func TestTemplateExecute(t *testing.T) {
+	vectorType := tensor.MustType(tensor.Float, tensor.Indexed("x", 3))
+
+	template, err := NewTemplate(
+		NewQueryBuilder().
+			From("products").
+			Where(Field("category").Eq(Param("category", StringParam))).
+			Where(Field("price").Between(Param("min_price", FloatParam), Param("max_price", FloatParam))).
+			Where(Field("brand").In(Param("brands", StringListParam))).
+			Where(Field("color").NotEq(Param("excluded_color", StringParam))).
+			Where(Field("embedding").NearestNeighbor("q", 100)).
+			WithQueryInput(TensorInput("q", vectorType), Param("vector", VectorParam)).
+			WithParameter("trace.level", Param("trace", IntParam)).
+			WithRanking("hybrid"),
+		WithHitsParam("hits"),
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	query, err := template.Execute(map[string]interface{}{
+		"category":       "men's shoes",
+		"min_price":      10,
+		"max_price":      99.5,
+		"brands":         []string{"nike", "adidas"},
+		"excluded_color": "red",
+		"vector":         []float32{0.1, 0.2, 0.3},
+		"trace":          2,
+		"hits":           20,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expectedYQL := "select * from sources products where (category contains 'men\\'s shoes') and " +
+		"((price >= 10) and (price <= 99.5)) and (brand in ('nike', 'adidas')) and !(color contains 'red') and " +
+		"({targetHits:100}nearestNeighbor(embedding, q))"
+	if query.YQL != expectedYQL {
+		t.Errorf("Expected YQL %q, got %q", expectedYQL, query.YQL)
+	}
+	if query.Hits != 20 {
+		t.Errorf("Expected 20 hits, got %d", query.Hits)
+	}
+	if query.Ranking == nil || query.Ranking.Profile != "hybrid" {
+		t.Errorf("Expected ranking profile hybrid, got %v", query.Ranking)
+	}
+	if _, ok := query.Input["input.query(q)"].(*tensor.Tensor); !ok {
+		t.Errorf("Expected vector input to be serialized as a tensor, got %T", query.Input["input.query(q)"])
+	}
+	if query.Parameters["trace.level"] != 2 {
+		t.Errorf("Expected trace.level parameter 2, got %v", query.Parameters["trace.level"])
+	}
+
+	// The output matches building the same query directly
+	vector, _ := tensor.NewDenseFloat32(vectorType, []float32{0.1, 0.2, 0.3})
+	direct, err := NewQueryBuilder().
+		From("products").
+		Where(Field("category").Eq("men's shoes")).
+		Where(Field("price").Between(10, 99.5)).
+		Where(Field("brand").In("nike", "adidas")).
+		Where(Field("color").NotEq("red")).
+		Where(Field("embedding").NearestNeighbor("q", 100)).
+		WithQueryInput(TensorInput("q", vectorType), vector).
+		WithParameter("trace.level", 2).
+		WithRanking("hybrid").
+		WithHits(20).
+		Build()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if direct.YQL != query.YQL {
+		t.Errorf("Expected template YQL to match builder YQL %q, got %q", direct.YQL, query.YQL)
+	}
+}
+
+func TestTemplateExecuteIsolation(t *testing.T) {
+	template, err := NewTemplate(
+		NewQueryBuilder().
+			From("products").
+			Where(Field("category").Eq(Param("category", StringParam))).
+			WithInput("input.query(boost)", 2).
+			WithParameter("trace.level", 1).
+			WithRanking("hybrid", WithMatchPhase("popularity", 1000, false)),
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	values := map[string]interface{}{"category": "shoes"}
+	first, err := template.Execute(values)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	first.Input["input.query(boost)"] = 5
+	first.Parameters["trace.level"] = 9
+	first.Ranking.Profile = "other"
+	first.Ranking.MatchPhase.MaxHits = 10
+
+	second, err := template.Execute(values)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if second.Input["input.query(boost)"] != 2 {
+		t.Errorf("Expected input 2, got %v", second.Input["input.query(boost)"])
+	}
+	if second.Parameters["trace.level"] != 1 {
+		t.Errorf("Expected trace.level parameter 1, got %v", second.Parameters["trace.level"])
+	}
+	if second.Ranking.Profile != "hybrid" {
+		t.Errorf("Expected ranking profile %q, got %q", "hybrid", second.Ranking.Profile)
+	}
+	if second.Ranking.MatchPhase.MaxHits != 1000 {
+		t.Errorf("Expected match phase max hits 1000, got %d", second.Ranking.MatchPhase.MaxHits)
+	}
+}
+
+func TestTemplateExecuteEmbedding(t *testing.T) {
+	vectorType := tensor.MustType(tensor.Float, tensor.Indexed("x", 3))
+	template, err := NewTemplate(
+		NewQueryBuilder().
+			From("products").
+			Where(Field("embedding").NearestNeighbor("q", 100)).
+			WithQueryInput(TensorInput("q", vectorType), Param("query", VectorParam)).
+			WithParameter("lang", "en"),
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	query, err := template.Execute(map[string]interface{}{"query": Embed("e5", "running shoes").BindTo("text")})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if query.Parameters["text"] != "running shoes" {
+		t.Errorf("Expected text parameter %q, got %v", "running shoes", query.Parameters["text"])
+	}
+	if _, ok := query.Input["input.query(q)"].(*Embedding); !ok {
+		t.Errorf("Expected embedding input, got %T", query.Input["input.query(q)"])
+	}
+
+	tests := []struct {
+		name      string
+		embedding *Embedding
+		expected  string
+	}{
+		{"Empty text", Embed("e5", ""), "embed() requires a non-empty text"},
+		{"Invalid parameter name", Embed("e5", "shoes").BindTo("my text"), "invalid embed() text parameter name 'my text'"},
+		{"Parameter clash", Embed("e5", "shoes").BindTo("lang"), "embed() text parameter 'lang' is already bound to a different value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := template.Execute(map[string]interface{}{"query": tt.embedding})
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) || validationErr.Message != tt.expected {
+				t.Errorf("Expected %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestTemplateValidation(t *testing.T) {
+	template, err := NewTemplate(
+		NewImmutableQueryBuilder().
+			From("products").
+			Where(Field("category").Eq(Param("category", StringParam))).
+			Where(Field("id").In(Param("ids", IntListParam))),
+		WithHitsParam("hits"),
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	valid := map[string]interface{}{"category": "shoes", "ids": []int{1, 2}, "hits": 10}
+	if _, err := template.Execute(valid); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		values map[string]interface{}
+		field  string
+	}{
+		{"Missing value", map[string]interface{}{"ids": []int{1}, "hits": 10}, "category"},
+		{"Wrong kind", map[string]interface{}{"category": 5, "ids": []int{1}, "hits": 10}, "category"},
+		{"Empty list", map[string]interface{}{"category": "a", "ids": []int{}, "hits": 10}, "ids"},
+		{"Unknown parameter", map[string]interface{}{"category": "a", "ids": []int{1}, "hits": 10, "extra": 1}, "extra"},
+		{"Negative hits", map[string]interface{}{"category": "a", "ids": []int{1}, "hits": -1}, "hits"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := template.Execute(tt.values)
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("Expected ValidationError, got %v", err)
+			}
+			if validationErr.Field != tt.field {
+				t.Errorf("Expected error for field %q, got %q", tt.field, validationErr.Field)
+			}
+		})
+	}
+
+	params := template.Params()
+	if len(params) != 3 || params["ids"] != IntListParam || params["hits"] != IntParam {
+		t.Errorf("Unexpected params %v", params)
+	}
+}
+
+func TestNewTemplateErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		builder QueryBuilder
+	}{
+		{"Conflicting kinds", NewQueryBuilder().From("p").
+			Where(Field("a").Eq(Param("x", StringParam))).
+			Where(Field("b").Gt(Param("x", IntParam)))},
+		{"List outside in", NewQueryBuilder().From("p").
+			Where(Field("a").Eq(Param("x", StringListParam)))},
+		{"Vector in condition", NewQueryBuilder().From("p").
+			Where(Field("a").Eq(Param("x", VectorParam)))},
+		{"Invalid name", NewQueryBuilder().From("p").
+			Where(Field("a").Eq(Param("not a name", StringParam)))},
+		{"Invalid builder", NewQueryBuilder().
+			Where(Field("a").Eq(Param("x", StringParam)))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewTemplate(tt.builder); err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+}
+
+func TestBuildRejectsPlaceholders(t *testing.T) {
+	_, err := NewQueryBuilder().
+		From("products").
+		Where(Field("category").Eq(Param("category", StringParam))).
+		Build()
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Field != "yql" {
+		t.Errorf("Expected ValidationError for unbound placeholders, got %v", err)
+	}
+}
+
+func TestTemplateConcurrentExecute(t *testing.T) {
+	template, err := NewTemplate(
+		NewQueryBuilder().From("products").Where(Field("category").Eq(Param("category", StringParam))),
+		WithHitsParam("hits"),
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			category := fmt.Sprintf("c%d", i)
+			query, err := template.Execute(map[string]interface{}{"category": category, "hits": i})
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+				return
+			}
+			expected := fmt.Sprintf("select * from sources products where (category contains '%s')", category)
+			if query.YQL != expected || query.Hits != i {
+				t.Errorf("Expected %q with %d hits, got %q with %d", expected, i, query.YQL, query.Hits)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,172 @@
+package tensor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Decode parses a tensor of the given type from any JSON form produced by
+// Encode: short form ("values", "cells" or "blocks"), long form or hex form.
+func Decode(t Type, data []byte) (*Tensor, error) {
+	var raw struct {
+		Values json.RawMessage `json:"values"`
+		Cells  json.RawMessage `json:"cells"`
+		Blocks json.RawMessage `json:"blocks"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("tensor: %w", err)
+	}
+
+	tensor := New(t)
+	var err error
+	switch {
+	case raw.Values != nil:
+		err = tensor.decodeValues(raw.Values)
+	case raw.Cells != nil:
+		err = tensor.decodeCells(raw.Cells)
+	case raw.Blocks != nil:
+		err = tensor.decodeBlocks(raw.Blocks)
+	default:
+		err = fmt.Errorf("tensor: expected values, cells or blocks")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tensor, nil
+}
+
+func (t *Tensor) decodeValues(data json.RawMessage) error {
+	if !t.typ.IsDense() {
+		return fmt.Errorf("tensor: values require a dense type, got %s", t.typ)
+	}
+	values, err := t.blockFromJSON(data)
+	if err != nil {
+		return err
+	}
+	if len(values) != t.typ.denseSize() {
+		return fmt.Errorf("tensor: type %s requires %d values, got %d", t.typ, t.typ.denseSize(), len(values))
+	}
+	return t.setDenseBlock(Address{}, values)
+}
+
+func (t *Tensor) decodeCells(data json.RawMessage) error {
+	if isJSONArray(data) {
+		var cells []struct {
+			Address Address `json:"address"`
+			Value   float64 `json:"value"`
+		}
+		if err := json.Unmarshal(data, &cells); err != nil {
+			return fmt.Errorf("tensor: %w", err)
+		}
+		for _, cell := range cells {
+			if err := t.Set(cell.Address, cell.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	mapped := t.typ.mappedDimensions()
+	if !t.typ.IsSparse() || len(mapped) != 1 {
+		return fmt.Errorf("tensor: cells by label require a sparse type with one mapped dimension, got %s", t.typ)
+	}
+	var cells map[string]float64
+	if err := json.Unmarshal(data, &cells); err != nil {
+		return fmt.Errorf("tensor: %w", err)
+	}
+	for label, value := range cells {
+		if err := t.Set(Address{mapped[0].Name: label}, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Tensor) decodeBlocks(data json.RawMessage) error {
+	if isJSONArray(data) {
+		var blocks []struct {
+			Address Address         `json:"address"`
+			Values  json.RawMessage `json:"values"`
+		}
+		if err := json.Unmarshal(data, &blocks); err != nil {
+			return fmt.Errorf("tensor: %w", err)
+		}
+		for _, block := range blocks {
+			values, err := t.blockFromJSON(block.Values)
+			if err != nil {
+				return err
+			}
+			if err := t.SetBlock(block.Address, values...); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	mapped := t.typ.mappedDimensions()
+	if len(mapped) != 1 {
+		return fmt.Errorf("tensor: blocks by label require a type with one mapped dimension, got %s", t.typ)
+	}
+	var blocks map[string]json.RawMessage
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		return fmt.Errorf("tensor: %w", err)
+	}
+	for label, raw := range blocks {
+		values, err := t.blockFromJSON(raw)
+		if err != nil {
+			return err
+		}
+		if err := t.SetBlock(Address{mapped[0].Name: label}, values...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blockFromJSON parses dense values given as a number array or a hex string
+func (t *Tensor) blockFromJSON(data json.RawMessage) ([]float64, error) {
+	var hex string
+	if err := json.Unmarshal(data, &hex); err == nil {
+		return t.unhex(hex)
+	}
+	var values []float64
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("tensor: %w", err)
+	}
+	return values, nil
+}
+
+// unhex decodes big-endian binary cells of the tensor's cell type
+func (t *Tensor) unhex(hex string) ([]float64, error) {
+	width := map[CellType]int{Double: 16, Float: 8, BFloat16: 4, Int8: 2}[t.typ.CellType]
+	if len(hex)%width != 0 {
+		return nil, fmt.Errorf("tensor: hex string length %d is not a multiple of %d", len(hex), width)
+	}
+
+	values := make([]float64, 0, len(hex)/width)
+	for i := 0; i < len(hex); i += width {
+		bits, err := strconv.ParseUint(hex[i:i+width], 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("tensor: invalid hex value '%s'", hex[i:i+width])
+		}
+		switch t.typ.CellType {
+		case Float:
+			values = append(values, float64(math.Float32frombits(uint32(bits))))
+		case BFloat16:
+			values = append(values, float64(math.Float32frombits(uint32(bits)<<16)))
+		case Int8:
+			values = append(values, float64(int8(bits)))
+		default:
+			values = append(values, math.Float64frombits(bits))
+		}
+	}
+	return values, nil
+}
+
+func isJSONArray(data json.RawMessage) bool {
+	trimmed := bytes.TrimSpace(data)
+	return len(trimmed) > 0 && trimmed[0] == '['
+}
@@ -0,0 +1,250 @@
+package tensor
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Form selects the JSON representation of a tensor
+type Form int
+
+const (
+	// ShortForm uses "values" for dense, "cells" for single-mapped-dimension
+	// sparse and "blocks" for mixed tensors, falling back to LongForm for
+	// sparse tensors with several mapped dimensions.
+	ShortForm Form = iota
+	// LongForm lists every cell with its full address
+	LongForm
+	// HexForm encodes dense values and mixed blocks as a hex string of the
+	// binary cell representation. Sparse tensors cannot be hex encoded.
+	HexForm
+)
+
+// MarshalJSON serializes the tensor in short form
+func (t *Tensor) MarshalJSON() ([]byte, error) {
+	return t.Encode(ShortForm)
+}
+
+// Encode serializes the tensor into the given JSON form
+func (t *Tensor) Encode(form Form) ([]byte, error) {
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	var err error
+	switch form {
+	case ShortForm:
+		value = t.shortForm()
+	case LongForm:
+		value = t.longForm()
+	case HexForm:
+		value, err = t.hexForm()
+	default:
+		err = fmt.Errorf("tensor: unknown form %d", form)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+func (t *Tensor) shortForm() interface{} {
+	mapped := t.typ.mappedDimensions()
+	switch {
+	case t.typ.IsDense():
+		return map[string]interface{}{"values": t.numbers(t.blockValues(Address{}))}
+	case t.typ.IsSparse() && len(mapped) == 1:
+		cells := make(map[string]json.Number, len(t.cells))
+		for _, cell := range t.cells {
+			cells[cell.Address[mapped[0].Name]] = t.number(cell.Value)
+		}
+		return map[string]interface{}{"cells": cells}
+	case t.typ.IsMixed():
+		return t.blocksForm(func(values []float64) interface{} { return t.numbers(values) })
+	default:
+		return t.longForm()
+	}
+}
+
+func (t *Tensor) longForm() interface{} {
+	type longCell struct {
+		Address Address     `json:"address"`
+		Value   json.Number `json:"value"`
+	}
+
+	cells := t.Cells()
+	if t.typ.IsDense() {
+		// Long form lists every cell, including the zero-valued unset ones
+		values := t.blockValues(Address{})
+		full, _ := NewDense(t.typ, values...)
+		cells = full.Cells()
+	}
+
+	long := make([]longCell, len(cells))
+	for i, cell := range cells {
+		long[i] = longCell{Address: cell.Address, Value: t.number(cell.Value)}
+	}
+	return map[string]interface{}{"cells": long}
+}
+
+func (t *Tensor) hexForm() (interface{}, error) {
+	switch {
+	case t.typ.IsDense():
+		return map[string]interface{}{"values": t.hex(t.blockValues(Address{}))}, nil
+	case t.typ.IsMixed():
+		return t.blocksForm(func(values []float64) interface{} { return t.hex(values) }), nil
+	default:
+		return nil, fmt.Errorf("tensor: sparse type %s cannot be hex encoded", t.typ)
+	}
+}
+
+// blocksForm renders mixed tensors as {"blocks": {label: values}} for a single
+// mapped dimension and as a list of addressed blocks otherwise
+func (t *Tensor) blocksForm(encode func([]float64) interface{}) interface{} {
+	mapped := t.typ.mappedDimensions()
+	blocks := t.blocks()
+
+	if len(mapped) == 1 {
+		byLabel := make(map[string]interface{}, len(blocks))
+		for _, block := range blocks {
+			byLabel[block[mapped[0].Name]] = encode(t.blockValues(block))
+		}
+		return map[string]interface{}{"blocks": byLabel}
+	}
+
+	type addressedBlock struct {
+		Address Address     `json:"address"`
+		Values  interface{} `json:"values"`
+	}
+	list := make([]addressedBlock, len(blocks))
+	for i, block := range blocks {
+		list[i] = addressedBlock{Address: block, Values: encode(t.blockValues(block))}
+	}
+	return map[string]interface{}{"blocks": list}
+}
+
+// hex encodes values as big-endian binary cells of the tensor's cell type
+func (t *Tensor) hex(values []float64) string {
+	var sb strings.Builder
+	for _, v := range values {
+		switch t.typ.CellType {
+		case Float:
+			fmt.Fprintf(&sb, "%08X", math.Float32bits(float32(v)))
+		case BFloat16:
+			fmt.Fprintf(&sb, "%04X", math.Float32bits(float32(v))>>16)
+		case Int8:
+			fmt.Fprintf(&sb, "%02X", uint8(int8(v)))
+		default:
+			fmt.Fprintf(&sb, "%016X", math.Float64bits(v))
+		}
+	}
+	return sb.String()
+}
+
+func (t *Tensor) numbers(values []float64) []json.Number {
+	numbers := make([]json.Number, len(values))
+	for i, v := range values {
+		numbers[i] = t.number(v)
+	}
+	return numbers
+}
+
+// number formats a value with the precision of the tensor's cell type
+func (t *Tensor) number(v float64) json.Number {
+	return json.Number(t.formatValue(v))
+}
+
+func (t *Tensor) formatValue(v float64) string {
+	switch t.typ.CellType {
+	case Float, BFloat16:
+		return strconv.FormatFloat(v, 'g', -1, 32)
+	case Int8:
+		return strconv.FormatInt(int64(v), 10)
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// =============================================================================
+// Tensor literal form
+// =============================================================================
+
+// Literal returns the tensor in Vespa's tensor literal form, as accepted by
+// query parameters such as input.query(q), e.g. tensor<float>(x[3]):[1,2,3]
+func (t *Tensor) Literal() string {
+	mapped := t.typ.mappedDimensions()
+
+	var body string
+	switch {
+	case t.typ.IsDense() && len(t.typ.Dimensions) > 0:
+		body = t.denseLiteral(t.blockValues(Address{}), t.typ.indexedDimensions())
+	case t.typ.IsSparse() && len(mapped) == 1:
+		var parts []string
+		for _, cell := range t.Cells() {
+			parts = append(parts, fmt.Sprintf("%s:%s", quoteLabel(cell.Address[mapped[0].Name]), t.formatValue(cell.Value)))
+		}
+		body = "{" + strings.Join(parts, ",") + "}"
+	case t.typ.IsMixed() && len(mapped) == 1:
+		var parts []string
+		for _, block := range t.blocks() {
+			values := t.denseLiteral(t.blockValues(block), t.typ.indexedDimensions())
+			parts = append(parts, fmt.Sprintf("%s:%s", quoteLabel(block[mapped[0].Name]), values))
+		}
+		body = "{" + strings.Join(parts, ",") + "}"
+	default:
+		body = t.verboseLiteral()
+	}
+
+	return fmt.Sprintf("%s:%s", t.typ, body)
+}
+
+// String returns the tensor literal form
+func (t *Tensor) String() string {
+	return t.Literal()
+}
+
+// denseLiteral renders values as nested arrays, one level per indexed dimension
+func (t *Tensor) denseLiteral(values []float64, dims []Dimension) string {
+	if len(dims) == 0 {
+		return t.formatValue(values[0])
+	}
+
+	stride := len(values) / dims[0].Size
+	parts := make([]string, dims[0].Size)
+	for i := range parts {
+		parts[i] = t.denseLiteral(values[i*stride:(i+1)*stride], dims[1:])
+	}
+	return "[" + strings.Join(parts, ",") + "]"
+}
+
+func (t *Tensor) verboseLiteral() string {
+	var parts []string
+	cells := t.Cells()
+	if t.typ.IsDense() {
+		full, _ := NewDense(t.typ, t.blockValues(Address{})...)
+		cells = full.Cells()
+	}
+	for _, cell := range cells {
+		var labels []string
+		for _, d := range t.typ.Dimensions {
+			labels = append(labels, fmt.Sprintf("%s:%s", d.Name, quoteLabel(cell.Address[d.Name])))
+		}
+		parts = append(parts, fmt.Sprintf("{%s}:%s", strings.Join(labels, ","), t.formatValue(cell.Value)))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// quoteLabel quotes labels that are not plain identifiers or integers
+func quoteLabel(label string) string {
+	if IsIdentifier(label) {
+		return label
+	}
+	if _, err := strconv.Atoi(label); err == nil {
+		return label
+	}
+	return "'" + strings.ReplaceAll(label, "'", "\\'") + "'"
+}
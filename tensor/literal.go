@@ -0,0 +1,248 @@
+package tensor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseLiteral parses a tensor in Vespa's tensor literal form, the inverse
+// of Literal:
+//
+//	tensor<float>(x[3]):[1,2,3]                      // dense
+//	tensor(category{}):{shoes:0.8,bags:0.2}          // sparse, one mapped dimension
+//	tensor(key{},x[2]):{a:[1,2],b:[3,4]}             // mixed, one mapped dimension
+//	tensor(a{},b{}):{{a:x,b:'new york'}:1}           // verbose, any type
+func ParseLiteral(literal string) (*Tensor, error) {
+	s := strings.TrimSpace(literal)
+	typeEnd := strings.Index(s, ")")
+	if typeEnd < 0 {
+		return nil, fmt.Errorf("tensor: missing type in literal '%s'", literal)
+	}
+	t, err := ParseType(s[:typeEnd+1])
+	if err != nil {
+		return nil, err
+	}
+
+	p := &literalParser{input: s, pos: typeEnd + 1}
+	if err := p.expect(':'); err != nil {
+		return nil, err
+	}
+	result := New(t)
+	if err := p.parseBody(result); err != nil {
+		return nil, err
+	}
+	if p.skipSpace(); p.pos < len(p.input) {
+		return nil, p.errorf("unexpected '%s'", p.input[p.pos:])
+	}
+	return result, nil
+}
+
+// literalParser reads the body of a tensor literal
+type literalParser struct {
+	input string
+	pos   int
+}
+
+func (p *literalParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("tensor: invalid literal '%s' at offset %d: %s", p.input, p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *literalParser) skipSpace() {
+	for p.pos < len(p.input) && strings.ContainsRune(" \t\r\n", rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *literalParser) peek() byte {
+	p.skipSpace()
+	if p.pos >= len(p.input) {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *literalParser) expect(c byte) error {
+	if p.peek() != c {
+		return p.errorf("expected '%c'", c)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *literalParser) parseBody(t *Tensor) error {
+	mapped := t.typ.mappedDimensions()
+	indexed := t.typ.indexedDimensions()
+
+	switch {
+	case p.peek() == '[':
+		if !t.typ.IsDense() {
+			return p.errorf("dense values require a dense type, got %s", t.typ)
+		}
+		values, err := p.parseDense(indexed)
+		if err != nil {
+			return err
+		}
+		return t.setDenseBlock(Address{}, values)
+	case p.peek() != '{':
+		// A single value, for a tensor without dimensions
+		value, err := p.parseNumber()
+		if err != nil {
+			return err
+		}
+		return t.Set(Address{}, value)
+	}
+
+	p.pos++
+	for p.peek() != '}' {
+		if p.peek() == '{' {
+			if err := p.parseVerboseCell(t); err != nil {
+				return err
+			}
+		} else {
+			if len(mapped) != 1 {
+				return p.errorf("labels without dimension names require exactly one mapped dimension, got %s", t.typ)
+			}
+			label, err := p.parseLabel()
+			if err != nil {
+				return err
+			}
+			if err := p.expect(':'); err != nil {
+				return err
+			}
+			address := Address{mapped[0].Name: label}
+			if len(indexed) == 0 {
+				value, err := p.parseNumber()
+				if err != nil {
+					return err
+				}
+				if err := t.Set(address, value); err != nil {
+					return err
+				}
+			} else {
+				values, err := p.parseDense(indexed)
+				if err != nil {
+					return err
+				}
+				if err := t.SetBlock(address, values...); err != nil {
+					return err
+				}
+			}
+		}
+		if p.peek() != ',' {
+			break
+		}
+		p.pos++
+	}
+	return p.expect('}')
+}
+
+// parseVerboseCell parses {dim:label,...}:value
+func (p *literalParser) parseVerboseCell(t *Tensor) error {
+	p.pos++
+	address := Address{}
+	for p.peek() != '}' {
+		dimension, err := p.parseLabel()
+		if err != nil {
+			return err
+		}
+		if err := p.expect(':'); err != nil {
+			return err
+		}
+		label, err := p.parseLabel()
+		if err != nil {
+			return err
+		}
+		address[dimension] = label
+		if p.peek() != ',' {
+			break
+		}
+		p.pos++
+	}
+	if err := p.expect('}'); err != nil {
+		return err
+	}
+	if err := p.expect(':'); err != nil {
+		return err
+	}
+	value, err := p.parseNumber()
+	if err != nil {
+		return err
+	}
+	return t.Set(address, value)
+}
+
+// parseDense parses nested arrays, one level per indexed dimension
+func (p *literalParser) parseDense(dims []Dimension) ([]float64, error) {
+	if len(dims) == 0 {
+		value, err := p.parseNumber()
+		if err != nil {
+			return nil, err
+		}
+		return []float64{value}, nil
+	}
+
+	if err := p.expect('['); err != nil {
+		return nil, err
+	}
+	var values []float64
+	for i := 0; i < dims[0].Size; i++ {
+		if i > 0 {
+			if err := p.expect(','); err != nil {
+				return nil, err
+			}
+		}
+		inner, err := p.parseDense(dims[1:])
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, inner...)
+	}
+	if err := p.expect(']'); err != nil {
+		return nil, fmt.Errorf("%w (dimension %s has size %d)", err, dims[0].Name, dims[0].Size)
+	}
+	return values, nil
+}
+
+func (p *literalParser) parseLabel() (string, error) {
+	if quote := p.peek(); quote == '\'' || quote == '"' {
+		var b strings.Builder
+		for p.pos++; p.pos < len(p.input); p.pos++ {
+			c := p.input[p.pos]
+			switch {
+			case c == '\\' && p.pos+1 < len(p.input):
+				p.pos++
+				b.WriteByte(p.input[p.pos])
+			case c == quote:
+				p.pos++
+				return b.String(), nil
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return "", p.errorf("unterminated label")
+	}
+
+	start := p.pos
+	for p.pos < len(p.input) && !strings.ContainsRune(":,{}[] \t\r\n", rune(p.input[p.pos])) {
+		p.pos++
+	}
+	if p.pos == start {
+		return "", p.errorf("expected a label")
+	}
+	return p.input[start:p.pos], nil
+}
+
+func (p *literalParser) parseNumber() (float64, error) {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.input) && !strings.ContainsRune(",}] \t\r\n", rune(p.input[p.pos])) {
+		p.pos++
+	}
+	value, err := strconv.ParseFloat(p.input[start:p.pos], 64)
+	if err != nil {
+		p.pos = start
+		return 0, p.errorf("expected a number")
+	}
+	return value, nil
+}
@@ -0,0 +1,278 @@
+package tensor
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Address identifies a tensor cell by dimension name and label.
+// Labels of indexed dimensions are decimal indexes, e.g. {"x": "2"}.
+type Address map[string]string
+
+// Cell is a single tensor cell
+type Cell struct {
+	Address Address
+	Value   float64
+}
+
+// Tensor is a typed tensor value
+type Tensor struct {
+	typ   Type
+	cells map[string]Cell
+}
+
+// New creates an empty tensor of the given type
+func New(t Type) *Tensor {
+	return &Tensor{typ: t, cells: make(map[string]Cell)}
+}
+
+// NewDense creates an indexed tensor from values in row-major order, with
+// dimensions in canonical (name-sorted) order.
+func NewDense(t Type, values ...float64) (*Tensor, error) {
+	if !t.IsDense() {
+		return nil, fmt.Errorf("tensor: type %s is not dense", t)
+	}
+	if len(values) != t.denseSize() {
+		return nil, fmt.Errorf("tensor: type %s requires %d values, got %d", t, t.denseSize(), len(values))
+	}
+
+	tensor := New(t)
+	if err := tensor.setDenseBlock(Address{}, values); err != nil {
+		return nil, err
+	}
+	return tensor, nil
+}
+
+// NewDenseFloat32 is like NewDense for float32 values, e.g. embedding vectors
+func NewDenseFloat32(t Type, values []float32) (*Tensor, error) {
+	converted := make([]float64, len(values))
+	for i, v := range values {
+		converted[i] = float64(v)
+	}
+	return NewDense(t, converted...)
+}
+
+// Type returns the tensor type
+func (t *Tensor) Type() Type {
+	return t.typ
+}
+
+// Len returns the number of cells set in the tensor
+func (t *Tensor) Len() int {
+	return len(t.cells)
+}
+
+// Set sets the value of the cell at the given address.
+// The address must label every dimension of the tensor type.
+func (t *Tensor) Set(address Address, value float64) error {
+	key, err := t.addressKey(address)
+	if err != nil {
+		return err
+	}
+	if err := t.validateValue(value); err != nil {
+		return err
+	}
+
+	stored := make(Address, len(address))
+	for _, d := range t.typ.Dimensions {
+		stored[d.Name] = address[d.Name]
+		if !d.IsMapped() {
+			index, _ := strconv.Atoi(address[d.Name])
+			stored[d.Name] = strconv.Itoa(index)
+		}
+	}
+	t.cells[key] = Cell{Address: stored, Value: value}
+	return nil
+}
+
+// SetBlock sets the dense sub-tensor at the given mapped address of a mixed
+// tensor. The address labels the mapped dimensions only, and values are given
+// in row-major order over the indexed dimensions.
+func (t *Tensor) SetBlock(mapped Address, values ...float64) error {
+	if t.typ.IsDense() || t.typ.IsSparse() {
+		return fmt.Errorf("tensor: blocks require a mixed type, got %s", t.typ)
+	}
+	if len(mapped) != len(t.typ.mappedDimensions()) {
+		return fmt.Errorf("tensor: block address %v must label exactly the mapped dimensions of %s", mapped, t.typ)
+	}
+	if len(values) != t.typ.denseSize() {
+		return fmt.Errorf("tensor: block of type %s requires %d values, got %d", t.typ, t.typ.denseSize(), len(values))
+	}
+	return t.setDenseBlock(mapped, values)
+}
+
+// Get returns the value at the given address and whether the cell is set.
+// Unset cells of indexed dimensions read as zero in Vespa.
+func (t *Tensor) Get(address Address) (float64, bool) {
+	key, err := t.addressKey(address)
+	if err != nil {
+		return 0, false
+	}
+	cell, ok := t.cells[key]
+	return cell.Value, ok
+}
+
+// Cells returns all cells in canonical address order
+func (t *Tensor) Cells() []Cell {
+	keys := make([]string, 0, len(t.cells))
+	for k := range t.cells {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return t.lessAddress(t.cells[keys[i]].Address, t.cells[keys[j]].Address)
+	})
+
+	cells := make([]Cell, len(keys))
+	for i, k := range keys {
+		cells[i] = t.cells[k]
+	}
+	return cells
+}
+
+// Values returns the cells of a dense tensor in row-major order,
+// with unset cells reading as zero
+func (t *Tensor) Values() ([]float64, error) {
+	if !t.typ.IsDense() {
+		return nil, fmt.Errorf("tensor: type %s is not dense", t.typ)
+	}
+	return t.blockValues(Address{}), nil
+}
+
+// Validate checks every cell against the tensor type
+func (t *Tensor) Validate() error {
+	for _, cell := range t.cells {
+		if _, err := t.addressKey(cell.Address); err != nil {
+			return err
+		}
+		if err := t.validateValue(cell.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Tensor) setDenseBlock(mapped Address, values []float64) error {
+	indexed := t.typ.indexedDimensions()
+	for i, v := range values {
+		address := make(Address, len(t.typ.Dimensions))
+		for k, label := range mapped {
+			address[k] = label
+		}
+		// Decompose the flat index into per-dimension indexes (row-major)
+		rest := i
+		for d := len(indexed) - 1; d >= 0; d-- {
+			address[indexed[d].Name] = strconv.Itoa(rest % indexed[d].Size)
+			rest /= indexed[d].Size
+		}
+		if err := t.Set(address, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blockValues returns the dense values under a mapped address in row-major order
+func (t *Tensor) blockValues(mapped Address) []float64 {
+	indexed := t.typ.indexedDimensions()
+	values := make([]float64, t.typ.denseSize())
+	for _, cell := range t.cells {
+		if !matchesMapped(cell.Address, mapped) {
+			continue
+		}
+		offset := 0
+		for _, d := range indexed {
+			index, _ := strconv.Atoi(cell.Address[d.Name])
+			offset = offset*d.Size + index
+		}
+		values[offset] = cell.Value
+	}
+	return values
+}
+
+// blocks returns the distinct mapped addresses of the tensor in canonical order
+func (t *Tensor) blocks() []Address {
+	mappedDims := t.typ.mappedDimensions()
+	seen := make(map[string]Address)
+	for _, cell := range t.cells {
+		mapped := make(Address, len(mappedDims))
+		labels := make([]string, len(mappedDims))
+		for i, d := range mappedDims {
+			mapped[d.Name] = cell.Address[d.Name]
+			labels[i] = cell.Address[d.Name]
+		}
+		seen[strings.Join(labels, "\x00")] = mapped
+	}
+
+	blocks := make([]Address, 0, len(seen))
+	for _, mapped := range seen {
+		blocks = append(blocks, mapped)
+	}
+	sort.Slice(blocks, func(i, j int) bool { return t.lessAddress(blocks[i], blocks[j]) })
+	return blocks
+}
+
+func matchesMapped(address, mapped Address) bool {
+	for k, v := range mapped {
+		if address[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// addressKey validates the address and returns its canonical key
+func (t *Tensor) addressKey(address Address) (string, error) {
+	if len(address) != len(t.typ.Dimensions) {
+		return "", fmt.Errorf("tensor: address %v does not match type %s", address, t.typ)
+	}
+
+	labels := make([]string, len(t.typ.Dimensions))
+	for i, d := range t.typ.Dimensions {
+		label, ok := address[d.Name]
+		if !ok {
+			return "", fmt.Errorf("tensor: address %v is missing dimension '%s'", address, d.Name)
+		}
+		if !d.IsMapped() {
+			index, err := strconv.Atoi(label)
+			if err != nil || index < 0 || index >= d.Size {
+				return "", fmt.Errorf("tensor: label '%s' is out of range for dimension %s", label, d)
+			}
+			// "01" and "1" address the same cell
+			label = strconv.Itoa(index)
+		}
+		labels[i] = label
+	}
+	return strings.Join(labels, "\x00"), nil
+}
+
+// validateValue checks that the value is representable in the cell type
+func (t *Tensor) validateValue(value float64) error {
+	if t.typ.CellType != Int8 {
+		return nil
+	}
+	if value != math.Trunc(value) || value < math.MinInt8 || value > math.MaxInt8 {
+		return fmt.Errorf("tensor: value %v is not representable as int8", value)
+	}
+	return nil
+}
+
+// lessAddress orders addresses by dimension order, comparing indexed labels numerically
+func (t *Tensor) lessAddress(a, b Address) bool {
+	for _, d := range t.typ.Dimensions {
+		la, aok := a[d.Name]
+		lb, bok := b[d.Name]
+		if !aok || !bok || la == lb {
+			continue
+		}
+		if !d.IsMapped() {
+			ia, _ := strconv.Atoi(la)
+			ib, _ := strconv.Atoi(lb)
+			return ia < ib
+		}
+		return la < lb
+	}
+	return false
+}
@@ -0,0 +1,409 @@
+package tensor
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseType(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     string
+		expected string
+		dense    bool
+		sparse   bool
+		mixed    bool
+	}{
+		{"Dense float", "tensor<float>(x[3])", "tensor<float>(x[3])", true, false, false},
+		{"Default double", "tensor(x[2])", "tensor(x[2])", true, false, false},
+		{"Explicit double", "tensor<double>(x[2])", "tensor(x[2])", true, false, false},
+		{"Sparse", "tensor<bfloat16>(key{})", "tensor<bfloat16>(key{})", false, true, false},
+		{"Mixed sorted dimensions", "tensor<int8>(x[4], key{})", "tensor<int8>(key{},x[4])", false, false, true},
+		{"Whitespace", " tensor < float > ( y[2] , x[3] ) ", "tensor<float>(x[3],y[2])", true, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			typ, err := ParseType(tt.spec)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if typ.String() != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, typ.String())
+			}
+			if typ.IsDense() != tt.dense || typ.IsSparse() != tt.sparse || typ.IsMixed() != tt.mixed {
+				t.Errorf("Unexpected kind for %s: dense=%t sparse=%t mixed=%t", typ, typ.IsDense(), typ.IsSparse(), typ.IsMixed())
+			}
+		})
+	}
+}
+
+func TestParseTypeErrors(t *testing.T) {
+	specs := []string{
+		"vector(x[3])",
+		"tensor<float16>(x[3])",
+		"tensor<float>(x[0])",
+		"tensor<float>(x[a])",
+		"tensor<float>(x[3],x{})",
+		"tensor<float(x[3])",
+		"tensor<float>x[3]",
+	}
+
+	for _, spec := range specs {
+		t.Run(spec, func(t *testing.T) {
+			if _, err := ParseType(spec); err == nil {
+				t.Errorf("Expected error for %q", spec)
+			}
+		})
+	}
+}
+
+func TestDenseTensor(t *testing.T) {
+	typ := MustType(Float, Indexed("x", 3))
+	tensor, err := NewDense(typ, 0.5, 1, -2.25)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		form     Form
+		expected string
+	}{
+		{ShortForm, `{"values":[0.5,1,-2.25]}`},
+		{LongForm, `{"cells":[{"address":{"x":"0"},"value":0.5},{"address":{"x":"1"},"value":1},{"address":{"x":"2"},"value":-2.25}]}`},
+		{HexForm, `{"values":"3F0000003F800000C0100000"}`},
+	}
+
+	for _, tt := range tests {
+		data, err := tensor.Encode(tt.form)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if string(data) != tt.expected {
+			t.Errorf("Form %d: expected %s, got %s", tt.form, tt.expected, data)
+		}
+	}
+
+	expectedLiteral := "tensor<float>(x[3]):[0.5,1,-2.25]"
+	if tensor.Literal() != expectedLiteral {
+		t.Errorf("Expected literal %q, got %q", expectedLiteral, tensor.Literal())
+	}
+}
+
+func TestDenseTensorMultiDimensional(t *testing.T) {
+	typ := MustType(Double, Indexed("y", 3), Indexed("x", 2))
+	tensor, err := NewDense(typ, 1, 2, 3, 4, 5, 6)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if v, _ := tensor.Get(Address{"x": "1", "y": "0"}); v != 4 {
+		t.Errorf("Expected value 4 at {x:1,y:0}, got %v", v)
+	}
+
+	expectedLiteral := "tensor(x[2],y[3]):[[1,2,3],[4,5,6]]"
+	if tensor.Literal() != expectedLiteral {
+		t.Errorf("Expected literal %q, got %q", expectedLiteral, tensor.Literal())
+	}
+}
+
+func TestDenseFloat32Precision(t *testing.T) {
+	tensor, err := NewDenseFloat32(MustType(Float, Indexed("x", 2)), []float32{0.1, 0.2})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	data, err := json.Marshal(tensor)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(data) != `{"values":[0.1,0.2]}` {
+		t.Errorf("Expected float32 precision values, got %s", data)
+	}
+}
+
+func TestSparseTensor(t *testing.T) {
+	tensor := New(MustType(Float, Mapped("category")))
+	if err := tensor.Set(Address{"category": "shoes"}, 0.8); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := tensor.Set(Address{"category": "bags"}, 0.2); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	data, err := tensor.Encode(ShortForm)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(data) != `{"cells":{"bags":0.2,"shoes":0.8}}` {
+		t.Errorf("Unexpected short form %s", data)
+	}
+
+	expectedLiteral := "tensor<float>(category{}):{bags:0.2,shoes:0.8}"
+	if tensor.Literal() != expectedLiteral {
+		t.Errorf("Expected literal %q, got %q", expectedLiteral, tensor.Literal())
+	}
+
+	if _, err := tensor.Encode(HexForm); err == nil {
+		t.Error("Expected error hex encoding a sparse tensor")
+	}
+}
+
+func TestSparseTensorMultipleMappedDimensions(t *testing.T) {
+	tensor := New(MustType(Double, Mapped("a"), Mapped("b")))
+	if err := tensor.Set(Address{"a": "x", "b": "new york"}, 1); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	data, err := tensor.Encode(ShortForm)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(data) != `{"cells":[{"address":{"a":"x","b":"new york"},"value":1}]}` {
+		t.Errorf("Expected long form fallback, got %s", data)
+	}
+
+	expectedLiteral := "tensor(a{},b{}):{{a:x,b:'new york'}:1}"
+	if tensor.Literal() != expectedLiteral {
+		t.Errorf("Expected literal %q, got %q", expectedLiteral, tensor.Literal())
+	}
+}
+
+func TestMixedTensor(t *testing.T) {
+	tensor := New(MustType(BFloat16, Mapped("key"), Indexed("x", 2)))
+	if err := tensor.SetBlock(Address{"key": "a"}, 1, 2); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := tensor.SetBlock(Address{"key": "b"}, 3, 4); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	data, err := tensor.Encode(ShortForm)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(data) != `{"blocks":{"a":[1,2],"b":[3,4]}}` {
+		t.Errorf("Unexpected short form %s", data)
+	}
+
+	data, err = tensor.Encode(HexForm)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(data) != `{"blocks":{"a":"3F804000","b":"40404080"}}` {
+		t.Errorf("Unexpected hex form %s", data)
+	}
+
+	expectedLiteral := "tensor<bfloat16>(key{},x[2]):{a:[1,2],b:[3,4]}"
+	if tensor.Literal() != expectedLiteral {
+		t.Errorf("Expected literal %q, got %q", expectedLiteral, tensor.Literal())
+	}
+}
+
+func TestInt8HexEncoding(t *testing.T) {
+	tensor, err := NewDense(MustType(Int8, Indexed("x", 3)), 1, -1, 127)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	data, err := tensor.Encode(HexForm)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(data) != `{"values":"01FF7F"}` {
+		t.Errorf("Unexpected hex form %s", data)
+	}
+}
+
+func TestTensorValidation(t *testing.T) {
+	dense := MustType(Float, Indexed("x", 2))
+	mixed := MustType(Float, Mapped("key"), Indexed("x", 2))
+
+	tests := []struct {
+		name string
+		fn   func() error
+	}{
+		{"Wrong value count", func() error {
+			_, err := NewDense(dense, 1, 2, 3)
+			return err
+		}},
+		{"Dense constructor on sparse type", func() error {
+			_, err := NewDense(MustType(Float, Mapped("key")), 1)
+			return err
+		}},
+		{"Index out of range", func() error {
+			return New(dense).Set(Address{"x": "2"}, 1)
+		}},
+		{"Non numeric index", func() error {
+			return New(dense).Set(Address{"x": "a"}, 1)
+		}},
+		{"Missing dimension", func() error {
+			return New(mixed).Set(Address{"key": "a"}, 1)
+		}},
+		{"Unknown dimension", func() error {
+			return New(dense).Set(Address{"y": "0"}, 1)
+		}},
+		{"Int8 out of range", func() error {
+			_, err := NewDense(MustType(Int8, Indexed("x", 1)), 200)
+			return err
+		}},
+		{"Int8 fraction", func() error {
+			_, err := NewDense(MustType(Int8, Indexed("x", 1)), 1.5)
+			return err
+		}},
+		{"Block on dense type", func() error {
+			return New(dense).SetBlock(Address{}, 1, 2)
+		}},
+		{"Block with wrong size", func() error {
+			return New(mixed).SetBlock(Address{"key": "a"}, 1)
+		}},
+		{"Indexed dimension of size zero", func() error {
+			_, err := NewType(Float, Indexed("x", 0))
+			return err
+		}},
+		{"Indexed dimension of negative size", func() error {
+			_, err := NewType(Float, Indexed("x", -1))
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.fn(); err == nil {
+				t.Error("Expected error but got none")
+			}
+		})
+	}
+}
+
+func TestIndexedLabelNormalization(t *testing.T) {
+	tensor := New(MustType(Float, Indexed("x", 2)))
+	if err := tensor.Set(Address{"x": "01"}, 1); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := tensor.Set(Address{"x": "1"}, 2); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if tensor.Len() != 1 {
+		t.Errorf("Expected 1 cell, got %d", tensor.Len())
+	}
+	if value, ok := tensor.Get(Address{"x": "001"}); !ok || value != 2 {
+		t.Errorf("Expected 2, got %v", value)
+	}
+	if label := tensor.Cells()[0].Address["x"]; label != "1" {
+		t.Errorf("Expected label %q, got %q", "1", label)
+	}
+}
+
+func TestDecodeRoundTrip(t *testing.T) {
+	dense, _ := NewDense(MustType(Float, Indexed("x", 2), Indexed("y", 2)), 1, 2, 3, 4.5)
+	int8Dense, _ := NewDense(MustType(Int8, Indexed("x", 3)), 1, -1, 127)
+	sparse := New(MustType(Double, Mapped("key")))
+	sparse.Set(Address{"key": "a"}, 1.5)
+	sparse.Set(Address{"key": "b"}, -2)
+	multiSparse := New(MustType(Double, Mapped("a"), Mapped("b")))
+	multiSparse.Set(Address{"a": "x", "b": "y"}, 3)
+	mixed := New(MustType(BFloat16, Mapped("key"), Indexed("x", 2)))
+	mixed.SetBlock(Address{"key": "a"}, 1, 2)
+	mixed.SetBlock(Address{"key": "b"}, 3, 4)
+	multiMixed := New(MustType(Float, Mapped("a"), Mapped("b"), Indexed("x", 2)))
+	multiMixed.SetBlock(Address{"a": "1", "b": "2"}, 5, 6)
+
+	tensors := map[string]*Tensor{
+		"dense": dense, "int8": int8Dense, "sparse": sparse, "multiSparse": multiSparse,
+		"mixed": mixed, "multiMixed": multiMixed,
+	}
+
+	for name, tensor := range tensors {
+		for _, form := range []Form{ShortForm, LongForm, HexForm} {
+			data, err := tensor.Encode(form)
+			if err != nil {
+				// Sparse tensors have no hex form
+				continue
+			}
+			decoded, err := Decode(tensor.Type(), data)
+			if err != nil {
+				t.Errorf("%s: unexpected error decoding %s: %v", name, data, err)
+				continue
+			}
+			if decoded.Literal() != tensor.Literal() {
+				t.Errorf("%s: expected %q, got %q from %s", name, tensor.Literal(), decoded.Literal(), data)
+			}
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	dense := MustType(Float, Indexed("x", 2))
+
+	tests := []struct {
+		name string
+		typ  Type
+		data string
+	}{
+		{"Not an object", dense, `[1,2]`},
+		{"No known key", dense, `{"value":[1,2]}`},
+		{"Wrong value count", dense, `{"values":[1,2,3]}`},
+		{"Values for sparse type", MustType(Float, Mapped("key")), `{"values":[1]}`},
+		{"Bad hex length", dense, `{"values":"3F80"}`},
+		{"Out of range address", dense, `{"cells":[{"address":{"x":"5"},"value":1}]}`},
+		{"Int8 overflow", MustType(Int8, Indexed("x", 1)), `{"values":[300]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decode(tt.typ, []byte(tt.data)); err == nil {
+				t.Errorf("Expected error decoding %s", tt.data)
+			}
+		})
+	}
+}
+
+func TestParseLiteralRoundTrip(t *testing.T) {
+	literals := []string{
+		"tensor<float>(x[3]):[0.5,1,-2.25]",
+		"tensor(x[2],y[3]):[[1,2,3],[4,5,6]]",
+		"tensor<float>(category{}):{bags:0.2,shoes:0.8}",
+		"tensor(key{},x[2]):{a:[1,2],b:[3,4]}",
+		"tensor(a{},b{}):{{a:x,b:'new york'}:1}",
+	}
+
+	for _, literal := range literals {
+		parsed, err := ParseLiteral(literal)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %v", literal, err)
+			continue
+		}
+		if parsed.Literal() != literal {
+			t.Errorf("Expected %q, got %q", literal, parsed.Literal())
+		}
+	}
+
+	parsed, err := ParseLiteral("tensor(x[2]) : { {x:1}:5 , {x:0}:4 }")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if parsed.Literal() != "tensor(x[2]):[4,5]" {
+		t.Errorf("Expected %q, got %q", "tensor(x[2]):[4,5]", parsed.Literal())
+	}
+}
+
+func TestParseLiteralErrors(t *testing.T) {
+	literals := []string{
+		"[1,2,3]",
+		"tensor(x[3]):[1,2]",
+		"tensor(x[2]):[1,2,3]",
+		"tensor(x[2]):[1,a]",
+		"tensor(key{}):[1,2]",
+		"tensor(a{},b{}):{x:1}",
+		"tensor(key{}):{'open:1}",
+		"tensor(x[2]):[1,2] extra",
+	}
+
+	for _, literal := range literals {
+		if _, err := ParseLiteral(literal); err == nil {
+			t.Errorf("Expected error parsing %q", literal)
+		}
+	}
+}
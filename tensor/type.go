@@ -0,0 +1,272 @@
+// Package tensor provides typed tensor values for Vespa query inputs and
+// document feeding.
+//
+// A tensor has a Type (cell type plus dimensions) and a set of cells. Indexed
+// dimensions (x[3]) make a tensor dense, mapped dimensions (key{}) make it
+// sparse, and a mix of both makes it a mixed tensor. Tensors serialize to
+// Vespa's short, long and hex-encoded JSON forms as well as the tensor literal
+// form used in query parameters.
+package tensor
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CellType represents the value type of tensor cells
+type CellType string
+
+const (
+	Double   CellType = "double"
+	Float    CellType = "float"
+	BFloat16 CellType = "bfloat16"
+	Int8     CellType = "int8"
+)
+
+// valid reports whether the cell type is known to Vespa
+func (c CellType) valid() bool {
+	switch c {
+	case Double, Float, BFloat16, Int8:
+		return true
+	default:
+		return false
+	}
+}
+
+// Dimension represents a single tensor dimension.
+// A Size of zero denotes a mapped dimension, a positive Size an indexed one.
+type Dimension struct {
+	Name string
+	Size int
+
+	indexed bool // created by Indexed, so a zero Size is an error
+}
+
+// Indexed creates an indexed (dense) dimension of the given size.
+// NewType rejects sizes below 1.
+func Indexed(name string, size int) Dimension {
+	return Dimension{Name: name, Size: size, indexed: true}
+}
+
+// Mapped creates a mapped (sparse) dimension
+func Mapped(name string) Dimension {
+	return Dimension{Name: name}
+}
+
+// IsMapped reports whether the dimension is mapped
+func (d Dimension) IsMapped() bool {
+	return d.Size == 0
+}
+
+// String returns the dimension in Vespa type syntax, e.g. x[3] or key{}
+func (d Dimension) String() string {
+	if d.IsMapped() {
+		return d.Name + "{}"
+	}
+	return fmt.Sprintf("%s[%d]", d.Name, d.Size)
+}
+
+// Type represents a tensor type such as tensor<float>(x[384])
+type Type struct {
+	CellType   CellType
+	Dimensions []Dimension
+}
+
+// NewType creates a tensor type. Dimensions are sorted by name, which is the
+// canonical order Vespa uses for addressing and for dense cell ordering.
+func NewType(cellType CellType, dimensions ...Dimension) (Type, error) {
+	if cellType == "" {
+		cellType = Double
+	}
+	if !cellType.valid() {
+		return Type{}, fmt.Errorf("tensor: unknown cell type '%s'", cellType)
+	}
+
+	dims := make([]Dimension, len(dimensions))
+	copy(dims, dimensions)
+	sort.Slice(dims, func(i, j int) bool { return dims[i].Name < dims[j].Name })
+
+	for i, d := range dims {
+		if !IsIdentifier(d.Name) {
+			return Type{}, fmt.Errorf("tensor: invalid dimension name '%s'", d.Name)
+		}
+		if d.Size < 0 || (d.indexed && d.Size == 0) {
+			return Type{}, fmt.Errorf("tensor: indexed dimension '%s' must have a positive size, got %d", d.Name, d.Size)
+		}
+		if i > 0 && dims[i-1].Name == d.Name {
+			return Type{}, fmt.Errorf("tensor: duplicate dimension '%s'", d.Name)
+		}
+	}
+
+	return Type{CellType: cellType, Dimensions: dims}, nil
+}
+
+// MustType is like NewType but panics on error.
+// It is intended for package-level type declarations.
+func MustType(cellType CellType, dimensions ...Dimension) Type {
+	t, err := NewType(cellType, dimensions...)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// ParseType parses a tensor type specification such as
+// "tensor<float>(x[3],key{})" or "tensor(x[2])". Cell type defaults to double.
+func ParseType(spec string) (Type, error) {
+	s := strings.TrimSpace(spec)
+	if !strings.HasPrefix(s, "tensor") {
+		return Type{}, fmt.Errorf("tensor: type '%s' must start with 'tensor'", spec)
+	}
+	s = strings.TrimSpace(s[len("tensor"):])
+
+	cellType := Double
+	if strings.HasPrefix(s, "<") {
+		end := strings.Index(s, ">")
+		if end < 0 {
+			return Type{}, fmt.Errorf("tensor: unterminated cell type in '%s'", spec)
+		}
+		cellType = CellType(strings.TrimSpace(s[1:end]))
+		s = strings.TrimSpace(s[end+1:])
+	}
+
+	if !strings.HasPrefix(s, "(") || !strings.HasSuffix(s, ")") {
+		return Type{}, fmt.Errorf("tensor: missing dimension list in '%s'", spec)
+	}
+	body := strings.TrimSpace(s[1 : len(s)-1])
+
+	var dims []Dimension
+	if body != "" {
+		for _, part := range strings.Split(body, ",") {
+			d, err := parseDimension(strings.TrimSpace(part))
+			if err != nil {
+				return Type{}, fmt.Errorf("tensor: %v in '%s'", err, spec)
+			}
+			dims = append(dims, d)
+		}
+	}
+
+	return NewType(cellType, dims...)
+}
+
+func parseDimension(s string) (Dimension, error) {
+	if strings.HasSuffix(s, "{}") {
+		return Mapped(strings.TrimSpace(strings.TrimSuffix(s, "{}"))), nil
+	}
+	open := strings.Index(s, "[")
+	if open < 0 || !strings.HasSuffix(s, "]") {
+		return Dimension{}, fmt.Errorf("invalid dimension '%s'", s)
+	}
+	size, err := strconv.Atoi(strings.TrimSpace(s[open+1 : len(s)-1]))
+	if err != nil || size <= 0 {
+		return Dimension{}, fmt.Errorf("invalid size for dimension '%s'", s)
+	}
+	return Indexed(strings.TrimSpace(s[:open]), size), nil
+}
+
+// String returns the type in Vespa syntax, e.g. tensor<float>(x[3])
+func (t Type) String() string {
+	dims := make([]string, len(t.Dimensions))
+	for i, d := range t.Dimensions {
+		dims[i] = d.String()
+	}
+
+	cellType := t.CellType
+	if cellType == "" {
+		cellType = Double
+	}
+	if cellType == Double {
+		return fmt.Sprintf("tensor(%s)", strings.Join(dims, ","))
+	}
+	return fmt.Sprintf("tensor<%s>(%s)", cellType, strings.Join(dims, ","))
+}
+
+// Equal reports whether two types have the same cell type and dimensions
+func (t Type) Equal(other Type) bool {
+	return t.String() == other.String()
+}
+
+// IsDense reports whether all dimensions are indexed
+func (t Type) IsDense() bool {
+	for _, d := range t.Dimensions {
+		if d.IsMapped() {
+			return false
+		}
+	}
+	return true
+}
+
+// IsSparse reports whether all dimensions are mapped
+func (t Type) IsSparse() bool {
+	for _, d := range t.Dimensions {
+		if !d.IsMapped() {
+			return false
+		}
+	}
+	return len(t.Dimensions) > 0
+}
+
+// IsMixed reports whether the type has both mapped and indexed dimensions
+func (t Type) IsMixed() bool {
+	return !t.IsDense() && !t.IsSparse()
+}
+
+// Dimension returns the dimension with the given name
+func (t Type) Dimension(name string) (Dimension, bool) {
+	for _, d := range t.Dimensions {
+		if d.Name == name {
+			return d, true
+		}
+	}
+	return Dimension{}, false
+}
+
+// mappedDimensions returns the mapped dimensions in canonical order
+func (t Type) mappedDimensions() []Dimension {
+	var dims []Dimension
+	for _, d := range t.Dimensions {
+		if d.IsMapped() {
+			dims = append(dims, d)
+		}
+	}
+	return dims
+}
+
+// indexedDimensions returns the indexed dimensions in canonical order
+func (t Type) indexedDimensions() []Dimension {
+	var dims []Dimension
+	for _, d := range t.Dimensions {
+		if !d.IsMapped() {
+			dims = append(dims, d)
+		}
+	}
+	return dims
+}
+
+// denseSize returns the number of cells spanned by the indexed dimensions
+func (t Type) denseSize() int {
+	size := 1
+	for _, d := range t.indexedDimensions() {
+		size *= d.Size
+	}
+	return size
+}
+
+// IsIdentifier reports whether s is a valid Vespa identifier: letters, digits
+// and underscores, not starting with a digit. Dimension names, and the names
+// of fields and request parameters referenced from YQL, are identifiers.
+func IsIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		isLetter := r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+		isDigit := r >= '0' && r <= '9'
+		if !isLetter && !(i > 0 && isDigit) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,270 @@
+package vespa
+
+// =============================================================================
+// Typed Field Builders
+// =============================================================================
+
+// Typed field builders only offer the operators Vespa supports for a field
+// type, so using the wrong operator is a compile error instead of a failed
+// query:
+//
+//	price := IntegerField[int32]("price")
+//	title := TextField("title")
+//	And(title.Contains("shoes"), price.Between(50, 150))
+//	title.Gt(10) // does not compile
+//
+// Conditions on arrays and weighted sets of primitives match any element, so
+// they use the builder of the element type. cmd/vespagen generates typed
+// builders for every field of a schema.
+
+// Number is the Go type of a numeric field
+type Number interface {
+	int | int8 | int16 | int32 | int64 |
+		uint | uint8 | uint16 | uint32 | uint64 |
+		float32 | float64
+}
+
+// Integer is the Go type of an int, long or byte field
+type Integer interface {
+	int | int8 | int16 | int32 | int64 |
+		uint | uint8 | uint16 | uint32 | uint64
+}
+
+// NumericFieldBuilder builds comparisons on float and double attributes.
+// Integer attributes use IntegerFieldBuilder, which adds in and not in.
+type NumericFieldBuilder[T Number] struct {
+	field string
+}
+
+// NumericField returns a builder for a numeric attribute, e.g.
+// NumericField[int32]("price").Gte(100)
+func NumericField[T Number](name string) NumericFieldBuilder[T] {
+	return NumericFieldBuilder[T]{field: name}
+}
+
+// Name returns the field name
+func (f NumericFieldBuilder[T]) Name() string { return f.field }
+
+// Eq matches values equal to value (field = value)
+func (f NumericFieldBuilder[T]) Eq(value T) WhereCondition { return Field(f.field).Eq(value) }
+
+// NotEq matches values different from value (!(field = value))
+func (f NumericFieldBuilder[T]) NotEq(value T) WhereCondition { return Field(f.field).NotEq(value) }
+
+// Gt matches values greater than value (field > value)
+func (f NumericFieldBuilder[T]) Gt(value T) WhereCondition { return Field(f.field).Gt(value) }
+
+// Gte matches values greater than or equal to value (field >= value)
+func (f NumericFieldBuilder[T]) Gte(value T) WhereCondition { return Field(f.field).Gte(value) }
+
+// Lt matches values less than value (field < value)
+func (f NumericFieldBuilder[T]) Lt(value T) WhereCondition { return Field(f.field).Lt(value) }
+
+// Lte matches values less than or equal to value (field <= value)
+func (f NumericFieldBuilder[T]) Lte(value T) WhereCondition { return Field(f.field).Lte(value) }
+
+// Between creates a range condition (field >= min AND field <= max)
+func (f NumericFieldBuilder[T]) Between(min, max T) WhereCondition {
+	return Field(f.field).Between(min, max)
+}
+
+// IntegerFieldBuilder builds conditions on int, long and byte attributes:
+// the comparisons of NumericFieldBuilder and in, which Vespa only supports
+// for integer and string attributes
+type IntegerFieldBuilder[T Integer] struct {
+	NumericFieldBuilder[T]
+}
+
+// IntegerField returns a builder for an integer attribute, e.g.
+// IntegerField[int32]("category").In(1, 2)
+func IntegerField[T Integer](name string) IntegerFieldBuilder[T] {
+	return IntegerFieldBuilder[T]{NumericFieldBuilder: NumericField[T](name)}
+}
+
+// In matches values equal to one of values (field in (...))
+func (f IntegerFieldBuilder[T]) In(values ...T) WhereCondition {
+	return Field(f.field).In(anyValues(values)...)
+}
+
+// NotIn matches values equal to none of values (!(field in (...)))
+func (f IntegerFieldBuilder[T]) NotIn(values ...T) WhereCondition {
+	return Field(f.field).NotIn(anyValues(values)...)
+}
+
+// StringFieldBuilder builds conditions on string attributes: equality, in,
+// prefix, fuzzy and regular expression matching
+type StringFieldBuilder struct {
+	field string
+}
+
+// StringField returns a builder for a string attribute, e.g.
+// StringField("brand").In("nike", "adidas")
+func StringField(name string) StringFieldBuilder {
+	return StringFieldBuilder{field: name}
+}
+
+// Name returns the field name
+func (f StringFieldBuilder) Name() string { return f.field }
+
+// Eq matches the whole value (field contains 'value'), since string
+// attributes are not tokenized
+func (f StringFieldBuilder) Eq(value string) WhereCondition { return Field(f.field).Eq(value) }
+
+// NotEq matches values other than value (!(field contains 'value'))
+func (f StringFieldBuilder) NotEq(value string) WhereCondition { return Field(f.field).NotEq(value) }
+
+// In matches values equal to one of values (field in ('a', 'b'))
+func (f StringFieldBuilder) In(values ...string) WhereCondition {
+	return Field(f.field).In(anyValues(values)...)
+}
+
+// NotIn matches values equal to none of values (!(field in ('a', 'b')))
+func (f StringFieldBuilder) NotIn(values ...string) WhereCondition {
+	return Field(f.field).NotIn(anyValues(values)...)
+}
+
+// Prefix matches values starting with prefix
+func (f StringFieldBuilder) Prefix(prefix string) WhereCondition {
+	return Field(f.field).Contains(prefix, WithPrefixMatching())
+}
+
+// Fuzzy matches values within an edit distance of 2 of term
+func (f StringFieldBuilder) Fuzzy(term string) WhereCondition {
+	return Field(f.field).Contains(term, WithFuzzyMatching())
+}
+
+// Matches matches values against a regular expression
+func (f StringFieldBuilder) Matches(pattern string) WhereCondition {
+	return Field(f.field).Matches(pattern)
+}
+
+// TextFieldBuilder builds conditions on indexed string fields and fieldsets:
+// term, phrase and near matching
+type TextFieldBuilder struct {
+	field string
+}
+
+// TextField returns a builder for an indexed string field or a fieldset, e.g.
+// TextField("title").Phrase("running", "shoes")
+func TextField(name string) TextFieldBuilder {
+	return TextFieldBuilder{field: name}
+}
+
+// Name returns the field name
+func (f TextFieldBuilder) Name() string { return f.field }
+
+// Contains matches fields containing the term (field contains 'term')
+func (f TextFieldBuilder) Contains(term string) WhereCondition { return Field(f.field).Contains(term) }
+
+// NotContains matches fields not containing the term (!(field contains 'term'))
+func (f TextFieldBuilder) NotContains(term string) WhereCondition {
+	return Field(f.field).NotContains(term)
+}
+
+// Phrase matches the keywords as consecutive terms
+func (f TextFieldBuilder) Phrase(keywords ...string) WhereCondition {
+	return Field(f.field).Contains(keywords, WithPhraseMatching())
+}
+
+// Near matches the keywords occurring close to each other, in any order
+func (f TextFieldBuilder) Near(keywords ...string) WhereCondition {
+	return Field(f.field).Contains(keywords, WithNearMatching())
+}
+
+// TensorFieldBuilder builds nearest neighbor conditions on tensor attributes
+type TensorFieldBuilder struct {
+	field string
+}
+
+// TensorField returns a builder for a tensor attribute, e.g.
+// TensorField("embedding").NearestNeighbor("q", 10)
+func TensorField(name string) TensorFieldBuilder {
+	return TensorFieldBuilder{field: name}
+}
+
+// Name returns the field name
+func (f TensorFieldBuilder) Name() string { return f.field }
+
+// NearestNeighbor finds the targetHits documents closest to the query
+// tensor input.query(queryVector)
+func (f TensorFieldBuilder) NearestNeighbor(queryVector string, targetHits int, opts ...NearestNeighborOption) WhereCondition {
+	return Field(f.field).NearestNeighbor(queryVector, targetHits, opts...)
+}
+
+// BoolFieldBuilder builds conditions on bool attributes
+type BoolFieldBuilder struct {
+	field string
+}
+
+// BoolField returns a builder for a bool attribute, e.g.
+// BoolField("in_stock").Eq(true)
+func BoolField(name string) BoolFieldBuilder {
+	return BoolFieldBuilder{field: name}
+}
+
+// Name returns the field name
+func (f BoolFieldBuilder) Name() string { return f.field }
+
+// Eq matches documents where the field is value (field = true)
+func (f BoolFieldBuilder) Eq(value bool) WhereCondition { return Field(f.field).Eq(value) }
+
+// ArrayFieldBuilder builds sameElement conditions on arrays of struct. Its
+// conditions name fields of the struct.
+type ArrayFieldBuilder struct {
+	field string
+}
+
+// ArrayField returns a builder for an array of struct field, e.g.
+// ArrayField("sizes").ContainsSameElement(StringField("label").Eq("M"))
+func ArrayField(name string) ArrayFieldBuilder {
+	return ArrayFieldBuilder{field: name}
+}
+
+// Name returns the field name
+func (f ArrayFieldBuilder) Name() string { return f.field }
+
+// ContainsSameElement matches arrays with an element satisfying all conditions
+func (f ArrayFieldBuilder) ContainsSameElement(conditions ...WhereCondition) WhereCondition {
+	return Field(f.field).ContainsSameElement(conditions...)
+}
+
+// MapFieldBuilder builds conditions on map fields with string keys. Values
+// have no typed builder, since their type is not known here; address them
+// with Field(name).Value() or inside sameElement with Value().
+type MapFieldBuilder struct {
+	field string
+}
+
+// MapField returns a builder for a map field, e.g.
+// MapField("attributes").ContainsEntry("color", Value().Eq("red"))
+func MapField(name string) MapFieldBuilder {
+	return MapFieldBuilder{field: name}
+}
+
+// Name returns the field name
+func (f MapFieldBuilder) Name() string { return f.field }
+
+// ContainsSameElement matches maps with an entry satisfying all conditions,
+// which name the key and value fields of the entry
+func (f MapFieldBuilder) ContainsSameElement(conditions ...WhereCondition) WhereCondition {
+	return Field(f.field).ContainsSameElement(conditions...)
+}
+
+// ContainsEntry matches entries with the given key whose value satisfies all
+// conditions
+func (f MapFieldBuilder) ContainsEntry(key string, conditions ...WhereCondition) WhereCondition {
+	return Field(f.field).ContainsEntry(key, conditions...)
+}
+
+// Key addresses the keys of the map outside sameElement
+func (f MapFieldBuilder) Key() StringFieldBuilder {
+	return StringField(f.field + ".key")
+}
+
+func anyValues[T any](values []T) []interface{} {
+	result := make([]interface{}, len(values))
+	for i, v := range values {
+		result[i] = v
+	}
+	return result
+}
@@ -0,0 +1,106 @@
+package vespa
+
+import "testing"
+
+func TestTypedFields(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition WhereCondition
+		expected  string
+	}{
+		{"numeric eq", NumericField[int32]("stock").Eq(0), "(stock = 0)"},
+		{"numeric not eq", NumericField[int64]("id").NotEq(7), "(id != 7)"},
+		{"numeric gt", NumericField[float64]("rating").Gt(4.5), "(rating > 4.5)"},
+		{"numeric gte", NumericField[int32]("price").Gte(100), "(price >= 100)"},
+		{"numeric lt", NumericField[float32]("weight").Lt(2.5), "(weight < 2.5)"},
+		{"numeric lte", NumericField[int8]("level").Lte(3), "(level <= 3)"},
+		{"numeric between", NumericField[int32]("price").Between(50, 150), "((price >= 50) and (price <= 150))"},
+		{"integer in", IntegerField[int32]("category").In(1, 2), "(category in (1, 2))"},
+		{"integer not in", IntegerField[int64]("category").NotIn(3), "(category not in (3))"},
+		{"integer comparison", IntegerField[uint8]("level").Between(1, 3), "((level >= 1) and (level <= 3))"},
+		{"string eq", StringField("brand").Eq("nike"), "(brand contains 'nike')"},
+		{"string not eq", StringField("brand").NotEq("nike"), "!(brand contains 'nike')"},
+		{"string in", StringField("brand").In("nike", "adidas"), "(brand in ('nike', 'adidas'))"},
+		{"string not in", StringField("brand").NotIn("puma"), "(brand not in ('puma'))"},
+		{"string prefix", StringField("brand").Prefix("ni"), "(brand contains ({prefix:true}'ni'))"},
+		{"string fuzzy", StringField("brand").Fuzzy("nkie"), "(brand contains fuzzy('nkie'))"},
+		{"string matches", StringField("sku").Matches("^AB"), "(sku matches '^AB')"},
+		{"text contains", TextField("title").Contains("shoes"), "(title contains 'shoes')"},
+		{"text not contains", TextField("title").NotContains("used"), "(title not contains 'used')"},
+		{"text phrase", TextField("title").Phrase("running", "shoes"), "(title contains phrase('running', 'shoes'))"},
+		{"text near", TextField("title").Near("trail", "shoes"), "(title contains near('trail', 'shoes'))"},
+		{"tensor", TensorField("embedding").NearestNeighbor("q", 10), "({targetHits:10}nearestNeighbor(embedding, q))"},
+		{"bool", BoolField("in_stock").Eq(true), "(in_stock = true)"},
+		{
+			"array of struct",
+			ArrayField("sizes").ContainsSameElement(StringField("label").Eq("M"), NumericField[int32]("stock").Gt(0)),
+			"(sizes contains sameElement((label contains 'M'), (stock > 0)))",
+		},
+		{
+			"map entry",
+			MapField("attributes").ContainsEntry("color", Value().Eq("red")),
+			"(attributes contains sameElement((key contains 'color'), (value contains 'red')))",
+		},
+		{"map key", MapField("attributes").Key().Eq("color"), "(attributes.key contains 'color')"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := tt.condition.ToYQL(); result != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestTypedFieldNames(t *testing.T) {
+	names := map[string]string{
+		NumericField[float32]("rating").Name(): "rating",
+		IntegerField[int32]("price").Name():    "price",
+		StringField("brand").Name():            "brand",
+		TextField("title").Name():              "title",
+		TensorField("embedding").Name():        "embedding",
+		BoolField("in_stock").Name():           "in_stock",
+		ArrayField("sizes").Name():             "sizes",
+		MapField("attributes").Name():          "attributes",
+	}
+	for result, expected := range names {
+		if result != expected {
+			t.Errorf("Expected %q, got %q", expected, result)
+		}
+	}
+}
+
+func TestTypedFieldsEvaluate(t *testing.T) {
+	document := map[string]interface{}{
+		"title": "Trail running shoes for rocky terrain",
+		"brand": "Salomon",
+		"price": 140,
+	}
+
+	tests := []struct {
+		name      string
+		condition WhereCondition
+		expected  bool
+	}{
+		{"prefix", StringField("brand").Prefix("sal"), true},
+		{"near in any order", TextField("title").Near("shoes", "trail"), true},
+		{"near within distance", TextField("title").Near("trail", "shoes", "rocky"), true},
+		{"near too far apart", TextField("title").Near("trail", "terrain"), false},
+		{"near gap between adjacent terms", TextField("title").Near("trail", "for", "rocky"), false},
+		{"near chained terms", TextField("title").Near("terrain", "shoes", "rocky", "trail"), true},
+		{"numeric", NumericField[int32]("price").Between(100, 150), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Evaluate(tt.condition, document)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
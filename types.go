@@ -1,6 +1,11 @@
 package vespa
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vipulsodha/vespa-go/schema"
+)
 
 // Operator represents comparison operators for where conditions
 type Operator string
@@ -33,22 +38,51 @@ const (
 	ExactMatch  ContainsType = "exact"
 	PhraseMatch ContainsType = "phrase"
 	FuzzyMatch  ContainsType = "fuzzy"
+	PrefixMatch ContainsType = "prefix"
+	NearMatch   ContainsType = "near"
+)
+
+// SortOrder represents the direction of an order by clause
+type SortOrder string
+
+const (
+	Ascending  SortOrder = "asc"
+	Descending SortOrder = "desc"
 )
 
+// OrderBySpec represents a single field of an order by clause
+type OrderBySpec struct {
+	Field string    `json:"field"`
+	Order SortOrder `json:"order"`
+}
+
 // QueryBuilder is the main interface for building YQL queries
 type QueryBuilder interface {
 	Select(fields ...string) QueryBuilder
 	From(sources ...string) QueryBuilder
 	Where(condition WhereCondition) QueryBuilder
 	Rank(rankExpression RankExpression) QueryBuilder
-	WithRanking(profile string) QueryBuilder
+	OrderBy(field string, order SortOrder) QueryBuilder
+	Limit(limit, offset int) QueryBuilder
+	Timeout(milliseconds int) QueryBuilder
+	Grouping(expression string) QueryBuilder
+	WithRanking(profile string, opts ...RankingOption) QueryBuilder
 	WithHits(hits int) QueryBuilder
 	WithOffset(offset int) QueryBuilder
 	WithDefaultIndex(index string) QueryBuilder
 	WithInput(key string, value interface{}) QueryBuilder
+	WithQueryInput(input QueryInput, value interface{}) QueryBuilder
+	WithParameter(name string, value interface{}) QueryBuilder
+	WithQueryProfile(profile string) QueryBuilder
 	WithQuery(query string) QueryBuilder
+	WithSchema(schemas ...*schema.Schema) QueryBuilder
+	Rewrite(fn RewriteFunc) QueryBuilder
+	Optimize() QueryBuilder
+	Clone() QueryBuilder
 	Build() (*VespaQuery, error)
 	BuildYQL() (string, error)
+	BuildPrettyYQL() (string, error)
+	BuildCanonicalYQL() (string, error)
 }
 
 // WhereCondition represents a condition in the WHERE clause
@@ -72,24 +106,75 @@ type FieldBuilder struct {
 // VespaQuery represents the final query structure
 type VespaQuery struct {
 	YQL          string                 `json:"yql"`
-	Ranking      string                 `json:"ranking,omitempty"`
+	Ranking      *Ranking               `json:"ranking,omitempty"`
 	Hits         int                    `json:"hits,omitempty"`
 	Offset       int                    `json:"offset,omitempty"`
 	DefaultIndex string                 `json:"defaultIndex,omitempty"`
 	Input        map[string]interface{} `json:"input,omitempty"`
 	Query        string                 `json:"query,omitempty"`
+	QueryProfile string                 `json:"queryProfile,omitempty"`
+	Parameters   map[string]interface{} `json:"-"` // additional request parameters, serialized at top level
 }
 
 // ValidationError represents validation errors in query building
 type ValidationError struct {
 	Field   string
 	Message string
+	Path    string // location of the offending condition, e.g. where[0].left
 }
 
 func (e ValidationError) Error() string {
+	if e.Path != "" {
+		return fmt.Sprintf("validation error in field '%s' at %s: %s", e.Field, e.Path, e.Message)
+	}
 	return fmt.Sprintf("validation error in field '%s': %s", e.Field, e.Message)
 }
 
+// ValidationErrors collects all validation errors of a query, so they can be
+// reported together. Use errors.As to access individual errors.
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("%d validation errors: %s", len(e), strings.Join(messages, "; "))
+}
+
+// Unwrap returns the individual errors
+func (e ValidationErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// add appends a validation error, or all errors of a ValidationErrors
+func (e *ValidationErrors) add(err error) {
+	switch v := err.(type) {
+	case nil:
+	case ValidationErrors:
+		*e = append(*e, v...)
+	case *ValidationError:
+		*e = append(*e, v)
+	default:
+		*e = append(*e, &ValidationError{Message: err.Error()})
+	}
+}
+
+// err returns the collected errors, or nil if there are none
+func (e ValidationErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
 // Option types for functional options pattern
 
 // NearestNeighborOption represents options for nearest neighbor operations
@@ -100,6 +185,7 @@ type NearestNeighborConfig struct {
 	Label             string
 	DistanceThreshold *float64
 	Approximate       *bool
+	Embedding         *Embedding
 }
 
 // WithLabel adds a label to the nearest neighbor operation
@@ -164,6 +250,116 @@ func WithFuzzyMatching() ContainsOption {
 	}
 }
 
+// WithPrefixMatching sets contains to match values starting with the term,
+// for attribute fields
+func WithPrefixMatching() ContainsOption {
+	return func(config *ContainsConfig) {
+		if config != nil {
+			config.Type = PrefixMatch
+		}
+	}
+}
+
+// WithNearMatching sets contains to match keywords ([]string) occurring
+// close to each other, for indexed fields
+func WithNearMatching() ContainsOption {
+	return func(config *ContainsConfig) {
+		if config != nil {
+			config.Type = NearMatch
+		}
+	}
+}
+
+// RankingOption represents options for the ranking configuration
+type RankingOption func(*Ranking)
+
+// WithListFeatures requests all rank features to be returned with each hit
+func WithListFeatures(listFeatures bool) RankingOption {
+	return func(config *Ranking) {
+		if config != nil {
+			config.ListFeatures = listFeatures
+		}
+	}
+}
+
+// WithSorting sets a sorting specification (e.g. "-price +title") that overrides relevance ordering
+func WithSorting(sorting string) RankingOption {
+	return func(config *Ranking) {
+		if config != nil {
+			config.Sorting = sorting
+		}
+	}
+}
+
+// WithFreshness sets the reference time used by the freshness rank feature
+func WithFreshness(freshness string) RankingOption {
+	return func(config *Ranking) {
+		if config != nil {
+			config.Freshness = freshness
+		}
+	}
+}
+
+// WithRerankCount sets the number of hits to rerank in the second phase per content node
+func WithRerankCount(count int) RankingOption {
+	return func(config *Ranking) {
+		if config != nil {
+			config.RerankCount = count
+		}
+	}
+}
+
+// WithGlobalPhaseRerankCount sets the number of hits to rerank in the global phase
+func WithGlobalPhaseRerankCount(count int) RankingOption {
+	return func(config *Ranking) {
+		if config != nil {
+			config.GlobalPhase = &GlobalPhase{RerankCount: count}
+		}
+	}
+}
+
+// WithMatchPhase enables match-phase degradation ordered by the given attribute
+func WithMatchPhase(attribute string, maxHits int, ascending bool) RankingOption {
+	return func(config *Ranking) {
+		if config != nil {
+			matchPhase := &MatchPhase{
+				Attribute: attribute,
+				MaxHits:   maxHits,
+				Ascending: &ascending,
+			}
+			if config.MatchPhase != nil {
+				matchPhase.MaxFilterCoverage = config.MatchPhase.MaxFilterCoverage
+			}
+			config.MatchPhase = matchPhase
+		}
+	}
+}
+
+// WithMaxFilterCoverage sets the fraction of the corpus (0 to 1) a filter may
+// match before match-phase degradation is applied. Use with WithMatchPhase.
+func WithMaxFilterCoverage(coverage float64) RankingOption {
+	return func(config *Ranking) {
+		if config != nil {
+			if config.MatchPhase == nil {
+				config.MatchPhase = &MatchPhase{}
+			}
+			config.MatchPhase.MaxFilterCoverage = &coverage
+		}
+	}
+}
+
+// WithDiversity requires at least minGroups distinct values of attribute among the results
+func WithDiversity(attribute string, minGroups int) RankingOption {
+	return func(config *Ranking) {
+		if config != nil {
+			config.Diversity = &Diversity{
+				Attribute: attribute,
+				MinGroups: minGroups,
+			}
+		}
+	}
+}
+
 // NotCondition represents a negated condition (!condition)
 type NotCondition struct {
 	Condition WhereCondition
@@ -0,0 +1,168 @@
+package vespa
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// =============================================================================
+// Condition Validation
+// =============================================================================
+
+// Validate checks a condition tree for conditions that would render invalid
+// or empty YQL: nil conditions, AND/OR without operands, empty sameElement
+// conditions, in and OR inside sameElement (rejected by Vespa), unknown
+// operators and missing field names. All problems are
+// reported together as ValidationErrors, each with the path to the offending
+// condition, e.g. "left.conditions[1]".
+//
+// Build and BuildYQL validate all where and rank conditions the same way.
+func Validate(condition WhereCondition) error {
+	return validateCondition(condition, "condition", "", false).err()
+}
+
+// validateConditions validates a list of conditions, e.g. the where clause
+func validateConditions(root string, conditions []WhereCondition) ValidationErrors {
+	var errs ValidationErrors
+	for i, condition := range conditions {
+		errs = append(errs, validateCondition(condition, root, fmt.Sprintf("%s[%d]", root, i), false)...)
+	}
+	return errs
+}
+
+// validateCondition validates a condition and its children. inSameElement is
+// set for the operands of a sameElement condition, where Vespa rejects in and OR.
+func validateCondition(condition WhereCondition, root, path string, inSameElement bool) ValidationErrors {
+	var errs ValidationErrors
+	fail := func(field, format string, args ...interface{}) {
+		if field == "" {
+			field = root
+		}
+		errs = append(errs, &ValidationError{Field: field, Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+	child := func(c WhereCondition, segment string) {
+		errs = append(errs, validateCondition(c, root, joinPath(path, segment), inSameElement)...)
+	}
+	children := func(conditions []WhereCondition) {
+		for i, c := range conditions {
+			child(c, fmt.Sprintf("conditions[%d]", i))
+		}
+	}
+
+	if isNilCondition(condition) {
+		fail("", "condition is nil")
+		return errs
+	}
+
+	switch c := condition.(type) {
+	case *FieldCondition:
+		validateFieldCondition(c, fail)
+		if inSameElement && (c.Operator == IN || c.Operator == NOT_IN) {
+			fail(c.Field, "%s is not supported inside sameElement, use DistributeSameElement or a top-level OR of sameElement conditions", c.Operator)
+		}
+	case *RangeCondition:
+		if c.Field == "" {
+			fail("", "range condition requires a field name")
+		}
+		if c.Min == nil || c.Max == nil {
+			fail(c.Field, "range condition requires both bounds")
+		}
+	case *BooleanCondition:
+		if !isJunctionOperator(c.Operator) {
+			fail("", "unknown boolean operator '%s', expected AND or OR", c.Operator)
+		}
+		if inSameElement && strings.EqualFold(c.Operator, "OR") {
+			fail("", "OR is not supported inside sameElement, use DistributeSameElement or a top-level OR of sameElement conditions")
+		}
+		child(c.Left, "left")
+		child(c.Right, "right")
+	case *JunctionCondition:
+		if !isJunctionOperator(c.Operator) {
+			fail("", "unknown boolean operator '%s', expected AND or OR", c.Operator)
+		}
+		if len(c.Conditions) == 0 {
+			fail("", "%s requires at least one condition", strings.ToUpper(c.Operator))
+		}
+		if inSameElement && strings.EqualFold(c.Operator, "OR") {
+			fail("", "OR is not supported inside sameElement, use DistributeSameElement or a top-level OR of sameElement conditions")
+		}
+		children(c.Conditions)
+	case *NotCondition:
+		child(c.Condition, "condition")
+	case *SameElementCondition:
+		if c.Field == "" {
+			fail("", "sameElement requires a field name")
+		}
+		if len(c.Conditions) == 0 {
+			fail(c.Field, "sameElement requires at least one condition")
+		}
+		for i, inner := range c.Conditions {
+			errs = append(errs, validateCondition(inner, root, joinPath(path, fmt.Sprintf("conditions[%d]", i)), true)...)
+		}
+	case *NearestNeighbor:
+		if c.Field == "" || c.QueryVector == "" {
+			fail(c.Field, "nearestNeighbor requires a field and a query vector")
+		}
+		if c.TargetHits <= 0 {
+			fail(c.Field, "nearestNeighbor requires a positive targetHits, got %d", c.TargetHits)
+		}
+	case *CustomFeature:
+		if strings.TrimSpace(c.Expression) == "" {
+			fail("", "custom condition has an empty expression")
+		}
+	case *UserQueryFeature, *ConstantCondition:
+	default:
+		// Conditions implemented outside this package
+		if condition.ToYQL() == "" {
+			fail("", "condition of type %T renders empty YQL", condition)
+		}
+		if node, ok := condition.(Node); ok {
+			for i, c := range node.Children() {
+				child(c, fmt.Sprintf("children[%d]", i))
+			}
+		}
+	}
+	return errs
+}
+
+func validateFieldCondition(c *FieldCondition, fail func(field, format string, args ...interface{})) {
+	if c.Field == "" {
+		fail("", "condition requires a field name")
+	}
+	if !isKnownOperator(c.Operator) {
+		fail(c.Field, "unknown operator '%s'", c.Operator)
+		return
+	}
+
+	switch c.Operator {
+	case IN, NOT_IN:
+		if rv := reflect.ValueOf(c.Value); rv.Kind() == reflect.Slice && rv.Len() == 0 {
+			fail(c.Field, "%s requires at least one value", c.Operator)
+		}
+	case CONTAINS:
+		if keywords, ok := c.Value.([]string); ok && (c.ContainsType == PhraseMatch || c.ContainsType == NearMatch) && len(keywords) == 0 {
+			fail(c.Field, "%s requires at least one keyword", c.ContainsType)
+		}
+	}
+}
+
+func isJunctionOperator(operator string) bool {
+	return strings.EqualFold(operator, "AND") || strings.EqualFold(operator, "OR")
+}
+
+// isNilCondition reports whether a condition is nil, including typed nil pointers
+func isNilCondition(condition WhereCondition) bool {
+	if condition == nil {
+		return true
+	}
+	rv := reflect.ValueOf(condition)
+	return rv.Kind() == reflect.Ptr && rv.IsNil()
+}
+
+func joinPath(path, segment string) string {
+	if path == "" {
+		return segment
+	}
+	return path + "." + segment
+}
@@ -0,0 +1,225 @@
+package vespa
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition WhereCondition
+		paths     []string
+	}{
+		{"Valid", And(Field("a").Eq(1), Not(Field("b").Contains("x"))), nil},
+		{"Nil", nil, []string{""}},
+		{"Nil child", And(Field("a").Eq(1), nil), []string{"right"}},
+		{"Empty And", And(), []string{""}},
+		{"Empty Or nested", Not(Or()), []string{"condition"}},
+		{"Unknown operator", &FieldCondition{Field: "a", Operator: "~", Value: 1}, []string{""}},
+		{"Missing field", Field("").Eq(1), []string{""}},
+		{"Empty in", Field("a").In(), []string{""}},
+		{"Empty sameElement", Field("sizes").ContainsSameElement(), []string{""}},
+		{"Nested sameElement error", Field("sizes").ContainsSameElement(Field("a").Eq(1), nil), []string{"conditions[1]"}},
+		{"In inside sameElement", Field("sizes").ContainsSameElement(Field("family").Eq("US"), Field("size").In("10", "11")),
+			[]string{"conditions[1]"}},
+		{"Or inside sameElement", Field("sizes").ContainsSameElement(And(Field("family").Eq("US"), Or(Field("a").Eq(1), Field("b").Eq(2)))),
+			[]string{"conditions[0].right"}},
+		{"Junction or inside sameElement", Field("sizes").ContainsSameElement(&JunctionCondition{Operator: "OR",
+			Conditions: []WhereCondition{Field("a").Eq(1), Field("b").Eq(2), Field("c").NotIn(3)}}),
+			[]string{"conditions[0]", "conditions[0].conditions[2]"}},
+		{"Or around sameElement", Or(Field("sizes").ContainsSameElement(Field("a").Eq(1)), Field("size").In("10")), nil},
+		{"Typed nil", And(Field("a").Eq(1), (*FieldCondition)(nil)), []string{"right"}},
+		{"Range without bounds", &RangeCondition{Field: "price"}, []string{""}},
+		{"Empty custom", Custom(""), []string{""}},
+		{"Multiple", Or(And(nil, Field("").Eq(1)), Field("s").ContainsSameElement()),
+			[]string{"left.left", "left.right", "right"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.condition)
+			if tt.paths == nil {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+
+			var errs ValidationErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("Expected ValidationErrors, got %v", err)
+			}
+			var paths []string
+			for _, e := range errs {
+				paths = append(paths, e.Path)
+			}
+			if strings.Join(paths, ",") != strings.Join(tt.paths, ",") {
+				t.Errorf("Expected errors at %v, got %v", tt.paths, errs)
+			}
+		})
+	}
+}
+
+func TestBuildReportsAllErrors(t *testing.T) {
+	_, err := NewQueryBuilder().
+		Where(Field("price").Gt(10)).
+		Where(And()).
+		Where(Or(Field("brand").Eq("nike"), nil)).
+		Rank(NewRank().AddCondition(Field("title").ContainsSameElement())).
+		Limit(-1, 0).
+		Build()
+
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Expected ValidationErrors, got %v", err)
+	}
+
+	expected := []string{
+		"sources: ",
+		"limit: ",
+		"where: where[1]",
+		"where: where[2].right",
+		"title: rank[0]",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, got %d: %v", len(expected), len(errs), err)
+	}
+	for i, e := range errs {
+		if actual := e.Field + ": " + e.Path; actual != expected[i] {
+			t.Errorf("Expected error %d at %q, got %q", i, expected[i], actual)
+		}
+	}
+
+	if !strings.HasPrefix(err.Error(), "5 validation errors: ") {
+		t.Errorf("Unexpected error message %q", err.Error())
+	}
+
+	var first *ValidationError
+	if !errors.As(err, &first) || first.Field != "sources" {
+		t.Errorf("Expected errors.As to find the first ValidationError, got %v", first)
+	}
+}
+
+func TestBuildYQLDoesNotPanicOnNilConditions(t *testing.T) {
+	yql, err := NewQueryBuilder().From("products").Where(And(nil, nil)).Where(Not(nil)).BuildYQL()
+	if err == nil {
+		t.Fatalf("Expected error, got YQL %q", yql)
+	}
+
+	var errs ValidationErrors
+	if !errors.As(err, &errs) || len(errs) != 3 {
+		t.Errorf("Expected 3 errors, got %v", err)
+	}
+}
+
+func TestDistributeSameElement(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition WhereCondition
+		expected  string
+	}{
+		{
+			"In",
+			Field("sizes").ContainsSameElement(Field("family").Eq("US"), Field("size").In("10", "11")),
+			"((sizes contains sameElement((family contains 'US'), (size contains '10'))) OR " +
+				"(sizes contains sameElement((family contains 'US'), (size contains '11'))))",
+		},
+		{
+			"Or",
+			Field("sizes").ContainsSameElement(Or(Field("family").Eq("US"), Field("family").Eq("EU")), Field("size").Gte(10)),
+			"((sizes contains sameElement((family contains 'US'), (size >= 10))) OR " +
+				"(sizes contains sameElement((family contains 'EU'), (size >= 10))))",
+		},
+		{
+			"Cross product",
+			Field("s").ContainsSameElement(Field("a").In(1, 2), Field("b").In(3, 4)),
+			"((s contains sameElement((a = 1), (b = 3))) OR (s contains sameElement((a = 1), (b = 4))) OR " +
+				"(s contains sameElement((a = 2), (b = 3))) OR (s contains sameElement((a = 2), (b = 4))))",
+		},
+		{
+			"Unchanged",
+			Field("s").ContainsSameElement(Field("a").Eq(1), And(Field("b").Eq(2), Field("c").Eq(3))),
+			"(s contains sameElement((a = 1), ((b = 2) AND (c = 3))))",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := DistributeSameElement(tt.condition)
+			if actual := result.ToYQL(); actual != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, actual)
+			}
+			if err := Validate(result); err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+
+	unchanged := Field("s").ContainsSameElement(Field("a").Eq(1), And(Field("b").Eq(2), Field("c").Eq(3)))
+	if DistributeSameElement(unchanged) != unchanged {
+		t.Error("Expected condition without in or OR to be returned as-is")
+	}
+}
+
+func TestDistributeSameElementLimit(t *testing.T) {
+	// 10^8 alternatives in a single operand must be rejected before they are expanded
+	var ors []WhereCondition
+	for i := 0; i < 8; i++ {
+		var values []WhereCondition
+		for j := 0; j < 10; j++ {
+			values = append(values, Field(fmt.Sprintf("f%d", i)).Eq(j))
+		}
+		ors = append(ors, Or(values...))
+	}
+
+	values := func(n int) []interface{} {
+		list := make([]interface{}, n)
+		for i := range list {
+			list[i] = i
+		}
+		return list
+	}
+
+	tests := []struct {
+		name      string
+		condition WhereCondition
+	}{
+		{"Nested AND of ORs", Field("s").ContainsSameElement(And(ors...))},
+		{"Operands", Field("s").ContainsSameElement(ors...)},
+		{"Large in", Field("s").ContainsSameElement(Field("a").In(values(257)...))},
+		{"Wide OR", Field("s").ContainsSameElement(Or(Field("a").In(values(200)...), Field("b").In(values(100)...)))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := DistributeSameElement(tt.condition); result != tt.condition {
+				t.Errorf("Expected condition over the limit to be returned as-is, got %d characters", len(result.ToYQL()))
+			}
+		})
+	}
+}
+
+func TestBuilderDistributeSameElement(t *testing.T) {
+	builder := NewQueryBuilder().
+		From("products").
+		Where(Field("in_stock").Eq(true)).
+		Where(Field("sizes").ContainsSameElement(Field("family").Eq("US"), Field("size").In("10", "11")))
+
+	if _, err := builder.BuildYQL(); err == nil {
+		t.Fatal("Expected error for in inside sameElement")
+	}
+
+	yql, err := builder.Rewrite(DistributeSameElement).BuildYQL()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := "select * from sources products where (in_stock = true) and " +
+		"((sizes contains sameElement((family contains 'US'), (size contains '10'))) OR " +
+		"(sizes contains sameElement((family contains 'US'), (size contains '11'))))"
+	if yql != expected {
+		t.Errorf("Expected %q, got %q", expected, yql)
+	}
+}
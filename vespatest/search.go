@@ -0,0 +1,425 @@
+package vespatest
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	vespa "github.com/vipulsodha/vespa-go"
+)
+
+// defaultHits is Vespa's default number of hits per page
+const defaultHits = 10
+
+// searchRequest holds the parsed parameters of a /search/ request
+type searchRequest struct {
+	query        *vespa.ParsedQuery
+	hits         int
+	offset       int
+	sorting      string
+	text         string
+	defaultIndex string
+	inputs       map[string]interface{}
+}
+
+// handleSearch serves /search/ requests as GET parameters or a JSON body
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	params, err := requestParameters(r)
+	if err != nil {
+		writeSearchError(w, http.StatusBadRequest, codeInvalidParameters, "Invalid query parameter", err.Error())
+		return
+	}
+
+	request, err := parseSearchRequest(params)
+	if err != nil {
+		writeSearchError(w, http.StatusBadRequest, codeIllegalQuery, "Illegal query", err.Error())
+		return
+	}
+
+	result, err := s.search(request)
+	if err != nil {
+		writeSearchError(w, http.StatusBadRequest, codeUnsupported, "Unsupported query", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// requestParameters returns the request parameters with dotted names, from
+// either the URL or a JSON body
+func requestParameters(r *http.Request) (map[string]interface{}, error) {
+	if r.Method == http.MethodGet {
+		params := make(map[string]interface{})
+		for name, values := range r.URL.Query() {
+			params[name] = values[0]
+		}
+		return params, nil
+	}
+
+	data, err := readBody(r)
+	if err != nil {
+		return nil, err
+	}
+	var query vespa.VespaQuery
+	if err := json.Unmarshal(data, &query); err != nil {
+		return nil, fmt.Errorf("invalid request body: %v", err)
+	}
+	return query.RequestParameters()
+}
+
+func parseSearchRequest(params map[string]interface{}) (*searchRequest, error) {
+	yql, _ := params["yql"].(string)
+	if yql == "" {
+		return nil, fmt.Errorf("missing yql parameter")
+	}
+	parsed, err := vespa.ParseYQL(yql)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Grouping != "" {
+		return nil, fmt.Errorf("grouping is not supported by vespatest")
+	}
+
+	request := &searchRequest{
+		query:        parsed,
+		hits:         defaultHits,
+		sorting:      stringParam(params, "ranking.sorting", "sorting"),
+		text:         stringParam(params, "query"),
+		defaultIndex: stringParam(params, "model.defaultIndex", "defaultIndex"),
+		inputs:       make(map[string]interface{}),
+	}
+	if hits, ok := intParam(params, "hits"); ok {
+		request.hits = hits
+	}
+	if offset, ok := intParam(params, "offset"); ok {
+		request.offset = offset
+	}
+	if parsed.Limit > 0 {
+		// limit is the end of the page, not its size
+		request.offset = parsed.Offset
+		request.hits = parsed.Limit - parsed.Offset
+	}
+
+	for name, value := range params {
+		if strings.HasPrefix(name, "input.query(") {
+			request.inputs[name] = value
+		}
+	}
+	return request, nil
+}
+
+func stringParam(params map[string]interface{}, names ...string) string {
+	for _, name := range names {
+		if value, ok := params[name]; ok {
+			return fmt.Sprint(value)
+		}
+	}
+	return ""
+}
+
+func intParam(params map[string]interface{}, name string) (int, bool) {
+	value, ok := params[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(fmt.Sprint(value))
+	return n, err == nil && n >= 0
+}
+
+// =============================================================================
+// Matching and Ranking
+// =============================================================================
+
+type match struct {
+	doc       document
+	relevance float64
+}
+
+func (s *Server) search(request *searchRequest) (*vespa.SearchResult, error) {
+	documents := s.snapshot(request.query.Sources)
+
+	conditions := append([]vespa.WhereCondition(nil), request.query.Where...)
+	if rank, ok := request.query.Rank.(*vespa.RankExpressionImpl); ok {
+		// Only the first operand of rank() selects documents
+		if rankConditions := rank.Conditions(); len(rankConditions) > 0 {
+			conditions = append(conditions, rankConditions[0])
+		}
+	}
+	var where vespa.WhereCondition = vespa.True()
+	if len(conditions) > 0 {
+		where = &vespa.JunctionCondition{Conditions: conditions, Operator: "AND"}
+	}
+
+	neighbors, err := s.nearestNeighbors(where, documents, request.inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []match
+	for i, doc := range documents {
+		var relevance float64
+		rewritten := vespa.Rewrite(where, func(c vespa.WhereCondition) vespa.WhereCondition {
+			switch c := c.(type) {
+			case *vespa.NearestNeighbor:
+				closeness, ok := neighbors[c][i]
+				relevance = math.Max(relevance, closeness)
+				return constant(ok)
+			case *vespa.UserQueryFeature:
+				return constant(userQueryMatches(doc.fields, request.text, c.DefaultIndex, request.defaultIndex))
+			default:
+				return c
+			}
+		})
+
+		matched, err := vespa.Evaluate(rewritten, doc.fields)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			matches = append(matches, match{doc: doc, relevance: relevance})
+		}
+	}
+
+	sortMatches(matches, request)
+
+	children := make([]vespa.Hit, 0)
+	for i := request.offset; i < len(matches) && i < request.offset+request.hits; i++ {
+		children = append(children, hit(matches[i], request.query.Select))
+	}
+
+	return &vespa.SearchResult{Root: vespa.Hit{
+		ID:        "toplevel",
+		Relevance: 1,
+		Fields:    map[string]interface{}{"totalCount": len(matches)},
+		Coverage: &vespa.Coverage{
+			Coverage: 100, Documents: int64(len(documents)), Full: true, Nodes: 1, Results: 1, ResultsFull: 1,
+		},
+		Children: children,
+	}}, nil
+}
+
+func constant(value bool) vespa.WhereCondition {
+	if value {
+		return vespa.True()
+	}
+	return vespa.False()
+}
+
+// nearestNeighbors finds the targetHits closest documents of every
+// nearestNeighbor condition by brute force, returning their closeness by
+// document index
+func (s *Server) nearestNeighbors(where vespa.WhereCondition, documents []document, inputs map[string]interface{}) (map[*vespa.NearestNeighbor]map[int]float64, error) {
+	neighbors := make(map[*vespa.NearestNeighbor]map[int]float64)
+
+	var err error
+	vespa.Inspect(where, func(c vespa.WhereCondition) bool {
+		nn, ok := c.(*vespa.NearestNeighbor)
+		if !ok || err != nil {
+			return err == nil
+		}
+
+		key := fmt.Sprintf("input.query(%s)", nn.QueryVector)
+		queryVector, ok := vector(inputs[key])
+		if !ok {
+			err = fmt.Errorf("nearestNeighbor requires a vector for %s", key)
+			return false
+		}
+
+		type candidate struct {
+			index    int
+			distance float64
+		}
+		var candidates []candidate
+		for i, doc := range documents {
+			docVector, ok := vector(doc.fields[nn.Field])
+			if !ok || len(docVector) != len(queryVector) {
+				continue
+			}
+			distance := euclideanDistance(docVector, queryVector)
+			if nn.DistanceThreshold != nil && distance > *nn.DistanceThreshold {
+				continue
+			}
+			candidates = append(candidates, candidate{index: i, distance: distance})
+		}
+		sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].distance < candidates[j].distance })
+		if len(candidates) > nn.TargetHits {
+			candidates = candidates[:nn.TargetHits]
+		}
+
+		closeness := make(map[int]float64, len(candidates))
+		for _, c := range candidates {
+			closeness[c.index] = 1 / (1 + c.distance)
+		}
+		neighbors[nn] = closeness
+		return true
+	})
+	return neighbors, err
+}
+
+// vector reads a tensor value as a list of numbers, in array or short form
+// ({"values": [...]}); strings are decoded as JSON first
+func vector(value interface{}) ([]float64, bool) {
+	if s, ok := value.(string); ok {
+		var decoded interface{}
+		if err := json.Unmarshal([]byte(s), &decoded); err != nil {
+			return nil, false
+		}
+		value = decoded
+	}
+	if object, ok := value.(map[string]interface{}); ok {
+		value = object["values"]
+	}
+
+	list, ok := value.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, false
+	}
+	values := make([]float64, len(list))
+	for i, element := range list {
+		f, err := strconv.ParseFloat(fmt.Sprint(element), 64)
+		if err != nil {
+			return nil, false
+		}
+		values[i] = f
+	}
+	return values, true
+}
+
+func euclideanDistance(a, b []float64) float64 {
+	var sum float64
+	for i := range a {
+		d := a[i] - b[i]
+		sum += d * d
+	}
+	return math.Sqrt(sum)
+}
+
+// userQueryMatches reports whether any token of the query text occurs in the
+// default index, or in any field for the index "default"
+func userQueryMatches(fields map[string]interface{}, text string, indexes ...string) bool {
+	tokens := strings.Fields(text)
+	if len(tokens) == 0 {
+		return false
+	}
+
+	index := "default"
+	for _, candidate := range indexes {
+		if candidate != "" {
+			index = candidate
+			break
+		}
+	}
+
+	names := []string{index}
+	if _, ok := fields[index]; !ok && index == "default" {
+		names = names[:0]
+		for name := range fields {
+			names = append(names, name)
+		}
+	}
+
+	for _, name := range names {
+		for _, token := range tokens {
+			if matched, _ := vespa.Evaluate(vespa.Field(name).Contains(token), fields); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sortMatches orders matches by the order by clause, ranking.sorting or
+// relevance, falling back to feed order
+func sortMatches(matches []match, request *searchRequest) {
+	specs := request.query.OrderBy
+	if len(specs) == 0 && request.sorting != "" {
+		specs = parseSorting(request.sorting)
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		for _, spec := range specs {
+			c := compareFields(matches[i].doc.fields[spec.Field], matches[j].doc.fields[spec.Field])
+			if c != 0 {
+				if spec.Order == vespa.Descending {
+					return c > 0
+				}
+				return c < 0
+			}
+		}
+		if len(specs) == 0 && matches[i].relevance != matches[j].relevance {
+			return matches[i].relevance > matches[j].relevance
+		}
+		return matches[i].doc.sequence < matches[j].doc.sequence
+	})
+}
+
+// parseSorting parses a sorting specification such as "+price -rating"
+func parseSorting(sorting string) []vespa.OrderBySpec {
+	var specs []vespa.OrderBySpec
+	for _, field := range strings.Fields(sorting) {
+		order := vespa.Ascending
+		switch field[0] {
+		case '-':
+			order = vespa.Descending
+			field = field[1:]
+		case '+':
+			field = field[1:]
+		}
+		specs = append(specs, vespa.OrderBySpec{Field: field, Order: order})
+	}
+	return specs
+}
+
+// compareFields compares two attribute values; missing values sort last
+func compareFields(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+
+	x, errX := strconv.ParseFloat(fmt.Sprint(a), 64)
+	y, errY := strconv.ParseFloat(fmt.Sprint(b), 64)
+	if errX == nil && errY == nil {
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		default:
+			return 0
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// hit renders a matched document with the selected fields
+func hit(m match, selected []string) vespa.Hit {
+	fields := map[string]interface{}{
+		"sddocname":  m.doc.docType,
+		"documentid": m.doc.docID(),
+	}
+	if len(selected) == 0 {
+		for name, value := range m.doc.fields {
+			fields[name] = value
+		}
+	}
+	for _, name := range selected {
+		if value, ok := m.doc.fields[name]; ok {
+			fields[name] = value
+		}
+	}
+
+	return vespa.Hit{
+		ID:        m.doc.docID(),
+		Relevance: m.relevance,
+		Source:    m.doc.docType,
+		Fields:    fields,
+	}
+}
@@ -0,0 +1,324 @@
+// Package vespatest provides an in-process fake Vespa container for
+// integration tests. It serves the /search/ and /document/v1/ APIs, stores
+// fed documents in memory and evaluates queries with the vespa package's
+// parser and offline evaluator.
+//
+// The fake supports the subset of Vespa needed to test client code: where
+// filters (see vespa.Evaluate), userQuery() as a token match against the
+// default index, brute-force nearestNeighbor with euclidean distance, order
+// by and ranking.sorting, hits, offset, limit and the select clause. Grouping
+// and custom rank features are rejected with a 400 response.
+package vespatest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+
+	vespa "github.com/vipulsodha/vespa-go"
+)
+
+// Server is a fake Vespa container listening on a local address. It is safe
+// for concurrent use.
+type Server struct {
+	// URL is the base URL of the server, e.g. http://127.0.0.1:51234
+	URL string
+
+	server    *httptest.Server
+	mu        sync.RWMutex
+	documents map[string]*document // keyed by document type and id
+	sequence  int
+}
+
+type document struct {
+	namespace string
+	docType   string
+	id        string
+	fields    map[string]interface{}
+	sequence  int // feed order, used as the final tie-breaker when ranking
+}
+
+// docID returns the full Vespa document id, e.g. id:shop:product::1
+func (d *document) docID() string {
+	return fmt.Sprintf("id:%s:%s::%s", d.namespace, d.docType, d.id)
+}
+
+// NewServer starts a fake Vespa server. Call Close when done.
+func NewServer() *Server {
+	s := &Server{documents: make(map[string]*document)}
+	s.server = httptest.NewServer(s.Handler())
+	s.URL = s.server.URL
+	return s
+}
+
+// Close shuts down the server
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Client returns a vespa.Client for the server
+func (s *Server) Client(opts ...vespa.ClientOption) *vespa.Client {
+	return vespa.NewClient(s.URL, opts...)
+}
+
+// Handler returns the HTTP handler of the fake, for use with a custom server
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/search/", s.handleSearch)
+	mux.HandleFunc("/document/v1/", s.handleDocument)
+	return mux
+}
+
+// Feed stores a document directly, as a POST to /document/v1/ would. fields is
+// a map or a struct that encodes to a JSON object. The namespace is "test".
+func (s *Server) Feed(docType, id string, fields interface{}) error {
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	decoded, err := decodeFields(data)
+	if err != nil {
+		return err
+	}
+	s.put("test", docType, id, decoded)
+	return nil
+}
+
+// Len returns the number of stored documents
+func (s *Server) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.documents)
+}
+
+// Reset removes all documents
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.documents = make(map[string]*document)
+}
+
+func (s *Server) put(namespace, docType, id string, fields map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := docType + "/" + id
+	if existing, ok := s.documents[key]; ok {
+		existing.namespace = namespace
+		existing.fields = fields
+		return
+	}
+	s.sequence++
+	s.documents[key] = &document{namespace: namespace, docType: docType, id: id, fields: fields, sequence: s.sequence}
+}
+
+// snapshot returns copies of the stored documents of the given types (all
+// types if empty) in feed order. Field maps are replaced, never modified, so
+// the copies can be read without holding the lock.
+func (s *Server) snapshot(docTypes []string) []document {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	wanted := make(map[string]bool, len(docTypes))
+	for _, docType := range docTypes {
+		wanted[docType] = true
+	}
+
+	documents := make([]document, 0, len(s.documents))
+	for _, doc := range s.documents {
+		if len(wanted) == 0 || wanted["*"] || wanted[doc.docType] {
+			documents = append(documents, *doc)
+		}
+	}
+	sort.Slice(documents, func(i, j int) bool { return documents[i].sequence < documents[j].sequence })
+	return documents
+}
+
+// =============================================================================
+// Responses
+// =============================================================================
+
+// Vespa error codes used in responses
+const (
+	codeIllegalQuery      = 3
+	codeInvalidParameters = 4
+	codeUnsupported       = 10
+)
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeSearchError(w http.ResponseWriter, status, code int, summary, message string) {
+	writeJSON(w, status, vespa.SearchResult{Root: vespa.Hit{
+		ID:        "toplevel",
+		Relevance: 1,
+		Fields:    map[string]interface{}{"totalCount": 0},
+		Errors:    []vespa.SearchError{{Code: code, Summary: summary, Message: message}},
+	}})
+}
+
+func decodeFields(data []byte) (map[string]interface{}, error) {
+	var fields map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, err
+	}
+	if fields == nil {
+		fields = make(map[string]interface{})
+	}
+	return fields, nil
+}
+
+func readBody(r *http.Request) ([]byte, error) {
+	defer r.Body.Close()
+	return io.ReadAll(r.Body)
+}
+
+// =============================================================================
+// Document API
+// =============================================================================
+
+// handleDocument serves /document/v1/<namespace>/<type>/docid/<id>
+func (s *Server) handleDocument(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/document/v1/"), "/"), "/")
+	if len(parts) < 3 || parts[2] != "docid" || len(parts) > 4 {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"pathId":  r.URL.Path,
+			"message": "expected /document/v1/<namespace>/<document-type>/docid/<id>",
+		})
+		return
+	}
+	namespace, docType := parts[0], parts[1]
+
+	if len(parts) == 3 {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]interface{}{"pathId": r.URL.Path, "message": "visiting only supports GET"})
+			return
+		}
+		s.visit(w, r, docType)
+		return
+	}
+
+	id := parts[3]
+	doc := &document{namespace: namespace, docType: docType, id: id}
+	response := map[string]interface{}{"pathId": r.URL.Path, "id": doc.docID()}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.mu.RLock()
+		stored, ok := s.documents[docType+"/"+id]
+		var fields map[string]interface{}
+		if ok {
+			fields = stored.fields
+		}
+		s.mu.RUnlock()
+		if !ok {
+			writeJSON(w, http.StatusNotFound, response)
+			return
+		}
+		response["fields"] = fields
+		writeJSON(w, http.StatusOK, response)
+	case http.MethodPost:
+		fields, err := readDocumentFields(r)
+		if err != nil {
+			response["message"] = err.Error()
+			writeJSON(w, http.StatusBadRequest, response)
+			return
+		}
+		s.put(namespace, docType, id, fields)
+		writeJSON(w, http.StatusOK, response)
+	case http.MethodPut:
+		if err := s.update(r, docType, id); err != nil {
+			response["message"] = err.Error()
+			status := http.StatusBadRequest
+			if err == errNotFound {
+				status = http.StatusNotFound
+			}
+			writeJSON(w, status, response)
+			return
+		}
+		writeJSON(w, http.StatusOK, response)
+	case http.MethodDelete:
+		s.mu.Lock()
+		delete(s.documents, docType+"/"+id)
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, response)
+	default:
+		response["message"] = fmt.Sprintf("method %s not supported", r.Method)
+		writeJSON(w, http.StatusMethodNotAllowed, response)
+	}
+}
+
+var errNotFound = fmt.Errorf("document not found")
+
+func readDocumentFields(r *http.Request) (map[string]interface{}, error) {
+	data, err := readBody(r)
+	if err != nil {
+		return nil, err
+	}
+	body, err := decodeFields(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid document JSON: %v", err)
+	}
+	fields, ok := body["fields"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("document JSON must have a 'fields' object")
+	}
+	return fields, nil
+}
+
+// update applies a partial update; only assign operations are supported
+func (s *Server) update(r *http.Request, docType, id string) error {
+	updates, err := readDocumentFields(r)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.documents[docType+"/"+id]
+	if !ok {
+		return errNotFound
+	}
+
+	fields := make(map[string]interface{}, len(stored.fields))
+	for name, value := range stored.fields {
+		fields[name] = value
+	}
+	for name, update := range updates {
+		operation, ok := update.(map[string]interface{})
+		value, assign := operation["assign"]
+		if !ok || !assign || len(operation) != 1 {
+			return fmt.Errorf("unsupported update of field '%s', only assign is supported", name)
+		}
+		if value == nil {
+			delete(fields, name)
+		} else {
+			fields[name] = value
+		}
+	}
+	stored.fields = fields
+	return nil
+}
+
+func (s *Server) visit(w http.ResponseWriter, r *http.Request, docType string) {
+	var documents []map[string]interface{}
+	for _, doc := range s.snapshot([]string{docType}) {
+		documents = append(documents, map[string]interface{}{"id": doc.docID(), "fields": doc.fields})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"pathId":        r.URL.Path,
+		"documents":     documents,
+		"documentCount": len(documents),
+	})
+}
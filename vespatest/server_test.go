@@ -0,0 +1,278 @@
+package vespatest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	vespa "github.com/vipulsodha/vespa-go"
+)
+
+type product struct {
+	Title     string    `json:"title"`
+	Brand     string    `json:"brand"`
+	Price     int       `json:"price"`
+	Embedding []float64 `json:"embedding,omitempty"`
+}
+
+func newProductServer(t *testing.T) *Server {
+	server := NewServer()
+	t.Cleanup(server.Close)
+
+	products := []product{
+		{Title: "Running shoes", Brand: "nike", Price: 120, Embedding: []float64{1, 0}},
+		{Title: "Trail shoes", Brand: "salomon", Price: 150, Embedding: []float64{0, 1}},
+		{Title: "Running shirt", Brand: "nike", Price: 40, Embedding: []float64{0.9, 0.1}},
+		{Title: "Rain jacket", Brand: "patagonia", Price: 200},
+	}
+	for i, p := range products {
+		if err := server.Feed("product", string(rune('1'+i)), p); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	return server
+}
+
+func search(t *testing.T, server *Server, builder vespa.QueryBuilder) *vespa.SearchResult {
+	query, err := builder.Build()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	result, err := server.Client().Search(context.Background(), query)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return result
+}
+
+func hitIDs(result *vespa.SearchResult) string {
+	ids := make([]string, len(result.Hits()))
+	for i, hit := range result.Hits() {
+		ids[i] = hit.ID[strings.LastIndex(hit.ID, ":")+1:]
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestServerSearch(t *testing.T) {
+	server := newProductServer(t)
+
+	tests := []struct {
+		name     string
+		builder  vespa.QueryBuilder
+		expected string
+		total    int64
+	}{
+		{
+			name:     "all documents in feed order",
+			builder:  vespa.NewQueryBuilder().From("product").Where(vespa.True()),
+			expected: "1,2,3,4",
+			total:    4,
+		},
+		{
+			name:     "filter",
+			builder:  vespa.NewQueryBuilder().From("product").Where(vespa.Field("brand").Eq("nike")),
+			expected: "1,3",
+			total:    2,
+		},
+		{
+			name: "range and text match",
+			builder: vespa.NewQueryBuilder().From("product").Where(
+				vespa.And(vespa.Field("title").Contains("shoes"), vespa.Field("price").Between(100, 140))),
+			expected: "1",
+			total:    1,
+		},
+		{
+			name:     "unknown source",
+			builder:  vespa.NewQueryBuilder().From("article").Where(vespa.True()),
+			expected: "",
+			total:    0,
+		},
+		{
+			name:     "order by",
+			builder:  vespa.NewQueryBuilder().From("product").Where(vespa.True()).OrderBy("price", vespa.Descending),
+			expected: "4,2,1,3",
+			total:    4,
+		},
+		{
+			name: "ranking.sorting",
+			builder: vespa.NewQueryBuilder().From("product").Where(vespa.True()).
+				WithRanking("default", vespa.WithSorting("+price")),
+			expected: "3,1,2,4",
+			total:    4,
+		},
+		{
+			name:     "hits and offset",
+			builder:  vespa.NewQueryBuilder().From("product").Where(vespa.True()).WithHits(2).WithOffset(1),
+			expected: "2,3",
+			total:    4,
+		},
+		{
+			name:     "limit and offset",
+			builder:  vespa.NewQueryBuilder().From("product").Where(vespa.True()).Limit(3, 2),
+			expected: "3",
+			total:    4,
+		},
+		{
+			name: "user query",
+			builder: vespa.NewQueryBuilder().From("product").Where(vespa.UserQuery()).
+				WithQuery("jacket").WithDefaultIndex("title"),
+			expected: "4",
+			total:    1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := search(t, server, tt.builder)
+			if got := hitIDs(result); got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+			if result.TotalCount() != tt.total {
+				t.Errorf("Expected total count %d, got %d", tt.total, result.TotalCount())
+			}
+		})
+	}
+}
+
+func TestServerSearchResponse(t *testing.T) {
+	server := newProductServer(t)
+
+	result := search(t, server, vespa.NewQueryBuilder().Select("title").From("product").Where(vespa.Field("price").Lt(50)))
+	if result.Root.Coverage == nil || result.Root.Coverage.Documents != 4 || !result.Root.Coverage.Full {
+		t.Errorf("Expected full coverage of 4 documents, got %+v", result.Root.Coverage)
+	}
+
+	hits := result.Hits()
+	if len(hits) != 1 {
+		t.Fatalf("Expected 1 hit, got %d", len(hits))
+	}
+	hit := hits[0]
+	if hit.ID != "id:test:product::3" || hit.Source != "product" {
+		t.Errorf("Expected id:test:product::3 from product, got %q from %q", hit.ID, hit.Source)
+	}
+	if hit.Fields["title"] != "Running shirt" || hit.Fields["documentid"] != hit.ID || hit.Fields["sddocname"] != "product" {
+		t.Errorf("Unexpected fields %v", hit.Fields)
+	}
+	if _, ok := hit.Fields["price"]; ok {
+		t.Errorf("Expected only selected fields, got %v", hit.Fields)
+	}
+}
+
+func TestServerNearestNeighbor(t *testing.T) {
+	server := newProductServer(t)
+
+	builder := vespa.NewQueryBuilder().From("product").
+		Where(vespa.Field("embedding").NearestNeighbor("q", 2)).
+		WithInput("input.query(q)", []float64{1, 0})
+	result := search(t, server, builder)
+
+	if got := hitIDs(result); got != "1,3" {
+		t.Errorf("Expected %q, got %q", "1,3", got)
+	}
+	hits := result.Hits()
+	if len(hits) == 2 && (hits[0].Relevance != 1 || hits[1].Relevance >= hits[0].Relevance) {
+		t.Errorf("Expected relevance by closeness, got %v and %v", hits[0].Relevance, hits[1].Relevance)
+	}
+
+	filtered := vespa.NewQueryBuilder().From("product").
+		Where(vespa.And(vespa.Field("embedding").NearestNeighbor("q", 2), vespa.Field("brand").Eq("salomon"))).
+		WithInput("input.query(q)", []float64{1, 0})
+	if got := hitIDs(search(t, server, filtered)); got != "" {
+		t.Errorf("Expected no hits outside the nearest neighbors, got %q", got)
+	}
+}
+
+func TestServerSearchErrors(t *testing.T) {
+	server := newProductServer(t)
+
+	tests := []struct {
+		name  string
+		query *vespa.VespaQuery
+		code  int
+	}{
+		{
+			name:  "invalid yql",
+			query: &vespa.VespaQuery{YQL: "select * from product where"},
+			code:  codeIllegalQuery,
+		},
+		{
+			name:  "grouping",
+			query: &vespa.VespaQuery{YQL: "select * from product where true | all(group(brand) each(output(count())))"},
+			code:  codeIllegalQuery,
+		},
+		{
+			name:  "missing query vector",
+			query: &vespa.VespaQuery{YQL: "select * from product where ({targetHits:2}nearestNeighbor(embedding, q))"},
+			code:  codeUnsupported,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := server.Client().Search(context.Background(), tt.query)
+			var responseErr *vespa.ResponseError
+			if !errors.As(err, &responseErr) {
+				t.Fatalf("Expected ResponseError, got %v", err)
+			}
+			if responseErr.StatusCode != http.StatusBadRequest {
+				t.Errorf("Expected status %d, got %d", http.StatusBadRequest, responseErr.StatusCode)
+			}
+			if len(responseErr.Errors) != 1 || responseErr.Errors[0].Code != tt.code {
+				t.Errorf("Expected error code %d, got %v", tt.code, responseErr.Errors)
+			}
+		})
+	}
+}
+
+func TestServerDocumentAPI(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+
+	request := func(method, path, body string) int {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	steps := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{http.MethodPost, "/document/v1/shop/product/docid/1", `{"fields": {"title": "Running shoes", "price": 120}}`, http.StatusOK},
+		{http.MethodGet, "/document/v1/shop/product/docid/1", "", http.StatusOK},
+		{http.MethodPut, "/document/v1/shop/product/docid/1", `{"fields": {"price": {"assign": 99}}}`, http.StatusOK},
+		{http.MethodPut, "/document/v1/shop/product/docid/1", `{"fields": {"price": {"increment": 1}}}`, http.StatusBadRequest},
+		{http.MethodPut, "/document/v1/shop/product/docid/2", `{"fields": {"price": {"assign": 1}}}`, http.StatusNotFound},
+		{http.MethodGet, "/document/v1/shop/product/docid/2", "", http.StatusNotFound},
+		{http.MethodPost, "/document/v1/shop/product/docid/2", `{"title": "missing fields"}`, http.StatusBadRequest},
+		{http.MethodGet, "/document/v1/shop/product", "", http.StatusBadRequest},
+	}
+	for _, step := range steps {
+		if status := request(step.method, step.path, step.body); status != step.status {
+			t.Errorf("%s %s: expected status %d, got %d", step.method, step.path, step.status, status)
+		}
+	}
+
+	result := search(t, server, vespa.NewQueryBuilder().From("product").Where(vespa.Field("price").Eq(99)))
+	if got := hitIDs(result); got != "1" {
+		t.Errorf("Expected updated document to match, got %q", got)
+	}
+
+	if status := request(http.MethodDelete, "/document/v1/shop/product/docid/1", ""); status != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, status)
+	}
+	if server.Len() != 0 {
+		t.Errorf("Expected no documents after delete, got %d", server.Len())
+	}
+}
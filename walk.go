@@ -0,0 +1,206 @@
+package vespa
+
+// =============================================================================
+// Condition Tree Traversal
+// =============================================================================
+
+// Node is implemented by all built-in conditions and gives uniform access to
+// nested conditions, so condition trees can be inspected and transformed.
+type Node interface {
+	WhereCondition
+	// Children returns the directly nested conditions, in rendering order
+	Children() []WhereCondition
+	// WithChildren returns a copy of the condition with its children replaced.
+	// A condition given fewer children than it needs collapses: a binary
+	// condition with one child becomes that child, and a condition left
+	// without children becomes nil.
+	WithChildren(children []WhereCondition) WhereCondition
+}
+
+// Visitor visits conditions during Walk. If Visit returns a non-nil visitor w,
+// Walk visits each child of the condition with w, followed by w.Visit(nil).
+type Visitor interface {
+	Visit(condition WhereCondition) (w Visitor)
+}
+
+// Walk traverses a condition tree in depth-first order
+func Walk(v Visitor, condition WhereCondition) {
+	if condition == nil {
+		return
+	}
+	if v = v.Visit(condition); v == nil {
+		return
+	}
+	for _, child := range children(condition) {
+		Walk(v, child)
+	}
+	v.Visit(nil)
+}
+
+// inspector adapts a function to the Visitor interface
+type inspector func(WhereCondition) bool
+
+func (f inspector) Visit(condition WhereCondition) Visitor {
+	if f(condition) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses a condition tree in depth-first order, calling f for each
+// condition. If f returns false, the children of that condition are skipped.
+//
+// Example:
+//
+//	Inspect(condition, func(c WhereCondition) bool {
+//		if fc, ok := c.(*FieldCondition); ok {
+//			fields = append(fields, fc.Field)
+//		}
+//		return true
+//	})
+func Inspect(condition WhereCondition, f func(WhereCondition) bool) {
+	Walk(inspector(func(c WhereCondition) bool {
+		return c == nil || f(c)
+	}), condition)
+}
+
+// RewriteFunc transforms a condition. Returning nil removes the condition.
+type RewriteFunc func(condition WhereCondition) WhereCondition
+
+// Rewrite transforms a condition tree bottom-up: children are rewritten
+// first, then fn is applied to the rebuilt parent. Removed children are
+// dropped from their parents; an AND/OR left with one operand is replaced by
+// that operand. Unchanged subtrees are returned as-is.
+//
+// Example (rename a field everywhere):
+//
+//	Rewrite(condition, func(c WhereCondition) WhereCondition {
+//		if fc, ok := c.(*FieldCondition); ok && fc.Field == "price" {
+//			renamed := *fc
+//			renamed.Field = "price_usd"
+//			return &renamed
+//		}
+//		return c
+//	})
+func Rewrite(condition WhereCondition, fn RewriteFunc) WhereCondition {
+	if condition == nil {
+		return nil
+	}
+
+	if node, ok := condition.(Node); ok && len(node.Children()) > 0 {
+		original := node.Children()
+		rewritten := make([]WhereCondition, 0, len(original))
+		changed := false
+		for _, child := range original {
+			result := Rewrite(child, fn)
+			if result != child {
+				changed = true
+			}
+			if result != nil {
+				rewritten = append(rewritten, result)
+			}
+		}
+		if changed {
+			condition = node.WithChildren(rewritten)
+			if condition == nil {
+				return nil
+			}
+		}
+	}
+
+	return fn(condition)
+}
+
+// children returns the nested conditions of built-in and custom nodes
+func children(condition WhereCondition) []WhereCondition {
+	if node, ok := condition.(Node); ok {
+		return node.Children()
+	}
+	return nil
+}
+
+// =============================================================================
+// Node implementations
+// =============================================================================
+
+func (uq *UserQueryFeature) Children() []WhereCondition { return nil }
+
+func (uq *UserQueryFeature) WithChildren(children []WhereCondition) WhereCondition { return uq }
+
+func (fc *FieldCondition) Children() []WhereCondition { return nil }
+
+func (fc *FieldCondition) WithChildren(children []WhereCondition) WhereCondition { return fc }
+
+func (rc *RangeCondition) Children() []WhereCondition { return nil }
+
+func (rc *RangeCondition) WithChildren(children []WhereCondition) WhereCondition { return rc }
+
+func (nn *NearestNeighbor) Children() []WhereCondition { return nil }
+
+func (nn *NearestNeighbor) WithChildren(children []WhereCondition) WhereCondition { return nn }
+
+func (cf *CustomFeature) Children() []WhereCondition { return nil }
+
+func (cf *CustomFeature) WithChildren(children []WhereCondition) WhereCondition { return cf }
+
+func (cc *ConstantCondition) Children() []WhereCondition { return nil }
+
+func (cc *ConstantCondition) WithChildren(children []WhereCondition) WhereCondition { return cc }
+
+func (bc *BooleanCondition) Children() []WhereCondition {
+	return []WhereCondition{bc.Left, bc.Right}
+}
+
+func (bc *BooleanCondition) WithChildren(children []WhereCondition) WhereCondition {
+	switch len(children) {
+	case 0:
+		return nil
+	case 1:
+		return children[0]
+	default:
+		return &BooleanCondition{Left: children[0], Right: children[1], Operator: bc.Operator}
+	}
+}
+
+func (jc *JunctionCondition) Children() []WhereCondition {
+	return jc.Conditions
+}
+
+func (jc *JunctionCondition) WithChildren(children []WhereCondition) WhereCondition {
+	switch len(children) {
+	case 0:
+		return nil
+	case 1:
+		return children[0]
+	default:
+		return &JunctionCondition{
+			Conditions: append(make([]WhereCondition, 0, len(children)), children...),
+			Operator:   jc.Operator,
+		}
+	}
+}
+
+func (nc *NotCondition) Children() []WhereCondition {
+	return []WhereCondition{nc.Condition}
+}
+
+func (nc *NotCondition) WithChildren(children []WhereCondition) WhereCondition {
+	if len(children) == 0 {
+		return nil
+	}
+	return &NotCondition{Condition: children[0]}
+}
+
+func (se *SameElementCondition) Children() []WhereCondition {
+	return se.Conditions
+}
+
+func (se *SameElementCondition) WithChildren(children []WhereCondition) WhereCondition {
+	if len(children) == 0 {
+		return nil
+	}
+	return &SameElementCondition{
+		Field:      se.Field,
+		Conditions: append(make([]WhereCondition, 0, len(children)), children...),
+	}
+}
@@ -0,0 +1,186 @@
+package vespa
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	condition := And(
+		Field("price").Between(10, 100),
+		Or(Field("brand").Eq("nike"), Not(Field("color").Eq("red"))),
+		Field("sizes").ContainsSameElement(Field("family").Eq("US"), Field("value").Eq("10")),
+	)
+
+	var visited []string
+	Inspect(condition, func(c WhereCondition) bool {
+		switch v := c.(type) {
+		case *FieldCondition:
+			visited = append(visited, v.Field)
+		case *RangeCondition:
+			visited = append(visited, v.Field)
+		case *SameElementCondition:
+			visited = append(visited, v.Field)
+		}
+		return true
+	})
+
+	expected := "price,brand,color,sizes,family,value"
+	if strings.Join(visited, ",") != expected {
+		t.Errorf("Expected visit order %q, got %q", expected, strings.Join(visited, ","))
+	}
+
+	var skipped []string
+	Inspect(condition, func(c WhereCondition) bool {
+		if fc, ok := c.(*FieldCondition); ok {
+			skipped = append(skipped, fc.Field)
+		}
+		_, isNot := c.(*NotCondition)
+		_, isSameElement := c.(*SameElementCondition)
+		return !isNot && !isSameElement
+	})
+
+	if strings.Join(skipped, ",") != "brand" {
+		t.Errorf("Expected children of NOT and sameElement to be skipped, got %v", skipped)
+	}
+}
+
+// depthVisitor records the maximum nesting depth of a condition tree
+type depthVisitor struct {
+	depth int
+	max   *int
+}
+
+func (v depthVisitor) Visit(condition WhereCondition) Visitor {
+	if condition == nil {
+		return nil
+	}
+	if v.depth > *v.max {
+		*v.max = v.depth
+	}
+	return depthVisitor{depth: v.depth + 1, max: v.max}
+}
+
+func TestWalk(t *testing.T) {
+	condition := And(Field("a").Eq(1), Not(Or(Field("b").Eq(2), Field("c").Eq(3))))
+
+	max := 0
+	Walk(depthVisitor{max: &max}, condition)
+
+	if max != 3 {
+		t.Errorf("Expected max depth 3, got %d", max)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition WhereCondition
+		fn        RewriteFunc
+		expected  string
+	}{
+		{
+			"Rename field",
+			And(Field("price").Gt(10), Not(Field("price").Lt(5))),
+			func(c WhereCondition) WhereCondition {
+				if fc, ok := c.(*FieldCondition); ok && fc.Field == "price" {
+					renamed := *fc
+					renamed.Field = "price_usd"
+					return &renamed
+				}
+				return c
+			},
+			"((price_usd > 10) AND !((price_usd < 5)))",
+		},
+		{
+			"Strip condition collapses AND",
+			And(Field("brand").Eq("nike"), Field("internal_flag").Eq(true)),
+			stripField("internal_flag"),
+			"(brand contains 'nike')",
+		},
+		{
+			"Strip condition removes NOT",
+			Or(Field("brand").Eq("nike"), Not(Field("internal_flag").Eq(true))),
+			stripField("internal_flag"),
+			"(brand contains 'nike')",
+		},
+		{
+			"Strip condition inside sameElement",
+			Field("sizes").ContainsSameElement(Field("family").Eq("US"), Field("internal_flag").Eq(true)),
+			stripField("internal_flag"),
+			"(sizes contains sameElement((family contains 'US')))",
+		},
+		{
+			"Wrap condition",
+			Field("category").Eq("shoes"),
+			func(c WhereCondition) WhereCondition {
+				if _, ok := c.(*FieldCondition); ok {
+					return And(c, Field("tenant").Eq("acme"))
+				}
+				return c
+			},
+			"((category contains 'shoes') AND (tenant contains 'acme'))",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := Rewrite(tt.condition, tt.fn)
+			if result.ToYQL() != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result.ToYQL())
+			}
+		})
+	}
+}
+
+func TestRewriteRemovesEverything(t *testing.T) {
+	condition := And(Field("internal_flag").Eq(true), Not(Field("internal_flag").Eq(false)))
+
+	if result := Rewrite(condition, stripField("internal_flag")); result != nil {
+		t.Errorf("Expected nil, got %q", result.ToYQL())
+	}
+}
+
+func TestRewriteKeepsUnchangedSubtrees(t *testing.T) {
+	left := Or(Field("a").Eq(1), Field("b").Eq(2))
+	condition := And(left, Field("c").Eq(3))
+
+	result := Rewrite(condition, func(c WhereCondition) WhereCondition { return c })
+	if result != condition {
+		t.Error("Expected identity rewrite to return the original condition")
+	}
+
+	result = Rewrite(condition, stripField("c"))
+	if result != left {
+		t.Error("Expected untouched subtree to be reused")
+	}
+}
+
+func TestQueryBuilder_Rewrite(t *testing.T) {
+	yql, err := NewQueryBuilder().
+		From("products").
+		Where(Field("internal_flag").Eq(true)).
+		Where(And(Field("category").Eq("shoes"), Field("internal_flag").Eq(false))).
+		Rank(NewRank().AddCondition(Field("brand").Contains("nike")).AddCondition(Field("internal_flag").Eq(true))).
+		Rewrite(stripField("internal_flag")).
+		Where(Field("tenant").Eq("acme")).
+		BuildYQL()
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expectedYQL := "select * from sources products where (category contains 'shoes') and (tenant contains 'acme') and rank((brand contains 'nike'))"
+	if yql != expectedYQL {
+		t.Errorf("Expected YQL %q, got %q", expectedYQL, yql)
+	}
+}
+
+func stripField(field string) RewriteFunc {
+	return func(c WhereCondition) WhereCondition {
+		if fc, ok := c.(*FieldCondition); ok && fc.Field == field {
+			return nil
+		}
+		return c
+	}
+}